@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/greatliontech/pbr/internal/gitsync"
+	"github.com/greatliontech/pbr/internal/service"
+)
+
+// stringsFlag collects the values of a repeated flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// runImport imports the history of a git repository into a module through the admin API of a running registry.
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	server := fs.String("server", "https://localhost", "base URL of the registry")
+	token := fs.String("token", os.Getenv("PBR_ADMIN_TOKEN"), "admin token (default $PBR_ADMIN_TOKEN)")
+	module := fs.String("module", "", "module to import into, as owner/module")
+	remote := fs.String("remote", "", "git repository (default: the remote of the configured module)")
+	path := fs.String("path", "", "module directory in the repository")
+	rng := fs.String("range", "", "git revision range to import (default: all tags)")
+	var filters stringsFlag
+	fs.Var(&filters, "filter", "glob of files to include, may be repeated (default: *.proto)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: pbr import -module owner/module [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *module == "" {
+		fs.Usage()
+		return 2
+	}
+
+	q := url.Values{"module": {*module}}
+	if *remote != "" {
+		q.Set("remote", *remote)
+	}
+	if *path != "" {
+		q.Set("path", *path)
+	}
+	if *rng != "" {
+		q.Set("range", *rng)
+	}
+	for _, f := range filters {
+		q.Add("filter", f)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*server, "/")+service.ImportPath+"?"+q.Encode(), nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "import failed: %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		return 1
+	}
+
+	var revisions []gitsync.Revision
+	if err := json.NewDecoder(resp.Body).Decode(&revisions); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, rev := range revisions {
+		fmt.Printf("%s %s %s %s\n", rev.GitCommit[:12], rev.CommitID, rev.CreateTime.Format("2006-01-02"), strings.Join(rev.Labels, ","))
+	}
+	fmt.Printf("imported %d revisions into %s\n", len(revisions), *module)
+	return 0
+}
@@ -18,6 +18,10 @@ import (
 var version = "0.0.0-dev"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImport(os.Args[2:]))
+	}
+
 	// Create a context that is canceled when SIGTERM or SIGINT is received.
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
@@ -0,0 +1,486 @@
+// Package breaking detects breaking changes between two versions of a module's descriptors.
+//
+// The rule sets follow those of buf breaking, from the most to the least strict:
+// FILE, PACKAGE, WIRE_JSON and WIRE. FILE and PACKAGE protect generated code, WIRE_JSON
+// the JSON and binary encodings, and WIRE the binary encoding only.
+package breaking
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/greatliontech/pbr/internal/diag"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// RuleSet is a set of breaking change rules.
+type RuleSet string
+
+const (
+	RuleSetFile     RuleSet = "FILE"
+	RuleSetPackage  RuleSet = "PACKAGE"
+	RuleSetWireJSON RuleSet = "WIRE_JSON"
+	RuleSetWire     RuleSet = "WIRE"
+)
+
+// ParseRuleSet parses a rule set name. The empty name is FILE.
+func ParseRuleSet(name string) (RuleSet, error) {
+	switch set := RuleSet(strings.ToUpper(name)); set {
+	case "":
+		return RuleSetFile, nil
+	case RuleSetFile, RuleSetPackage, RuleSetWireJSON, RuleSetWire:
+		return set, nil
+	default:
+		return "", fmt.Errorf("unknown breaking rule set %q, expected FILE, PACKAGE, WIRE_JSON or WIRE", name)
+	}
+}
+
+// level orders the rule sets, each including the checks of the ones below it.
+func (s RuleSet) level() int {
+	switch s {
+	case RuleSetWire:
+		return wire
+	case RuleSetWireJSON:
+		return wireJSON
+	case RuleSetPackage:
+		return pkg
+	default:
+		return file
+	}
+}
+
+const (
+	wire = iota
+	wireJSON
+	pkg
+	file
+)
+
+// Finding is a breaking change. Its position is in the current version of File, and
+// missing if the change has none there, such as a deleted file.
+type Finding = diag.Diagnostic
+
+// Check returns the breaking changes of current against previous, sorted by position.
+func Check[F protoreflect.FileDescriptor](current, previous []F, set RuleSet) []Finding {
+	c := &checker{
+		level: set.level(),
+		files: make(map[string]protoreflect.FileDescriptor),
+		types: make(map[protoreflect.FullName]protoreflect.Descriptor),
+		pkgs:  make(map[protoreflect.FullName]bool),
+	}
+	for _, fd := range current {
+		c.files[fd.Path()] = fd
+		c.pkgs[fd.Package()] = true
+		indexTypes(fd, c.types)
+	}
+
+	prevPkgs := make(map[protoreflect.FullName]string)
+	for _, prev := range previous {
+		if _, ok := prevPkgs[prev.Package()]; !ok {
+			prevPkgs[prev.Package()] = prev.Path()
+		}
+		c.checkFile(prev)
+	}
+	if c.level == pkg {
+		for name, path := range prevPkgs {
+			if !c.pkgs[name] {
+				c.add("PACKAGE_NO_DELETE", path, nil, "package %q was deleted", name)
+			}
+		}
+	}
+
+	slices.SortFunc(c.findings, func(a, b Finding) int {
+		return cmp.Or(
+			strings.Compare(a.File, b.File),
+			cmp.Compare(a.Line, b.Line),
+			cmp.Compare(a.Column, b.Column),
+			strings.Compare(a.Rule, b.Rule),
+			strings.Compare(a.Message, b.Message),
+		)
+	})
+	return c.findings
+}
+
+type checker struct {
+	level    int
+	files    map[string]protoreflect.FileDescriptor
+	types    map[protoreflect.FullName]protoreflect.Descriptor
+	pkgs     map[protoreflect.FullName]bool
+	findings []Finding
+}
+
+// indexTypes adds the messages, enums and services of a file to types by full name.
+func indexTypes(fd protoreflect.FileDescriptor, types map[protoreflect.FullName]protoreflect.Descriptor) {
+	var messages func(protoreflect.MessageDescriptors)
+	enums := func(eds protoreflect.EnumDescriptors) {
+		for i := range eds.Len() {
+			types[eds.Get(i).FullName()] = eds.Get(i)
+		}
+	}
+	messages = func(mds protoreflect.MessageDescriptors) {
+		for i := range mds.Len() {
+			md := mds.Get(i)
+			types[md.FullName()] = md
+			messages(md.Messages())
+			enums(md.Enums())
+		}
+	}
+	messages(fd.Messages())
+	enums(fd.Enums())
+	for i := range fd.Services().Len() {
+		types[fd.Services().Get(i).FullName()] = fd.Services().Get(i)
+	}
+}
+
+// add records a finding at the position of d in the current version of path.
+// A nil d, or one without a source location, reports the file without a position.
+func (c *checker) add(rule, path string, d protoreflect.Descriptor, format string, args ...any) {
+	f := Finding{Rule: rule, File: path, Message: fmt.Sprintf(format, args...)}
+	if d != nil {
+		fd := d.ParentFile()
+		f.File = fd.Path()
+		if _, isFile := d.(protoreflect.FileDescriptor); !isFile {
+			if loc := fd.SourceLocations().ByDescriptor(d); loc.Path != nil {
+				f.Line, f.Column = loc.StartLine+1, loc.StartColumn+1
+			}
+		}
+	}
+	c.findings = append(c.findings, f)
+}
+
+func (c *checker) checkFile(prev protoreflect.FileDescriptor) {
+	cur, ok := c.files[prev.Path()]
+	if c.level == file {
+		if !ok {
+			c.add("FILE_NO_DELETE", prev.Path(), nil, "file %q was deleted", prev.Path())
+			return
+		}
+		if cur.Package() != prev.Package() {
+			c.add("FILE_SAME_PACKAGE", cur.Path(), cur, "package changed from %q to %q", prev.Package(), cur.Package())
+		}
+		prevOpts, _ := prev.Options().(*descriptorpb.FileOptions)
+		curOpts, _ := cur.Options().(*descriptorpb.FileOptions)
+		if prevOpts.GetGoPackage() != curOpts.GetGoPackage() {
+			c.add("FILE_SAME_GO_PACKAGE", cur.Path(), cur, "option go_package changed from %q to %q", prevOpts.GetGoPackage(), curOpts.GetGoPackage())
+		}
+		if prevOpts.GetJavaPackage() != curOpts.GetJavaPackage() {
+			c.add("FILE_SAME_JAVA_PACKAGE", cur.Path(), cur, "option java_package changed from %q to %q", prevOpts.GetJavaPackage(), curOpts.GetJavaPackage())
+		}
+	}
+
+	c.checkMessages(prev.Messages(), prev.Path())
+	c.checkEnums(prev.Enums(), prev.Path())
+	if c.level >= pkg {
+		for i := range prev.Services().Len() {
+			c.checkService(prev.Services().Get(i), prev.Path())
+		}
+	}
+}
+
+// lookup finds the current version of a previous message, enum or service. With the FILE
+// rule set, types must stay in the same file. If the type was deleted, lookup records it
+// and returns nil.
+func (c *checker) lookup(prev protoreflect.Descriptor, path, kind string) protoreflect.Descriptor {
+	cur, ok := c.types[prev.FullName()]
+	switch {
+	case ok && c.level == file && cur.ParentFile().Path() != path:
+		c.add(strings.ToUpper(kind)+"_NO_DELETE", path, c.files[path], "%s %q was moved to %q", kind, prev.FullName(), cur.ParentFile().Path())
+		return nil
+	case ok:
+		return cur
+	case c.level == file:
+		c.add(strings.ToUpper(kind)+"_NO_DELETE", path, c.parent(prev, path), "%s %q was deleted", kind, prev.FullName())
+	case c.level == pkg:
+		c.add("PACKAGE_"+strings.ToUpper(kind)+"_NO_DELETE", path, c.parent(prev, path), "%s %q was deleted", kind, prev.FullName())
+	}
+	return nil
+}
+
+// parent returns the current version of the closest ancestor of a deleted descriptor.
+func (c *checker) parent(prev protoreflect.Descriptor, path string) protoreflect.Descriptor {
+	for p := prev.Parent(); p != nil; p = p.Parent() {
+		if _, isFile := p.(protoreflect.FileDescriptor); isFile {
+			break
+		}
+		if cur, ok := c.types[p.FullName()]; ok {
+			return cur
+		}
+	}
+	if fd, ok := c.files[path]; ok {
+		return fd
+	}
+	return nil
+}
+
+func (c *checker) checkMessages(prevs protoreflect.MessageDescriptors, path string) {
+	for i := range prevs.Len() {
+		prev := prevs.Get(i)
+		cur, ok := c.lookup(prev, path, "message").(protoreflect.MessageDescriptor)
+		if !ok {
+			// Nested types go with their message
+			continue
+		}
+		c.checkMessage(cur, prev)
+		c.checkMessages(prev.Messages(), path)
+		c.checkEnums(prev.Enums(), path)
+	}
+}
+
+func (c *checker) checkMessage(cur, prev protoreflect.MessageDescriptor) {
+	path := cur.ParentFile().Path()
+	prevFields := prev.Fields()
+	for i := range prevFields.Len() {
+		pf := prevFields.Get(i)
+		cf := cur.Fields().ByNumber(pf.Number())
+		if cf == nil {
+			c.checkDeletedField(cur, pf)
+			continue
+		}
+		c.checkField(cf, pf)
+	}
+
+	curRanges := fieldRanges(cur.ReservedRanges())
+	for _, r := range fieldRanges(prev.ReservedRanges()) {
+		if !covered(r, curRanges) {
+			c.add("RESERVED_MESSAGE_NO_DELETE", path, cur, "reserved range %s of message %q is no longer fully reserved", formatRange(r), cur.FullName())
+		}
+	}
+	if c.level >= wireJSON {
+		for i := range prev.ReservedNames().Len() {
+			if name := prev.ReservedNames().Get(i); !cur.ReservedNames().Has(name) {
+				c.add("RESERVED_MESSAGE_NO_DELETE", path, cur, "reserved name %q of message %q was deleted", name, cur.FullName())
+			}
+		}
+	}
+}
+
+func (c *checker) checkDeletedField(cur protoreflect.MessageDescriptor, pf protoreflect.FieldDescriptor) {
+	path := cur.ParentFile().Path()
+	if c.level >= pkg {
+		c.add("FIELD_NO_DELETE", path, cur, "field %d %q was deleted from message %q", pf.Number(), pf.Name(), cur.FullName())
+		return
+	}
+	if !cur.ReservedRanges().Has(pf.Number()) {
+		c.add("FIELD_NO_DELETE_UNLESS_NUMBER_RESERVED", path, cur, "field %d %q was deleted from message %q without reserving its number", pf.Number(), pf.Name(), cur.FullName())
+	}
+	if c.level >= wireJSON && !cur.ReservedNames().Has(pf.Name()) {
+		c.add("FIELD_NO_DELETE_UNLESS_NAME_RESERVED", path, cur, "field %d %q was deleted from message %q without reserving its name", pf.Number(), pf.Name(), cur.FullName())
+	}
+}
+
+func (c *checker) checkField(cf, pf protoreflect.FieldDescriptor) {
+	path := cf.ParentFile().Path()
+	switch {
+	case c.level >= pkg:
+		if cf.Kind() != pf.Kind() || typeName(cf) != typeName(pf) {
+			c.add("FIELD_SAME_TYPE", path, cf, "field %d %q changed type from %s to %s", cf.Number(), cf.Name(), fieldType(pf), fieldType(cf))
+		}
+	case c.level == wireJSON:
+		if cf.Kind() != pf.Kind() {
+			c.add("FIELD_WIRE_JSON_COMPATIBLE_TYPE", path, cf, "field %d %q changed type from %s to %s", cf.Number(), cf.Name(), fieldType(pf), fieldType(cf))
+		}
+	default:
+		if wireClass(cf.Kind()) != wireClass(pf.Kind()) {
+			c.add("FIELD_WIRE_COMPATIBLE_TYPE", path, cf, "field %d %q changed type from %s to %s", cf.Number(), cf.Name(), fieldType(pf), fieldType(cf))
+		}
+	}
+
+	if c.level >= pkg {
+		if cardinality(cf) != cardinality(pf) {
+			c.add("FIELD_SAME_CARDINALITY", path, cf, "field %d %q changed cardinality from %s to %s", cf.Number(), cf.Name(), cardinality(pf), cardinality(cf))
+		}
+		if cf.Name() != pf.Name() {
+			c.add("FIELD_SAME_NAME", path, cf, "field %d changed name from %q to %q", cf.Number(), pf.Name(), cf.Name())
+		}
+		if oneofName(cf) != oneofName(pf) {
+			c.add("FIELD_SAME_ONEOF", path, cf, "field %d %q moved from oneof %q to %q", cf.Number(), cf.Name(), oneofName(pf), oneofName(cf))
+		}
+	} else if cf.IsList() != pf.IsList() {
+		c.add("FIELD_WIRE_COMPATIBLE_CARDINALITY", path, cf, "field %d %q changed cardinality from %s to %s", cf.Number(), cf.Name(), cardinality(pf), cardinality(cf))
+	}
+
+	if c.level >= wireJSON && cf.JSONName() != pf.JSONName() {
+		c.add("FIELD_SAME_JSON_NAME", path, cf, "field %d %q changed JSON name from %q to %q", cf.Number(), cf.Name(), pf.JSONName(), cf.JSONName())
+	}
+}
+
+func (c *checker) checkEnums(prevs protoreflect.EnumDescriptors, path string) {
+	for i := range prevs.Len() {
+		prev := prevs.Get(i)
+		if cur, ok := c.lookup(prev, path, "enum").(protoreflect.EnumDescriptor); ok {
+			c.checkEnum(cur, prev)
+		}
+	}
+}
+
+func (c *checker) checkEnum(cur, prev protoreflect.EnumDescriptor) {
+	path := cur.ParentFile().Path()
+	for i := range prev.Values().Len() {
+		pv := prev.Values().Get(i)
+		cv := cur.Values().ByNumber(pv.Number())
+		switch {
+		case cv == nil && c.level >= pkg:
+			c.add("ENUM_VALUE_NO_DELETE", path, cur, "enum value %d %q was deleted from enum %q", pv.Number(), pv.Name(), cur.FullName())
+		case cv == nil:
+			if !cur.ReservedRanges().Has(pv.Number()) {
+				c.add("ENUM_VALUE_NO_DELETE_UNLESS_NUMBER_RESERVED", path, cur, "enum value %d %q was deleted from enum %q without reserving its number", pv.Number(), pv.Name(), cur.FullName())
+			}
+			if c.level >= wireJSON && !cur.ReservedNames().Has(pv.Name()) {
+				c.add("ENUM_VALUE_NO_DELETE_UNLESS_NAME_RESERVED", path, cur, "enum value %d %q was deleted from enum %q without reserving its name", pv.Number(), pv.Name(), cur.FullName())
+			}
+		case c.level >= wireJSON && cv.Name() != pv.Name():
+			// Enum values are encoded by name in JSON
+			c.add("ENUM_VALUE_SAME_NAME", path, cv, "enum value %d changed name from %q to %q", cv.Number(), pv.Name(), cv.Name())
+		}
+	}
+
+	curRanges := enumRanges(cur.ReservedRanges())
+	for _, r := range enumRanges(prev.ReservedRanges()) {
+		if !covered(r, curRanges) {
+			c.add("RESERVED_ENUM_NO_DELETE", path, cur, "reserved range %s of enum %q is no longer fully reserved", formatRange(r), cur.FullName())
+		}
+	}
+	if c.level >= wireJSON {
+		for i := range prev.ReservedNames().Len() {
+			if name := prev.ReservedNames().Get(i); !cur.ReservedNames().Has(name) {
+				c.add("RESERVED_ENUM_NO_DELETE", path, cur, "reserved name %q of enum %q was deleted", name, cur.FullName())
+			}
+		}
+	}
+}
+
+func (c *checker) checkService(prev protoreflect.ServiceDescriptor, path string) {
+	cur, ok := c.lookup(prev, path, "service").(protoreflect.ServiceDescriptor)
+	if !ok {
+		return
+	}
+	for i := range prev.Methods().Len() {
+		pm := prev.Methods().Get(i)
+		cm := cur.Methods().ByName(pm.Name())
+		if cm == nil {
+			c.add("RPC_NO_DELETE", cur.ParentFile().Path(), cur, "rpc %q was deleted from service %q", pm.Name(), cur.FullName())
+			continue
+		}
+		p := cm.ParentFile().Path()
+		if cm.Input().FullName() != pm.Input().FullName() {
+			c.add("RPC_SAME_REQUEST_TYPE", p, cm, "rpc %q changed request type from %q to %q", cm.Name(), pm.Input().FullName(), cm.Input().FullName())
+		}
+		if cm.Output().FullName() != pm.Output().FullName() {
+			c.add("RPC_SAME_RESPONSE_TYPE", p, cm, "rpc %q changed response type from %q to %q", cm.Name(), pm.Output().FullName(), cm.Output().FullName())
+		}
+		if cm.IsStreamingClient() != pm.IsStreamingClient() {
+			c.add("RPC_SAME_CLIENT_STREAMING", p, cm, "rpc %q changed client streaming from %t to %t", cm.Name(), pm.IsStreamingClient(), cm.IsStreamingClient())
+		}
+		if cm.IsStreamingServer() != pm.IsStreamingServer() {
+			c.add("RPC_SAME_SERVER_STREAMING", p, cm, "rpc %q changed server streaming from %t to %t", cm.Name(), pm.IsStreamingServer(), cm.IsStreamingServer())
+		}
+	}
+}
+
+// typeName returns the full name of the message or enum type of a field, if any.
+func typeName(fd protoreflect.FieldDescriptor) protoreflect.FullName {
+	switch {
+	case fd.Message() != nil:
+		return fd.Message().FullName()
+	case fd.Enum() != nil:
+		return fd.Enum().FullName()
+	}
+	return ""
+}
+
+func fieldType(fd protoreflect.FieldDescriptor) string {
+	if name := typeName(fd); name != "" {
+		return string(name)
+	}
+	return fd.Kind().String()
+}
+
+// cardinality describes the label and presence of a field.
+func cardinality(fd protoreflect.FieldDescriptor) string {
+	switch {
+	case fd.IsMap():
+		return "map"
+	case fd.IsList():
+		return "repeated"
+	case fd.Cardinality() == protoreflect.Required:
+		return "required"
+	case fd.HasPresence() && fd.Message() == nil:
+		return "optional"
+	}
+	return "singular"
+}
+
+func oneofName(fd protoreflect.FieldDescriptor) protoreflect.Name {
+	if o := fd.ContainingOneof(); o != nil && !o.IsSynthetic() {
+		return o.Name()
+	}
+	return ""
+}
+
+// wireClass groups the kinds whose values can be read as one another in the binary encoding.
+func wireClass(k protoreflect.Kind) string {
+	switch k {
+	case protoreflect.Int32Kind, protoreflect.Int64Kind, protoreflect.Uint32Kind, protoreflect.Uint64Kind,
+		protoreflect.BoolKind, protoreflect.EnumKind:
+		return "varint"
+	case protoreflect.Sint32Kind, protoreflect.Sint64Kind:
+		return "zigzag"
+	case protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind:
+		return "fixed32"
+	case protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind:
+		return "fixed64"
+	case protoreflect.StringKind, protoreflect.BytesKind, protoreflect.MessageKind:
+		return "bytes"
+	}
+	return k.String()
+}
+
+// numberRange is an inclusive range of field or enum value numbers.
+type numberRange [2]int64
+
+func fieldRanges(ranges protoreflect.FieldRanges) []numberRange {
+	out := make([]numberRange, ranges.Len())
+	for i := range out {
+		r := ranges.Get(i)
+		out[i] = numberRange{int64(r[0]), int64(r[1]) - 1} // field ranges are exclusive
+	}
+	return out
+}
+
+func enumRanges(ranges protoreflect.EnumRanges) []numberRange {
+	out := make([]numberRange, ranges.Len())
+	for i := range out {
+		r := ranges.Get(i)
+		out[i] = numberRange{int64(r[0]), int64(r[1])}
+	}
+	return out
+}
+
+// covered reports whether the union of ranges contains r.
+func covered(r numberRange, ranges []numberRange) bool {
+	ranges = slices.Clone(ranges)
+	slices.SortFunc(ranges, func(a, b numberRange) int { return cmp.Compare(a[0], b[0]) })
+	next := r[0]
+	for _, o := range ranges {
+		if o[0] > next {
+			break
+		}
+		if o[1] >= next {
+			if o[1] >= r[1] {
+				return true
+			}
+			next = o[1] + 1
+		}
+	}
+	return false
+}
+
+func formatRange(r numberRange) string {
+	if r[0] == r[1] {
+		return fmt.Sprint(r[0])
+	}
+	return fmt.Sprintf("%d to %d", r[0], r[1])
+}
@@ -0,0 +1,163 @@
+package breaking
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/greatliontech/pbr/internal/compiler"
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+const previous = `syntax = "proto3";
+package acme.v1;
+option go_package = "acme/v1";
+
+message Pet {
+  string name = 1;
+  int32 age = 2;
+  string owner = 3;
+  reserved 10 to 20;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_DOG = 1;
+  KIND_CAT = 2;
+}
+
+message Toy {}
+
+service PetService {
+  rpc GetPet(Pet) returns (Pet);
+  rpc ListPets(Pet) returns (Pet);
+}
+`
+
+func protoFiles(files map[string]string) []registry.File {
+	var out []registry.File
+	for path, content := range files {
+		out = append(out, registry.File{Path: path, Content: content})
+	}
+	return out
+}
+
+func check(t *testing.T, current, prev string, set RuleSet) []string {
+	t.Helper()
+	cur, err := compiler.Compile(context.Background(), protoFiles(map[string]string{"acme/v1/pet.proto": current}), nil)
+	if err != nil {
+		t.Fatalf("Compile current failed: %v", err)
+	}
+	old, err := compiler.Compile(context.Background(), protoFiles(map[string]string{"acme/v1/pet.proto": prev}), nil)
+	if err != nil {
+		t.Fatalf("Compile previous failed: %v", err)
+	}
+	var got []string
+	for _, f := range Check(cur, old, set) {
+		got = append(got, f.String())
+	}
+	return got
+}
+
+func TestCheck_NoChanges(t *testing.T) {
+	if got := check(t, previous, previous, RuleSetFile); len(got) != 0 {
+		t.Errorf("expected no findings, got %q", got)
+	}
+}
+
+func TestCheck_RuleSets(t *testing.T) {
+	// age changes to a wire-compatible type, owner is deleted with its number reserved,
+	// KIND_CAT is renamed, Toy and ListPets are deleted and the reserved range shrinks
+	current := `syntax = "proto3";
+package acme.v1;
+option go_package = "acme/v1";
+
+message Pet {
+  string name = 1;
+  int64 age = 2;
+  reserved 3, 10 to 15;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_DOG = 1;
+  KIND_FELINE = 2;
+}
+
+service PetService {
+  rpc GetPet(Pet) returns (Pet);
+}
+`
+	tests := []struct {
+		set  RuleSet
+		want []string
+	}{
+		{RuleSetWire, []string{
+			"acme/v1/pet.proto:5:1: reserved range 10 to 20 of message \"acme.v1.Pet\" is no longer fully reserved (RESERVED_MESSAGE_NO_DELETE)",
+		}},
+		{RuleSetWireJSON, []string{
+			"acme/v1/pet.proto:5:1: field 3 \"owner\" was deleted from message \"acme.v1.Pet\" without reserving its name (FIELD_NO_DELETE_UNLESS_NAME_RESERVED)",
+			"acme/v1/pet.proto:5:1: reserved range 10 to 20 of message \"acme.v1.Pet\" is no longer fully reserved (RESERVED_MESSAGE_NO_DELETE)",
+			"acme/v1/pet.proto:7:3: field 2 \"age\" changed type from int32 to int64 (FIELD_WIRE_JSON_COMPATIBLE_TYPE)",
+			"acme/v1/pet.proto:14:3: enum value 2 changed name from \"KIND_CAT\" to \"KIND_FELINE\" (ENUM_VALUE_SAME_NAME)",
+		}},
+		{RuleSetPackage, []string{
+			"acme/v1/pet.proto: message \"acme.v1.Toy\" was deleted (PACKAGE_MESSAGE_NO_DELETE)",
+			"acme/v1/pet.proto:5:1: field 3 \"owner\" was deleted from message \"acme.v1.Pet\" (FIELD_NO_DELETE)",
+			"acme/v1/pet.proto:5:1: reserved range 10 to 20 of message \"acme.v1.Pet\" is no longer fully reserved (RESERVED_MESSAGE_NO_DELETE)",
+			"acme/v1/pet.proto:7:3: field 2 \"age\" changed type from int32 to int64 (FIELD_SAME_TYPE)",
+			"acme/v1/pet.proto:14:3: enum value 2 changed name from \"KIND_CAT\" to \"KIND_FELINE\" (ENUM_VALUE_SAME_NAME)",
+			"acme/v1/pet.proto:17:1: rpc \"ListPets\" was deleted from service \"acme.v1.PetService\" (RPC_NO_DELETE)",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.set), func(t *testing.T) {
+			got := check(t, current, previous, tt.set)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCheck_File(t *testing.T) {
+	prev, err := compiler.Compile(context.Background(), protoFiles(map[string]string{
+		"acme/v1/pet.proto": "syntax = \"proto3\";\npackage acme.v1;\nmessage Pet {}\nmessage Toy {}\n",
+		"acme/v1/old.proto": "syntax = \"proto3\";\npackage acme.v1;\n",
+	}), nil)
+	if err != nil {
+		t.Fatalf("Compile previous failed: %v", err)
+	}
+	cur, err := compiler.Compile(context.Background(), protoFiles(map[string]string{
+		"acme/v1/pet.proto": "syntax = \"proto3\";\npackage acme.v1;\noption go_package = \"acme/v1;acmev1\";\nmessage Pet {}\n",
+		"acme/v1/toy.proto": "syntax = \"proto3\";\npackage acme.v1;\nmessage Toy {}\n",
+	}), nil)
+	if err != nil {
+		t.Fatalf("Compile current failed: %v", err)
+	}
+
+	var got []string
+	for _, f := range Check(cur, prev, RuleSetFile) {
+		got = append(got, f.Rule)
+	}
+	want := "FILE_NO_DELETE,FILE_SAME_GO_PACKAGE,MESSAGE_NO_DELETE"
+	if strings.Join(got, ",") != want {
+		t.Errorf("rules = %s, want %s", strings.Join(got, ","), want)
+	}
+	// Moving a type between files of a package is fine for PACKAGE
+	if findings := Check(cur, prev, RuleSetPackage); len(findings) != 0 {
+		t.Errorf("PACKAGE: expected no findings, got %v", findings)
+	}
+}
+
+func TestParseRuleSet(t *testing.T) {
+	if set, err := ParseRuleSet(""); err != nil || set != RuleSetFile {
+		t.Errorf("default = %v, %v, want FILE", set, err)
+	}
+	if set, err := ParseRuleSet("wire_json"); err != nil || set != RuleSetWireJSON {
+		t.Errorf("wire_json = %v, %v", set, err)
+	}
+	if _, err := ParseRuleSet("STRICT"); err == nil {
+		t.Error("expected error for unknown rule set")
+	}
+}
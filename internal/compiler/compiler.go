@@ -0,0 +1,113 @@
+// Package compiler compiles the proto files of modules in-process, to validate uploads.
+package compiler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/linker"
+	"github.com/bufbuild/protocompile/reporter"
+	"github.com/greatliontech/pbr/internal/diag"
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+// maxReported is the number of diagnostics included in an Error's message.
+const maxReported = 20
+
+// Diagnostic is a compiler error in a proto file. It names no rule.
+type Diagnostic = diag.Diagnostic
+
+// Error is returned for modules that don't compile.
+type Error struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	for i, d := range e.Diagnostics {
+		if i == maxReported {
+			fmt.Fprintf(&b, "\nand %d more errors", len(e.Diagnostics)-maxReported)
+			break
+		}
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(d.String())
+	}
+	return b.String()
+}
+
+// Compile compiles the proto files of a module, resolving imports from the module itself,
+// the files of its dependencies and the well-known types. It returns the descriptors of the
+// module's proto files, or an *Error listing every error found.
+func Compile(ctx context.Context, files, deps []registry.File) (linker.Files, error) {
+	sources := make(map[string]string)
+	var names []string
+	for _, f := range files {
+		if strings.HasSuffix(f.Path, ".proto") {
+			sources[f.Path] = f.Content
+			names = append(names, f.Path)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	slices.Sort(names)
+
+	var diags []Diagnostic
+	for _, f := range deps {
+		if !strings.HasSuffix(f.Path, ".proto") {
+			continue
+		}
+		if _, ok := sources[f.Path]; ok {
+			// The first dependency providing a file wins, but a module can't redefine one
+			if _, own := slices.BinarySearch(names, f.Path); own {
+				diags = append(diags, Diagnostic{File: f.Path, Message: "file is also provided by a dependency"})
+			}
+			continue
+		}
+		sources[f.Path] = f.Content
+	}
+	if len(diags) > 0 {
+		return nil, &Error{Diagnostics: diags}
+	}
+
+	comp := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(sources),
+		}),
+		Reporter: reporter.NewReporter(func(err reporter.ErrorWithPos) error {
+			diags = append(diags, diagnostic(err))
+			// Keep going to report all errors
+			return nil
+		}, nil),
+		SourceInfoMode: protocompile.SourceInfoStandard,
+	}
+	compiled, err := comp.Compile(ctx, names...)
+	// Unresolvable imports are returned rather than reported
+	var posErr reporter.ErrorWithPos
+	if errors.As(err, &posErr) {
+		diags = append(diags, diagnostic(posErr))
+	}
+	if len(diags) > 0 {
+		return nil, &Error{Diagnostics: diags}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return compiled, nil
+}
+
+func diagnostic(err reporter.ErrorWithPos) Diagnostic {
+	pos := err.GetPosition()
+	return Diagnostic{
+		File:    pos.Filename,
+		Line:    pos.Line,
+		Column:  pos.Col,
+		Message: err.Unwrap().Error(),
+	}
+}
@@ -0,0 +1,91 @@
+package compiler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+func TestCompile(t *testing.T) {
+	deps := []registry.File{
+		{Path: "acme/types/v1/money.proto", Content: "syntax = \"proto3\";\npackage acme.types.v1;\nmessage Money { int64 units = 1; }\n"},
+	}
+	files := []registry.File{
+		{Path: "acme/pets/v1/pet.proto", Content: `syntax = "proto3";
+package acme.pets.v1;
+import "acme/types/v1/money.proto";
+import "google/protobuf/timestamp.proto";
+message Pet {
+  acme.types.v1.Money price = 1;
+  google.protobuf.Timestamp born = 2;
+}
+`},
+		{Path: "buf.yaml", Content: "version: v1\n"},
+	}
+
+	compiled, err := Compile(context.Background(), files, deps)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if len(compiled) != 1 || compiled[0].Path() != "acme/pets/v1/pet.proto" {
+		t.Errorf("expected the module's proto file only, got %v", compiled)
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []registry.File
+		deps  []registry.File
+		want  []string
+	}{
+		{
+			name:  "syntax error",
+			files: []registry.File{{Path: "a.proto", Content: "syntax = \"proto3\";\nmessage A {\n  string name = 1\n}\n"}},
+			want:  []string{"a.proto:4:1: syntax error: expecting ';'"},
+		},
+		{
+			name:  "unresolved import",
+			files: []registry.File{{Path: "a.proto", Content: "syntax = \"proto3\";\nimport \"missing.proto\";\n"}},
+			want:  []string{"a.proto:2:8: could not resolve path \"missing.proto\": file does not exist"},
+		},
+		{
+			name: "duplicate symbol",
+			files: []registry.File{
+				{Path: "a.proto", Content: "syntax = \"proto3\";\npackage p;\nmessage A {}\n"},
+				{Path: "b.proto", Content: "syntax = \"proto3\";\npackage p;\nmessage A {}\n"},
+			},
+			want: []string{`symbol "p.A" already defined`},
+		},
+		{
+			name:  "file of a dependency",
+			files: []registry.File{{Path: "a.proto", Content: "syntax = \"proto3\";\n"}},
+			deps:  []registry.File{{Path: "a.proto", Content: "syntax = \"proto3\";\n"}},
+			want:  []string{"a.proto: file is also provided by a dependency"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(context.Background(), tt.files, tt.deps)
+			var compileErr *Error
+			if !errors.As(err, &compileErr) {
+				t.Fatalf("expected *Error, got %v", err)
+			}
+			var got []string
+			for _, d := range compileErr.Diagnostics {
+				got = append(got, d.String())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("diagnostics = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if !strings.Contains(got[i], tt.want[i]) {
+					t.Errorf("diagnostic %d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
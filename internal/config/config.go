@@ -1,7 +1,11 @@
 package config
 
 import (
+	"cmp"
+	"fmt"
 	"os"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -18,15 +22,46 @@ type Config struct {
 	TLS         *TLS
 	Storage     *Storage
 	OIDC        *OIDC
-	Host        string
-	Address     string
-	LogLevel    string
-	CacheDir    string
-	AdminToken  string
-	NoLogin     bool
+	// Authorization configures policy-based authorization of API requests.
+	Authorization *Authorization
+	// RateLimit configures per-principal, per-procedure and global request rate limits.
+	RateLimit *RateLimit `yaml:"ratelimit"`
+	// Audit configures the audit log of mutating operations.
+	Audit *Audit
+	// Mirrors are upstream registries whose modules are fetched on first use and served from storage.
+	Mirrors []Mirror
+	// RemoteRegistries are the hosts of other registries, such as "buf.build", whose commits
+	// uploads may depend on. Their module and digest are looked up over HTTPS on upload.
+	// Without any, dependencies must be commits of this registry or of a mirror.
+	RemoteRegistries []string `yaml:"remote_registries"`
+	// Breaking configures the detection of breaking changes on upload.
+	Breaking *Breaking `yaml:"breaking"`
+	// Lint configures the lint rules enforced on upload.
+	Lint *Lint `yaml:"lint"`
+	// Compile configures uploads that can't be compiled here.
+	Compile *Compile `yaml:"compile"`
+	// Limits bounds the size and paths of uploads.
+	Limits *Limits `yaml:"limits"`
+	// Quotas bounds the storage used by each owner.
+	Quotas *Quotas `yaml:"quotas"`
+	// Retention configures the pruning of old commits.
+	Retention *Retention `yaml:"retention"`
+	// Deletion configures the grace period of deleted modules and the dependents check.
+	Deletion *Deletion `yaml:"deletion"`
+	// Renames configures how long the former names of renamed modules keep resolving.
+	Renames    *Renames `yaml:"renames"`
+	Host       string
+	Address    string
+	LogLevel   string
+	CacheDir   string
+	AdminToken string
+	NoLogin    bool
 	// TokenTTL is the duration for which OIDC tokens are valid (e.g., "7d", "24h", "168h").
 	// Tokens are refreshed on each use (sliding expiration). Default: "7d" (7 days).
 	TokenTTL string `yaml:"token_ttl"`
+	// SyncInterval is how often the git repositories of Modules are synced (e.g., "10m", "1h").
+	// "0" syncs them only at startup and on demand. Default: "10m".
+	SyncInterval string `yaml:"sync_interval"`
 }
 
 // OIDC configures OpenID Connect authentication.
@@ -44,6 +79,422 @@ type OIDC struct {
 	UsernameClaim string `yaml:"username_claim"`
 }
 
+// Authorization configures policy-based authorization.
+type Authorization struct {
+	// PolicyFile is the path to a YAML file of CEL authorization rules.
+	PolicyFile string `yaml:"policy_file"`
+	// Groups maps group names to their member usernames, exposed to policies as principal.groups.
+	Groups map[string][]string `yaml:"groups"`
+}
+
+// GroupsFor returns the names of the groups a user is a member of.
+func (a *Authorization) GroupsFor(username string) []string {
+	if a == nil {
+		return nil
+	}
+	var groups []string
+	for group, members := range a.Groups {
+		if slices.Contains(members, username) {
+			groups = append(groups, group)
+		}
+	}
+	slices.Sort(groups)
+	return groups
+}
+
+// RateLimit configures token-bucket request rate limits.
+// Requests are keyed by the authenticated username, or by client address when login is disabled.
+type RateLimit struct {
+	// Global limits all requests to the registry.
+	Global *Limit `yaml:"global"`
+	// PerPrincipal limits each principal that has no entry in Principals.
+	PerPrincipal *Limit `yaml:"per_principal"`
+	// Principals overrides PerPrincipal for specific usernames.
+	Principals map[string]Limit `yaml:"principals"`
+	// Procedures limits each principal's calls to a procedure,
+	// keyed by full procedure name (e.g., "/buf.registry.module.v1.GraphService/GetGraph").
+	Procedures map[string]Limit `yaml:"procedures"`
+	// GlobalProcedures limits the calls of all principals to a procedure, keyed like Procedures.
+	GlobalProcedures map[string]Limit `yaml:"global_procedures"`
+}
+
+// Limit is a token bucket refilled at Rate tokens per second, holding at most Burst tokens.
+// If Burst is not set, it defaults to Rate rounded up (minimum 1).
+type Limit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// Audit configures the audit log. Mutating requests and logins are always
+// recorded in the metadata docstore; ExportFile additionally appends them as JSON lines.
+type Audit struct {
+	// ExportFile is the path of a JSON lines file every audit record is appended to.
+	ExportFile string `yaml:"export_file"`
+	// TrustedProxies are the addresses or CIDR ranges of the reverse proxies whose
+	// X-Forwarded-For header is trusted for the client IP. Without any, the header is ignored.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// Mirror configures pull-through mirroring of an upstream BSR-compatible registry.
+// Commits are fetched through the upstream's v1 module APIs on first use and stored,
+// so that they are served without contacting the upstream from then on.
+type Mirror struct {
+	// Registry is the upstream host as it appears in module names and dependencies (e.g., "buf.build").
+	Registry string `yaml:"registry"`
+	// URL is the base URL of the upstream API (default: "https://" + Registry).
+	URL string `yaml:"url"`
+	// Token authenticates requests to the upstream (supports ${ENV_VAR} substitution).
+	Token string `yaml:"token"`
+	// Owners are globs of the owner names whose modules are looked up on the upstream when
+	// they don't exist here, e.g. "googleapis" or "bufbuild". Without any, module names are
+	// never looked up upstream; commits are still pulled by ID and as dependencies.
+	Owners []string `yaml:"owners"`
+	// RefreshInterval is how long the labels of mirrored modules are served from storage
+	// before they are refreshed from the upstream in the background (e.g., "1m", "1h").
+	// "0" refreshes them on every request. Default: "5m".
+	RefreshInterval string `yaml:"refresh_interval"`
+}
+
+// DefaultMirrorRefreshInterval is how long mirrored labels are served before they are refreshed by default.
+const DefaultMirrorRefreshInterval = 5 * time.Minute
+
+// GetRefreshInterval returns how long mirrored labels are served before they are refreshed.
+// If not configured or invalid, returns DefaultMirrorRefreshInterval.
+func (m Mirror) GetRefreshInterval() time.Duration {
+	if m.RefreshInterval == "" {
+		return DefaultMirrorRefreshInterval
+	}
+	d, err := ParseDuration(m.RefreshInterval)
+	if err != nil || d < 0 {
+		return DefaultMirrorRefreshInterval
+	}
+	return d
+}
+
+// MirrorsOwner reports whether the modules of an owner that don't exist here are looked up on the upstream.
+func (m Mirror) MirrorsOwner(owner string) bool {
+	for _, pattern := range m.Owners {
+		if ok, _ := path.Match(pattern, owner); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Breaking change modes.
+const (
+	BreakingOff    = "off"
+	BreakingWarn   = "warn"
+	BreakingReject = "reject"
+)
+
+// Breaking configures the detection of breaking changes on upload. Uploaded modules are
+// compared with the commits their target labels point to.
+type Breaking struct {
+	// Default is the policy of modules matching none of Modules.
+	Default BreakingPolicy `yaml:"default"`
+	// Modules maps "owner/module" globs (e.g., "acme/*") to policies. The longest matching glob wins.
+	Modules map[string]BreakingPolicy `yaml:"modules"`
+}
+
+// BreakingPolicy is the breaking change policy of a module.
+type BreakingPolicy struct {
+	// Mode is "off" (default), "warn" to record breaking changes on the commit,
+	// or "reject" to refuse uploads with breaking changes.
+	Mode string `yaml:"mode"`
+	// Rules is the rule set checked: "FILE" (default), "PACKAGE", "WIRE_JSON" or "WIRE".
+	Rules string `yaml:"rules"`
+}
+
+// PolicyFor returns the policy of a module, given as "owner/module".
+func (b *Breaking) PolicyFor(module string) BreakingPolicy {
+	if b == nil {
+		return BreakingPolicy{}
+	}
+	if policy, ok := matchModule(b.Modules, module); ok {
+		return policy
+	}
+	return b.Default
+}
+
+// matchModule returns the value of the longest glob of patterns matching a module.
+func matchModule[P any](patterns map[string]P, module string) (P, bool) {
+	var policy P
+	best := ""
+	for pattern, p := range patterns {
+		if ok, _ := path.Match(pattern, module); !ok {
+			continue
+		}
+		// Ties are broken by name so that the choice doesn't depend on map order
+		if best == "" || len(pattern) > len(best) || len(pattern) == len(best) && pattern < best {
+			policy, best = p, pattern
+		}
+	}
+	return policy, best != ""
+}
+
+// Lint configures the lint rules checked on upload, per owner.
+type Lint struct {
+	// Default is the policy of owners without an entry in Owners.
+	Default LintPolicy `yaml:"default"`
+	// Owners maps owner names to policies.
+	Owners map[string]LintPolicy `yaml:"owners"`
+}
+
+// LintPolicy is the lint policy of an owner. A rule listed in both Error and Warn is an error.
+type LintPolicy struct {
+	// Error are the rules whose violations reject the upload (e.g., "FIELD_LOWER_SNAKE_CASE").
+	Error []string `yaml:"error"`
+	// Warn are the rules whose violations are reported and recorded on the commit.
+	Warn []string `yaml:"warn"`
+}
+
+// PolicyFor returns the policy of an owner.
+func (l *Lint) PolicyFor(owner string) LintPolicy {
+	if l == nil {
+		return LintPolicy{}
+	}
+	if p, ok := l.Owners[owner]; ok {
+		return p
+	}
+	return l.Default
+}
+
+// Default upload limits.
+const (
+	DefaultMaxUploadBytes = 100 << 20
+	DefaultMaxUploadFiles = 10000
+	DefaultMaxFileBytes   = 10 << 20
+)
+
+// Remote dependency modes.
+const (
+	RemoteDepsWarn   = "warn"   // accept the upload unchecked, and warn
+	RemoteDepsReject = "reject" // refuse the upload
+)
+
+// Compile configures the compilation of uploaded modules.
+type Compile struct {
+	// RemoteDeps is how uploads depending on commits of other registries are handled. Their
+	// files are not stored here, so they can't be compiled, linted or checked for breaking
+	// changes: "warn" or "reject". Default: "warn". Uploads are rejected either way if a
+	// breaking change policy in reject mode or lint error rules apply to them.
+	RemoteDeps string `yaml:"remote_deps"`
+}
+
+// GetRemoteDeps returns the remote dependency mode, RemoteDepsWarn if not configured.
+func (c *Compile) GetRemoteDeps() string {
+	if c == nil || c.RemoteDeps != RemoteDepsReject {
+		return RemoteDepsWarn
+	}
+	return RemoteDepsReject
+}
+
+// Limits bounds the uploads of each owner.
+type Limits struct {
+	// Default applies to owners without an entry in Owners.
+	Default UploadLimits `yaml:"default"`
+	// Owners maps owner names to limits. Unset fields fall back to Default.
+	Owners map[string]UploadLimits `yaml:"owners"`
+}
+
+// UploadLimits are the limits of the modules an upload pushes for one owner.
+// Sizes are in bytes, and -1 removes a limit.
+type UploadLimits struct {
+	// MaxBytes is the total size of the files (default: 100 MiB).
+	MaxBytes int64 `yaml:"max_bytes"`
+	// MaxFiles is the number of files (default: 10000).
+	MaxFiles int `yaml:"max_files"`
+	// MaxFileBytes is the size of a single file (default: 10 MiB).
+	MaxFileBytes int64 `yaml:"max_file_bytes"`
+	// Extensions are the allowed file extensions (default: [".proto"]). A module's buf.yaml,
+	// buf.lock, README.md and LICENSE are always allowed.
+	Extensions []string `yaml:"extensions"`
+}
+
+// For returns the limits of an owner, with defaults applied.
+func (l *Limits) For(owner string) UploadLimits {
+	var limits, def UploadLimits
+	if l != nil {
+		def = l.Default
+		limits = l.Owners[owner]
+	}
+	limits.MaxBytes = cmp.Or(limits.MaxBytes, def.MaxBytes, DefaultMaxUploadBytes)
+	limits.MaxFiles = cmp.Or(limits.MaxFiles, def.MaxFiles, DefaultMaxUploadFiles)
+	limits.MaxFileBytes = cmp.Or(limits.MaxFileBytes, def.MaxFileBytes, DefaultMaxFileBytes)
+	if limits.Extensions == nil {
+		limits.Extensions = def.Extensions
+	}
+	if limits.Extensions == nil {
+		limits.Extensions = []string{".proto"}
+	}
+	return limits
+}
+
+// Quotas bounds the storage used by each owner, as accounted across all its modules.
+type Quotas struct {
+	// Default applies to owners without an entry in Owners.
+	Default Quota `yaml:"default"`
+	// Owners maps owner names to quotas. Unset fields fall back to Default.
+	Owners map[string]Quota `yaml:"owners"`
+}
+
+// Quota is the storage an owner may use. Uploads exceeding a soft quota succeed with a
+// warning, uploads exceeding a hard quota are rejected. Zero and -1 mean no quota, -1
+// also overriding the default.
+type Quota struct {
+	// SoftBytes and HardBytes bound the bytes of the unique files of the owner's commits.
+	SoftBytes int64 `yaml:"soft_bytes"`
+	HardBytes int64 `yaml:"hard_bytes"`
+	// SoftCommits and HardCommits bound the number of commits of the owner.
+	SoftCommits int64 `yaml:"soft_commits"`
+	HardCommits int64 `yaml:"hard_commits"`
+}
+
+// For returns the quota of an owner, with the default applied. Unbounded fields are zero.
+func (q *Quotas) For(owner string) Quota {
+	var quota, def Quota
+	if q != nil {
+		def = q.Default
+		quota = q.Owners[owner]
+	}
+	unbounded := func(v int64) int64 { return max(v, 0) }
+	quota.SoftBytes = unbounded(cmp.Or(quota.SoftBytes, def.SoftBytes))
+	quota.HardBytes = unbounded(cmp.Or(quota.HardBytes, def.HardBytes))
+	quota.SoftCommits = unbounded(cmp.Or(quota.SoftCommits, def.SoftCommits))
+	quota.HardCommits = unbounded(cmp.Or(quota.HardCommits, def.HardCommits))
+	return quota
+}
+
+// Default retention settings.
+const (
+	DefaultRetentionInterval    = 24 * time.Hour
+	DefaultRetentionGracePeriod = time.Hour
+)
+
+// Retention configures the pruning of old commits, followed by the collection of the
+// stored files no commit references anymore.
+type Retention struct {
+	// Interval is how often retention is applied (e.g., "12h", "7d"). Default: "24h".
+	Interval string `yaml:"interval"`
+	// GracePeriod is how long unreferenced files are kept, so that files stored by uploads
+	// in progress are not collected. Default: "1h".
+	GracePeriod string `yaml:"grace_period"`
+	// DryRun only logs the commits and files that would be deleted.
+	DryRun bool `yaml:"dry_run"`
+	// Modules maps "owner/module" globs (e.g., "acme/*") to policies. The longest matching
+	// glob wins. Modules matching none keep all their commits.
+	Modules map[string]RetentionPolicy `yaml:"modules"`
+}
+
+// RetentionPolicy selects the commits of a module to keep. Commits a label points at, and
+// commits other commits depend on, are always kept. The other commits are pruned unless a
+// rule keeps them.
+type RetentionPolicy struct {
+	// KeepDays keeps the commits created in the last KeepDays days.
+	KeepDays int `yaml:"keep_days"`
+	// KeepLast keeps the newest KeepLast commits, however old.
+	KeepLast int `yaml:"keep_last"`
+}
+
+// PolicyFor returns the policy of a module, given as "owner/module", and false if it
+// keeps all its commits.
+func (r *Retention) PolicyFor(module string) (RetentionPolicy, bool) {
+	if r == nil {
+		return RetentionPolicy{}, false
+	}
+	return matchModule(r.Modules, module)
+}
+
+// GetInterval returns how often retention is applied.
+// If not configured or invalid, returns DefaultRetentionInterval.
+func (r *Retention) GetInterval() time.Duration {
+	d, err := ParseDuration(r.Interval)
+	if err != nil || d <= 0 {
+		return DefaultRetentionInterval
+	}
+	return d
+}
+
+// GetGracePeriod returns how long unreferenced files are kept.
+// If not configured or invalid, returns DefaultRetentionGracePeriod.
+func (r *Retention) GetGracePeriod() time.Duration {
+	d, err := ParseDuration(r.GracePeriod)
+	if err != nil || d <= 0 {
+		return DefaultRetentionGracePeriod
+	}
+	return d
+}
+
+// DefaultDeletionGracePeriod is how long deleted modules can be restored by default.
+const DefaultDeletionGracePeriod = 30 * 24 * time.Hour
+
+// Dependents checks applied when deleting a module that commits of other modules depend on.
+const (
+	DependentsBlock = "block" // refuse the deletion
+	DependentsWarn  = "warn"  // delete, and warn about the dependents
+	DependentsOff   = "off"   // delete without checking
+)
+
+// Deletion configures how deleted modules are kept before they are purged.
+type Deletion struct {
+	// GracePeriod is how long a deleted module can be restored before it is purged
+	// (e.g., "7d", "72h"). Default: "30d".
+	GracePeriod string `yaml:"grace_period"`
+	// Dependents is the check applied when other modules depend on a module being
+	// deleted: "block", "warn" or "off". Default: "block".
+	Dependents string `yaml:"dependents"`
+}
+
+// GetGracePeriod returns how long deleted modules can be restored.
+// If not configured or invalid, returns DefaultDeletionGracePeriod.
+func (d *Deletion) GetGracePeriod() time.Duration {
+	if d == nil {
+		return DefaultDeletionGracePeriod
+	}
+	p, err := ParseDuration(d.GracePeriod)
+	if err != nil || p <= 0 {
+		return DefaultDeletionGracePeriod
+	}
+	return p
+}
+
+// GetDependents returns the dependents check, DependentsBlock if not configured.
+func (d *Deletion) GetDependents() string {
+	if d == nil {
+		return DependentsBlock
+	}
+	switch d.Dependents {
+	case DependentsWarn, DependentsOff:
+		return d.Dependents
+	}
+	return DependentsBlock
+}
+
+// DefaultRedirectPeriod is how long the former names of renamed modules resolve by default.
+const DefaultRedirectPeriod = 90 * 24 * time.Hour
+
+// Renames configures the redirects left behind by renamed modules.
+type Renames struct {
+	// RedirectPeriod is how long the former name of a renamed module keeps resolving to it,
+	// and can't be taken by another module (e.g., "30d", "720h"). "0" leaves no redirect.
+	// Default: "90d".
+	RedirectPeriod string `yaml:"redirect_period"`
+}
+
+// GetRedirectPeriod returns how long the former names of renamed modules resolve.
+// If not configured or invalid, returns DefaultRedirectPeriod.
+func (r *Renames) GetRedirectPeriod() time.Duration {
+	if r == nil || r.RedirectPeriod == "" {
+		return DefaultRedirectPeriod
+	}
+	d, err := ParseDuration(r.RedirectPeriod)
+	if err != nil || d < 0 {
+		return DefaultRedirectPeriod
+	}
+	return d
+}
+
 // Storage configures the backend storage using gocloud.dev URLs.
 // See https://gocloud.dev/howto/blob/ and https://gocloud.dev/howto/docstore/ for URL formats.
 type Storage struct {
@@ -54,10 +505,17 @@ type Storage struct {
 	DocstoreURL string `yaml:"docstore_url"`
 }
 
+// Module is a git repository whose branches and tags are synced as commits of a module.
 type Module struct {
-	Remote  string
-	Path    string
+	// Remote is the URL of the repository (https, ssh, scp-like or a local path).
+	Remote string
+	// Path is the directory of the module within the repository (default: the root).
+	Path string
+	// Filters are globs of the files included, relative to Path (default: ["*.proto"]).
+	// Globs without a slash match file names in any directory.
+	// buf.yaml, buf.lock, LICENSE and README.md at Path are always included.
 	Filters []string
+	// Shallow only fetches the tip of each branch and tag.
 	Shallow bool
 }
 
@@ -165,6 +623,12 @@ func ParseConfig(b []byte) (*Config, error) {
 		}
 		c.Credentials.ContainerRegistry[k] = v
 	}
+	for i, m := range c.Mirrors {
+		c.Mirrors[i].Token, err = envsubst.EvalEnv(m.Token)
+		if err != nil {
+			return nil, err
+		}
+	}
 	// TLS PEM env substitution
 	if c.TLS != nil {
 		if c.TLS.CertPEM != "" {
@@ -204,9 +668,32 @@ func ParseConfig(b []byte) (*Config, error) {
 			}
 		}
 	}
+	if err := c.validateModes(); err != nil {
+		return nil, err
+	}
 	return c, nil
 }
 
+// validateModes rejects unknown values of the settings that choose between modes, which
+// would otherwise silently get the default.
+func (c *Config) validateModes() error {
+	if c.Compile != nil {
+		switch c.Compile.RemoteDeps {
+		case "", RemoteDepsWarn, RemoteDepsReject:
+		default:
+			return fmt.Errorf("compile: unknown remote_deps %q, expected warn or reject", c.Compile.RemoteDeps)
+		}
+	}
+	if c.Deletion != nil {
+		switch c.Deletion.Dependents {
+		case "", DependentsBlock, DependentsWarn, DependentsOff:
+		default:
+			return fmt.Errorf("deletion: unknown dependents %q, expected block, warn or off", c.Deletion.Dependents)
+		}
+	}
+	return nil
+}
+
 func FromFile(f string) (*Config, error) {
 	b, err := os.ReadFile(f)
 	if err != nil {
@@ -262,3 +749,19 @@ func ParseDuration(s string) (time.Duration, error) {
 	// No days, use standard parsing
 	return time.ParseDuration(s)
 }
+
+// DefaultSyncInterval is the default interval of git module syncs.
+const DefaultSyncInterval = 10 * time.Minute
+
+// GetSyncInterval returns the configured git sync interval.
+// If not configured or invalid, returns DefaultSyncInterval.
+func (c *Config) GetSyncInterval() time.Duration {
+	if c.SyncInterval == "" {
+		return DefaultSyncInterval
+	}
+	d, err := ParseDuration(c.SyncInterval)
+	if err != nil {
+		return DefaultSyncInterval
+	}
+	return d
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseValidConfig(t *testing.T) {
@@ -131,6 +133,29 @@ func TestParseInvalidConfig(t *testing.T) {
 	}
 }
 
+func TestParseUnknownModes(t *testing.T) {
+	for _, tc := range []struct {
+		yaml string
+		want string
+	}{
+		{"compile:\n  remote_deps: rejct\n", `compile: unknown remote_deps "rejct"`},
+		{"deletion:\n  dependents: none\n", `deletion: unknown dependents "none"`},
+	} {
+		_, err := ParseConfig([]byte(tc.yaml))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("ParseConfig(%q) error = %v, want %q", tc.yaml, err, tc.want)
+		}
+	}
+
+	config, err := ParseConfig([]byte("compile:\n  remote_deps: reject\n"))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	if config.Compile.GetRemoteDeps() != RemoteDepsReject {
+		t.Errorf("remote deps = %q, want %q", config.Compile.GetRemoteDeps(), RemoteDepsReject)
+	}
+}
+
 func TestFromFile(t *testing.T) {
 	// Create a temporary file with test data
 	tempFile, err := os.CreateTemp("", "test_config_*.yaml")
@@ -156,3 +181,249 @@ func TestFromFile(t *testing.T) {
 		t.Errorf("Expected host 'localhost', got '%s'", config.Host)
 	}
 }
+
+func TestParseAuthorization(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+authorization:
+  policy_file: /etc/pbr/policy.yaml
+  groups:
+    platform: [alice, bob]
+    release: [alice]
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %s", err)
+	}
+
+	if config.Authorization == nil || config.Authorization.PolicyFile != "/etc/pbr/policy.yaml" {
+		t.Fatalf("Expected policy file '/etc/pbr/policy.yaml', got %+v", config.Authorization)
+	}
+
+	groups := config.Authorization.GroupsFor("alice")
+	if len(groups) != 2 || groups[0] != "platform" || groups[1] != "release" {
+		t.Errorf("Expected alice groups [platform release], got %v", groups)
+	}
+
+	if groups := config.Authorization.GroupsFor("carol"); len(groups) != 0 {
+		t.Errorf("Expected no groups for carol, got %v", groups)
+	}
+
+	var nilAuthz *Authorization
+	if groups := nilAuthz.GroupsFor("alice"); groups != nil {
+		t.Errorf("Expected nil groups without authorization config, got %v", groups)
+	}
+}
+
+func TestParseMirrors(t *testing.T) {
+	t.Setenv("BUF_TOKEN", "secret")
+	config, err := ParseConfig([]byte(`
+mirrors:
+  - registry: buf.build
+    token: ${BUF_TOKEN}
+    owners: [googleapis, "bufbuild*"]
+    refresh_interval: 0
+  - registry: upstream.example.com
+    url: http://localhost:8080
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	if len(config.Mirrors) != 2 {
+		t.Fatalf("expected 2 mirrors, got %d", len(config.Mirrors))
+	}
+	if config.Mirrors[0].Registry != "buf.build" || config.Mirrors[0].Token != "secret" {
+		t.Errorf("unexpected first mirror: %+v", config.Mirrors[0])
+	}
+	if config.Mirrors[1].URL != "http://localhost:8080" {
+		t.Errorf("unexpected second mirror: %+v", config.Mirrors[1])
+	}
+	for owner, want := range map[string]bool{"googleapis": true, "bufbuild-extra": true, "acme": false} {
+		if got := config.Mirrors[0].MirrorsOwner(owner); got != want {
+			t.Errorf("MirrorsOwner(%q) = %v, want %v", owner, got, want)
+		}
+	}
+	if config.Mirrors[1].MirrorsOwner("googleapis") {
+		t.Error("expected a mirror without owners to look up no names")
+	}
+	if got := config.Mirrors[0].GetRefreshInterval(); got != 0 {
+		t.Errorf("expected refresh interval 0, got %v", got)
+	}
+	if got := config.Mirrors[1].GetRefreshInterval(); got != DefaultMirrorRefreshInterval {
+		t.Errorf("expected default refresh interval, got %v", got)
+	}
+}
+
+func TestParseBreaking(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+breaking:
+  default:
+    mode: warn
+  modules:
+    "acme/*":
+      mode: reject
+      rules: WIRE_JSON
+    "acme/legacy":
+      mode: "off"
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+
+	tests := map[string]BreakingPolicy{
+		"acme/pets":   {Mode: BreakingReject, Rules: "WIRE_JSON"},
+		"acme/legacy": {Mode: BreakingOff},
+		"other/pets":  {Mode: BreakingWarn},
+	}
+	for module, want := range tests {
+		if got := config.Breaking.PolicyFor(module); got != want {
+			t.Errorf("PolicyFor(%s) = %+v, want %+v", module, got, want)
+		}
+	}
+
+	var nilBreaking *Breaking
+	if got := nilBreaking.PolicyFor("acme/pets"); got.Mode != "" {
+		t.Errorf("Expected no policy without breaking config, got %+v", got)
+	}
+}
+
+func TestParseLint(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+lint:
+  default:
+    warn: [COMMENT_SERVICE]
+  owners:
+    acme:
+      error: [FIELD_NO_PROTO3_OPTIONAL]
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	if p := config.Lint.PolicyFor("acme"); len(p.Error) != 1 || len(p.Warn) != 0 {
+		t.Errorf("unexpected acme policy: %+v", p)
+	}
+	if p := config.Lint.PolicyFor("other"); len(p.Warn) != 1 || p.Warn[0] != "COMMENT_SERVICE" {
+		t.Errorf("unexpected default policy: %+v", p)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+limits:
+  default:
+    max_files: 100
+  owners:
+    acme:
+      max_bytes: -1
+      extensions: [.proto, .json]
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	acme := config.Limits.For("acme")
+	if acme.MaxBytes != -1 || acme.MaxFiles != 100 || acme.MaxFileBytes != DefaultMaxFileBytes || len(acme.Extensions) != 2 {
+		t.Errorf("unexpected acme limits: %+v", acme)
+	}
+	other := config.Limits.For("other")
+	if other.MaxBytes != DefaultMaxUploadBytes || other.MaxFiles != 100 || len(other.Extensions) != 1 || other.Extensions[0] != ".proto" {
+		t.Errorf("unexpected default limits: %+v", other)
+	}
+
+	var nilLimits *Limits
+	if got := nilLimits.For("acme"); got.MaxFiles != DefaultMaxUploadFiles {
+		t.Errorf("expected built-in defaults without limits config, got %+v", got)
+	}
+}
+
+func TestParseQuotas(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+quotas:
+  default:
+    soft_bytes: 1000
+    hard_bytes: 2000
+  owners:
+    acme:
+      hard_bytes: -1
+      hard_commits: 10
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	if got, want := config.Quotas.For("acme"), (Quota{SoftBytes: 1000, HardCommits: 10}); got != want {
+		t.Errorf("acme quota = %+v, want %+v", got, want)
+	}
+	if got, want := config.Quotas.For("other"), (Quota{SoftBytes: 1000, HardBytes: 2000}); got != want {
+		t.Errorf("default quota = %+v, want %+v", got, want)
+	}
+
+	var nilQuotas *Quotas
+	if got := nilQuotas.For("acme"); got != (Quota{}) {
+		t.Errorf("expected no quota without quotas config, got %+v", got)
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+retention:
+  interval: 12h
+  modules:
+    "acme/*":
+      keep_days: 30
+    acme/pets:
+      keep_last: 5
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	r := config.Retention
+	if r.GetInterval() != 12*time.Hour || r.GetGracePeriod() != DefaultRetentionGracePeriod {
+		t.Errorf("unexpected durations %v, %v", r.GetInterval(), r.GetGracePeriod())
+	}
+	if p, ok := r.PolicyFor("acme/pets"); !ok || p != (RetentionPolicy{KeepLast: 5}) {
+		t.Errorf("acme/pets policy = %+v, %v", p, ok)
+	}
+	if p, ok := r.PolicyFor("acme/toys"); !ok || p != (RetentionPolicy{KeepDays: 30}) {
+		t.Errorf("acme/toys policy = %+v, %v", p, ok)
+	}
+	if _, ok := r.PolicyFor("other/pets"); ok {
+		t.Error("expected modules matching no glob to keep all commits")
+	}
+}
+
+func TestParseDeletion(t *testing.T) {
+	var unset *Deletion
+	if unset.GetGracePeriod() != DefaultDeletionGracePeriod || unset.GetDependents() != DependentsBlock {
+		t.Errorf("unexpected defaults %v, %q", unset.GetGracePeriod(), unset.GetDependents())
+	}
+
+	config, err := ParseConfig([]byte(`
+deletion:
+  grace_period: 7d
+  dependents: warn
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	d := config.Deletion
+	if d.GetGracePeriod() != 7*24*time.Hour || d.GetDependents() != DependentsWarn {
+		t.Errorf("unexpected deletion settings %v, %q", d.GetGracePeriod(), d.GetDependents())
+	}
+}
+
+func TestParseRenames(t *testing.T) {
+	for _, tc := range []struct {
+		yaml string
+		want time.Duration
+	}{
+		{"", DefaultRedirectPeriod},
+		{"renames: {}", DefaultRedirectPeriod},
+		{"renames:\n  redirect_period: 30d", 30 * 24 * time.Hour},
+		{"renames:\n  redirect_period: \"0\"", 0},
+	} {
+		config, err := ParseConfig([]byte(tc.yaml))
+		if err != nil {
+			t.Fatalf("ParseConfig(%q) failed: %v", tc.yaml, err)
+		}
+		if got := config.Renames.GetRedirectPeriod(); got != tc.want {
+			t.Errorf("%q: redirect period = %v, want %v", tc.yaml, got, tc.want)
+		}
+	}
+}
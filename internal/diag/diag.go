@@ -0,0 +1,27 @@
+// Package diag holds the diagnostics reported on proto files by the compiler, the linter
+// and breaking change detection.
+package diag
+
+import "fmt"
+
+// Diagnostic is a problem in a proto file. Line and Column are 1-based, and zero if the
+// problem has no position. Rule names the rule violated, empty for compiler errors.
+type Diagnostic struct {
+	Rule    string `json:"rule,omitempty"`
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// String formats d as "file:line:column: message (RULE)", leaving out what it doesn't have.
+func (d Diagnostic) String() string {
+	s := d.File + ": " + d.Message
+	if d.Line > 0 {
+		s = fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+	}
+	if d.Rule != "" {
+		s += " (" + d.Rule + ")"
+	}
+	return s
+}
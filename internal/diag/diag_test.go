@@ -0,0 +1,20 @@
+package diag
+
+import "testing"
+
+func TestDiagnosticString(t *testing.T) {
+	tests := []struct {
+		d    Diagnostic
+		want string
+	}{
+		{Diagnostic{File: "a.proto", Line: 3, Column: 5, Message: "syntax error"}, "a.proto:3:5: syntax error"},
+		{Diagnostic{File: "a.proto", Message: "file is also provided by a dependency"}, "a.proto: file is also provided by a dependency"},
+		{Diagnostic{Rule: "FIELD_NO_DELETE", File: "a.proto", Line: 1, Column: 1, Message: "field deleted"}, "a.proto:1:1: field deleted (FIELD_NO_DELETE)"},
+		{Diagnostic{Rule: "FILE_NO_DELETE", File: "a.proto", Message: "file deleted"}, "a.proto: file deleted (FILE_NO_DELETE)"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package gitsync
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greatliontech/pbr/internal/config"
+)
+
+// remoteHostPath returns the host and repository path of a remote URL, without user info or ".git" suffix.
+// It handles URLs and scp-like "user@host:path" remotes. Local paths return an empty host.
+func remoteHostPath(remote string) (host, repoPath string) {
+	if u, err := url.Parse(remote); err == nil && strings.Contains(remote, "://") {
+		if u.Scheme == "file" {
+			return "", ""
+		}
+		return u.Hostname(), strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	}
+	// scp-like syntax, a colon before any slash
+	if i := strings.Index(remote, ":"); i > 0 && !strings.Contains(remote[:i], "/") {
+		host := remote[:i]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		return host, strings.TrimSuffix(strings.Trim(remote[i+1:], "/"), ".git")
+	}
+	return "", ""
+}
+
+// transportHelper matches the "<transport>::<address>" syntax of git remote helpers, such as ext::.
+var transportHelper = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*::`)
+
+// checkRemote rejects remotes that git would take as an option or hand to a remote helper.
+func checkRemote(remote string) error {
+	if strings.HasPrefix(remote, "-") {
+		return fmt.Errorf("invalid remote %q", remote)
+	}
+	if transportHelper.MatchString(remote) {
+		return fmt.Errorf("remote %q: remote helpers are not supported", remote)
+	}
+	return nil
+}
+
+// ValidateRemote checks a remote given by a client rather than the configuration: besides
+// what checkRemote rejects, it must be a network remote, over HTTP(S), SSH or git, and not a
+// local path or file:// URL.
+func ValidateRemote(remote string) error {
+	if err := checkRemote(remote); err != nil {
+		return err
+	}
+	if u, err := url.Parse(remote); err == nil && strings.Contains(remote, "://") {
+		switch u.Scheme {
+		case "https", "http", "ssh", "git+ssh", "git":
+		default:
+			return fmt.Errorf("remote %q: unsupported scheme %q", remote, u.Scheme)
+		}
+	}
+	if host, _ := remoteHostPath(remote); host == "" {
+		return fmt.Errorf("remote %q: local repositories are not allowed", remote)
+	}
+	return nil
+}
+
+// isSSH reports whether the remote is reached over SSH.
+func isSSH(remote string) bool {
+	if u, err := url.Parse(remote); err == nil && strings.Contains(remote, "://") {
+		return u.Scheme == "ssh" || u.Scheme == "git+ssh"
+	}
+	host, _ := remoteHostPath(remote)
+	return host != ""
+}
+
+// commitURL returns a browsable URL of a commit, in the format of GitHub and GitLab.
+// Local remotes have no commit URL.
+func commitURL(remote, commit string) string {
+	host, repoPath := remoteHostPath(remote)
+	if host == "" {
+		return ""
+	}
+	return "https://" + host + "/" + repoPath + "/commit/" + commit
+}
+
+// credentialsFor returns the git credentials whose glob matches the remote's "host/path".
+// When several globs match, the longest one wins.
+func credentialsFor(creds map[string]config.GitAuth, remote string) (config.GitAuth, bool) {
+	host, repoPath := remoteHostPath(remote)
+	if host == "" {
+		return config.GitAuth{}, false
+	}
+	name := host + "/" + repoPath
+
+	globs := make([]string, 0, len(creds))
+	for glob := range creds {
+		globs = append(globs, glob)
+	}
+	slices.SortFunc(globs, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return strings.Compare(a, b)
+	})
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return creds[glob], true
+		}
+	}
+	return config.GitAuth{}, false
+}
+
+// authEnv returns the environment that authenticates git to the remote, and a function
+// removing any temporary files it references. Secrets are passed as environment variables,
+// so they don't show up in process listings.
+func (s *Syncer) authEnv(ctx context.Context, remote string) ([]string, func(), error) {
+	noop := func() {}
+	auth, ok := credentialsFor(s.creds, remote)
+	if !ok {
+		return nil, noop, nil
+	}
+
+	if isSSH(remote) {
+		if auth.SSHKey == "" {
+			return nil, noop, nil
+		}
+		f, err := os.CreateTemp("", "pbr-ssh-key-*")
+		if err != nil {
+			return nil, noop, err
+		}
+		cleanup := func() { os.Remove(f.Name()) }
+		key := auth.SSHKey
+		if !strings.HasSuffix(key, "\n") {
+			key += "\n"
+		}
+		if _, err := f.WriteString(key); err != nil {
+			f.Close()
+			cleanup()
+			return nil, noop, err
+		}
+		if err := f.Close(); err != nil {
+			cleanup()
+			return nil, noop, err
+		}
+		sshCmd := fmt.Sprintf("ssh -i '%s' -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new", f.Name())
+		return []string{"GIT_SSH_COMMAND=" + sshCmd}, cleanup, nil
+	}
+
+	var username, password string
+	switch {
+	case auth.Token != "":
+		username, password = "x-access-token", auth.Token
+	case auth.Basic != nil:
+		username, password = auth.Basic.Username, auth.Basic.Password
+	case auth.GithubApp != nil:
+		host, _ := remoteHostPath(remote)
+		token, err := s.appTokens.token(ctx, host, auth.GithubApp)
+		if err != nil {
+			return nil, noop, fmt.Errorf("failed to get GitHub App token: %w", err)
+		}
+		username, password = "x-access-token", token
+	default:
+		return nil, noop, nil
+	}
+	basic := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + basic,
+	}, noop, nil
+}
+
+// appTokenCache exchanges GitHub App credentials for installation access tokens,
+// reusing tokens until shortly before they expire.
+type appTokenCache struct {
+	client *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]appToken
+}
+
+type appToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+func (c *appTokenCache) token(ctx context.Context, host string, app *config.GithubAppGitAuth) (string, error) {
+	key := fmt.Sprintf("%s/%d/%d", host, app.AppID, app.InstallationID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.tokens[key]; ok && time.Until(t.expiresAt) > time.Minute {
+		return t.token, nil
+	}
+
+	jwt, err := appJWT(app, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	// GitHub Enterprise Server serves the API under /api/v3
+	api := "https://api.github.com"
+	if host != "github.com" {
+		api = "https://" + host + "/api/v3"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/app/installations/%d/access_tokens", api, app.InstallationID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var body struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid response: %w", err)
+	}
+
+	if c.tokens == nil {
+		c.tokens = map[string]appToken{}
+	}
+	c.tokens[key] = appToken{token: body.Token, expiresAt: body.ExpiresAt}
+	return body.Token, nil
+}
+
+// appJWT returns the RS256 JSON Web Token authenticating as the GitHub App.
+func appJWT(app *config.GithubAppGitAuth, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(app.PrivateKey))
+	if block == nil {
+		return "", errors.New("invalid private key: no PEM block")
+	}
+	var key *rsa.PrivateKey
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		key = k
+	} else {
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("invalid private key: %w", err)
+		}
+		rsaKey, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return "", errors.New("invalid private key: not an RSA key")
+		}
+		key = rsaKey
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		// Backdated to allow for clock drift, GitHub rejects expirations over 10 minutes
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": fmt.Sprint(app.AppID),
+	})
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
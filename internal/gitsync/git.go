@@ -0,0 +1,195 @@
+package gitsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+// repo is a bare repository in the cache directory, driven through the git CLI.
+type repo struct {
+	dir string
+	env []string
+}
+
+// ref is a branch or tag and the commit it points to.
+type ref struct {
+	name   string // full ref name, e.g. "refs/tags/v1.0.0"
+	commit string
+}
+
+// label returns the ref name without its refs/heads/ or refs/tags/ prefix.
+func (r ref) label() string {
+	if name, ok := strings.CutPrefix(r.name, "refs/heads/"); ok {
+		return name
+	}
+	return strings.TrimPrefix(r.name, "refs/tags/")
+}
+
+func (r *repo) git(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", r.dir}, args...)...)
+	cmd.Env = append(os.Environ(), r.env...)
+	cmd.Env = append(cmd.Env, "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdin = stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// fetch initializes the repository if needed and mirrors the branches and tags of the remote.
+func (r *repo) fetch(ctx context.Context, remote string, shallow bool) error {
+	if _, err := os.Stat(filepath.Join(r.dir, "HEAD")); os.IsNotExist(err) {
+		if err := os.MkdirAll(r.dir, 0o755); err != nil {
+			return err
+		}
+		if _, err := r.git(ctx, nil, "init", "--bare", "--quiet"); err != nil {
+			return err
+		}
+	}
+	args := []string{"fetch", "--quiet", "--prune", "--no-tags"}
+	if shallow {
+		args = append(args, "--depth=1")
+	} else if _, err := os.Stat(filepath.Join(r.dir, "shallow")); err == nil {
+		args = append(args, "--unshallow")
+	}
+	if err := checkRemote(remote); err != nil {
+		return err
+	}
+	args = append(args, "--", remote, "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+	_, err := r.git(ctx, nil, args...)
+	return err
+}
+
+// refs lists the branches and tags, with annotated tags peeled to their commit.
+func (r *repo) refs(ctx context.Context) ([]ref, error) {
+	out, err := r.git(ctx, nil, "for-each-ref", "--format=%(refname)%09%(objecttype)%09%(objectname)%09%(*objecttype)%09%(*objectname)", "refs/heads", "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+	var refs []ref
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 {
+			continue
+		}
+		switch {
+		case fields[1] == "commit":
+			refs = append(refs, ref{name: fields[0], commit: fields[2]})
+		case fields[3] == "commit":
+			refs = append(refs, ref{name: fields[0], commit: fields[4]})
+		}
+		// Tags of trees or blobs are skipped
+	}
+	return refs, nil
+}
+
+// revList returns the commits of a revision range, oldest first, limited to those changing files under dir.
+func (r *repo) revList(ctx context.Context, rng, dir string) ([]string, error) {
+	if strings.HasPrefix(rng, "-") {
+		return nil, fmt.Errorf("invalid revision range %q", rng)
+	}
+	args := []string{"rev-list", "--reverse", "--topo-order", rng}
+	if dir = strings.Trim(path.Clean("/"+dir), "/"); dir != "" {
+		args = append(args, "--", dir)
+	}
+	out, err := r.git(ctx, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// commitTime returns the committer date of a commit.
+func (r *repo) commitTime(ctx context.Context, commit string) (time.Time, error) {
+	out, err := r.git(ctx, nil, "show", "-s", "--format=%ct", commit)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sec, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid commit time: %w", err)
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+// files returns the files of a commit under dir whose paths, relative to dir, are accepted by keep.
+// Returned paths are relative to dir.
+func (r *repo) files(ctx context.Context, commit, dir string, keep func(string) bool) ([]registry.File, error) {
+	dir = strings.Trim(path.Clean("/"+dir), "/")
+	args := []string{"ls-tree", "-r", "-z", commit}
+	if dir != "" {
+		args = append(args, "--", dir+"/")
+	}
+	out, err := r.git(ctx, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []registry.File
+	var objects bytes.Buffer
+	for _, entry := range bytes.Split(out, []byte{0}) {
+		// <mode> SP <type> SP <object> TAB <path>
+		meta, name, ok := strings.Cut(string(entry), "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 3 || fields[1] != "blob" || fields[0] == "120000" {
+			continue
+		}
+		rel := name
+		if dir != "" {
+			rel = strings.TrimPrefix(name, dir+"/")
+		}
+		if !keep(rel) {
+			continue
+		}
+		files = append(files, registry.File{Path: rel})
+		objects.WriteString(fields[2] + "\n")
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	out, err = r.git(ctx, &objects, "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(bytes.NewReader(out))
+	for i := range files {
+		// <object> SP <type> SP <size> LF <contents> LF
+		header, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("read object header: %w", err)
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected object header %q", strings.TrimSpace(header))
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected object header %q", strings.TrimSpace(header))
+		}
+		content := make([]byte, size+1)
+		if _, err := io.ReadFull(br, content); err != nil {
+			return nil, fmt.Errorf("read object %s: %w", fields[0], err)
+		}
+		files[i].Content = string(content[:size])
+	}
+	return files, nil
+}
@@ -0,0 +1,291 @@
+// Package gitsync keeps modules in sync with the git repositories configured in config.Modules.
+package gitsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// ErrUnknownModule is returned when syncing a module that is not configured.
+var ErrUnknownModule = errors.New("module is not configured for git sync")
+
+// defaultFilters select the files of a module when none are configured.
+var defaultFilters = []string{"*.proto"}
+
+// moduleFiles are included from the root of the module path regardless of filters.
+var moduleFiles = []string{"buf.yaml", "buf.lock", "LICENSE", "README.md"}
+
+// DepResolver returns the dependency record of a commit hosted by a registry,
+// for the dependencies listed in buf.lock.
+type DepResolver func(ctx context.Context, registry, commitID string) (storage.DepRecord, error)
+
+// Option configures a Syncer.
+type Option func(*Syncer)
+
+// WithDepResolver sets how buf.lock dependencies are resolved.
+// By default, only dependencies on this registry are resolved.
+func WithDepResolver(resolve DepResolver) Option {
+	return func(s *Syncer) {
+		s.resolveDep = resolve
+	}
+}
+
+// Syncer creates commits from the branches and tags of git repositories.
+type Syncer struct {
+	reg        *registry.Registry
+	modules    map[string]config.Module
+	creds      map[string]config.GitAuth
+	dir        string
+	interval   time.Duration
+	resolveDep DepResolver
+
+	appTokens *appTokenCache
+
+	mu     sync.Mutex        // serializes syncs, they share the repository cache
+	synced map[string]string // module + ref -> git commit last synced
+}
+
+// New creates a Syncer for the modules of the config, keyed "owner/module".
+// Repositories are cached under CacheDir/git.
+func New(reg *registry.Registry, c *config.Config, opts ...Option) (*Syncer, error) {
+	for name, mod := range c.Modules {
+		if err := validate(name, mod); err != nil {
+			return nil, err
+		}
+	}
+	s := &Syncer{
+		reg:       reg,
+		modules:   c.Modules,
+		creds:     c.Credentials.Git,
+		dir:       filepath.Join(c.CacheDir, "git"),
+		interval:  c.GetSyncInterval(),
+		appTokens: &appTokenCache{client: http.DefaultClient},
+		synced:    map[string]string{},
+	}
+	s.resolveDep = s.localDep
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+func validate(name string, mod config.Module) error {
+	owner, module, ok := strings.Cut(name, "/")
+	if !ok || owner == "" || module == "" || strings.Contains(module, "/") {
+		return fmt.Errorf("invalid module name %q, expected owner/module", name)
+	}
+	if mod.Remote == "" {
+		return fmt.Errorf("module %s: remote is required", name)
+	}
+	if err := checkRemote(mod.Remote); err != nil {
+		return fmt.Errorf("module %s: %w", name, err)
+	}
+	for _, filter := range mod.Filters {
+		if _, err := path.Match(filter, ""); err != nil {
+			return fmt.Errorf("module %s: invalid filter %q: %w", name, filter, err)
+		}
+	}
+	return nil
+}
+
+// Module returns the configuration of a synced module.
+func (s *Syncer) Module(name string) (config.Module, bool) {
+	mod, ok := s.modules[name]
+	return mod, ok
+}
+
+func (s *Syncer) localDep(ctx context.Context, registry, commitID string) (storage.DepRecord, error) {
+	if registry != "" && registry != s.reg.HostName() {
+		return storage.DepRecord{}, fmt.Errorf("dependency %s on %s: remote dependencies are not supported", commitID, registry)
+	}
+	commit, err := s.reg.CommitByID(ctx, commitID)
+	if err != nil {
+		return storage.DepRecord{}, fmt.Errorf("dependency commit %s: %w", commitID, err)
+	}
+	return commit.AsDep(), nil
+}
+
+// Run syncs all modules immediately and then on every interval, until ctx is done.
+// With a zero interval, modules are only synced once.
+func (s *Syncer) Run(ctx context.Context) {
+	for {
+		if err := s.SyncAll(ctx); err != nil {
+			slog.ErrorContext(ctx, "git sync failed", "error", err)
+		}
+		if s.interval <= 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// SyncAll syncs every configured module. A module failing to sync doesn't stop the others;
+// their errors are joined.
+func (s *Syncer) SyncAll(ctx context.Context) error {
+	names := make([]string, 0, len(s.modules))
+	for name := range s.modules {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var errs []error
+	for _, name := range names {
+		if err := s.Sync(ctx, name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sync fetches the repository of a module and commits the files of every branch and tag
+// that changed since the last sync, labeled with the branch or tag name.
+func (s *Syncer) Sync(ctx context.Context, name string) error {
+	conf, ok := s.modules[name]
+	if !ok {
+		return fmt.Errorf("%s: %w", name, ErrUnknownModule)
+	}
+	owner, module, _ := strings.Cut(name, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slog.DebugContext(ctx, "Syncer.Sync", "module", name, "remote", conf.Remote)
+
+	r, mod, cleanup, err := s.open(ctx, owner, module, conf, conf.Shallow)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	defer cleanup()
+
+	refs, err := r.refs(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	keep := fileFilter(conf.Filters)
+	for _, ref := range refs {
+		key := name + "\x00" + ref.name
+		if s.synced[key] == ref.commit {
+			continue
+		}
+		commit, err := s.commitRevision(ctx, r, mod, conf, keep, ref.commit, time.Now(), []string{ref.label()})
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", name, ref.name, err)
+		}
+		if commit != nil {
+			slog.InfoContext(ctx, "synced git ref", "module", name, "ref", ref.name, "gitCommit", ref.commit, "commitID", commit.ID)
+		}
+		s.synced[key] = ref.commit
+	}
+	return nil
+}
+
+// open fetches the repository of a module and returns it along with the module, creating the module if needed.
+// The returned function releases the credentials used for fetching.
+func (s *Syncer) open(ctx context.Context, owner, module string, conf config.Module, shallow bool) (*repo, *registry.Module, func(), error) {
+	env, cleanup, err := s.authEnv(ctx, conf.Remote)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sum := sha256.Sum256([]byte(conf.Remote))
+	r := &repo{dir: filepath.Join(s.dir, hex.EncodeToString(sum[:8])), env: env}
+	if err := r.fetch(ctx, conf.Remote, shallow); err != nil {
+		cleanup()
+		return nil, nil, nil, fmt.Errorf("failed to fetch %s: %w", conf.Remote, err)
+	}
+
+	mod, err := s.reg.GetOrCreateModule(ctx, owner, module)
+	if err != nil {
+		cleanup()
+		return nil, nil, nil, err
+	}
+	if mod.Mirror() != "" {
+		cleanup()
+		return nil, nil, nil, fmt.Errorf("module is mirrored from %s", mod.Mirror())
+	}
+	return r, mod, cleanup, nil
+}
+
+// commitRevision commits the module files of a git commit, with the dependencies of its buf.lock.
+// It returns nil if the revision has no module files.
+func (s *Syncer) commitRevision(ctx context.Context, r *repo, mod *registry.Module, conf config.Module, keep func(string) bool, gitCommit string, createTime time.Time, labels []string) (*registry.Commit, error) {
+	files, err := r.files(ctx, gitCommit, conf.Path, keep)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		slog.DebugContext(ctx, "no module files", "module", mod.Owner()+"/"+mod.Name(), "gitCommit", gitCommit)
+		return nil, nil
+	}
+	deps, err := s.lockDeps(ctx, files)
+	if err != nil {
+		return nil, err
+	}
+	return mod.CreateCommitAt(ctx, createTime, files, labels, commitURL(conf.Remote, gitCommit), "", deps)
+}
+
+// lockDeps resolves the dependencies pinned by the buf.lock among the files, if any.
+func (s *Syncer) lockDeps(ctx context.Context, files []registry.File) ([]storage.DepRecord, error) {
+	var deps []storage.DepRecord
+	for _, f := range files {
+		if f.Path != "buf.lock" {
+			continue
+		}
+		lock, err := registry.ParseBufLock(f.Content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid buf.lock: %w", err)
+		}
+		for _, dep := range lock.Deps {
+			rec, err := s.resolveDep(ctx, dep.Remote, dep.Commit)
+			if err != nil {
+				return nil, err
+			}
+			deps = append(deps, rec)
+		}
+	}
+	return deps, nil
+}
+
+// fileFilter returns whether a path, relative to the module path, belongs to the module.
+// A filter without a slash matches file names in any directory.
+func fileFilter(filters []string) func(string) bool {
+	if len(filters) == 0 {
+		filters = defaultFilters
+	}
+	return func(p string) bool {
+		if slices.Contains(moduleFiles, p) {
+			return true
+		}
+		for _, filter := range filters {
+			if ok, _ := path.Match(filter, p); ok {
+				return true
+			}
+			if !strings.Contains(filter, "/") {
+				if ok, _ := path.Match(filter, path.Base(p)); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
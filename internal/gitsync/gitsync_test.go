@@ -0,0 +1,314 @@
+package gitsync
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/docstore/memdocstore"
+)
+
+func setupTestRegistry(t *testing.T) *registry.Registry {
+	t.Helper()
+
+	bucket := memblob.OpenBucket(nil)
+	owners, _ := memdocstore.OpenCollection("ID", nil)
+	modules, _ := memdocstore.OpenCollection("ID", nil)
+	commits, _ := memdocstore.OpenCollection("ID", nil)
+	labels, _ := memdocstore.OpenCollection("ID", nil)
+	t.Cleanup(func() {
+		bucket.Close()
+		owners.Close()
+		modules.Close()
+		commits.Close()
+		labels.Close()
+	})
+
+	metadataStore := storage.NewMetadataStore(owners, modules, commits, labels)
+	return registry.New(storage.NewBlobStore(bucket), storage.NewManifestStore(bucket), metadataStore, "test.registry.com")
+}
+
+// testRepo is a working tree pushing to a local bare repository.
+type testRepo struct {
+	t      *testing.T
+	work   string
+	remote string
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	r := &testRepo{t: t, work: filepath.Join(dir, "work"), remote: filepath.Join(dir, "remote.git")}
+	r.git(dir, "init", "--bare", "--quiet", r.remote)
+	r.git(dir, "init", "--quiet", "-b", "main", r.work)
+	return r
+}
+
+func (r *testRepo) git(dir string, args ...string) string {
+	r.t.Helper()
+	return r.gitEnv(dir, nil, args...)
+}
+
+func (r *testRepo) gitEnv(dir string, env []string, args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes the files, commits them and pushes all branches and tags.
+func (r *testRepo) commit(files map[string]string) string {
+	r.t.Helper()
+	return r.commitAt("", files)
+}
+
+// commitAt is like commit, with the commit dated date (RFC 3339) if set.
+func (r *testRepo) commitAt(date string, files map[string]string) string {
+	r.t.Helper()
+	for name, content := range files {
+		p := filepath.Join(r.work, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			r.t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	r.git(r.work, "add", "-A")
+	var env []string
+	if date != "" {
+		env = []string{"GIT_AUTHOR_DATE=" + date, "GIT_COMMITTER_DATE=" + date}
+	}
+	r.gitEnv(r.work, env, "commit", "--quiet", "-m", "update")
+	r.push()
+	return r.git(r.work, "rev-parse", "HEAD")
+}
+
+func (r *testRepo) push() {
+	r.t.Helper()
+	r.git(r.work, "push", "--quiet", "--force", r.remote, "refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*")
+}
+
+func TestSyncer_Sync(t *testing.T) {
+	ctx := context.Background()
+	reg := setupTestRegistry(t)
+	repo := newTestRepo(t)
+
+	repo.commit(map[string]string{
+		"proto/buf.yaml":              "version: v1\n",
+		"proto/acme/v1/acme.proto":    "syntax = \"proto3\";\npackage acme.v1;\n",
+		"proto/acme/v1/notes.txt":     "not part of the module",
+		"other/ignored.proto":         "syntax = \"proto3\";\n",
+		"proto/acme/v1/extra/x.proto": "syntax = \"proto3\";\npackage acme.v1.extra;\n",
+	})
+	repo.git(repo.work, "tag", "-a", "v1.0.0", "-m", "release")
+	repo.push()
+
+	syncer, err := New(reg, &config.Config{
+		CacheDir: t.TempDir(),
+		Modules: map[string]config.Module{
+			"acme/petapis": {Remote: repo.remote, Path: "proto"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := syncer.Sync(ctx, "acme/petapis"); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	mod, err := reg.Module(ctx, "acme", "petapis")
+	if err != nil {
+		t.Fatalf("module not created: %v", err)
+	}
+	files, main, err := mod.FilesAndCommit(ctx, "main")
+	if err != nil {
+		t.Fatalf("main label not synced: %v", err)
+	}
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	want := "acme/v1/acme.proto,acme/v1/extra/x.proto,buf.yaml"
+	if got := strings.Join(paths, ","); got != want {
+		t.Errorf("files = %s, want %s", got, want)
+	}
+	tagged, err := mod.Commit(ctx, "v1.0.0")
+	if err != nil {
+		t.Fatalf("tag label not synced: %v", err)
+	}
+	if tagged.ID != main.ID {
+		t.Errorf("tag and branch of the same tree should share a commit")
+	}
+
+	// Unchanged refs create no commits
+	if err := syncer.Sync(ctx, "acme/petapis"); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	commits, _, err := mod.ListCommits(ctx, 10, "")
+	if err != nil {
+		t.Fatalf("ListCommits failed: %v", err)
+	}
+	if len(commits) != 1 {
+		t.Errorf("got %d commits, want 1", len(commits))
+	}
+
+	// A new commit moves the branch label only
+	repo.commit(map[string]string{"proto/acme/v1/acme.proto": "syntax = \"proto3\";\npackage acme.v1;\nmessage Pet {}\n"})
+	if err := syncer.SyncAll(ctx); err != nil {
+		t.Fatalf("SyncAll failed: %v", err)
+	}
+	updated, err := mod.Commit(ctx, "main")
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if updated.ID == main.ID {
+		t.Error("main label should point to the new commit")
+	}
+	if tagged, _ := mod.Commit(ctx, "v1.0.0"); tagged.ID != main.ID {
+		t.Error("tag label should not move")
+	}
+}
+
+func TestSyncer_Filters(t *testing.T) {
+	ctx := context.Background()
+	reg := setupTestRegistry(t)
+	repo := newTestRepo(t)
+	repo.commit(map[string]string{
+		"a.proto":          "syntax = \"proto3\";\n",
+		"internal/b.proto": "syntax = \"proto3\";\n",
+		"LICENSE":          "MIT",
+	})
+
+	syncer, err := New(reg, &config.Config{
+		CacheDir: t.TempDir(),
+		Modules: map[string]config.Module{
+			"acme/filtered": {Remote: repo.remote, Filters: []string{"internal/*.proto"}, Shallow: true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := syncer.Sync(ctx, "acme/filtered"); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	mod, err := reg.Module(ctx, "acme", "filtered")
+	if err != nil {
+		t.Fatalf("module not created: %v", err)
+	}
+	files, _, err := mod.FilesAndCommit(ctx, "main")
+	if err != nil {
+		t.Fatalf("FilesAndCommit failed: %v", err)
+	}
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	if got := strings.Join(paths, ","); got != "LICENSE,internal/b.proto" {
+		t.Errorf("files = %s", got)
+	}
+}
+
+func TestSyncer_UnknownModule(t *testing.T) {
+	syncer, err := New(setupTestRegistry(t), &config.Config{CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := syncer.Sync(context.Background(), "acme/missing"); err == nil {
+		t.Error("expected error for unconfigured module")
+	}
+}
+
+func TestNew_InvalidModuleName(t *testing.T) {
+	_, err := New(setupTestRegistry(t), &config.Config{
+		Modules: map[string]config.Module{"petapis": {Remote: "/tmp/repo"}},
+	})
+	if err == nil {
+		t.Error("expected error for module name without owner")
+	}
+}
+
+func TestNew_InvalidRemote(t *testing.T) {
+	for _, remote := range []string{"--upload-pack=touch /tmp/pwned", "ext::sh -c touch% /tmp/pwned"} {
+		_, err := New(setupTestRegistry(t), &config.Config{
+			Modules: map[string]config.Module{"acme/petapis": {Remote: remote}},
+		})
+		if err == nil {
+			t.Errorf("expected error for remote %q", remote)
+		}
+	}
+}
+
+func TestValidateRemote(t *testing.T) {
+	tests := []struct {
+		remote string
+		valid  bool
+	}{
+		{"https://github.com/acme/apis.git", true},
+		{"git@github.com:acme/apis.git", true},
+		{"ssh://git@[::1]:2222/group/apis", true},
+		{"/srv/git/apis.git", false},
+		{"file:///srv/git/apis.git", false},
+		{"-oProxyCommand=touch /tmp/pwned", false},
+		{"ext::sh -c touch% /tmp/pwned", false},
+		{"fd::17", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateRemote(tt.remote); (err == nil) != tt.valid {
+			t.Errorf("ValidateRemote(%q) = %v, want valid %v", tt.remote, err, tt.valid)
+		}
+	}
+}
+
+func TestCommitURL(t *testing.T) {
+	tests := []struct {
+		remote string
+		want   string
+	}{
+		{"https://github.com/acme/apis.git", "https://github.com/acme/apis/commit/abc"},
+		{"git@github.com:acme/apis.git", "https://github.com/acme/apis/commit/abc"},
+		{"ssh://git@gitlab.example.com:2222/group/apis", "https://gitlab.example.com/group/apis/commit/abc"},
+		{"/srv/git/apis.git", ""},
+		{"file:///srv/git/apis.git", ""},
+	}
+	for _, tt := range tests {
+		if got := commitURL(tt.remote, "abc"); got != tt.want {
+			t.Errorf("commitURL(%q) = %q, want %q", tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestCredentialsFor(t *testing.T) {
+	creds := map[string]config.GitAuth{
+		"github.com/*/*":    {Token: "org-wide"},
+		"github.com/acme/*": {Token: "acme"},
+	}
+	tests := []struct {
+		remote string
+		want   string
+	}{
+		{"https://github.com/acme/apis.git", "acme"},
+		{"git@github.com:other/apis.git", "org-wide"},
+		{"https://gitlab.com/acme/apis.git", ""},
+	}
+	for _, tt := range tests {
+		auth, _ := credentialsFor(creds, tt.remote)
+		if auth.Token != tt.want {
+			t.Errorf("credentialsFor(%q) = %q, want %q", tt.remote, auth.Token, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/greatliontech/pbr/internal/config"
+)
+
+// Revision is a git commit imported as a commit of a module.
+type Revision struct {
+	GitCommit  string    `json:"git_commit"`
+	CommitID   string    `json:"commit_id"`
+	CreateTime time.Time `json:"create_time"`
+	Labels     []string  `json:"labels,omitempty"`
+}
+
+// Import creates a commit for each revision in the history of a module's repository, oldest first.
+// Without rng, every tagged git commit is imported. With rng (e.g., "v1.0.0..main"), every git commit
+// of the range that changes files under the module path is imported.
+// Commits keep the committer date and URL of their git commit, and tags become labels.
+// Revisions whose files were committed before reuse the existing commit, so an import can be re-run
+// to pick up new tags.
+func (s *Syncer) Import(ctx context.Context, name string, conf config.Module, rng string) ([]Revision, error) {
+	if err := validate(name, conf); err != nil {
+		return nil, err
+	}
+	owner, module, _ := strings.Cut(name, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slog.DebugContext(ctx, "Syncer.Import", "module", name, "remote", conf.Remote, "range", rng)
+
+	// History needs all commits, a shallow clone is deepened
+	r, mod, cleanup, err := s.open(ctx, owner, module, conf, false)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	defer cleanup()
+
+	refs, err := r.refs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	tags := map[string][]string{}
+	var tagged []string
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref.name, "refs/tags/") {
+			continue
+		}
+		if _, ok := tags[ref.commit]; !ok {
+			tagged = append(tagged, ref.commit)
+		}
+		tags[ref.commit] = append(tags[ref.commit], ref.label())
+	}
+
+	var revisions []Revision
+	if rng != "" {
+		commits, err := r.revList(ctx, rng, conf.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, c := range commits {
+			revisions = append(revisions, Revision{GitCommit: c})
+		}
+	} else {
+		for _, c := range tagged {
+			revisions = append(revisions, Revision{GitCommit: c})
+		}
+	}
+	for i := range revisions {
+		rev := &revisions[i]
+		if rev.CreateTime, err = r.commitTime(ctx, rev.GitCommit); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		rev.Labels = tags[rev.GitCommit]
+		slices.Sort(rev.Labels)
+	}
+	if rng == "" {
+		slices.SortStableFunc(revisions, func(a, b Revision) int {
+			return a.CreateTime.Compare(b.CreateTime)
+		})
+	}
+
+	keep := fileFilter(conf.Filters)
+	imported := revisions[:0]
+	for _, rev := range revisions {
+		commit, err := s.commitRevision(ctx, r, mod, conf, keep, rev.GitCommit, rev.CreateTime, rev.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", name, rev.GitCommit, err)
+		}
+		if commit == nil {
+			continue
+		}
+		rev.CommitID = commit.ID
+		imported = append(imported, rev)
+	}
+	slog.InfoContext(ctx, "imported git history", "module", name, "revisions", len(imported))
+	return imported, nil
+}
@@ -0,0 +1,149 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+func TestSyncer_ImportTags(t *testing.T) {
+	ctx := context.Background()
+	reg := setupTestRegistry(t)
+	repo := newTestRepo(t)
+
+	first := repo.commitAt("2023-01-02T10:00:00Z", map[string]string{"proto/pets.proto": "syntax = \"proto3\";\n"})
+	repo.git(repo.work, "tag", "v1.0.0")
+	repo.commitAt("2023-02-01T10:00:00Z", map[string]string{"docs/notes.md": "unrelated"})
+	repo.git(repo.work, "tag", "-a", "v1.0.1", "-m", "docs only")
+	second := repo.commitAt("2023-03-01T10:00:00Z", map[string]string{"proto/pets.proto": "syntax = \"proto3\";\nmessage Pet {}\n"})
+	repo.git(repo.work, "tag", "v1.1.0")
+	repo.commitAt("2023-04-01T10:00:00Z", map[string]string{"proto/owners.proto": "syntax = \"proto3\";\n"})
+	repo.push()
+
+	syncer, err := New(reg, &config.Config{CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	conf := config.Module{Remote: repo.remote, Path: "proto"}
+	revisions, err := syncer.Import(ctx, "acme/pets", conf, "")
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("got %d revisions, want 3: %+v", len(revisions), revisions)
+	}
+	if revisions[0].GitCommit != first || revisions[2].GitCommit != second {
+		t.Errorf("revisions not in history order: %+v", revisions)
+	}
+	if revisions[0].CommitID != revisions[1].CommitID {
+		t.Error("a tag without module changes should reuse the previous commit")
+	}
+
+	mod, err := reg.Module(ctx, "acme", "pets")
+	if err != nil {
+		t.Fatalf("module not created: %v", err)
+	}
+	commit, err := mod.Commit(ctx, "v1.0.0")
+	if err != nil {
+		t.Fatalf("v1.0.0 label not imported: %v", err)
+	}
+	if want := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC); !commit.CreateTime.Equal(want) {
+		t.Errorf("create time = %v, want %v", commit.CreateTime, want)
+	}
+	if commit.SourceControlURL != "" {
+		t.Errorf("local remotes have no source URL, got %q", commit.SourceControlURL)
+	}
+	if latest, _ := mod.Commit(ctx, "v1.1.0"); latest == nil || latest.ID != revisions[2].CommitID {
+		t.Error("v1.1.0 label should point to the last imported commit")
+	}
+
+	// Re-running after a new tag only adds the new revision
+	repo.git(repo.work, "tag", "v1.2.0")
+	repo.push()
+	again, err := syncer.Import(ctx, "acme/pets", conf, "")
+	if err != nil {
+		t.Fatalf("second Import failed: %v", err)
+	}
+	for i, rev := range revisions {
+		if again[i].CommitID != rev.CommitID {
+			t.Errorf("revision %d: commit %s, want %s", i, again[i].CommitID, rev.CommitID)
+		}
+	}
+	commits, _, err := mod.ListCommits(ctx, 10, "")
+	if err != nil {
+		t.Fatalf("ListCommits failed: %v", err)
+	}
+	if len(commits) != 3 {
+		t.Errorf("got %d commits, want 3", len(commits))
+	}
+}
+
+func TestSyncer_ImportRange(t *testing.T) {
+	ctx := context.Background()
+	reg := setupTestRegistry(t)
+	repo := newTestRepo(t)
+
+	repo.commit(map[string]string{"proto/pets.proto": "syntax = \"proto3\";\n"})
+	repo.git(repo.work, "tag", "v1.0.0")
+	repo.commit(map[string]string{"README.md": "outside the module"})
+	second := repo.commit(map[string]string{"proto/pets.proto": "syntax = \"proto3\";\nmessage Pet {}\n"})
+	third := repo.commit(map[string]string{"proto/owners.proto": "syntax = \"proto3\";\n"})
+
+	syncer, err := New(reg, &config.Config{CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	revisions, err := syncer.Import(ctx, "acme/pets", config.Module{Remote: repo.remote, Path: "proto"}, "v1.0.0..main")
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].GitCommit != second || revisions[1].GitCommit != third {
+		t.Fatalf("unexpected revisions: %+v", revisions)
+	}
+
+	if _, err := syncer.Import(ctx, "acme/pets", config.Module{Remote: repo.remote}, "--all"); err == nil {
+		t.Error("expected error for option-like range")
+	}
+}
+
+func TestSyncer_ImportLockDeps(t *testing.T) {
+	ctx := context.Background()
+	reg := setupTestRegistry(t)
+
+	base, err := reg.GetOrCreateModule(ctx, "acme", "base")
+	if err != nil {
+		t.Fatalf("GetOrCreateModule failed: %v", err)
+	}
+	dep, err := base.CreateCommit(ctx, []registry.File{{Path: "base.proto", Content: "syntax = \"proto3\";\n"}}, []string{"main"}, "", "", nil)
+	if err != nil {
+		t.Fatalf("CreateCommit failed: %v", err)
+	}
+
+	repo := newTestRepo(t)
+	repo.commit(map[string]string{
+		"pets.proto": "syntax = \"proto3\";\nimport \"base.proto\";\n",
+		"buf.lock":   fmt.Sprintf("version: v1\ndeps:\n  - remote: test.registry.com\n    owner: acme\n    repository: base\n    commit: %s\n", dep.ID),
+	})
+	repo.git(repo.work, "tag", "v1.0.0")
+	repo.push()
+
+	syncer, err := New(reg, &config.Config{CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	revisions, err := syncer.Import(ctx, "acme/pets", config.Module{Remote: repo.remote}, "")
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	commit, err := reg.CommitByID(ctx, revisions[0].CommitID)
+	if err != nil {
+		t.Fatalf("CommitByID failed: %v", err)
+	}
+	if len(commit.Deps) != 1 || commit.Deps[0].CommitID != dep.ID || commit.Deps[0].ModuleID != dep.ModuleID {
+		t.Errorf("deps = %+v, want commit %s", commit.Deps, dep.ID)
+	}
+}
@@ -0,0 +1,189 @@
+// Package lint checks the descriptors of a module against style rules.
+//
+// Rule names follow those of buf lint where one exists.
+package lint
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/greatliontech/pbr/internal/diag"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Rules are the names of the supported rules, sorted.
+var Rules = []string{
+	"COMMENT_MESSAGE",
+	"COMMENT_RPC",
+	"COMMENT_SERVICE",
+	"ENUM_PASCAL_CASE",
+	"ENUM_VALUE_UPPER_SNAKE_CASE",
+	"ENUM_ZERO_VALUE_SUFFIX",
+	"FIELD_LOWER_SNAKE_CASE",
+	"FIELD_NO_PROTO3_OPTIONAL",
+	"MESSAGE_PASCAL_CASE",
+	"PACKAGE_DEFINED",
+	"PACKAGE_DIRECTORY_MATCH",
+	"PACKAGE_LOWER_SNAKE_CASE",
+	"PACKAGE_VERSION_SUFFIX",
+	"RPC_PASCAL_CASE",
+	"SERVICE_PASCAL_CASE",
+}
+
+// ValidateRule returns an error if name is not a supported rule.
+func ValidateRule(name string) error {
+	if _, ok := slices.BinarySearch(Rules, name); !ok {
+		return fmt.Errorf("unknown lint rule %q", name)
+	}
+	return nil
+}
+
+// Finding is a rule violation.
+type Finding = diag.Diagnostic
+
+var (
+	lowerSnakeCase = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+	upperSnakeCase = regexp.MustCompile(`^[A-Z][A-Z0-9]*(_[A-Z0-9]+)*$`)
+	pascalCase     = regexp.MustCompile(`^[A-Z][a-zA-Z0-9]*$`)
+	versionSuffix  = regexp.MustCompile(`^v[1-9][0-9]*((alpha|beta)[1-9][0-9]*)?(test)?$`)
+)
+
+// Check returns the violations of the given rules in files, sorted by position.
+// Unknown rules are ignored.
+func Check[F protoreflect.FileDescriptor](files []F, rules []string) []Finding {
+	c := &checker{rules: make(map[string]bool)}
+	for _, rule := range rules {
+		c.rules[rule] = true
+	}
+	for _, fd := range files {
+		c.checkFile(fd)
+	}
+	slices.SortFunc(c.findings, func(a, b Finding) int {
+		return cmp.Or(
+			strings.Compare(a.File, b.File),
+			cmp.Compare(a.Line, b.Line),
+			cmp.Compare(a.Column, b.Column),
+			strings.Compare(a.Rule, b.Rule),
+		)
+	})
+	return c.findings
+}
+
+type checker struct {
+	rules    map[string]bool
+	findings []Finding
+}
+
+// add records a violation of rule at the position of d, if the rule is enabled.
+func (c *checker) add(rule string, d protoreflect.Descriptor, format string, args ...any) {
+	if !c.rules[rule] {
+		return
+	}
+	fd := d.ParentFile()
+	f := Finding{Rule: rule, File: fd.Path(), Message: fmt.Sprintf(format, args...)}
+	if _, isFile := d.(protoreflect.FileDescriptor); !isFile {
+		if loc := fd.SourceLocations().ByDescriptor(d); loc.Path != nil {
+			f.Line, f.Column = loc.StartLine+1, loc.StartColumn+1
+		}
+	}
+	c.findings = append(c.findings, f)
+}
+
+// commented reports whether d has a non-empty leading comment.
+func commented(d protoreflect.Descriptor) bool {
+	loc := d.ParentFile().SourceLocations().ByDescriptor(d)
+	return strings.TrimSpace(loc.LeadingComments) != ""
+}
+
+func (c *checker) checkFile(fd protoreflect.FileDescriptor) {
+	pkg := string(fd.Package())
+	if pkg == "" {
+		c.add("PACKAGE_DEFINED", fd, "file has no package")
+	} else {
+		parts := strings.Split(pkg, ".")
+		for _, part := range parts {
+			if !lowerSnakeCase.MatchString(part) {
+				c.add("PACKAGE_LOWER_SNAKE_CASE", fd, "package %q should be lower_snake_case", pkg)
+				break
+			}
+		}
+		if !versionSuffix.MatchString(parts[len(parts)-1]) {
+			c.add("PACKAGE_VERSION_SUFFIX", fd, "package %q should end with a version, such as %q", pkg, pkg+".v1")
+		}
+		dir := ""
+		if i := strings.LastIndexByte(fd.Path(), '/'); i >= 0 {
+			dir = fd.Path()[:i]
+		}
+		if want := strings.ReplaceAll(pkg, ".", "/"); dir != want {
+			c.add("PACKAGE_DIRECTORY_MATCH", fd, "files of package %q should be in directory %q", pkg, want)
+		}
+	}
+
+	c.checkMessages(fd.Messages(), fd.Syntax())
+	c.checkEnums(fd.Enums())
+	for i := range fd.Services().Len() {
+		sd := fd.Services().Get(i)
+		if !pascalCase.MatchString(string(sd.Name())) {
+			c.add("SERVICE_PASCAL_CASE", sd, "service %q should be PascalCase", sd.Name())
+		}
+		if !commented(sd) {
+			c.add("COMMENT_SERVICE", sd, "service %q should have a comment", sd.Name())
+		}
+		for j := range sd.Methods().Len() {
+			md := sd.Methods().Get(j)
+			if !pascalCase.MatchString(string(md.Name())) {
+				c.add("RPC_PASCAL_CASE", md, "rpc %q should be PascalCase", md.Name())
+			}
+			if !commented(md) {
+				c.add("COMMENT_RPC", md, "rpc %q should have a comment", md.Name())
+			}
+		}
+	}
+}
+
+func (c *checker) checkMessages(mds protoreflect.MessageDescriptors, syntax protoreflect.Syntax) {
+	for i := range mds.Len() {
+		md := mds.Get(i)
+		if md.IsMapEntry() {
+			continue
+		}
+		if !pascalCase.MatchString(string(md.Name())) {
+			c.add("MESSAGE_PASCAL_CASE", md, "message %q should be PascalCase", md.Name())
+		}
+		if !commented(md) {
+			c.add("COMMENT_MESSAGE", md, "message %q should have a comment", md.Name())
+		}
+		for j := range md.Fields().Len() {
+			fd := md.Fields().Get(j)
+			if !lowerSnakeCase.MatchString(string(fd.Name())) {
+				c.add("FIELD_LOWER_SNAKE_CASE", fd, "field %q should be lower_snake_case", fd.Name())
+			}
+			if syntax == protoreflect.Proto3 && fd.HasOptionalKeyword() {
+				c.add("FIELD_NO_PROTO3_OPTIONAL", fd, "field %q should not be optional", fd.Name())
+			}
+		}
+		c.checkMessages(md.Messages(), syntax)
+		c.checkEnums(md.Enums())
+	}
+}
+
+func (c *checker) checkEnums(eds protoreflect.EnumDescriptors) {
+	for i := range eds.Len() {
+		ed := eds.Get(i)
+		if !pascalCase.MatchString(string(ed.Name())) {
+			c.add("ENUM_PASCAL_CASE", ed, "enum %q should be PascalCase", ed.Name())
+		}
+		for j := range ed.Values().Len() {
+			vd := ed.Values().Get(j)
+			if !upperSnakeCase.MatchString(string(vd.Name())) {
+				c.add("ENUM_VALUE_UPPER_SNAKE_CASE", vd, "enum value %q should be UPPER_SNAKE_CASE", vd.Name())
+			}
+			if vd.Number() == 0 && !strings.HasSuffix(string(vd.Name()), "_UNSPECIFIED") {
+				c.add("ENUM_ZERO_VALUE_SUFFIX", vd, "enum zero value %q should end with _UNSPECIFIED", vd.Name())
+			}
+		}
+	}
+}
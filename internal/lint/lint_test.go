@@ -0,0 +1,97 @@
+package lint
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/greatliontech/pbr/internal/compiler"
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+func check(t *testing.T, path, content string, rules []string) []string {
+	t.Helper()
+	files, err := compiler.Compile(context.Background(), []registry.File{{Path: path, Content: content}}, nil)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	var got []string
+	for _, f := range Check(files, rules) {
+		got = append(got, f.String())
+	}
+	return got
+}
+
+func TestCheck(t *testing.T) {
+	content := `syntax = "proto3";
+package acme.Pets;
+
+message pet {
+  string Name = 1;
+  optional int32 age = 2;
+  map<string, string> labels = 3;
+}
+
+enum Kind {
+  DOG = 0;
+  cat = 1;
+}
+
+// Manages pets.
+service PetService {
+  rpc getPet(pet) returns (pet);
+  // Lists pets.
+  rpc ListPets(pet) returns (pet);
+}
+`
+	got := check(t, "pets/pet.proto", content, Rules)
+	want := []string{
+		`pets/pet.proto: files of package "acme.Pets" should be in directory "acme/Pets" (PACKAGE_DIRECTORY_MATCH)`,
+		`pets/pet.proto: package "acme.Pets" should be lower_snake_case (PACKAGE_LOWER_SNAKE_CASE)`,
+		`pets/pet.proto: package "acme.Pets" should end with a version, such as "acme.Pets.v1" (PACKAGE_VERSION_SUFFIX)`,
+		`pets/pet.proto:4:1: message "pet" should have a comment (COMMENT_MESSAGE)`,
+		`pets/pet.proto:4:1: message "pet" should be PascalCase (MESSAGE_PASCAL_CASE)`,
+		`pets/pet.proto:5:3: field "Name" should be lower_snake_case (FIELD_LOWER_SNAKE_CASE)`,
+		`pets/pet.proto:6:3: field "age" should not be optional (FIELD_NO_PROTO3_OPTIONAL)`,
+		`pets/pet.proto:11:3: enum zero value "DOG" should end with _UNSPECIFIED (ENUM_ZERO_VALUE_SUFFIX)`,
+		`pets/pet.proto:12:3: enum value "cat" should be UPPER_SNAKE_CASE (ENUM_VALUE_UPPER_SNAKE_CASE)`,
+		`pets/pet.proto:17:3: rpc "getPet" should have a comment (COMMENT_RPC)`,
+		`pets/pet.proto:17:3: rpc "getPet" should be PascalCase (RPC_PASCAL_CASE)`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Only the given rules are checked
+	got = check(t, "pets/pet.proto", content, []string{"COMMENT_SERVICE", "FIELD_NO_PROTO3_OPTIONAL"})
+	if len(got) != 1 || !strings.HasSuffix(got[0], "(FIELD_NO_PROTO3_OPTIONAL)") {
+		t.Errorf("expected only the optional field, got %q", got)
+	}
+}
+
+func TestCheck_Clean(t *testing.T) {
+	content := `syntax = "proto3";
+package acme.pets.v1;
+
+// A pet.
+message Pet {
+  string name = 1;
+}
+`
+	if got := check(t, "acme/pets/v1/pet.proto", content, Rules); len(got) != 0 {
+		t.Errorf("expected no findings, got %q", got)
+	}
+}
+
+func TestValidateRule(t *testing.T) {
+	if !slices.IsSorted(Rules) {
+		t.Fatal("Rules must be sorted")
+	}
+	if err := ValidateRule("COMMENT_SERVICE"); err != nil {
+		t.Errorf("COMMENT_SERVICE: %v", err)
+	}
+	if err := ValidateRule("FIELD_UPPER_CASE"); err == nil {
+		t.Error("expected error for unknown rule")
+	}
+}
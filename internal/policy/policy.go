@@ -0,0 +1,171 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"gopkg.in/yaml.v3"
+)
+
+// Effect is the outcome of a matching rule.
+type Effect string
+
+const (
+	EffectAllow Effect = "allow"
+	EffectDeny  Effect = "deny"
+)
+
+// Rule is a single authorization rule.
+// When Condition evaluates to true, Effect decides the request.
+type Rule struct {
+	Name      string `yaml:"name"`
+	Effect    Effect `yaml:"effect"`
+	Condition string `yaml:"condition"`
+
+	program cel.Program
+}
+
+// Policy is an ordered list of CEL rules. The first matching rule wins;
+// if no rule matches, Default applies.
+//
+// Conditions can reference the following variables:
+//
+//	principal.username  string
+//	principal.groups    list(string)
+//	principal.scopes    list(string)
+//	procedure           string, e.g. "/buf.registry.module.v1.UploadService/Upload"
+//	resource.owner      string
+//	resource.module     string
+//	resource.label      string
+//	now                 timestamp
+type Policy struct {
+	// Default is the effect when no rule matches. Defaults to deny.
+	Default Effect  `yaml:"default"`
+	Rules   []*Rule `yaml:"rules"`
+}
+
+// Input is the request context a policy is evaluated against.
+type Input struct {
+	Username  string
+	Groups    []string
+	Scopes    []string
+	Procedure string
+	Owner     string
+	Module    string
+	Label     string
+	Time      time.Time
+}
+
+// Decision is the result of evaluating a policy.
+type Decision struct {
+	Allowed bool
+	// Rule is the name of the matching rule, empty if the default applied.
+	Rule string
+}
+
+// FromFile loads and compiles a policy from a YAML file.
+func FromFile(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Parse parses and compiles a YAML policy.
+func Parse(b []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	if p.Default == "" {
+		p.Default = EffectDeny
+	}
+	if p.Default != EffectAllow && p.Default != EffectDeny {
+		return nil, fmt.Errorf("invalid default effect %q", p.Default)
+	}
+
+	env, err := newEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, r := range p.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i)
+		}
+		if r.Effect != EffectAllow && r.Effect != EffectDeny {
+			return nil, fmt.Errorf("rule %q: invalid effect %q", r.Name, r.Effect)
+		}
+		ast, iss := env.Compile(r.Condition)
+		if iss.Err() != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, iss.Err())
+		}
+		if ast.OutputType() != cel.BoolType {
+			return nil, fmt.Errorf("rule %q: condition must evaluate to bool, got %s", r.Name, ast.OutputType())
+		}
+		prg, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		r.program = prg
+	}
+
+	return p, nil
+}
+
+func newEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("principal", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("procedure", cel.StringType),
+		cel.Variable("resource", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("now", cel.TimestampType),
+	)
+}
+
+// Evaluate evaluates the policy against the input.
+// An evaluation error in any rule denies the request.
+func (p *Policy) Evaluate(ctx context.Context, in Input) (Decision, error) {
+	now := in.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	groups := in.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	scopes := in.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	vars := map[string]any{
+		"principal": map[string]any{
+			"username": in.Username,
+			"groups":   groups,
+			"scopes":   scopes,
+		},
+		"procedure": in.Procedure,
+		"resource": map[string]string{
+			"owner":  in.Owner,
+			"module": in.Module,
+			"label":  in.Label,
+		},
+		"now": now,
+	}
+
+	for _, r := range p.Rules {
+		out, _, err := r.program.ContextEval(ctx, vars)
+		if err != nil {
+			return Decision{Allowed: false, Rule: r.Name}, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		if matched, ok := out.Value().(bool); ok && matched {
+			return Decision{Allowed: r.Effect == EffectAllow, Rule: r.Name}, nil
+		}
+	}
+
+	return Decision{Allowed: p.Default == EffectAllow}, nil
+}
@@ -0,0 +1,162 @@
+package policy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testPolicy = `
+default: allow
+rules:
+  - name: release-labels-platform-weekdays
+    effect: deny
+    condition: >
+      procedure == "/buf.registry.module.v1.UploadService/Upload" &&
+      resource.owner == "acme" &&
+      resource.label.startsWith("release/") &&
+      (!("platform" in principal.groups) || now.getDayOfWeek() in [0, 6])
+  - name: no-deletes
+    effect: deny
+    condition: procedure.endsWith("/DeleteModules") && principal.username != "admin"
+`
+
+const uploadProcedure = "/buf.registry.module.v1.UploadService/Upload"
+
+func TestParse_Evaluate(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// 2025-01-08 is a Wednesday, 2025-01-11 a Saturday
+	wednesday := time.Date(2025, 1, 8, 12, 0, 0, 0, time.UTC)
+	saturday := time.Date(2025, 1, 11, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		input    Input
+		wantOK   bool
+		wantRule string
+	}{
+		{
+			name: "group member pushes release label on weekday",
+			input: Input{Username: "alice", Groups: []string{"platform"}, Procedure: uploadProcedure,
+				Owner: "acme", Module: "api", Label: "release/1.0", Time: wednesday},
+			wantOK: true,
+		},
+		{
+			name: "group member pushes release label on weekend",
+			input: Input{Username: "alice", Groups: []string{"platform"}, Procedure: uploadProcedure,
+				Owner: "acme", Module: "api", Label: "release/1.0", Time: saturday},
+			wantOK:   false,
+			wantRule: "release-labels-platform-weekdays",
+		},
+		{
+			name: "non member pushes release label",
+			input: Input{Username: "bob", Procedure: uploadProcedure,
+				Owner: "acme", Module: "api", Label: "release/1.0", Time: wednesday},
+			wantOK:   false,
+			wantRule: "release-labels-platform-weekdays",
+		},
+		{
+			name: "non member pushes main",
+			input: Input{Username: "bob", Procedure: uploadProcedure,
+				Owner: "acme", Module: "api", Label: "main", Time: wednesday},
+			wantOK: true,
+		},
+		{
+			name:     "delete by regular user",
+			input:    Input{Username: "bob", Procedure: "/buf.registry.module.v1.ModuleService/DeleteModules"},
+			wantOK:   false,
+			wantRule: "no-deletes",
+		},
+		{
+			name:   "delete by admin",
+			input:  Input{Username: "admin", Procedure: "/buf.registry.module.v1.ModuleService/DeleteModules"},
+			wantOK: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := p.Evaluate(context.Background(), tt.input)
+			if err != nil {
+				t.Fatalf("Evaluate failed: %v", err)
+			}
+			if d.Allowed != tt.wantOK {
+				t.Errorf("Allowed = %v, want %v", d.Allowed, tt.wantOK)
+			}
+			if d.Rule != tt.wantRule {
+				t.Errorf("Rule = %q, want %q", d.Rule, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestParse_DefaultDeny(t *testing.T) {
+	p, err := Parse([]byte(`
+rules:
+  - effect: allow
+    condition: principal.username == "alice"
+`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	d, err := p.Evaluate(context.Background(), Input{Username: "alice"})
+	if err != nil || !d.Allowed {
+		t.Errorf("expected alice to be allowed, got %+v, %v", d, err)
+	}
+	if d.Rule != "rule-0" {
+		t.Errorf("expected generated rule name 'rule-0', got %q", d.Rule)
+	}
+
+	d, err = p.Evaluate(context.Background(), Input{Username: "bob"})
+	if err != nil || d.Allowed {
+		t.Errorf("expected bob to be denied by default, got %+v, %v", d, err)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+	}{
+		{name: "invalid yaml", policy: "rules: ["},
+		{name: "invalid default", policy: "default: maybe"},
+		{name: "invalid effect", policy: "rules:\n  - effect: perhaps\n    condition: 'true'"},
+		{name: "syntax error", policy: "rules:\n  - effect: allow\n    condition: 'principal.username =='"},
+		{name: "unknown variable", policy: "rules:\n  - effect: allow\n    condition: 'user == \"x\"'"},
+		{name: "non bool condition", policy: "rules:\n  - effect: allow\n    condition: 'procedure'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.policy)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(testPolicy), 0o644); err != nil {
+		t.Fatalf("failed to write policy: %v", err)
+	}
+
+	p, err := FromFile(path)
+	if err != nil {
+		t.Fatalf("FromFile failed: %v", err)
+	}
+	if len(p.Rules) != 2 {
+		t.Errorf("expected 2 rules, got %d", len(p.Rules))
+	}
+
+	if _, err := FromFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
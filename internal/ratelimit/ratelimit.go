@@ -0,0 +1,190 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/greatliontech/pbr/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/time/rate"
+)
+
+var meter = otel.Meter("pbr.dev/internal/ratelimit")
+
+// Scopes identify which bucket rejected a request.
+const (
+	ScopeGlobal          = "global"
+	ScopeGlobalProcedure = "global_procedure"
+	ScopePrincipal       = "principal"
+	ScopeProcedure       = "procedure"
+)
+
+// idleTimeout is how long an unused per-principal bucket is kept before eviction.
+const idleTimeout = 10 * time.Minute
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter enforces token-bucket limits globally, per procedure, per principal and per
+// principal+procedure.
+type Limiter struct {
+	conf       *config.RateLimit
+	global     *rate.Limiter
+	procedures map[string]*rate.Limiter // shared by all principals
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	rejected metric.Int64Counter
+}
+
+// New creates a Limiter from configuration.
+func New(conf *config.RateLimit) (*Limiter, error) {
+	l := &Limiter{
+		conf:      conf,
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+	}
+	if conf.Global != nil {
+		l.global = newRateLimiter(*conf.Global)
+	}
+	l.procedures = make(map[string]*rate.Limiter, len(conf.GlobalProcedures))
+	for procedure, limit := range conf.GlobalProcedures {
+		l.procedures[procedure] = newRateLimiter(limit)
+	}
+
+	var err error
+	l.rejected, err = meter.Int64Counter("pbr.ratelimit.rejected",
+		metric.WithDescription("Requests rejected by the rate limiter"))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = meter.Int64ObservableGauge("pbr.ratelimit.buckets",
+		metric.WithDescription("Active per-principal rate limit buckets"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			l.mu.Lock()
+			n := len(l.buckets)
+			l.mu.Unlock()
+			o.Observe(int64(n))
+			return nil
+		}))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = meter.Float64ObservableGauge("pbr.ratelimit.global.tokens",
+		metric.WithDescription("Tokens currently available in the global bucket"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			if l.global != nil {
+				o.Observe(l.global.Tokens())
+			}
+			return nil
+		}))
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func newRateLimiter(limit config.Limit) *rate.Limiter {
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(limit.Rate)))
+	}
+	return rate.NewLimiter(rate.Limit(limit.Rate), burst)
+}
+
+// Allow reports whether a request from principal to procedure may proceed.
+// If not, it returns how long the caller should wait and the scope of the bucket that rejected it.
+func (l *Limiter) Allow(ctx context.Context, principal, procedure string) (bool, time.Duration, string) {
+	now := time.Now()
+
+	type check struct {
+		scope   string
+		limiter *rate.Limiter
+	}
+	var checks []check
+	if l.global != nil {
+		checks = append(checks, check{ScopeGlobal, l.global})
+	}
+	if lim, ok := l.procedures[procedure]; ok {
+		checks = append(checks, check{ScopeGlobalProcedure, lim})
+	}
+
+	l.mu.Lock()
+	l.sweep(now)
+	if lim, ok := l.principalLimit(principal); ok {
+		checks = append(checks, check{ScopePrincipal, l.bucket("p:"+principal, lim, now)})
+	}
+	if lim, ok := l.conf.Procedures[procedure]; ok {
+		checks = append(checks, check{ScopeProcedure, l.bucket("r:"+principal+"\x00"+procedure, lim, now)})
+	}
+	l.mu.Unlock()
+
+	// Reserve a token from every applicable bucket; if any would have to wait,
+	// give all reservations back so rejected requests don't consume tokens.
+	reservations := make([]*rate.Reservation, 0, len(checks))
+	for _, c := range checks {
+		r := c.limiter.ReserveN(now, 1)
+		reservations = append(reservations, r)
+		delay := time.Duration(math.MaxInt64)
+		if r.OK() {
+			delay = r.DelayFrom(now)
+		}
+		if delay > 0 {
+			for _, res := range reservations {
+				res.CancelAt(now)
+			}
+			l.rejected.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("scope", c.scope),
+				attribute.String("procedure", procedure),
+			))
+			return false, delay, c.scope
+		}
+	}
+
+	return true, 0, ""
+}
+
+func (l *Limiter) principalLimit(principal string) (config.Limit, bool) {
+	if lim, ok := l.conf.Principals[principal]; ok {
+		return lim, true
+	}
+	if l.conf.PerPrincipal != nil {
+		return *l.conf.PerPrincipal, true
+	}
+	return config.Limit{}, false
+}
+
+// bucket returns the limiter for key, creating it if needed. Must be called with l.mu held.
+func (l *Limiter) bucket(key string, limit config.Limit, now time.Time) *rate.Limiter {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: newRateLimiter(limit)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	return b.limiter
+}
+
+// sweep evicts idle buckets. Must be called with l.mu held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTimeout {
+		return
+	}
+	for k, b := range l.buckets {
+		if now.Sub(b.lastSeen) > idleTimeout {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastSweep = now
+}
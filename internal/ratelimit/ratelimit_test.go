@@ -0,0 +1,172 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/greatliontech/pbr/internal/config"
+)
+
+const getGraph = "/buf.registry.module.v1.GraphService/GetGraph"
+
+func TestLimiter_PerPrincipal(t *testing.T) {
+	l, err := New(&config.RateLimit{
+		PerPrincipal: &config.Limit{Rate: 0.001, Burst: 2},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := l.Allow(ctx, "ci", getGraph); !ok {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+
+	ok, retryAfter, scope := l.Allow(ctx, "ci", getGraph)
+	if ok {
+		t.Fatal("third request should be rejected")
+	}
+	if scope != ScopePrincipal {
+		t.Errorf("scope = %q, want %q", scope, ScopePrincipal)
+	}
+	if retryAfter <= 0 {
+		t.Errorf("expected positive retry hint, got %v", retryAfter)
+	}
+
+	// Other principals have their own bucket
+	if ok, _, _ := l.Allow(ctx, "alice", getGraph); !ok {
+		t.Error("other principal should be allowed")
+	}
+}
+
+func TestLimiter_PrincipalOverride(t *testing.T) {
+	l, err := New(&config.RateLimit{
+		PerPrincipal: &config.Limit{Rate: 0.001, Burst: 1},
+		Principals: map[string]config.Limit{
+			"bot": {Rate: 0.001, Burst: 3},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := l.Allow(ctx, "bot", getGraph); !ok {
+			t.Fatalf("bot request %d should be allowed", i)
+		}
+	}
+	if ok, _, _ := l.Allow(ctx, "bot", getGraph); ok {
+		t.Error("bot request 4 should be rejected")
+	}
+}
+
+func TestLimiter_Procedure(t *testing.T) {
+	l, err := New(&config.RateLimit{
+		Procedures: map[string]config.Limit{
+			getGraph: {Rate: 0.001, Burst: 1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if ok, _, _ := l.Allow(ctx, "ci", getGraph); !ok {
+		t.Fatal("first GetGraph should be allowed")
+	}
+	ok, _, scope := l.Allow(ctx, "ci", getGraph)
+	if ok {
+		t.Fatal("second GetGraph should be rejected")
+	}
+	if scope != ScopeProcedure {
+		t.Errorf("scope = %q, want %q", scope, ScopeProcedure)
+	}
+
+	// Unlimited procedures and other principals are unaffected
+	if ok, _, _ := l.Allow(ctx, "ci", "/buf.registry.module.v1.DownloadService/Download"); !ok {
+		t.Error("Download should not be limited")
+	}
+	if ok, _, _ := l.Allow(ctx, "alice", getGraph); !ok {
+		t.Error("other principal's GetGraph should be allowed")
+	}
+}
+
+func TestLimiter_GlobalProcedure(t *testing.T) {
+	l, err := New(&config.RateLimit{
+		GlobalProcedures: map[string]config.Limit{
+			getGraph: {Rate: 0.001, Burst: 2},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx := context.Background()
+
+	// The bucket is shared by all principals
+	if ok, _, _ := l.Allow(ctx, "ci", getGraph); !ok {
+		t.Fatal("ci GetGraph should be allowed")
+	}
+	if ok, _, _ := l.Allow(ctx, "alice", getGraph); !ok {
+		t.Fatal("alice GetGraph should be allowed")
+	}
+	ok, _, scope := l.Allow(ctx, "bob", getGraph)
+	if ok {
+		t.Fatal("bob GetGraph should be rejected")
+	}
+	if scope != ScopeGlobalProcedure {
+		t.Errorf("scope = %q, want %q", scope, ScopeGlobalProcedure)
+	}
+
+	if ok, _, _ := l.Allow(ctx, "bob", "/buf.registry.module.v1.DownloadService/Download"); !ok {
+		t.Error("Download should not be limited")
+	}
+}
+
+func TestLimiter_Global(t *testing.T) {
+	l, err := New(&config.RateLimit{
+		Global:       &config.Limit{Rate: 0.001, Burst: 2},
+		PerPrincipal: &config.Limit{Rate: 0.001, Burst: 1},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if ok, _, _ := l.Allow(ctx, "a", getGraph); !ok {
+		t.Fatal("a should be allowed")
+	}
+	// a's principal bucket is empty; the rejection must not consume a global token
+	if ok, _, scope := l.Allow(ctx, "a", getGraph); ok || scope != ScopePrincipal {
+		t.Fatalf("a should be rejected by principal bucket, got ok=%v scope=%q", ok, scope)
+	}
+	if ok, _, _ := l.Allow(ctx, "b", getGraph); !ok {
+		t.Fatal("b should be allowed")
+	}
+	ok, _, scope := l.Allow(ctx, "c", getGraph)
+	if ok {
+		t.Fatal("c should be rejected by global bucket")
+	}
+	if scope != ScopeGlobal {
+		t.Errorf("scope = %q, want %q", scope, ScopeGlobal)
+	}
+}
+
+func TestLimiter_DefaultBurst(t *testing.T) {
+	l, err := New(&config.RateLimit{
+		PerPrincipal: &config.Limit{Rate: 0.5},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if ok, _, _ := l.Allow(ctx, "ci", getGraph); !ok {
+		t.Fatal("first request should be allowed with default burst")
+	}
+	if ok, _, _ := l.Allow(ctx, "ci", getGraph); ok {
+		t.Error("second request should be rejected")
+	}
+}
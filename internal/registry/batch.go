@@ -0,0 +1,93 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// WithBatches journals the writes of ApplyCommits, so that batches interrupted by a crash
+// can be undone by RecoverBatches.
+func WithBatches(batches storage.BatchStore) Option {
+	return func(r *Registry) {
+		r.batches = batches
+	}
+}
+
+// RecoverBatches undoes the batches of commits started before startedBefore whose journal
+// was left behind, because the process stopped while they were applied or their writes
+// failed to be undone. Batches started since may still be applied by another replica
+// sharing the store, and are left alone. Batches that fail to be undone are logged and
+// kept for the next run. It returns the number of batches undone.
+func (r *Registry) RecoverBatches(ctx context.Context, startedBefore time.Time) (int, error) {
+	if r.batches == nil {
+		return 0, nil
+	}
+	batches, err := r.batches.ListBatches(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list commit batches: %w", err)
+	}
+	recovered := 0
+	for _, batch := range batches {
+		if !batch.CreateTime.Before(startedBefore) {
+			continue
+		}
+		if err := r.undoBatch(ctx, batch); err != nil {
+			slog.ErrorContext(ctx, "failed to undo interrupted commit batch", "batch", batch.ID, "createTime", batch.CreateTime, "error", err)
+			continue
+		}
+		if err := r.batches.DeleteBatch(ctx, batch.ID); err != nil {
+			return recovered, fmt.Errorf("failed to delete commit batch journal: %w", err)
+		}
+		slog.WarnContext(ctx, "interrupted commit batch undone", "batch", batch.ID, "createTime", batch.CreateTime, "commits", len(batch.Commits), "labels", len(batch.Labels))
+		recovered++
+	}
+	return recovered, nil
+}
+
+// undoBatch undoes the journaled writes of a batch. Writes that were journaled but never
+// made are no-ops to undo, and labels moved again since are left alone.
+func (r *Registry) undoBatch(ctx context.Context, batch *storage.Batch) error {
+	var errs []error
+	for i := len(batch.Labels) - 1; i >= 0; i-- {
+		l := batch.Labels[i]
+		errs = append(errs, r.restoreLabel(ctx, l.OwnerID, l.Before, l.After))
+	}
+	for _, prev := range batch.Updated {
+		if err := r.metadata.UpdateCommit(ctx, prev); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	for _, id := range batch.Commits {
+		errs = append(errs, r.metadata.DeleteCommit(ctx, id))
+	}
+	for _, id := range batch.Modules {
+		errs = append(errs, r.deleteIfEmpty(ctx, id))
+	}
+	return errors.Join(errs...)
+}
+
+// deleteIfEmpty deletes a module created for an undone batch, unless it got commits or
+// labels meanwhile.
+func (r *Registry) deleteIfEmpty(ctx context.Context, moduleID string) error {
+	record, err := r.metadata.GetModule(ctx, moduleID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	commits, _, err := r.metadata.ListCommits(ctx, moduleID, 1, "")
+	if err != nil || len(commits) > 0 {
+		return err
+	}
+	labels, err := r.metadata.ListLabels(ctx, moduleID)
+	if err != nil || len(labels) > 0 {
+		return err
+	}
+	return r.purgeModule(ctx, record)
+}
@@ -0,0 +1,239 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/greatliontech/pbr/internal/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration file versions.
+const (
+	BufVersionV1Beta1 = "v1beta1"
+	BufVersionV1      = "v1"
+	BufVersionV2      = "v2"
+)
+
+// BufLock is a parsed buf.lock file.
+type BufLock struct {
+	Version string
+	Deps    []BufLockDep
+}
+
+// BufLockDep is a dependency pinned by buf.lock.
+type BufLockDep struct {
+	Remote     string
+	Owner      string
+	Repository string
+	Commit     string
+	// Digest is the digest as written in the file: "shake256:<hex>" (b4) or "b5:<hex>",
+	// or a legacy "b1-"/"b3-" digest in v1beta1 files.
+	Digest string
+}
+
+// Name returns the full name of the dependency, "remote/owner/repository".
+func (d BufLockDep) Name() string {
+	return d.Remote + "/" + d.Owner + "/" + d.Repository
+}
+
+// ModuleDigest returns the b4 or b5 digest of the dependency.
+// It reports false for dependencies without one, including the legacy digests of v1beta1 files.
+func (d BufLockDep) ModuleDigest() (storage.ModuleDigest, bool) {
+	digest, err := storage.ParseModuleDigest(d.Digest)
+	if err != nil {
+		return storage.ModuleDigest{}, false
+	}
+	return digest, true
+}
+
+// bufLockFile is the YAML model of every buf.lock version. v1beta1 and v1 name
+// dependencies by remote, owner and repository, v2 by a single name.
+type bufLockFile struct {
+	Version string `yaml:"version"`
+	Deps    []struct {
+		Name       string `yaml:"name"`
+		Remote     string `yaml:"remote"`
+		Owner      string `yaml:"owner"`
+		Repository string `yaml:"repository"`
+		Branch     string `yaml:"branch"`
+		Commit     string `yaml:"commit"`
+		Digest     string `yaml:"digest"`
+		CreateTime string `yaml:"create_time"`
+	} `yaml:"deps"`
+}
+
+// ParseBufLock parses and validates a buf.lock file of version v1beta1, v1 or v2.
+// A missing version is v1beta1.
+func ParseBufLock(content string) (*BufLock, error) {
+	var file bufLockFile
+	if err := yaml.Unmarshal([]byte(content), &file); err != nil {
+		return nil, fmt.Errorf("invalid buf.lock: %w", err)
+	}
+
+	lock := &BufLock{Version: file.Version}
+	if lock.Version == "" {
+		lock.Version = BufVersionV1Beta1
+	}
+	switch lock.Version {
+	case BufVersionV1Beta1, BufVersionV1, BufVersionV2:
+	default:
+		return nil, fmt.Errorf("invalid buf.lock: unknown version %q", file.Version)
+	}
+
+	for i, d := range file.Deps {
+		dep := BufLockDep{
+			Remote:     d.Remote,
+			Owner:      d.Owner,
+			Repository: d.Repository,
+			Commit:     d.Commit,
+			Digest:     d.Digest,
+		}
+		if lock.Version == BufVersionV2 {
+			if d.Remote != "" || d.Owner != "" || d.Repository != "" {
+				return nil, fmt.Errorf("invalid buf.lock: dep %d: v2 dependencies are named by name, not remote, owner and repository", i)
+			}
+			var err error
+			if dep.Remote, dep.Owner, dep.Repository, err = ParseModuleName(d.Name); err != nil {
+				return nil, fmt.Errorf("invalid buf.lock: dep %d: %w", i, err)
+			}
+		} else if d.Name != "" {
+			return nil, fmt.Errorf("invalid buf.lock: dep %d: name requires version v2", i)
+		}
+		if err := dep.validate(lock.Version); err != nil {
+			return nil, fmt.Errorf("invalid buf.lock: dep %s: %w", dep.Name(), err)
+		}
+		lock.Deps = append(lock.Deps, dep)
+	}
+	return lock, nil
+}
+
+func (d BufLockDep) validate(version string) error {
+	if d.Remote == "" || d.Owner == "" || d.Repository == "" {
+		return errors.New("remote, owner and repository are required")
+	}
+	if d.Commit == "" {
+		return errors.New("commit is required")
+	}
+	if d.Digest == "" {
+		if version == BufVersionV2 {
+			return errors.New("digest is required")
+		}
+		return nil
+	}
+	if version == BufVersionV1Beta1 && (strings.HasPrefix(d.Digest, "b1-") || strings.HasPrefix(d.Digest, "b3-")) {
+		return nil
+	}
+	if _, err := storage.ParseModuleDigest(d.Digest); err != nil {
+		return fmt.Errorf("digest: %w", err)
+	}
+	return nil
+}
+
+// BufYAML is a parsed buf.yaml file.
+type BufYAML struct {
+	Version string
+	// Name is the module name of a v1 or v1beta1 configuration.
+	Name string
+	// Modules are the modules of a v2 workspace.
+	Modules []BufYAMLModule
+	// Deps are the dependencies, "remote/owner/repository" with an optional ":ref".
+	Deps []string
+}
+
+// BufYAMLModule is a module of a v2 buf.yaml.
+type BufYAMLModule struct {
+	Path string
+	Name string
+}
+
+type bufYAMLFile struct {
+	Version string   `yaml:"version"`
+	Name    string   `yaml:"name"`
+	Deps    []string `yaml:"deps"`
+	Modules []struct {
+		Path string `yaml:"path"`
+		Name string `yaml:"name"`
+	} `yaml:"modules"`
+}
+
+// ParseBufYAML parses and validates a buf.yaml file of version v1beta1, v1 or v2.
+func ParseBufYAML(content string) (*BufYAML, error) {
+	var file bufYAMLFile
+	if err := yaml.Unmarshal([]byte(content), &file); err != nil {
+		return nil, fmt.Errorf("invalid buf.yaml: %w", err)
+	}
+
+	conf := &BufYAML{Version: file.Version, Name: file.Name, Deps: file.Deps}
+	switch conf.Version {
+	case BufVersionV1Beta1, BufVersionV1:
+		if len(file.Modules) > 0 {
+			return nil, fmt.Errorf("invalid buf.yaml: modules requires version v2")
+		}
+	case BufVersionV2:
+		if file.Name != "" {
+			return nil, fmt.Errorf("invalid buf.yaml: name is not supported by version v2, name modules instead")
+		}
+	case "":
+		return nil, errors.New("invalid buf.yaml: version is required")
+	default:
+		return nil, fmt.Errorf("invalid buf.yaml: unknown version %q", conf.Version)
+	}
+
+	if conf.Name != "" {
+		if _, _, _, err := ParseModuleName(conf.Name); err != nil {
+			return nil, fmt.Errorf("invalid buf.yaml: name: %w", err)
+		}
+	}
+	paths := map[string]bool{}
+	for i, m := range file.Modules {
+		path := m.Path
+		if path == "" {
+			path = "."
+		}
+		if paths[path] {
+			return nil, fmt.Errorf("invalid buf.yaml: module %d: duplicate path %q", i, path)
+		}
+		paths[path] = true
+		if m.Name != "" {
+			if _, _, _, err := ParseModuleName(m.Name); err != nil {
+				return nil, fmt.Errorf("invalid buf.yaml: module %d: %w", i, err)
+			}
+		}
+		conf.Modules = append(conf.Modules, BufYAMLModule{Path: path, Name: m.Name})
+	}
+	for _, dep := range conf.Deps {
+		name, _, _ := strings.Cut(dep, ":")
+		if _, _, _, err := ParseModuleName(name); err != nil {
+			return nil, fmt.Errorf("invalid buf.yaml: dep %q: %w", dep, err)
+		}
+	}
+	return conf, nil
+}
+
+// ParseModuleName splits a full module name "remote/owner/repository".
+func ParseModuleName(name string) (remote, owner, repository string, err error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("invalid module name %q, expected remote/owner/repository", name)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// ValidateBufConfig validates the buf.yaml and buf.lock among the files of a module, if present.
+func ValidateBufConfig(files []File) error {
+	for _, f := range files {
+		var err error
+		switch f.Path {
+		case "buf.yaml":
+			_, err = ParseBufYAML(f.Content)
+		case "buf.lock":
+			_, err = ParseBufLock(f.Content)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+var (
+	testB4Digest = "shake256:" + strings.Repeat("ab", 64)
+	testB5Digest = "b5:" + strings.Repeat("cd", 64)
+)
+
+func TestParseBufLock(t *testing.T) {
+	content := `version: v1
+deps:
+  - remote: buf.build
+    owner: googleapis
+    repository: googleapis
+    commit: cc916c31859748a68fd229a3c8d7a2e8
+    digest: ` + testB4Digest + `
+  - remote: buf.build
+    owner: grpc-ecosystem
+    repository: grpc-gateway
+    commit: bc28b723cd7746fa80d15cbc63c8b5e8
+    digest: ` + testB4Digest + `
+`
+
+	lock, err := ParseBufLock(content)
+	if err != nil {
+		t.Fatalf("ParseBufLock failed: %v", err)
+	}
+
+	if lock.Version != BufVersionV1 {
+		t.Errorf("expected version v1, got %s", lock.Version)
+	}
+
+	if len(lock.Deps) != 2 {
+		t.Fatalf("expected 2 deps, got %d", len(lock.Deps))
+	}
+
+	if lock.Deps[0].Remote != "buf.build" {
+		t.Errorf("expected remote 'buf.build', got %s", lock.Deps[0].Remote)
+	}
+
+	if lock.Deps[0].Owner != "googleapis" {
+		t.Errorf("expected owner 'googleapis', got %s", lock.Deps[0].Owner)
+	}
+
+	if lock.Deps[0].Repository != "googleapis" {
+		t.Errorf("expected repository 'googleapis', got %s", lock.Deps[0].Repository)
+	}
+
+	digest, ok := lock.Deps[1].ModuleDigest()
+	if !ok || digest.Type != storage.DigestTypeB4 {
+		t.Errorf("expected b4 digest, got %v", digest)
+	}
+}
+
+func TestParseBufLock_V2(t *testing.T) {
+	content := `# Generated by buf. DO NOT EDIT.
+version: v2
+deps:
+  - name: "buf.build/bufbuild/protovalidate" # pinned
+    commit: 'a6c49f84cc0f4e038680d390392e2ab0'
+    digest: ` + testB5Digest + `
+`
+
+	lock, err := ParseBufLock(content)
+	if err != nil {
+		t.Fatalf("ParseBufLock failed: %v", err)
+	}
+	if len(lock.Deps) != 1 {
+		t.Fatalf("expected 1 dep, got %d", len(lock.Deps))
+	}
+	dep := lock.Deps[0]
+	if dep.Name() != "buf.build/bufbuild/protovalidate" || dep.Commit != "a6c49f84cc0f4e038680d390392e2ab0" {
+		t.Errorf("unexpected dep: %+v", dep)
+	}
+	digest, ok := dep.ModuleDigest()
+	if !ok || digest.Type != storage.DigestTypeB5 {
+		t.Errorf("expected b5 digest, got %v", digest)
+	}
+}
+
+func TestParseBufLock_V1Beta1(t *testing.T) {
+	content := `deps:
+  - remote: buf.build
+    owner: acme
+    repository: weather
+    branch: main
+    commit: 2ffa2e7a9a1f4f1a9d4b1c1e8d6a3f3b
+    digest: b1-kY3Z0YnZjZ3ZjZ3Zj
+    create_time: 2021-08-10T16:56:55.154549Z
+`
+
+	lock, err := ParseBufLock(content)
+	if err != nil {
+		t.Fatalf("ParseBufLock failed: %v", err)
+	}
+	if lock.Version != BufVersionV1Beta1 {
+		t.Errorf("expected version v1beta1, got %s", lock.Version)
+	}
+	if _, ok := lock.Deps[0].ModuleDigest(); ok {
+		t.Error("legacy digests have no module digest")
+	}
+}
+
+func TestParseBufLock_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"syntax":          "version: v1\ndeps: [",
+		"unknown version": "version: v3\n",
+		"missing commit":  "version: v1\ndeps:\n  - remote: buf.build\n    owner: acme\n    repository: pets\n",
+		"v2 without name": "version: v2\ndeps:\n  - remote: buf.build\n    owner: acme\n    repository: pets\n    commit: abc\n    digest: " + testB5Digest + "\n",
+		"v2 bad name":     "version: v2\ndeps:\n  - name: acme/pets\n    commit: abc\n    digest: " + testB5Digest + "\n",
+		"v2 no digest":    "version: v2\ndeps:\n  - name: buf.build/acme/pets\n    commit: abc\n",
+		"bad digest":      "version: v1\ndeps:\n  - remote: buf.build\n    owner: acme\n    repository: pets\n    commit: abc\n    digest: shake256:xyz\n",
+	}
+	for name, content := range tests {
+		if _, err := ParseBufLock(content); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseBufYAML(t *testing.T) {
+	v1, err := ParseBufYAML("version: v1\nname: buf.build/acme/pets\ndeps:\n  - buf.build/googleapis/googleapis:v1\nlint:\n  use: [DEFAULT]\n")
+	if err != nil {
+		t.Fatalf("ParseBufYAML v1 failed: %v", err)
+	}
+	if v1.Name != "buf.build/acme/pets" || len(v1.Deps) != 1 {
+		t.Errorf("unexpected v1 config: %+v", v1)
+	}
+
+	v2, err := ParseBufYAML("version: v2\nmodules:\n  - path: proto/common\n    name: buf.build/acme/common\n  - path: proto/api\ndeps:\n  - buf.build/googleapis/googleapis\n")
+	if err != nil {
+		t.Fatalf("ParseBufYAML v2 failed: %v", err)
+	}
+	if len(v2.Modules) != 2 || v2.Modules[0].Name != "buf.build/acme/common" || v2.Modules[1].Path != "proto/api" {
+		t.Errorf("unexpected v2 modules: %+v", v2.Modules)
+	}
+
+	invalid := map[string]string{
+		"missing version":  "name: buf.build/acme/pets\n",
+		"bad name":         "version: v1\nname: pets\n",
+		"v1 with modules":  "version: v1\nmodules:\n  - path: .\n",
+		"v2 with name":     "version: v2\nname: buf.build/acme/pets\n",
+		"duplicate module": "version: v2\nmodules:\n  - path: a\n  - path: a\n",
+		"bad dep":          "version: v1\ndeps:\n  - googleapis\n",
+	}
+	for name, content := range invalid {
+		if _, err := ParseBufYAML(content); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
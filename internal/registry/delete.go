@@ -0,0 +1,170 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// ErrModuleDeleted is returned when creating a module whose name is held by a deleted
+// module that has not been purged yet.
+var ErrModuleDeleted = errors.New("module is deleted")
+
+// isDeleted reports whether a module record is soft deleted.
+func isDeleted(record *storage.ModuleRecord) bool {
+	return !record.DeleteTime.IsZero()
+}
+
+// SoftDeleteModule deletes a module by owner and name, keeping its commits, labels and
+// files so that RestoreModule can bring it back. Deleted modules are hidden from lookups
+// and listings, and hold on to their name until PurgeDeletedModules removes them.
+func (r *Registry) SoftDeleteModule(ctx context.Context, owner, name string) (*Module, error) {
+	slog.DebugContext(ctx, "Registry.SoftDeleteModule", "owner", owner, "name", name)
+
+	record, err := r.metadata.GetModuleByName(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	if isDeleted(record) {
+		return nil, storage.ErrNotFound
+	}
+	deleted := *record
+	deleted.DeleteTime = time.Now()
+	deleted.UpdateTime = deleted.DeleteTime
+	if err := r.metadata.UpdateModule(ctx, &deleted); err != nil {
+		return nil, fmt.Errorf("failed to delete module: %w", err)
+	}
+	r.indexFiles(ctx, record.ID, nil)
+	return &Module{record: &deleted, registry: r}, nil
+}
+
+// DeletedModuleByCommitID returns the deleted module holding a commit. It returns
+// storage.ErrNotFound if the commit doesn't exist or its module is not deleted.
+func (r *Registry) DeletedModuleByCommitID(ctx context.Context, commitID string) (*Module, error) {
+	commit, err := r.metadata.GetCommit(ctx, commitID)
+	if err != nil {
+		return nil, err
+	}
+	record, err := r.metadata.GetModule(ctx, commit.ModuleID)
+	if err != nil {
+		return nil, err
+	}
+	if !isDeleted(record) {
+		return nil, storage.ErrNotFound
+	}
+	return &Module{record: record, registry: r}, nil
+}
+
+// RestoreModule restores a deleted module by owner and name.
+func (r *Registry) RestoreModule(ctx context.Context, owner, name string) (*Module, error) {
+	slog.DebugContext(ctx, "Registry.RestoreModule", "owner", owner, "name", name)
+
+	record, err := r.metadata.GetModuleByName(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	if !isDeleted(record) {
+		return nil, fmt.Errorf("deleted module %s/%s: %w", owner, name, storage.ErrNotFound)
+	}
+	restored := *record
+	restored.DeleteTime = time.Time{}
+	restored.UpdateTime = time.Now()
+	if err := r.metadata.UpdateModule(ctx, &restored); err != nil {
+		return nil, fmt.Errorf("failed to restore module: %w", err)
+	}
+
+	mod := &Module{record: &restored, registry: r}
+	files, _, err := mod.FilesAndCommit(ctx, "")
+	if err == nil {
+		r.indexFiles(ctx, restored.ID, files)
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		slog.WarnContext(ctx, "failed to index restored module", "moduleID", restored.ID, "error", err)
+	}
+	return mod, nil
+}
+
+// ListDeletedModules lists the deleted modules of all owners, oldest deletion first.
+func (r *Registry) ListDeletedModules(ctx context.Context) ([]*Module, error) {
+	records, err := r.metadata.ListModules(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	records = slices.DeleteFunc(records, func(m *storage.ModuleRecord) bool { return !isDeleted(m) })
+	slices.SortFunc(records, func(a, b *storage.ModuleRecord) int {
+		return a.DeleteTime.Compare(b.DeleteTime)
+	})
+	modules := make([]*Module, len(records))
+	for i, record := range records {
+		modules[i] = &Module{record: record, registry: r}
+	}
+	return modules, nil
+}
+
+// PurgeDeletedModules permanently deletes the modules deleted before a cutoff, with their
+// labels and commits, and returns them. Retention then collects the files no remaining
+// commit references. Commits of other modules that depended on them no longer resolve.
+func (r *Registry) PurgeDeletedModules(ctx context.Context, before time.Time) ([]*Module, error) {
+	slog.DebugContext(ctx, "Registry.PurgeDeletedModules", "before", before)
+
+	deleted, err := r.ListDeletedModules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var purged []*Module
+	for _, mod := range deleted {
+		if !mod.DeleteTime().Before(before) {
+			break
+		}
+		if err := r.purgeModule(ctx, mod.record); err != nil {
+			return purged, fmt.Errorf("failed to purge %s/%s: %w", mod.Owner(), mod.Name(), err)
+		}
+		purged = append(purged, mod)
+	}
+	return purged, nil
+}
+
+// ModuleDependents returns the modules, other than mod, that have commits depending on
+// a commit of mod. Deleted modules are not included.
+func (r *Registry) ModuleDependents(ctx context.Context, mod *Module) ([]*Module, error) {
+	slog.DebugContext(ctx, "Registry.ModuleDependents", "owner", mod.Owner(), "name", mod.Name())
+
+	commits, err := r.allCommits(ctx, mod.ID())
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(commits))
+	for _, c := range commits {
+		ids[c.ID] = true
+	}
+
+	records, err := r.metadata.ListModules(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	var dependents []*Module
+	for _, record := range records {
+		if record.ID == mod.ID() || isDeleted(record) {
+			continue
+		}
+		commits, err := r.allCommits(ctx, record.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list commits of %s/%s: %w", record.Owner, record.Name, err)
+		}
+		depends := slices.ContainsFunc(commits, func(c *storage.CommitRecord) bool {
+			return slices.ContainsFunc(c.DepCommitIDs, func(id string) bool { return ids[id] })
+		})
+		if depends {
+			dependents = append(dependents, &Module{record: record, registry: r})
+		}
+	}
+	slices.SortFunc(dependents, func(a, b *Module) int {
+		return strings.Compare(a.Owner()+"/"+a.Name(), b.Owner()+"/"+b.Name())
+	})
+	return dependents, nil
+}
@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// ErrNotMirrored is returned when mirroring into a module that is hosted here or mirrored from another registry.
+var ErrNotMirrored = errors.New("module is not mirrored from this registry")
+
+// ErrDigestMismatch is returned when importing a commit whose files and dependencies don't
+// hash to its module digest.
+var ErrDigestMismatch = errors.New("content does not match the module digest")
+
+// MirrorModule returns the local copy of a module of the upstream registry, creating it if needed.
+// A module of the same name that is hosted here, or mirrored from another registry, is never
+// turned into a mirror, even if it was created concurrently.
+func (r *Registry) MirrorModule(ctx context.Context, upstream, owner, name, defaultLabelName string) (*Module, error) {
+	slog.DebugContext(ctx, "Registry.MirrorModule", "upstream", upstream, "owner", owner, "name", name)
+
+	mod, err := r.createModule(ctx, &storage.ModuleRecord{
+		Owner:            owner,
+		Name:             name,
+		DefaultLabelName: defaultLabelName,
+		Mirror:           upstream,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if mod.Mirror() != upstream {
+		return nil, fmt.Errorf("module %s/%s: %w", owner, name, ErrNotMirrored)
+	}
+	return mod, nil
+}
+
+// ImportCommit stores a commit of the upstream registry under its original ID and module digest.
+// The B5 digest of the files and the digests of deps must match digest, else ErrDigestMismatch
+// is returned. If the commit already exists, it is returned unchanged.
+func (m *Module) ImportCommit(ctx context.Context, id string, createTime time.Time, files []File, deps []storage.DepRecord, digest storage.ModuleDigest) (*Commit, error) {
+	slog.DebugContext(ctx, "Module.ImportCommit", "owner", m.Owner(), "module", m.Name(), "commitID", id, "files", len(files), "deps", len(deps))
+
+	if commit, err := m.CommitByID(ctx, id); err == nil {
+		return commit, nil
+	}
+
+	manifest, filesDigest, err := m.storeFiles(ctx, files)
+	if err != nil {
+		return nil, err
+	}
+
+	depCommitIDs := make([]string, 0, len(deps))
+	depDigests := make([]storage.ModuleDigest, 0, len(deps))
+	for _, dep := range deps {
+		depCommitIDs = append(depCommitIDs, dep.CommitID)
+		depDigests = append(depDigests, dep.Digest)
+	}
+	// The stored files are content-addressed, so those of a rejected commit are only
+	// left to retention
+	computed, err := storage.ComputeB5Digest(manifest, depDigests)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute module digest: %w", err)
+	}
+	if digest.Type != storage.DigestTypeB5 || !bytes.Equal(computed.Value, digest.Value) {
+		return nil, fmt.Errorf("commit %s: %w: got %s, want %s", id, ErrDigestMismatch, computed, digest)
+	}
+
+	record := &storage.CommitRecord{
+		ID:           id,
+		ModuleID:     m.record.ID,
+		OwnerID:      m.record.OwnerID,
+		FilesDigest:  filesDigest,
+		ModuleDigest: digest,
+		CreateTime:   createTime,
+		DepCommitIDs: depCommitIDs,
+		Deps:         deps,
+	}
+	m.registry.pruneMu.RLock()
+	defer m.registry.pruneMu.RUnlock()
+	if err := m.registry.metadata.CreateCommit(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to create commit: %w", err)
+	}
+	m.registry.recorded.Add(1)
+	m.registry.accountCommit(ctx, record, commitBlobs(manifest, files))
+	return commitFromRecord(record), nil
+}
+
+// SetLabel points the named label at a commit, creating the label if needed.
+func (m *Module) SetLabel(ctx context.Context, name, commitID string) error {
+	m.registry.pruneMu.RLock()
+	before, _, err := m.moveLabel(ctx, name, commitID, nil, nil)
+	if err == nil {
+		m.registry.recorded.Add(1)
+	}
+	m.registry.pruneMu.RUnlock()
+	if err != nil || name != m.DefaultLabelName() || before != nil && before.CommitID == commitID {
+		return err
+	}
+	files, _, err := m.FilesAndCommitByCommitID(ctx, commitID)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to load files to index", "moduleID", m.record.ID, "commitID", commitID, "error", err)
+		return nil
+	}
+	m.registry.indexFiles(ctx, m.record.ID, files)
+	return nil
+}
@@ -2,9 +2,11 @@ package registry
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"slices"
 	"strings"
 	"time"
 
@@ -53,6 +55,16 @@ func (m *Module) CreateTime() time.Time {
 	return m.record.CreateTime
 }
 
+// Mirror returns the upstream registry the module is mirrored from, or "" if it is hosted here.
+func (m *Module) Mirror() string {
+	return m.record.Mirror
+}
+
+// DeleteTime returns when the module was deleted, or the zero time if it is not.
+func (m *Module) DeleteTime() time.Time {
+	return m.record.DeleteTime
+}
+
 // Commit retrieves a commit by label/ref name.
 // If ref is empty, returns the commit for the default label.
 func (m *Module) Commit(ctx context.Context, ref string) (*Commit, error) {
@@ -84,15 +96,7 @@ func (m *Module) CommitByID(ctx context.Context, id string) (*Commit, error) {
 		return nil, storage.ErrNotFound
 	}
 
-	return &Commit{
-		ID:           record.ID,
-		ModuleID:     record.ModuleID,
-		OwnerID:      record.OwnerID,
-		FilesDigest:  record.FilesDigest,
-		ModuleDigest: record.ModuleDigest,
-		CreateTime:   record.CreateTime,
-		DepCommitIDs: record.DepCommitIDs,
-	}, nil
+	return commitFromRecord(record), nil
 }
 
 // FilesAndCommit retrieves files and commit info by label/ref.
@@ -178,31 +182,103 @@ func (m *Module) parseBufLock(files []File) (*BufLock, error) {
 	return nil, fmt.Errorf("buf.lock not found")
 }
 
-// CreateCommit creates a new commit with the given files.
+// CreateCommit creates a new commit with the given files, recording createdByUserID as its author.
 // Returns the created commit or an existing commit if content is identical.
-// depDigests should contain the B5 digests of all dependencies (in the same order as depCommitIDs).
-func (m *Module) CreateCommit(ctx context.Context, files []File, labels []string, sourceControlURL string, depCommitIDs []string, depDigests []storage.ModuleDigest) (*Commit, error) {
-	slog.DebugContext(ctx, "Module.CreateCommit", "owner", m.Owner(), "module", m.Name(), "files", len(files), "labels", labels, "depCommitIDs", len(depCommitIDs))
+// deps may include commits of other registries; each must carry its B5 module digest.
+func (m *Module) CreateCommit(ctx context.Context, files []File, labels []string, sourceControlURL, createdByUserID string, deps []storage.DepRecord) (*Commit, error) {
+	return m.CreateCommitAt(ctx, time.Now(), files, labels, sourceControlURL, createdByUserID, deps)
+}
 
-	// Store blobs and build manifest
-	manifest := &storage.Manifest{}
+// CreateCommitAt is like CreateCommit, but records createTime as the creation time of a new commit.
+// It is used to import history, where commits keep the time of their source revision.
+func (m *Module) CreateCommitAt(ctx context.Context, createTime time.Time, files []File, labels []string, sourceControlURL, createdByUserID string, deps []storage.DepRecord) (*Commit, error) {
+	pending, err := m.PrepareCommit(ctx, createTime, files, labels, sourceControlURL, createdByUserID, deps)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.registry.ApplyCommits(ctx, []*PendingCommit{pending}); err != nil {
+		return nil, err
+	}
+	return pending.Commit(), nil
+}
 
-	for _, f := range files {
-		digest, err := m.registry.blobs.Put(ctx, strings.NewReader(f.Content))
-		if err != nil {
-			return nil, fmt.Errorf("failed to store blob %s: %w", f.Path, err)
-		}
+// PendingCommit is a commit prepared by PrepareCommit. Its files are stored, but the commit
+// is only recorded, and its labels moved, by Registry.ApplyCommits.
+type PendingCommit struct {
+	module   *Module
+	record   *storage.CommitRecord
+	labels   []string
+	files    []File
+	blobs    []storage.BlobUsage
+	exists   bool // an existing commit with the same files is reused
+	modified bool // the findings of the commit were changed
+	// moduleCreated is set if the module was created for the commit
+	moduleCreated bool
+	// conditions are the commits labels must point at to be moved, by label name
+	conditions map[string]labelCondition
+}
 
-		manifest.Entries = append(manifest.Entries, storage.ManifestEntry{
-			Digest: digest,
-			Path:   f.Path,
-		})
+// Commit returns the commit as it will be recorded. Other commits applied in the same
+// batch can depend on it.
+func (p *PendingCommit) Commit() *Commit {
+	return commitFromRecord(p.record)
+}
+
+// Files returns the files of the commit.
+func (p *PendingCommit) Files() []File {
+	return p.files
+}
+
+// Module returns the module the commit is prepared for.
+func (p *PendingCommit) Module() *Module {
+	return p.module
+}
+
+// Blobs returns the blobs the commit adds to the usage of its module, none if an
+// existing commit is reused.
+func (p *PendingCommit) Blobs() []storage.BlobUsage {
+	return p.blobs
+}
+
+// ExpectLabel only moves the named label if it currently points at commitID. An empty
+// commitID expects the label not to exist. Otherwise, applying the commit fails with ErrLabelMoved.
+func (p *PendingCommit) ExpectLabel(name, commitID string) {
+	if p.conditions == nil {
+		p.conditions = make(map[string]labelCondition)
+	}
+	p.conditions[name] = labelCondition{commitID: commitID}
+}
+
+// ModuleCreated records that the module was created for the commit. If the batch applying
+// the commit is interrupted, RecoverBatches deletes the module again unless it has commits.
+func (p *PendingCommit) ModuleCreated() {
+	p.moduleCreated = true
+}
+
+// SetFindings replaces the findings of a check to record on the commit.
+func (p *PendingCommit) SetFindings(check string, findings []storage.Finding) {
+	p.record.Findings = slices.DeleteFunc(p.record.Findings, func(f storage.Finding) bool {
+		return f.Check == check
+	})
+	p.record.Findings = append(p.record.Findings, findings...)
+	p.modified = true
+}
+
+// PrepareCommit stores the files of a new commit and returns it pending. If a commit of this
+// module has the same files, it is reused and only the labels are moved to it.
+func (m *Module) PrepareCommit(ctx context.Context, createTime time.Time, files []File, labels []string, sourceControlURL, createdByUserID string, deps []storage.DepRecord) (*PendingCommit, error) {
+	slog.DebugContext(ctx, "Module.PrepareCommit", "owner", m.Owner(), "module", m.Name(), "files", len(files), "labels", labels, "deps", len(deps))
+
+	depCommitIDs := make([]string, 0, len(deps))
+	depDigests := make([]storage.ModuleDigest, 0, len(deps))
+	for _, dep := range deps {
+		depCommitIDs = append(depCommitIDs, dep.CommitID)
+		depDigests = append(depDigests, dep.Digest)
 	}
 
-	// Store manifest and get files digest
-	filesDigest, err := m.registry.manifests.PutManifest(ctx, manifest)
+	manifest, filesDigest, err := m.storeFiles(ctx, files)
 	if err != nil {
-		return nil, fmt.Errorf("failed to store manifest: %w", err)
+		return nil, err
 	}
 
 	// Compute module digest (files + dependencies)
@@ -211,72 +287,122 @@ func (m *Module) CreateCommit(ctx context.Context, files []File, labels []string
 		return nil, fmt.Errorf("failed to compute module digest: %w", err)
 	}
 
+	pending := &PendingCommit{module: m, labels: labels, files: files}
+
 	// Check for existing commit with same files digest (deduplication)
 	existingCommit, err := m.registry.metadata.GetCommitByFilesDigest(ctx, filesDigest)
 	if err == nil && existingCommit != nil && existingCommit.ModuleID == m.record.ID {
 		slog.DebugContext(ctx, "commit already exists", "commitID", existingCommit.ID)
-		// Update labels to point to existing commit
-		for _, labelName := range labels {
-			if err := m.updateLabel(ctx, labelName, existingCommit.ID); err != nil {
-				return nil, err
-			}
-		}
-		return &Commit{
-			ID:             existingCommit.ID,
-			ModuleID:       existingCommit.ModuleID,
-			OwnerID:        existingCommit.OwnerID,
-			FilesDigest:  existingCommit.FilesDigest,
-			ModuleDigest: existingCommit.ModuleDigest,
-			CreateTime:     existingCommit.CreateTime,
-			DepCommitIDs:   existingCommit.DepCommitIDs,
-		}, nil
+		pending.record = existingCommit
+		pending.exists = true
+		return pending, nil
 	}
 
-	// Create new commit
 	// Commit ID is UUID v7 (time-sortable) as 32 hex chars
-	commitID := strings.ReplaceAll(uuid.Must(uuid.NewV7()).String(), "-", "")
-
-	commitRecord := &storage.CommitRecord{
-		ID:               commitID,
+	pending.record = &storage.CommitRecord{
+		ID:               strings.ReplaceAll(uuid.Must(uuid.NewV7()).String(), "-", ""),
 		ModuleID:         m.record.ID,
 		OwnerID:          m.record.OwnerID,
 		FilesDigest:      filesDigest,
 		ModuleDigest:     moduleDigest,
-		CreateTime:       time.Now(),
+		CreateTime:       createTime,
+		CreatedByUserID:  createdByUserID,
 		SourceControlURL: sourceControlURL,
 		DepCommitIDs:     depCommitIDs,
+		Deps:             deps,
 	}
+	pending.blobs = commitBlobs(manifest, files)
+	return pending, nil
+}
 
-	if err := m.registry.metadata.CreateCommit(ctx, commitRecord); err != nil {
-		return nil, fmt.Errorf("failed to create commit: %w", err)
-	}
+// storeFiles stores the file contents and their manifest.
+func (m *Module) storeFiles(ctx context.Context, files []File) (*storage.Manifest, storage.Digest, error) {
+	manifest := &storage.Manifest{}
 
-	// Update labels
-	for _, labelName := range labels {
-		if err := m.updateLabel(ctx, labelName, commitID); err != nil {
-			return nil, err
+	for _, f := range files {
+		digest, err := m.registry.blobs.Put(ctx, strings.NewReader(f.Content))
+		if err != nil {
+			return nil, storage.Digest{}, fmt.Errorf("failed to store blob %s: %w", f.Path, err)
 		}
+
+		manifest.Entries = append(manifest.Entries, storage.ManifestEntry{
+			Digest: digest,
+			Path:   f.Path,
+		})
 	}
 
-	return &Commit{
-		ID:           commitID,
-		ModuleID:     m.record.ID,
-		OwnerID:      m.record.OwnerID,
-		FilesDigest:  filesDigest,
-		ModuleDigest: moduleDigest,
-		CreateTime:   commitRecord.CreateTime,
-		DepCommitIDs: depCommitIDs,
-	}, nil
-}
-
-func (m *Module) updateLabel(ctx context.Context, name, commitID string) error {
-	label := &storage.LabelRecord{
-		ID:       m.record.ID + "/" + name,
-		ModuleID: m.record.ID,
-		Name:     name,
-		CommitID: commitID,
+	filesDigest, err := m.registry.manifests.PutManifest(ctx, manifest)
+	if err != nil {
+		return nil, storage.Digest{}, fmt.Errorf("failed to store manifest: %w", err)
 	}
-	return m.registry.metadata.CreateOrUpdateLabel(ctx, label)
+	return manifest, filesDigest, nil
+}
+
+// maxLabelRetries is how often a label move is retried after losing a race with another move.
+const maxLabelRetries = 5
+
+// ErrLabelMoved is returned when a label doesn't point at the commit a move expects.
+var ErrLabelMoved = errors.New("label was moved")
+
+// labelCondition is the commit a label must point at to be moved, empty for a label that must not exist.
+type labelCondition struct {
+	commitID string
+}
+
+// moveLabel points a label at a commit, with a compare-and-swap of its revision. Lost races
+// are retried up to maxLabelRetries times, after which storage.ErrConflict is returned. With
+// a condition, the label is only moved while it points at the expected commit, otherwise
+// ErrLabelMoved is returned. It returns the label before and after the move, before being
+// nil if the label was created. If journal is set, it is given each attempted move before
+// it is made, and the move is abandoned if it fails.
+func (m *Module) moveLabel(ctx context.Context, name, commitID string, cond *labelCondition, journal func(before, after *storage.LabelRecord) error) (before, after *storage.LabelRecord, err error) {
+	for range maxLabelRetries {
+		before, err = m.registry.metadata.GetLabel(ctx, m.record.ID, name)
+		if errors.Is(err, storage.ErrNotFound) {
+			before, err = nil, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get label %s: %w", name, err)
+		}
+		if cond != nil {
+			current := ""
+			if before != nil {
+				current = before.CommitID
+			}
+			if current != cond.commitID {
+				return nil, nil, fmt.Errorf("%w: label %s points at %q, expected %q", ErrLabelMoved, name, current, cond.commitID)
+			}
+		}
+
+		after = &storage.LabelRecord{
+			ModuleID: m.record.ID,
+			Name:     name,
+			CommitID: commitID,
+		}
+		if before != nil {
+			after.Revision = before.Revision
+		}
+		if journal != nil {
+			moved := *after
+			moved.Revision++
+			if err := journal(before, &moved); err != nil {
+				return nil, nil, err
+			}
+		}
+		err = m.registry.metadata.SwapLabel(ctx, after)
+		if errors.Is(err, storage.ErrConflict) {
+			slog.DebugContext(ctx, "label moved concurrently, retrying", "module", m.record.ID, "label", name)
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to move label %s: %w", name, err)
+		}
+		if before == nil {
+			m.registry.accountLabels(ctx, m.record.OwnerID, m.record.ID, 1)
+		}
+		return before, after, nil
+	}
+	return nil, nil, fmt.Errorf("label %s was moved concurrently %d times: %w", name, maxLabelRetries, storage.ErrConflict)
 }
 
 // ListCommits lists commits for this module.
@@ -288,15 +414,7 @@ func (m *Module) ListCommits(ctx context.Context, limit int, pageToken string) (
 
 	commits := make([]*Commit, len(records))
 	for i, record := range records {
-		commits[i] = &Commit{
-			ID:             record.ID,
-			ModuleID:       record.ModuleID,
-			OwnerID:        record.OwnerID,
-			FilesDigest:  record.FilesDigest,
-			ModuleDigest: record.ModuleDigest,
-			CreateTime:     record.CreateTime,
-			DepCommitIDs:   record.DepCommitIDs,
-		}
+		commits[i] = commitFromRecord(record)
 	}
 
 	return commits, nextToken, nil
@@ -0,0 +1,134 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/greatliontech/pbr/internal/storage"
+	"github.com/greatliontech/pbr/internal/util"
+)
+
+// ErrOwnerHasModules is returned when deleting an owner that still owns modules.
+var ErrOwnerHasModules = errors.New("owner has modules")
+
+// ErrOwnerIsOrganization is returned when provisioning a user whose name is taken by an organization.
+var ErrOwnerIsOrganization = errors.New("owner name is taken by an organization")
+
+// GetOrCreateUser returns the user record for an authenticated principal,
+// provisioning it with a new, stable ID on first sight.
+// It returns ErrOwnerIsOrganization if an organization has the name: logging in never
+// takes over an organization and its modules.
+func (r *Registry) GetOrCreateUser(ctx context.Context, name string) (*storage.OwnerRecord, error) {
+	r.ownersMu.Lock()
+	defer r.ownersMu.Unlock()
+
+	record, err := r.metadata.GetOwnerByName(ctx, name)
+	switch {
+	case err == nil && record.Type == storage.OwnerTypeUser:
+		return record, nil
+	case err == nil:
+		return nil, fmt.Errorf("%w: %s", ErrOwnerIsOrganization, name)
+	case err != storage.ErrNotFound:
+		return nil, fmt.Errorf("failed to get owner: %w", err)
+	}
+
+	record, err = r.createOwner(ctx, &storage.OwnerRecord{Name: name, Type: storage.OwnerTypeUser})
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "provisioned user", "name", name, "id", record.ID)
+	return record, nil
+}
+
+// getOrCreateOrganization returns the owner with the given name, creating an organization if none exists.
+func (r *Registry) getOrCreateOrganization(ctx context.Context, name string) (*storage.OwnerRecord, error) {
+	r.ownersMu.Lock()
+	defer r.ownersMu.Unlock()
+
+	record, err := r.metadata.GetOwnerByName(ctx, name)
+	if err == nil {
+		return record, nil
+	}
+	if err != storage.ErrNotFound {
+		return nil, fmt.Errorf("failed to get owner: %w", err)
+	}
+	return r.createOwner(ctx, &storage.OwnerRecord{Name: name, Type: storage.OwnerTypeOrganization})
+}
+
+// CreateOwner creates a user or organization. Owner names are unique across both kinds.
+// Users get a new random ID; organizations keep the name-derived ID used for implicitly created owners.
+func (r *Registry) CreateOwner(ctx context.Context, owner *storage.OwnerRecord) (*storage.OwnerRecord, error) {
+	slog.DebugContext(ctx, "Registry.CreateOwner", "name", owner.Name, "type", owner.Type)
+
+	r.ownersMu.Lock()
+	defer r.ownersMu.Unlock()
+
+	if _, err := r.metadata.GetOwnerByName(ctx, owner.Name); err == nil {
+		return nil, storage.ErrAlreadyExists
+	} else if err != storage.ErrNotFound {
+		return nil, fmt.Errorf("failed to get owner: %w", err)
+	}
+	return r.createOwner(ctx, owner)
+}
+
+// createOwner assigns an ID and timestamps and stores the owner. Must be called with r.ownersMu held.
+func (r *Registry) createOwner(ctx context.Context, owner *storage.OwnerRecord) (*storage.OwnerRecord, error) {
+	record := *owner
+	if record.Type == storage.OwnerTypeUser {
+		record.ID = strings.ReplaceAll(uuid.Must(uuid.NewV7()).String(), "-", "")
+	} else {
+		record.Type = storage.OwnerTypeOrganization
+		record.ID = util.OwnerID(record.Name)
+	}
+	record.CreateTime = time.Now()
+	record.UpdateTime = record.CreateTime
+
+	if err := r.metadata.CreateOwner(ctx, &record); err != nil {
+		if err == storage.ErrAlreadyExists {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to create owner: %w", err)
+	}
+	return &record, nil
+}
+
+// UpdateOwner stores changes to an owner's mutable fields.
+func (r *Registry) UpdateOwner(ctx context.Context, owner *storage.OwnerRecord) error {
+	owner.UpdateTime = time.Now()
+	return r.metadata.UpdateOwner(ctx, owner)
+}
+
+// DeleteOwner deletes an owner. Owners that still own modules, including deleted
+// modules not purged yet, cannot be deleted.
+func (r *Registry) DeleteOwner(ctx context.Context, id string) error {
+	slog.DebugContext(ctx, "Registry.DeleteOwner", "id", id)
+
+	modules, err := r.metadata.ListModules(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(modules) > 0 {
+		return ErrOwnerHasModules
+	}
+	return r.metadata.DeleteOwner(ctx, id)
+}
+
+// ListOwnersByType lists all owners of the given type.
+func (r *Registry) ListOwnersByType(ctx context.Context, ownerType storage.OwnerType) ([]*storage.OwnerRecord, error) {
+	owners, err := r.metadata.ListOwners(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []*storage.OwnerRecord
+	for _, o := range owners {
+		if o.Type == ownerType {
+			filtered = append(filtered, o)
+		}
+	}
+	return filtered, nil
+}
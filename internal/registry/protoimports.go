@@ -0,0 +1,264 @@
+package registry
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProtoImport is an import statement of a proto file.
+type ProtoImport struct {
+	Path   string
+	Public bool
+	Weak   bool
+	Line   int
+}
+
+// ParseProtoImports returns the import statements of a proto file.
+// The file is tokenized, so imports in comments and string literals, and fields or
+// options named import, are not mistaken for import statements.
+func ParseProtoImports(content string) ([]ProtoImport, error) {
+	lex := &protoLexer{src: content, line: 1}
+	var imports []ProtoImport
+	depth := 0
+	// atStatement is set where a top-level statement may begin
+	atStatement := true
+	for {
+		tok, err := lex.next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case tok.kind == tokenEOF:
+			return imports, nil
+		case tok.kind == tokenIdent && tok.text == "import" && depth == 0 && atStatement:
+			imp, err := lex.importStatement(tok.line)
+			if err != nil {
+				return nil, err
+			}
+			imports = append(imports, imp)
+			continue
+		case tok.text == "{":
+			depth++
+		case tok.text == "}":
+			if depth > 0 {
+				depth--
+			}
+		}
+		atStatement = depth == 0 && (tok.text == ";" || tok.text == "}")
+	}
+}
+
+// WellKnownImport reports whether an import is one of the well-known types shipped with
+// every protobuf compiler, which no module provides.
+func WellKnownImport(path string) bool {
+	return strings.HasPrefix(path, "google/protobuf/")
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	// text is the identifier, punctuation or number as written, or the value of a string literal
+	text string
+	line int
+}
+
+// protoLexer tokenizes the proto language, skipping whitespace and comments.
+type protoLexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+// importStatement parses the remainder of an import statement after the import keyword.
+func (l *protoLexer) importStatement(line int) (ProtoImport, error) {
+	imp := ProtoImport{Line: line}
+	tok, err := l.next()
+	if err != nil {
+		return imp, err
+	}
+	if tok.kind == tokenIdent && (tok.text == "public" || tok.text == "weak") {
+		imp.Public = tok.text == "public"
+		imp.Weak = tok.text == "weak"
+		if tok, err = l.next(); err != nil {
+			return imp, err
+		}
+	}
+	if tok.kind != tokenString {
+		return imp, fmt.Errorf("line %d: expected import path, got %q", tok.line, tok.text)
+	}
+	// Adjacent string literals are concatenated
+	for tok.kind == tokenString {
+		imp.Path += tok.text
+		if tok, err = l.next(); err != nil {
+			return imp, err
+		}
+	}
+	if tok.text != ";" {
+		return imp, fmt.Errorf("line %d: expected \";\" after import, got %q", tok.line, tok.text)
+	}
+	if imp.Path == "" {
+		return imp, fmt.Errorf("line %d: empty import path", line)
+	}
+	return imp, nil
+}
+
+func (l *protoLexer) next() (token, error) {
+	if err := l.skipSpaceAndComments(); err != nil {
+		return token{}, err
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, line: l.line}, nil
+	}
+
+	start, line := l.pos, l.line
+	c := l.src[l.pos]
+	switch {
+	case c == '"' || c == '\'':
+		s, err := l.stringLiteral()
+		return token{kind: tokenString, text: s, line: line}, err
+	case isIdentStart(c):
+		for l.pos < len(l.src) && (isIdentStart(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokenIdent, text: l.src[start:l.pos], line: line}, nil
+	case isDigit(c):
+		for l.pos < len(l.src) && (isIdentStart(l.src[l.pos]) || isDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return token{kind: tokenNumber, text: l.src[start:l.pos], line: line}, nil
+	default:
+		l.pos++
+		return token{kind: tokenPunct, text: l.src[start:l.pos], line: line}, nil
+	}
+}
+
+func (l *protoLexer) skipSpaceAndComments() error {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f':
+			l.pos++
+		case strings.HasPrefix(l.src[l.pos:], "//"):
+			end := strings.IndexByte(l.src[l.pos:], '\n')
+			if end < 0 {
+				l.pos = len(l.src)
+			} else {
+				l.pos += end
+			}
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				return fmt.Errorf("line %d: unterminated comment", l.line)
+			}
+			comment := l.src[l.pos : l.pos+2+end+2]
+			l.line += strings.Count(comment, "\n")
+			l.pos += len(comment)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// stringLiteral reads a single- or double-quoted string literal and returns its value.
+func (l *protoLexer) stringLiteral() (string, error) {
+	quote := l.src[l.pos]
+	line := l.line
+	l.pos++
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			return "", fmt.Errorf("line %d: unterminated string", line)
+		}
+		c := l.src[l.pos]
+		switch {
+		case c == quote:
+			l.pos++
+			return b.String(), nil
+		case c == '\\':
+			if err := l.escape(&b); err != nil {
+				return "", fmt.Errorf("line %d: %w", line, err)
+			}
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+// escape decodes the escape sequence at the current position.
+func (l *protoLexer) escape(b *strings.Builder) error {
+	l.pos++ // backslash
+	if l.pos >= len(l.src) {
+		return fmt.Errorf("invalid escape")
+	}
+	c := l.src[l.pos]
+	l.pos++
+	switch c {
+	case 'a':
+		b.WriteByte('\a')
+	case 'b':
+		b.WriteByte('\b')
+	case 'f':
+		b.WriteByte('\f')
+	case 'n':
+		b.WriteByte('\n')
+	case 'r':
+		b.WriteByte('\r')
+	case 't':
+		b.WriteByte('\t')
+	case 'v':
+		b.WriteByte('\v')
+	case '\\', '\'', '"', '?':
+		b.WriteByte(c)
+	case 'x', 'X':
+		return l.numericEscape(b, 16, 2)
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		l.pos--
+		return l.numericEscape(b, 8, 3)
+	default:
+		return fmt.Errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
+
+// numericEscape decodes up to max digits of the given base as a byte.
+func (l *protoLexer) numericEscape(b *strings.Builder, base, max int) error {
+	start := l.pos
+	for l.pos < len(l.src) && l.pos-start < max && isBaseDigit(l.src[l.pos], base) {
+		l.pos++
+	}
+	v, err := strconv.ParseUint(l.src[start:l.pos], base, 8)
+	if err != nil {
+		return fmt.Errorf("invalid escape \\%s", l.src[start:l.pos])
+	}
+	b.WriteByte(byte(v))
+	return nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isBaseDigit(c byte, base int) bool {
+	if base == 8 {
+		return '0' <= c && c <= '7'
+	}
+	return isDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
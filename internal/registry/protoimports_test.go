@@ -0,0 +1,56 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProtoImports(t *testing.T) {
+	content := `// import "commented/line.proto";
+syntax = "proto3";
+/* import "commented/block.proto";
+*/
+package acme.v1;
+
+import "acme/v1/types.proto";
+import public "acme/v1/public.proto";
+import weak 'acme/v1/weak.proto';
+import "acme/v1/" "split.proto";
+import "acme/v1/esc\x61ped.proto";
+
+option go_package = "import \"not/an/import.proto\";";
+
+message Pet {
+  string import = 1;
+  option (acme.opt) = { import: "not/an/import.proto" };
+}
+`
+	imports, err := ParseProtoImports(content)
+	if err != nil {
+		t.Fatalf("ParseProtoImports failed: %v", err)
+	}
+	want := []ProtoImport{
+		{Path: "acme/v1/types.proto", Line: 7},
+		{Path: "acme/v1/public.proto", Public: true, Line: 8},
+		{Path: "acme/v1/weak.proto", Weak: true, Line: 9},
+		{Path: "acme/v1/split.proto", Line: 10},
+		{Path: "acme/v1/escaped.proto", Line: 11},
+	}
+	if !reflect.DeepEqual(imports, want) {
+		t.Errorf("imports = %+v\nwant %+v", imports, want)
+	}
+}
+
+func TestParseProtoImports_Invalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"unterminated string":  "import \"a.proto;\n",
+		"unterminated comment": "syntax = \"proto3\";\n/* import \"a.proto\";",
+		"missing path":         "import a.proto;",
+		"missing semicolon":    "import \"a.proto\"\nmessage A {}",
+		"invalid escape":       `import "a\q.proto";`,
+	} {
+		if _, err := ParseProtoImports(content); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -2,11 +2,18 @@ package registry
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/greatliontech/pbr/internal/storage"
 	"github.com/greatliontech/pbr/internal/util"
 )
@@ -17,6 +24,28 @@ type Registry struct {
 	manifests storage.ManifestStore
 	metadata  storage.MetadataStore
 	hostName  string
+	files     storage.FileIndex
+	usage     storage.UsageStore
+	redirects storage.RedirectStore
+	batches   storage.BatchStore
+
+	ownersMu sync.Mutex // serializes owner creation, names must be unique
+	namesMu  sync.Mutex // serializes module creation and renames, names must be unique
+	// pruneMu is held for reading while commits are recorded and labels moved, and for
+	// writing while retention deletes commits, so that it doesn't delete commits being reused
+	pruneMu  sync.RWMutex
+	recorded atomic.Uint64 // counts the writes under pruneMu, for retention to detect them
+}
+
+// Option configures a Registry.
+type Option func(*Registry)
+
+// WithFileIndex maintains an index of the files of the commit each module's default
+// label points at, used by ModulesWithFile.
+func WithFileIndex(files storage.FileIndex) Option {
+	return func(r *Registry) {
+		r.files = files
+	}
 }
 
 // New creates a new CAS-backed registry.
@@ -25,13 +54,18 @@ func New(
 	manifests storage.ManifestStore,
 	metadata storage.MetadataStore,
 	hostName string,
+	opts ...Option,
 ) *Registry {
-	return &Registry{
+	r := &Registry{
 		blobs:     blobs,
 		manifests: manifests,
 		metadata:  metadata,
 		hostName:  hostName,
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 // HostName returns the registry's hostname.
@@ -47,6 +81,9 @@ func (r *Registry) Module(ctx context.Context, owner, name string) (*Module, err
 	if err != nil {
 		return nil, err
 	}
+	if isDeleted(record) {
+		return nil, storage.ErrNotFound
+	}
 
 	return &Module{
 		record:   record,
@@ -62,6 +99,9 @@ func (r *Registry) ModuleByID(ctx context.Context, id string) (*Module, error) {
 	if err != nil {
 		return nil, err
 	}
+	if isDeleted(record) {
+		return nil, storage.ErrNotFound
+	}
 
 	return &Module{
 		record:   record,
@@ -81,7 +121,8 @@ func (r *Registry) ModuleByCommitID(ctx context.Context, commitID string) (*Modu
 	return r.ModuleByID(ctx, commit.ModuleID)
 }
 
-// CommitByID retrieves a commit by its ID (from any module).
+// CommitByID retrieves a commit by its ID (from any module). Commits of deleted
+// modules are not found.
 func (r *Registry) CommitByID(ctx context.Context, commitID string) (*Commit, error) {
 	slog.DebugContext(ctx, "Registry.CommitByID", "commitID", commitID)
 
@@ -89,71 +130,76 @@ func (r *Registry) CommitByID(ctx context.Context, commitID string) (*Commit, er
 	if err != nil {
 		return nil, err
 	}
+	if mod, err := r.metadata.GetModule(ctx, record.ModuleID); err == nil && isDeleted(mod) {
+		return nil, storage.ErrNotFound
+	}
 
-	return &Commit{
-		ID:           record.ID,
-		ModuleID:     record.ModuleID,
-		OwnerID:      record.OwnerID,
-		FilesDigest:  record.FilesDigest,
-		ModuleDigest: record.ModuleDigest,
-		CreateTime:   record.CreateTime,
-		DepCommitIDs: record.DepCommitIDs,
-	}, nil
+	return commitFromRecord(record), nil
 }
 
-// GetDepModuleDigests retrieves module digests for a list of dependency commit IDs.
-func (r *Registry) GetDepModuleDigests(ctx context.Context, depCommitIDs []string) ([]storage.ModuleDigest, error) {
-	digests := make([]storage.ModuleDigest, 0, len(depCommitIDs))
+// LocalDeps builds dependency records for commits of this registry.
+func (r *Registry) LocalDeps(ctx context.Context, depCommitIDs []string) ([]storage.DepRecord, error) {
+	deps := make([]storage.DepRecord, 0, len(depCommitIDs))
 	for _, commitID := range depCommitIDs {
 		commit, err := r.CommitByID(ctx, commitID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get dependency commit %s: %w", commitID, err)
 		}
-		digests = append(digests, commit.ModuleDigest)
+		deps = append(deps, commit.AsDep())
 	}
-	return digests, nil
+	return deps, nil
 }
 
 // CreateModule creates a new module.
 func (r *Registry) CreateModule(ctx context.Context, owner, name, description string) (*Module, error) {
 	slog.DebugContext(ctx, "Registry.CreateModule", "owner", owner, "name", name)
+	return r.createModule(ctx, &storage.ModuleRecord{Owner: owner, Name: name, Description: description})
+}
 
-	// Get or create owner
-	ownerID := util.OwnerID(owner)
-	_, err := r.metadata.GetOwner(ctx, ownerID)
-	if err == storage.ErrNotFound {
-		// Create owner
-		ownerRecord := &storage.OwnerRecord{
-			ID:         ownerID,
-			Name:       owner,
-			CreateTime: time.Now(),
-		}
-		if err := r.metadata.CreateOwner(ctx, ownerRecord); err != nil && err != storage.ErrAlreadyExists {
-			return nil, fmt.Errorf("failed to create owner: %w", err)
-		}
-	} else if err != nil {
-		return nil, fmt.Errorf("failed to get owner: %w", err)
+// createModule creates a module from a record giving its owner, name and optional fields,
+// or returns the module that already has the name.
+func (r *Registry) createModule(ctx context.Context, record *storage.ModuleRecord) (*Module, error) {
+	// Get or create owner. Owners are looked up by name since user IDs are not derived from the name.
+	ownerRecord, err := r.getOrCreateOrganization(ctx, record.Owner)
+	if err != nil {
+		return nil, err
 	}
+	ownerID := ownerRecord.ID
 
-	moduleID := util.ModuleID(ownerID, name)
-	now := time.Now()
+	r.namesMu.Lock()
+	defer r.namesMu.Unlock()
 
-	record := &storage.ModuleRecord{
-		ID:               moduleID,
-		OwnerID:          ownerID,
-		Owner:            owner,
-		Name:             name,
-		Description:      description,
-		DefaultLabelName: "main",
-		CreateTime:       now,
-		UpdateTime:       now,
+	// Return the existing module, unless it is deleted and its name still reserved
+	existing, err := r.metadata.GetModuleByName(ctx, record.Owner, record.Name)
+	if err == nil {
+		if isDeleted(existing) {
+			return nil, ErrModuleDeleted
+		}
+		return &Module{record: existing, registry: r}, nil
+	}
+	if err != storage.ErrNotFound {
+		return nil, err
+	}
+	if err := r.checkNotRedirected(ctx, record.Owner, record.Name); err != nil {
+		return nil, err
 	}
 
-	if err := r.metadata.CreateModule(ctx, record); err != nil {
-		if err == storage.ErrAlreadyExists {
-			// Return existing module
-			return r.Module(ctx, owner, name)
-		}
+	now := time.Now()
+	record.ID = util.ModuleID(ownerID, record.Name)
+	record.OwnerID = ownerID
+	if record.DefaultLabelName == "" {
+		record.DefaultLabelName = "main"
+	}
+	record.CreateTime = now
+	record.UpdateTime = now
+
+	err = r.metadata.CreateModule(ctx, record)
+	if err == storage.ErrAlreadyExists {
+		// Renamed modules keep their ID, so the ID derived from the name can be taken
+		record.ID = strings.ReplaceAll(uuid.Must(uuid.NewV7()).String(), "-", "")
+		err = r.metadata.CreateModule(ctx, record)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to create module: %w", err)
 	}
 
@@ -175,7 +221,8 @@ func (r *Registry) GetOrCreateModule(ctx context.Context, owner, name string) (*
 	return r.CreateModule(ctx, owner, name, "")
 }
 
-// DeleteModule deletes a module by owner and name.
+// DeleteModule deletes a module by owner and name immediately, with its labels and commits,
+// whether or not it is soft deleted, and releases its index entries and usage.
 func (r *Registry) DeleteModule(ctx context.Context, owner, name string) error {
 	slog.DebugContext(ctx, "Registry.DeleteModule", "owner", owner, "name", name)
 
@@ -183,19 +230,312 @@ func (r *Registry) DeleteModule(ctx context.Context, owner, name string) error {
 	if err != nil {
 		return err
 	}
+	return r.purgeModule(ctx, record)
+}
+
+// purgeModule deletes a module with its labels and commits, and releases its index entries
+// and usage. The module record goes last, so that an interrupted purge can be retried.
+func (r *Registry) purgeModule(ctx context.Context, record *storage.ModuleRecord) error {
+	labels, err := r.metadata.ListLabels(ctx, record.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list labels: %w", err)
+	}
+	for _, l := range labels {
+		if err := r.metadata.DeleteLabel(ctx, record.ID, l.Name); err != nil {
+			return fmt.Errorf("failed to delete label %s: %w", l.Name, err)
+		}
+	}
+	commits, err := r.allCommits(ctx, record.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list commits: %w", err)
+	}
+	for _, c := range commits {
+		if err := r.metadata.DeleteCommit(ctx, c.ID); err != nil {
+			return fmt.Errorf("failed to delete commit %s: %w", c.ID, err)
+		}
+	}
+	if err := r.metadata.DeleteModule(ctx, record.ID); err != nil {
+		return err
+	}
+	r.indexFiles(ctx, record.ID, nil)
+	r.accountModuleDeleted(ctx, record)
+	return nil
+}
+
+// ModulesWithFile returns the modules whose default label commit contains a proto file.
+// Without a file index, it returns no modules.
+func (r *Registry) ModulesWithFile(ctx context.Context, path string) ([]*Module, error) {
+	if r.files == nil {
+		return nil, nil
+	}
+	ids, err := r.files.ModulesWithFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	modules := make([]*Module, 0, len(ids))
+	for _, id := range ids {
+		mod, err := r.ModuleByID(ctx, id)
+		if err == storage.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, mod)
+	}
+	return modules, nil
+}
+
+// BuildFileIndex indexes the default label commit of every module, once.
+// It brings registries created before the file index was introduced up to date.
+func (r *Registry) BuildFileIndex(ctx context.Context) error {
+	if r.files == nil {
+		return nil
+	}
+	built, err := r.files.Built(ctx)
+	if err != nil || built {
+		return err
+	}
+
+	owners, err := r.ListOwners(ctx)
+	if err != nil {
+		return err
+	}
+	for _, owner := range owners {
+		modules, err := r.ListModules(ctx, owner.Name)
+		if err != nil {
+			return err
+		}
+		for _, mod := range modules {
+			files, _, err := mod.FilesAndCommit(ctx, "")
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to index %s/%s: %w", mod.Owner(), mod.Name(), err)
+			}
+			r.indexFiles(ctx, mod.ID(), files)
+		}
+	}
+	return r.files.MarkBuilt(ctx)
+}
+
+// ApplyCommits records pending commits and moves their labels as one batch. If any write
+// fails, the writes already made are undone, so that either all commits and label moves
+// are applied or none are. Stored files are left in place, they are content-addressed.
+//
+// The metadata store has no transactions, so this is a best-effort compensation rather than
+// an atomic write: concurrent readers can see the commits and label moves of a batch before
+// it completes or is undone. With WithBatches, each write is journaled before it is made,
+// and batches interrupted by a crash, or whose writes failed to be undone, are undone by
+// RecoverBatches. Without it, they are left in place and undo failures are only logged.
+func (r *Registry) ApplyCommits(ctx context.Context, pending []*PendingCommit) (err error) {
+	r.pruneMu.RLock()
+	defer r.pruneMu.RUnlock()
+	if err := r.checkNotPruned(ctx, pending); err != nil {
+		return err
+	}
+
+	batch := &storage.Batch{ID: uuid.New().String(), CreateTime: time.Now().UTC()}
+	for _, p := range pending {
+		if p.moduleCreated && !slices.Contains(batch.Modules, p.module.record.ID) {
+			batch.Modules = append(batch.Modules, p.module.record.ID)
+		}
+	}
+	// journal records a write of the batch before it is made
+	journal := func(ctx context.Context, update func(*storage.Batch)) error {
+		if r.batches == nil {
+			return nil
+		}
+		update(batch)
+		if err := r.batches.PutBatch(ctx, batch); err != nil {
+			return fmt.Errorf("failed to journal commit batch: %w", err)
+		}
+		return nil
+	}
+
+	var undo []func(context.Context) error
+	defer func() {
+		if err != nil {
+			// Undo even if the request was canceled
+			ctx := context.WithoutCancel(ctx)
+			var undoErr error
+			for i := len(undo) - 1; i >= 0; i-- {
+				if e := undo[i](ctx); e != nil {
+					slog.ErrorContext(ctx, "failed to roll back commit batch", "batch", batch.ID, "error", e)
+					undoErr = e
+				}
+			}
+			if undoErr != nil {
+				// The journal is kept for RecoverBatches
+				return
+			}
+		}
+		if r.batches != nil {
+			if err := r.batches.DeleteBatch(context.WithoutCancel(ctx), batch.ID); err != nil {
+				slog.ErrorContext(ctx, "failed to delete commit batch journal", "batch", batch.ID, "error", err)
+			}
+		}
+	}()
+
+	for _, p := range pending {
+		record := p.record
+		switch {
+		case !p.exists:
+			if err := journal(ctx, func(b *storage.Batch) { b.Commits = append(b.Commits, record.ID) }); err != nil {
+				return err
+			}
+			if err := r.metadata.CreateCommit(ctx, record); err != nil {
+				return fmt.Errorf("failed to create commit: %w", err)
+			}
+			undo = append(undo, func(ctx context.Context) error {
+				return r.metadata.DeleteCommit(ctx, record.ID)
+			})
+		case p.modified:
+			prev, err := r.metadata.GetCommit(ctx, record.ID)
+			if err != nil {
+				return fmt.Errorf("failed to get commit: %w", err)
+			}
+			if err := journal(ctx, func(b *storage.Batch) { b.Updated = append(b.Updated, prev) }); err != nil {
+				return err
+			}
+			if err := r.metadata.UpdateCommit(ctx, record); err != nil {
+				return fmt.Errorf("failed to update commit: %w", err)
+			}
+			undo = append(undo, func(ctx context.Context) error {
+				return r.metadata.UpdateCommit(ctx, prev)
+			})
+		}
+
+		ownerID := p.module.record.OwnerID
+		for _, name := range p.labels {
+			var cond *labelCondition
+			if c, ok := p.conditions[name]; ok {
+				cond = &c
+			}
+			journaled := false
+			before, after, err := p.module.moveLabel(ctx, name, record.ID, cond, func(before, after *storage.LabelRecord) error {
+				return journal(ctx, func(b *storage.Batch) {
+					// A retried move replaces the one journaled before it
+					if journaled {
+						b.Labels = b.Labels[:len(b.Labels)-1]
+					}
+					journaled = true
+					b.Labels = append(b.Labels, storage.BatchLabel{OwnerID: ownerID, Before: before, After: after})
+				})
+			})
+			if err != nil {
+				return err
+			}
+			undo = append(undo, func(ctx context.Context) error {
+				return r.restoreLabel(ctx, ownerID, before, after)
+			})
+		}
+	}
+
+	r.recorded.Add(1)
+	for _, p := range pending {
+		if !p.exists {
+			r.accountCommit(ctx, p.record, p.blobs)
+		}
+		if slices.Contains(p.labels, p.module.DefaultLabelName()) {
+			r.indexFiles(ctx, p.module.record.ID, p.files)
+		}
+	}
+	return nil
+}
+
+// checkNotPruned checks that the commits a batch reuses, and the commits of this registry
+// its new commits depend on, weren't deleted by retention since they were looked up.
+// Otherwise the batch fails with storage.ErrConflict, to be retried.
+func (r *Registry) checkNotPruned(ctx context.Context, pending []*PendingCommit) error {
+	batched := make(map[string]bool)
+	for _, p := range pending {
+		batched[p.record.ID] = true
+	}
+	exists := func(id string) error {
+		_, err := r.metadata.GetCommit(ctx, id)
+		if errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("commit %s was pruned: %w", id, storage.ErrConflict)
+		}
+		return err
+	}
+	for _, p := range pending {
+		if p.exists {
+			if err := exists(p.record.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, dep := range p.record.Deps {
+			if dep.Registry != "" || batched[dep.CommitID] {
+				continue
+			}
+			if err := exists(dep.CommitID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restoreLabel undoes a label move, unless the label was moved again since.
+func (r *Registry) restoreLabel(ctx context.Context, ownerID string, before, after *storage.LabelRecord) error {
+	if before == nil {
+		current, err := r.metadata.GetLabel(ctx, after.ModuleID, after.Name)
+		if errors.Is(err, storage.ErrNotFound) || err == nil && current.Revision != after.Revision {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.metadata.DeleteLabel(ctx, after.ModuleID, after.Name); err != nil {
+			return err
+		}
+		r.accountLabels(ctx, ownerID, after.ModuleID, -1)
+		return nil
+	}
+	restored := *before
+	restored.Revision = after.Revision
+	if err := r.metadata.SwapLabel(ctx, &restored); err != nil && !errors.Is(err, storage.ErrConflict) {
+		return err
+	}
+	return nil
+}
 
-	return r.metadata.DeleteModule(ctx, record.ID)
+// indexFiles records the proto files of a module's default label commit in the file index.
+// The index only serves dependency inference, so failures are logged, not returned.
+func (r *Registry) indexFiles(ctx context.Context, moduleID string, files []File) {
+	if r.files == nil {
+		return
+	}
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		if strings.HasSuffix(f.Path, ".proto") {
+			paths = append(paths, f.Path)
+		}
+	}
+	if err := r.files.SetModuleFiles(ctx, moduleID, paths); err != nil {
+		slog.WarnContext(ctx, "failed to update file index", "moduleID", moduleID, "error", err)
+	}
 }
 
 // ListModules lists all modules for an owner.
 func (r *Registry) ListModules(ctx context.Context, owner string) ([]*Module, error) {
 	slog.DebugContext(ctx, "Registry.ListModules", "owner", owner)
 
-	ownerID := util.OwnerID(owner)
-	records, err := r.metadata.ListModules(ctx, ownerID)
+	ownerRecord, err := r.metadata.GetOwnerByName(ctx, owner)
+	if err == storage.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	records, err := r.metadata.ListModules(ctx, ownerRecord.ID)
 	if err != nil {
 		return nil, err
 	}
+	records = slices.DeleteFunc(records, isDeleted)
 
 	modules := make([]*Module, len(records))
 	for i, record := range records {
@@ -207,6 +547,122 @@ func (r *Registry) ListModules(ctx context.Context, owner string) ([]*Module, er
 	return modules, nil
 }
 
+// ModuleQuery filters, orders and pages a module listing.
+type ModuleQuery struct {
+	OwnerIDs   []string // owners to list modules for; empty lists the modules of all owners
+	NamePrefix string   // matched against the module name, or against "owner/name" if it contains a slash
+	Descending bool     // newest first; oldest first otherwise
+	PageSize   int      // zero returns all matching modules
+	PageToken  string   // position of the last module of the previous page
+}
+
+// ErrInvalidPageToken is returned for a page token that was not returned by QueryModules.
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// modulePageToken encodes the position of a module in a listing: its creation time and ID.
+// Positions stay valid when modules are created or deleted between pages.
+func modulePageToken(m *storage.ModuleRecord) string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%d:%s", m.CreateTime.UnixNano(), m.ID))
+}
+
+// parseModulePageToken decodes a page token into the position of a module.
+func parseModulePageToken(token string) (*storage.ModuleRecord, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	nanos, id, ok := strings.Cut(string(b), ":")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if !ok || err != nil || id == "" {
+		return nil, ErrInvalidPageToken
+	}
+	return &storage.ModuleRecord{ID: id, CreateTime: time.Unix(0, n)}, nil
+}
+
+// QueryModules lists modules ordered by creation time and returns one page,
+// along with the token for the next page or "" if this is the last page.
+func (r *Registry) QueryModules(ctx context.Context, q ModuleQuery) ([]*Module, string, error) {
+	slog.DebugContext(ctx, "Registry.QueryModules", "owners", len(q.OwnerIDs), "prefix", q.NamePrefix)
+
+	var records []*storage.ModuleRecord
+	if len(q.OwnerIDs) == 0 {
+		all, err := r.metadata.ListModules(ctx, "")
+		if err != nil {
+			return nil, "", err
+		}
+		records = all
+	}
+	seen := map[string]bool{}
+	for _, ownerID := range q.OwnerIDs {
+		if seen[ownerID] {
+			continue
+		}
+		seen[ownerID] = true
+		owned, err := r.metadata.ListModules(ctx, ownerID)
+		if err != nil {
+			return nil, "", err
+		}
+		records = append(records, owned...)
+	}
+
+	records = slices.DeleteFunc(records, isDeleted)
+	if q.NamePrefix != "" {
+		records = slices.DeleteFunc(records, func(m *storage.ModuleRecord) bool {
+			name := m.Name
+			if strings.Contains(q.NamePrefix, "/") {
+				name = m.Owner + "/" + m.Name
+			}
+			return !strings.HasPrefix(name, q.NamePrefix)
+		})
+	}
+
+	compare := func(a, b *storage.ModuleRecord) int {
+		c := a.CreateTime.Compare(b.CreateTime)
+		if c == 0 {
+			c = strings.Compare(a.ID, b.ID)
+		}
+		if q.Descending {
+			return -c
+		}
+		return c
+	}
+	slices.SortFunc(records, compare)
+
+	start := 0
+	if q.PageToken != "" {
+		last, err := parseModulePageToken(q.PageToken)
+		if err != nil {
+			return nil, "", err
+		}
+		// Resume after the position of the last module, which may since have been deleted
+		start, _ = slices.BinarySearchFunc(records, last, compare)
+		if start < len(records) && records[start].ID == last.ID {
+			start++
+		}
+	}
+	end := len(records)
+	if q.PageSize > 0 {
+		end = min(start+q.PageSize, len(records))
+	}
+	if start >= end {
+		return nil, "", nil
+	}
+
+	var next string
+	if end < len(records) {
+		next = modulePageToken(records[end-1])
+	}
+
+	modules := make([]*Module, 0, end-start)
+	for _, record := range records[start:end] {
+		modules = append(modules, &Module{
+			record:   record,
+			registry: r,
+		})
+	}
+	return modules, next, nil
+}
+
 // Owner retrieves an owner by ID.
 func (r *Registry) Owner(ctx context.Context, id string) (*storage.OwnerRecord, error) {
 	return r.metadata.GetOwner(ctx, id)
@@ -238,62 +694,43 @@ type Commit struct {
 	ModuleDigest storage.ModuleDigest // module digest (B5)
 	CreateTime   time.Time
 	DepCommitIDs []string // dependency commit IDs
-}
-
-// BufLock represents a parsed buf.lock file.
-type BufLock struct {
-	Deps []BufLockDep
-}
+	// Deps are the dependencies with their registry and digest. For commits
+	// created before these were recorded, only CommitID is set.
+	Deps []storage.DepRecord
 
-// BufLockDep represents a dependency in buf.lock.
-type BufLockDep struct {
-	Remote     string
-	Owner      string
-	Repository string
-	Commit     string
-	Digest     string
+	CreatedByUserID  string // ID of the user who pushed the commit, if known
+	SourceControlURL string
+	Findings         []storage.Finding
 }
 
-// ParseBufLock parses buf.lock content.
-func ParseBufLock(content string) (*BufLock, error) {
-	// Simple YAML-like parsing for buf.lock format
-	lock := &BufLock{}
-	lines := strings.Split(content, "\n")
-	var currentDep *BufLockDep
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		if line == "deps:" {
-			continue
-		}
-
-		if strings.HasPrefix(line, "- remote:") {
-			if currentDep != nil {
-				lock.Deps = append(lock.Deps, *currentDep)
-			}
-			currentDep = &BufLockDep{
-				Remote: strings.TrimSpace(strings.TrimPrefix(line, "- remote:")),
-			}
-		} else if currentDep != nil {
-			if strings.HasPrefix(line, "owner:") {
-				currentDep.Owner = strings.TrimSpace(strings.TrimPrefix(line, "owner:"))
-			} else if strings.HasPrefix(line, "repository:") {
-				currentDep.Repository = strings.TrimSpace(strings.TrimPrefix(line, "repository:"))
-			} else if strings.HasPrefix(line, "commit:") {
-				currentDep.Commit = strings.TrimSpace(strings.TrimPrefix(line, "commit:"))
-			} else if strings.HasPrefix(line, "digest:") {
-				currentDep.Digest = strings.TrimSpace(strings.TrimPrefix(line, "digest:"))
-			}
+func commitFromRecord(record *storage.CommitRecord) *Commit {
+	deps := record.Deps
+	if len(deps) == 0 {
+		for _, id := range record.DepCommitIDs {
+			deps = append(deps, storage.DepRecord{CommitID: id})
 		}
 	}
-
-	if currentDep != nil {
-		lock.Deps = append(lock.Deps, *currentDep)
+	return &Commit{
+		ID:               record.ID,
+		ModuleID:         record.ModuleID,
+		OwnerID:          record.OwnerID,
+		FilesDigest:      record.FilesDigest,
+		ModuleDigest:     record.ModuleDigest,
+		CreateTime:       record.CreateTime,
+		DepCommitIDs:     record.DepCommitIDs,
+		Deps:             deps,
+		CreatedByUserID:  record.CreatedByUserID,
+		SourceControlURL: record.SourceControlURL,
+		Findings:         record.Findings,
 	}
+}
 
-	return lock, nil
+// AsDep returns the dependency record of a commit of this registry.
+func (c *Commit) AsDep() storage.DepRecord {
+	return storage.DepRecord{
+		CommitID: c.ID,
+		OwnerID:  c.OwnerID,
+		ModuleID: c.ModuleID,
+		Digest:   c.ModuleDigest,
+	}
 }
@@ -2,7 +2,9 @@ package registry
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/greatliontech/pbr/internal/storage"
 	"gocloud.dev/blob/memblob"
@@ -92,7 +94,7 @@ func TestRegistry_CreateCommit(t *testing.T) {
 		{Path: "buf.yaml", Content: "version: v1\nname: buf.build/testowner/testmodule"},
 	}
 
-	commit, err := mod.CreateCommit(ctx, files, []string{"main"}, "", nil, nil)
+	commit, err := mod.CreateCommit(ctx, files, []string{"main"}, "", "", nil)
 	if err != nil {
 		t.Fatalf("CreateCommit failed: %v", err)
 	}
@@ -150,7 +152,7 @@ func TestRegistry_ModuleByCommitID(t *testing.T) {
 		{Path: "test.proto", Content: "syntax = \"proto3\";"},
 	}
 
-	commit, err := mod.CreateCommit(ctx, files, []string{"main"}, "", nil, nil)
+	commit, err := mod.CreateCommit(ctx, files, []string{"main"}, "", "", nil)
 	if err != nil {
 		t.Fatalf("CreateCommit failed: %v", err)
 	}
@@ -181,12 +183,12 @@ func TestRegistry_CommitDeduplication(t *testing.T) {
 	}
 
 	// Create same content twice
-	commit1, err := mod.CreateCommit(ctx, files, []string{"main"}, "", nil, nil)
+	commit1, err := mod.CreateCommit(ctx, files, []string{"main"}, "", "", nil)
 	if err != nil {
 		t.Fatalf("CreateCommit failed: %v", err)
 	}
 
-	commit2, err := mod.CreateCommit(ctx, files, []string{"v1.0.0"}, "", nil, nil)
+	commit2, err := mod.CreateCommit(ctx, files, []string{"v1.0.0"}, "", "", nil)
 	if err != nil {
 		t.Fatalf("CreateCommit failed: %v", err)
 	}
@@ -212,39 +214,788 @@ func TestRegistry_CommitDeduplication(t *testing.T) {
 	}
 }
 
-func TestParseBufLock(t *testing.T) {
-	content := `version: v1
-deps:
-  - remote: buf.build
-    owner: googleapis
-    repository: googleapis
-    commit: cc916c31859748a68fd229a3c8d7a2e8
-    digest: shake256:469b049d0f58c6eedc4f3ae52e5b4395a99d6417e0d5a3cdd04b400dc4b3e4f41d7ce326a96c1d1c955a7fdf61a3e6b0c31a3da9692d5d72e4e50a30a9e16f1
-  - remote: buf.build
-    owner: grpc-ecosystem
-    repository: grpc-gateway
-    commit: bc28b723cd7746fa80d15cbc63c8b5e8
-    digest: shake256:8b1d7f88bfc34b79a68e82c3c0a23f1842fbcbf8c7d4de0b31d2a6e5c7a0f9e3d7c1f5e2b8a9d4c0e7f1a3b6c9d2e5f8a1b4c7d0e3f6a9b2c5d8e1f4a7b0c3d6e9
-`
+func TestRegistry_GetOrCreateUser(t *testing.T) {
+	reg, cleanup := setupTestRegistry(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user, err := reg.GetOrCreateUser(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	if user.Type != storage.OwnerTypeUser {
+		t.Errorf("expected user type, got %q", user.Type)
+	}
+
+	again, err := reg.GetOrCreateUser(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	if again.ID != user.ID {
+		t.Errorf("expected stable ID %s, got %s", user.ID, again.ID)
+	}
+
+	// Modules pushed to the user's namespace belong to the user record
+	mod, err := reg.CreateModule(ctx, "alice", "petapis", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	if mod.OwnerID() != user.ID {
+		t.Errorf("expected module owner %s, got %s", user.ID, mod.OwnerID())
+	}
+	mods, err := reg.ListModules(ctx, "alice")
+	if err != nil || len(mods) != 1 {
+		t.Errorf("expected 1 module for alice, got %d (%v)", len(mods), err)
+	}
+
+	// An organization is not taken over by a user of the same name
+	org, err := reg.CreateModule(ctx, "bob", "api", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	if _, err := reg.GetOrCreateUser(ctx, "bob"); !errors.Is(err, ErrOwnerIsOrganization) {
+		t.Errorf("expected ErrOwnerIsOrganization, got %v", err)
+	}
+	owner, err := reg.OwnerByName(ctx, "bob")
+	if err != nil {
+		t.Fatalf("OwnerByName failed: %v", err)
+	}
+	if owner.ID != org.OwnerID() || owner.Type != storage.OwnerTypeOrganization {
+		t.Errorf("expected organization %s unchanged, got %+v", org.OwnerID(), owner)
+	}
+}
+
+func TestRegistry_MirrorModule(t *testing.T) {
+	reg, cleanup := setupTestRegistry(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	mirrored, err := reg.MirrorModule(ctx, "buf.build", "googleapis", "googleapis", "master")
+	if err != nil {
+		t.Fatalf("MirrorModule failed: %v", err)
+	}
+	if mirrored.Mirror() != "buf.build" || mirrored.DefaultLabelName() != "master" {
+		t.Errorf("unexpected mirrored module: mirror %q, default label %q", mirrored.Mirror(), mirrored.DefaultLabelName())
+	}
+	again, err := reg.MirrorModule(ctx, "buf.build", "googleapis", "googleapis", "master")
+	if err != nil || again.ID() != mirrored.ID() {
+		t.Errorf("expected the mirrored module again, got %v, %v", again, err)
+	}
+
+	// Modules hosted here, such as one created by a concurrent upload, are never turned into mirrors
+	if _, err := reg.CreateModule(ctx, "acme", "api", ""); err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	if _, err := reg.MirrorModule(ctx, "buf.build", "acme", "api", ""); !errors.Is(err, ErrNotMirrored) {
+		t.Errorf("expected ErrNotMirrored, got %v", err)
+	}
+	local, err := reg.Module(ctx, "acme", "api")
+	if err != nil {
+		t.Fatalf("Module failed: %v", err)
+	}
+	if local.Mirror() != "" {
+		t.Errorf("expected acme/api to stay hosted here, got mirror %q", local.Mirror())
+	}
+}
+
+// failingLabels fails moves of one label, to test rollbacks.
+type failingLabels struct {
+	storage.MetadataStore
+	name string
+}
+
+func (f *failingLabels) SwapLabel(ctx context.Context, label *storage.LabelRecord) error {
+	if label.Name == f.name {
+		return errors.New("label store unavailable")
+	}
+	return f.MetadataStore.SwapLabel(ctx, label)
+}
+
+func TestRegistry_ApplyCommitsRollsBack(t *testing.T) {
+	reg, cleanup := setupTestRegistry(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	a, err := reg.CreateModule(ctx, "acme", "a", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	b, err := reg.CreateModule(ctx, "acme", "b", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	before, err := a.CreateCommit(ctx, []File{{Path: "a.proto", Content: "// v1"}}, []string{"main"}, "", "", nil)
+	if err != nil {
+		t.Fatalf("CreateCommit failed: %v", err)
+	}
+
+	reg.metadata = &failingLabels{MetadataStore: reg.metadata, name: "broken"}
+	pa, err := a.PrepareCommit(ctx, time.Now(), []File{{Path: "a.proto", Content: "// v2"}}, []string{"main", "v2"}, "", "", nil)
+	if err != nil {
+		t.Fatalf("PrepareCommit failed: %v", err)
+	}
+	pb, err := b.PrepareCommit(ctx, time.Now(), []File{{Path: "b.proto", Content: "// v1"}}, []string{"main", "broken"}, "", "", []storage.DepRecord{pa.Commit().AsDep()})
+	if err != nil {
+		t.Fatalf("PrepareCommit failed: %v", err)
+	}
+
+	if err := reg.ApplyCommits(ctx, []*PendingCommit{pa, pb}); err == nil {
+		t.Fatal("expected ApplyCommits to fail")
+	}
+
+	for _, id := range []string{pa.Commit().ID, pb.Commit().ID} {
+		if _, err := reg.CommitByID(ctx, id); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected commit %s to be rolled back, got %v", id, err)
+		}
+	}
+	if got, err := a.Commit(ctx, "main"); err != nil || got.ID != before.ID {
+		t.Errorf("expected main of a to be restored to %s, got %v %v", before.ID, got, err)
+	}
+	for _, label := range []struct {
+		mod  *Module
+		name string
+	}{{a, "v2"}, {b, "main"}} {
+		if _, err := label.mod.Commit(ctx, label.name); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected label %s of %s to be deleted, got %v", label.name, label.mod.Name(), err)
+		}
+	}
+}
+
+// failingCommitDeletes fails all commit deletions, so that batches can't be undone.
+type failingCommitDeletes struct {
+	storage.MetadataStore
+}
 
-	lock, err := ParseBufLock(content)
+func (failingCommitDeletes) DeleteCommit(context.Context, string) error {
+	return errors.New("commit store unavailable")
+}
+
+func TestRegistry_RecoverBatches(t *testing.T) {
+	reg, cleanup := setupTestRegistry(t)
+	defer cleanup()
+	batches, err := memdocstore.OpenCollection("ID", nil)
 	if err != nil {
-		t.Fatalf("ParseBufLock failed: %v", err)
+		t.Fatalf("failed to open batches collection: %v", err)
 	}
+	defer batches.Close()
+	reg.batches = storage.NewBatchStore(batches)
+	ctx := context.Background()
 
-	if len(lock.Deps) != 2 {
-		t.Errorf("expected 2 deps, got %d", len(lock.Deps))
+	a, err := reg.CreateModule(ctx, "acme", "a", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	before, err := a.CreateCommit(ctx, []File{{Path: "a.proto", Content: "// v1"}}, []string{"main"}, "", "", nil)
+	if err != nil {
+		t.Fatalf("CreateCommit failed: %v", err)
+	}
+	if n, err := reg.RecoverBatches(ctx, time.Now()); err != nil || n != 0 {
+		t.Fatalf("expected applied batches to leave no journal, got %d %v", n, err)
+	}
+	b, err := reg.CreateModule(ctx, "acme", "b", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
 	}
 
-	if lock.Deps[0].Remote != "buf.build" {
-		t.Errorf("expected remote 'buf.build', got %s", lock.Deps[0].Remote)
+	// The batch fails, and so does undoing its commits, as if the process had stopped
+	metadata := reg.metadata
+	reg.metadata = &failingCommitDeletes{&failingLabels{MetadataStore: metadata, name: "broken"}}
+	pa, err := a.PrepareCommit(ctx, time.Now(), []File{{Path: "a.proto", Content: "// v2"}}, []string{"main"}, "", "", nil)
+	if err != nil {
+		t.Fatalf("PrepareCommit failed: %v", err)
+	}
+	pb, err := b.PrepareCommit(ctx, time.Now(), []File{{Path: "b.proto", Content: "// v1"}}, []string{"broken"}, "", "", nil)
+	if err != nil {
+		t.Fatalf("PrepareCommit failed: %v", err)
+	}
+	pb.ModuleCreated()
+	if err := reg.ApplyCommits(ctx, []*PendingCommit{pa, pb}); err == nil {
+		t.Fatal("expected ApplyCommits to fail")
+	}
+	if _, err := reg.CommitByID(ctx, pb.Commit().ID); err != nil {
+		t.Fatalf("expected commit %s to be left behind, got %v", pb.Commit().ID, err)
 	}
 
-	if lock.Deps[0].Owner != "googleapis" {
-		t.Errorf("expected owner 'googleapis', got %s", lock.Deps[0].Owner)
+	reg.metadata = metadata
+	if n, err := reg.RecoverBatches(ctx, time.Now().Add(-time.Minute)); err != nil || n != 0 {
+		t.Fatalf("expected a batch started since to be left alone, got %d %v", n, err)
+	}
+	if n, err := reg.RecoverBatches(ctx, time.Now()); err != nil || n != 1 {
+		t.Fatalf("expected 1 batch to be recovered, got %d %v", n, err)
 	}
+	for _, id := range []string{pa.Commit().ID, pb.Commit().ID} {
+		if _, err := reg.CommitByID(ctx, id); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected commit %s to be undone, got %v", id, err)
+		}
+	}
+	if got, err := a.Commit(ctx, "main"); err != nil || got.ID != before.ID {
+		t.Errorf("expected main of a to point at %s, got %v %v", before.ID, got, err)
+	}
+	if _, err := reg.Module(ctx, "acme", "b"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected the module created for the batch to be deleted, got %v", err)
+	}
+	if _, err := reg.Module(ctx, "acme", "a"); err != nil {
+		t.Errorf("expected the existing module to be kept, got %v", err)
+	}
+	if n, err := reg.RecoverBatches(ctx, time.Now()); err != nil || n != 0 {
+		t.Errorf("expected the journal to be deleted, got %d %v", n, err)
+	}
+}
+
+// racingLabels moves a label to another commit right before each of the first races swaps of it,
+// as a concurrent push would.
+type racingLabels struct {
+	storage.MetadataStore
+	races int
+}
+
+func (r *racingLabels) SwapLabel(ctx context.Context, label *storage.LabelRecord) error {
+	if r.races > 0 {
+		r.races--
+		current, err := r.MetadataStore.GetLabel(ctx, label.ModuleID, label.Name)
+		if err != nil {
+			return err
+		}
+		current.CommitID = "other"
+		if err := r.MetadataStore.SwapLabel(ctx, current); err != nil {
+			return err
+		}
+	}
+	return r.MetadataStore.SwapLabel(ctx, label)
+}
 
-	if lock.Deps[0].Repository != "googleapis" {
-		t.Errorf("expected repository 'googleapis', got %s", lock.Deps[0].Repository)
+func TestRegistry_LabelMovesCompareAndSwap(t *testing.T) {
+	reg, cleanup := setupTestRegistry(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	mod, err := reg.CreateModule(ctx, "acme", "a", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	first, err := mod.CreateCommit(ctx, []File{{Path: "a.proto", Content: "// v1"}}, []string{"main"}, "", "", nil)
+	if err != nil {
+		t.Fatalf("CreateCommit failed: %v", err)
+	}
+
+	// A lost race is retried
+	store := &racingLabels{MetadataStore: reg.metadata, races: 1}
+	reg.metadata = store
+	second, err := mod.CreateCommit(ctx, []File{{Path: "a.proto", Content: "// v2"}}, []string{"main"}, "", "", nil)
+	if err != nil {
+		t.Fatalf("CreateCommit failed: %v", err)
+	}
+	label, err := reg.metadata.GetLabel(ctx, mod.ID(), "main")
+	if err != nil || label.CommitID != second.ID || label.Revision != 3 {
+		t.Errorf("expected main at %s revision 3, got %+v %v", second.ID, label, err)
+	}
+
+	// Losing every race fails with ErrConflict
+	store.races = maxLabelRetries
+	if _, err := mod.CreateCommit(ctx, []File{{Path: "a.proto", Content: "// v3"}}, []string{"main"}, "", "", nil); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	// Conditional moves fail if the label points elsewhere
+	reg.metadata = store.MetadataStore
+	pending, err := mod.PrepareCommit(ctx, time.Now(), []File{{Path: "a.proto", Content: "// v4"}}, []string{"main"}, "", "", nil)
+	if err != nil {
+		t.Fatalf("PrepareCommit failed: %v", err)
+	}
+	pending.ExpectLabel("main", first.ID)
+	if err := reg.ApplyCommits(ctx, []*PendingCommit{pending}); !errors.Is(err, ErrLabelMoved) {
+		t.Errorf("expected ErrLabelMoved, got %v", err)
+	}
+	pending.ExpectLabel("main", "other")
+	if err := reg.ApplyCommits(ctx, []*PendingCommit{pending}); err != nil {
+		t.Errorf("ApplyCommits with the current commit expected failed: %v", err)
+	}
+}
+
+func TestRegistry_Usage(t *testing.T) {
+	reg, cleanup := setupTestRegistry(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	newUsage := func() storage.UsageStore {
+		coll, err := memdocstore.OpenCollection("ID", nil)
+		if err != nil {
+			t.Fatalf("failed to open usage collection: %v", err)
+		}
+		t.Cleanup(func() { coll.Close() })
+		return storage.NewUsageStore(coll)
+	}
+	ownerUsage := func(ownerID string) storage.Usage {
+		t.Helper()
+		u, err := reg.Usage().OwnerUsage(ctx, ownerID)
+		if err != nil {
+			t.Fatalf("OwnerUsage failed: %v", err)
+		}
+		return *u
+	}
+
+	reg.usage = newUsage()
+	mod, err := reg.CreateModule(ctx, "acme", "pets", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	if _, err := mod.CreateCommit(ctx, []File{{Path: "a.proto", Content: "aaaa"}, {Path: "b.proto", Content: "bb"}}, []string{"main"}, "", "", nil); err != nil {
+		t.Fatalf("CreateCommit failed: %v", err)
+	}
+	if _, err := mod.CreateCommit(ctx, []File{{Path: "a.proto", Content: "aaaa"}, {Path: "c.proto", Content: "ccc"}}, []string{"main", "v1"}, "", "", nil); err != nil {
+		t.Fatalf("CreateCommit failed: %v", err)
+	}
+	want := storage.Usage{OwnerID: mod.OwnerID(), Bytes: 9, Commits: 2, Labels: 2}
+	if got := ownerUsage(mod.OwnerID()); got != want {
+		t.Errorf("incremental usage = %+v, want %+v", got, want)
+	}
+
+	// Building usage for existing commits gives the same result, once
+	reg.usage = newUsage()
+	if err := reg.BuildUsage(ctx); err != nil {
+		t.Fatalf("BuildUsage failed: %v", err)
+	}
+	if got := ownerUsage(mod.OwnerID()); got != want {
+		t.Errorf("built usage = %+v, want %+v", got, want)
+	}
+	if err := reg.BuildUsage(ctx); err != nil {
+		t.Fatalf("BuildUsage failed: %v", err)
+	}
+	if got := ownerUsage(mod.OwnerID()); got != want {
+		t.Errorf("usage after second build = %+v, want %+v", got, want)
+	}
+
+	if err := reg.DeleteModule(ctx, "acme", "pets"); err != nil {
+		t.Fatalf("DeleteModule failed: %v", err)
+	}
+	if got := ownerUsage(mod.OwnerID()); got != (storage.Usage{OwnerID: mod.OwnerID()}) {
+		t.Errorf("usage after deleting the module = %+v", got)
+	}
+}
+
+func TestRegistry_ApplyRetention(t *testing.T) {
+	reg, cleanup := setupTestRegistry(t)
+	defer cleanup()
+	ctx := context.Background()
+	now := time.Now()
+
+	pets, err := reg.CreateModule(ctx, "acme", "pets", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	app, err := reg.CreateModule(ctx, "acme", "app", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	commit := func(mod *Module, age time.Duration, content string, labels []string, deps ...*Commit) *Commit {
+		t.Helper()
+		var depRecords []storage.DepRecord
+		for _, d := range deps {
+			depRecords = append(depRecords, d.AsDep())
+		}
+		c, err := mod.CreateCommitAt(ctx, now.Add(-age), []File{{Path: "a.proto", Content: content}, {Path: "shared.proto", Content: "shared"}}, labels, "", "", depRecords)
+		if err != nil {
+			t.Fatalf("CreateCommitAt failed: %v", err)
+		}
+		return c
+	}
+	day := 24 * time.Hour
+	c1 := commit(pets, 40*day, "v1", nil)
+	c2 := commit(pets, 30*day, "v2", nil)
+	c3 := commit(pets, 20*day, "v3", nil)
+	c4 := commit(pets, 10*day, "v4", []string{"main"})
+	commit(app, 40*day, "app", []string{"main"}, c2)
+
+	opts := RetentionOptions{
+		Policy: func(mod *Module) (RetentionPolicy, bool) {
+			return RetentionPolicy{KeepFor: 25 * day}, mod.Name() == "pets"
+		},
+		// Files stored by this test are past the grace period
+		Now:         now.Add(2 * time.Hour),
+		GracePeriod: time.Hour,
+		DryRun:      true,
+	}
+	prunedIDs := func(result *RetentionResult) []string {
+		var ids []string
+		for _, c := range result.Pruned {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+
+	// c2 is kept as app depends on it, c3 for its age and c4 for its label
+	result, err := reg.ApplyRetention(ctx, opts)
+	if err != nil {
+		t.Fatalf("ApplyRetention failed: %v", err)
+	}
+	if ids := prunedIDs(result); len(ids) != 1 || ids[0] != c1.ID || result.Manifests != 1 || result.Blobs != 1 {
+		t.Fatalf("dry run: pruned %v, %d manifests, %d blobs", ids, result.Manifests, result.Blobs)
+	}
+	if _, err := reg.CommitByID(ctx, c1.ID); err != nil {
+		t.Fatalf("dry run deleted commit: %v", err)
+	}
+
+	opts.DryRun = false
+	if _, err := reg.ApplyRetention(ctx, opts); err != nil {
+		t.Fatalf("ApplyRetention failed: %v", err)
+	}
+	if _, err := reg.CommitByID(ctx, c1.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected c1 to be pruned, got %v", err)
+	}
+	for _, c := range []*Commit{c2, c3, c4} {
+		if _, err := reg.CommitByID(ctx, c.ID); err != nil {
+			t.Errorf("expected %s to be kept, got %v", c.ID, err)
+		}
+	}
+	if exists, _ := reg.manifests.Exists(ctx, c1.FilesDigest); exists {
+		t.Error("expected the manifest of c1 to be collected")
+	}
+	if files, _, err := pets.FilesAndCommitByCommitID(ctx, c2.ID); err != nil || len(files) != 2 {
+		t.Errorf("expected the files of c2 to be kept, got %d files, %v", len(files), err)
+	}
+
+	// Without the dependent module, c2 is pruned too
+	if err := reg.DeleteModule(ctx, "acme", "app"); err != nil {
+		t.Fatalf("DeleteModule failed: %v", err)
+	}
+	result, err = reg.ApplyRetention(ctx, opts)
+	if err != nil {
+		t.Fatalf("ApplyRetention failed: %v", err)
+	}
+	if ids := prunedIDs(result); len(ids) != 1 || ids[0] != c2.ID {
+		t.Errorf("pruned %v, want c2", ids)
+	}
+}
+
+// labelHook runs a function the first time the labels of a module are listed.
+type labelHook struct {
+	storage.MetadataStore
+	moduleID string
+	hook     func()
+}
+
+func (h *labelHook) ListLabels(ctx context.Context, moduleID string) ([]*storage.LabelRecord, error) {
+	if moduleID == h.moduleID && h.hook != nil {
+		hook := h.hook
+		h.hook = nil
+		hook()
+	}
+	return h.MetadataStore.ListLabels(ctx, moduleID)
+}
+
+func TestRegistry_ApplyRetentionConcurrentUploads(t *testing.T) {
+	reg, cleanup := setupTestRegistry(t)
+	defer cleanup()
+	ctx := context.Background()
+	day := 24 * time.Hour
+	now := time.Now()
+
+	// The commits of app are listed before those of pets
+	app, err := reg.CreateModule(ctx, "acme", "app", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	pets, err := reg.CreateModule(ctx, "acme", "pets", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	old1, err := pets.CreateCommitAt(ctx, now.Add(-40*day), []File{{Path: "pets.proto", Content: "// v1"}}, nil, "", "", nil)
+	if err != nil {
+		t.Fatalf("CreateCommitAt failed: %v", err)
+	}
+	old2, err := pets.CreateCommitAt(ctx, now.Add(-30*day), []File{{Path: "pets.proto", Content: "// v2"}}, nil, "", "", nil)
+	if err != nil {
+		t.Fatalf("CreateCommitAt failed: %v", err)
+	}
+	if _, err := pets.CreateCommit(ctx, []File{{Path: "pets.proto", Content: "// v3"}}, []string{"main"}, "", "", nil); err != nil {
+		t.Fatalf("CreateCommit failed: %v", err)
+	}
+
+	// A push reusing old2 is prepared before retention runs
+	reuse, err := pets.PrepareCommit(ctx, now, []File{{Path: "pets.proto", Content: "// v2"}}, []string{"v2"}, "", "", nil)
+	if err != nil {
+		t.Fatalf("PrepareCommit failed: %v", err)
+	}
+
+	// A push depending on old1 is applied while retention selects the commits to prune
+	metadata := reg.metadata
+	reg.metadata = &labelHook{MetadataStore: metadata, moduleID: pets.ID(), hook: func() {
+		if _, err := app.CreateCommit(ctx, []File{{Path: "app.proto", Content: "// v1"}}, []string{"main"}, "", "", []storage.DepRecord{old1.AsDep()}); err != nil {
+			t.Errorf("CreateCommit failed: %v", err)
+		}
+	}}
+	result, err := reg.ApplyRetention(ctx, RetentionOptions{
+		Policy: func(mod *Module) (RetentionPolicy, bool) {
+			return RetentionPolicy{KeepFor: 25 * day}, mod.Name() == "pets"
+		},
+		Now: now,
+	})
+	reg.metadata = metadata
+	if err != nil {
+		t.Fatalf("ApplyRetention failed: %v", err)
+	}
+	if len(result.Pruned) != 1 || result.Pruned[0].ID != old2.ID {
+		t.Fatalf("expected only %s to be pruned, got %v", old2.ID, result.Pruned)
+	}
+	if _, err := reg.CommitByID(ctx, old1.ID); err != nil {
+		t.Errorf("expected the commit depended on meanwhile to be kept, got %v", err)
+	}
+
+	// The push reusing the pruned commit fails, to be retried
+	if err := reg.ApplyCommits(ctx, []*PendingCommit{reuse}); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("expected ErrConflict reusing a pruned commit, got %v", err)
+	}
+	if _, err := pets.Commit(ctx, "v2"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected v2 not to point at the pruned commit, got %v", err)
+	}
+}
+
+func TestRegistry_SoftDeleteModule(t *testing.T) {
+	reg, cleanup := setupTestRegistry(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	pets, err := reg.CreateModule(ctx, "acme", "pets", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	app, err := reg.CreateModule(ctx, "acme", "app", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	c1, err := pets.CreateCommit(ctx, []File{{Path: "pets.proto", Content: "// v1"}}, []string{"main"}, "", "", nil)
+	if err != nil {
+		t.Fatalf("CreateCommit failed: %v", err)
+	}
+	if _, err := app.CreateCommit(ctx, []File{{Path: "app.proto", Content: "// v1"}}, []string{"main"}, "", "", []storage.DepRecord{c1.AsDep()}); err != nil {
+		t.Fatalf("CreateCommit failed: %v", err)
+	}
+
+	dependents, err := reg.ModuleDependents(ctx, pets)
+	if err != nil || len(dependents) != 1 || dependents[0].Name() != "app" {
+		t.Fatalf("ModuleDependents(pets) = %v, %v", dependents, err)
+	}
+	if dependents, err := reg.ModuleDependents(ctx, app); err != nil || len(dependents) != 0 {
+		t.Fatalf("ModuleDependents(app) = %v, %v", dependents, err)
+	}
+
+	// Deleted modules and their commits are hidden, and their name is held
+	if _, err := reg.SoftDeleteModule(ctx, "acme", "pets"); err != nil {
+		t.Fatalf("SoftDeleteModule failed: %v", err)
+	}
+	if _, err := reg.Module(ctx, "acme", "pets"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Module: expected ErrNotFound, got %v", err)
+	}
+	if _, err := reg.ModuleByID(ctx, pets.ID()); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("ModuleByID: expected ErrNotFound, got %v", err)
+	}
+	if _, err := reg.CommitByID(ctx, c1.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("CommitByID: expected ErrNotFound, got %v", err)
+	}
+	if modules, err := reg.ListModules(ctx, "acme"); err != nil || len(modules) != 1 || modules[0].Name() != "app" {
+		t.Errorf("ListModules = %v, %v", modules, err)
+	}
+	if _, err := reg.CreateModule(ctx, "acme", "pets", ""); !errors.Is(err, ErrModuleDeleted) {
+		t.Errorf("CreateModule: expected ErrModuleDeleted, got %v", err)
+	}
+	if err := reg.DeleteOwner(ctx, pets.OwnerID()); !errors.Is(err, ErrOwnerHasModules) {
+		t.Errorf("DeleteOwner: expected ErrOwnerHasModules, got %v", err)
+	}
+	if dependents, err := reg.ModuleDependents(ctx, app); err != nil || len(dependents) != 0 {
+		t.Errorf("deleted modules should not be dependents, got %v, %v", dependents, err)
+	}
+
+	deleted, err := reg.ListDeletedModules(ctx)
+	if err != nil || len(deleted) != 1 || deleted[0].Name() != "pets" || deleted[0].DeleteTime().IsZero() {
+		t.Fatalf("ListDeletedModules = %v, %v", deleted, err)
+	}
+
+	// Restored modules come back with their commits
+	if _, err := reg.RestoreModule(ctx, "acme", "app"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("RestoreModule of a module not deleted: expected ErrNotFound, got %v", err)
+	}
+	if _, err := reg.RestoreModule(ctx, "acme", "pets"); err != nil {
+		t.Fatalf("RestoreModule failed: %v", err)
+	}
+	restored, err := reg.Module(ctx, "acme", "pets")
+	if err != nil {
+		t.Fatalf("Module failed after restore: %v", err)
+	}
+	if c, err := restored.Commit(ctx, ""); err != nil || c.ID != c1.ID {
+		t.Errorf("Commit after restore = %v, %v", c, err)
+	}
+
+	// Only modules deleted before the cutoff are purged
+	if _, err := reg.SoftDeleteModule(ctx, "acme", "pets"); err != nil {
+		t.Fatalf("SoftDeleteModule failed: %v", err)
+	}
+	if purged, err := reg.PurgeDeletedModules(ctx, time.Now().Add(-time.Hour)); err != nil || len(purged) != 0 {
+		t.Fatalf("PurgeDeletedModules before the deletion = %v, %v", purged, err)
+	}
+	purged, err := reg.PurgeDeletedModules(ctx, time.Now().Add(time.Second))
+	if err != nil || len(purged) != 1 || purged[0].Name() != "pets" {
+		t.Fatalf("PurgeDeletedModules = %v, %v", purged, err)
+	}
+	if _, err := reg.RestoreModule(ctx, "acme", "pets"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("RestoreModule after purge: expected ErrNotFound, got %v", err)
+	}
+	if _, err := reg.metadata.GetCommit(ctx, c1.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("commit record after purge: expected ErrNotFound, got %v", err)
+	}
+
+	// The name comes back as an empty module
+	recreated, err := reg.CreateModule(ctx, "acme", "pets", "")
+	if err != nil {
+		t.Fatalf("CreateModule after purge failed: %v", err)
+	}
+	if _, err := recreated.Commit(ctx, "main"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("main of the re-created module: expected ErrNotFound, got %v", err)
+	}
+	if commits, _, err := recreated.ListCommits(ctx, 10, ""); err != nil || len(commits) != 0 {
+		t.Errorf("commits of the re-created module = %v, %v", commits, err)
+	}
+}
+
+func TestRegistry_RenameModule(t *testing.T) {
+	reg, cleanup := setupTestRegistry(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	redirects, err := memdocstore.OpenCollection("ID", nil)
+	if err != nil {
+		t.Fatalf("failed to open redirects collection: %v", err)
+	}
+	defer redirects.Close()
+	usage, err := memdocstore.OpenCollection("ID", nil)
+	if err != nil {
+		t.Fatalf("failed to open usage collection: %v", err)
+	}
+	defer usage.Close()
+	reg.redirects = storage.NewRedirectStore(redirects)
+	reg.usage = storage.NewUsageStore(usage)
+
+	billing, err := reg.CreateModule(ctx, "oldteam", "billing", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	c1, err := billing.CreateCommit(ctx, []File{{Path: "billing.proto", Content: "// v1"}}, []string{"main", "v1"}, "", "", nil)
+	if err != nil {
+		t.Fatalf("CreateCommit failed: %v", err)
+	}
+	if _, err := reg.CreateModule(ctx, "payments", "ledger", ""); err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+
+	if _, err := reg.RenameModule(ctx, "oldteam", "billing", "payments", "ledger", time.Hour); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("RenameModule onto an existing module: expected ErrAlreadyExists, got %v", err)
+	}
+	renamed, err := reg.RenameModule(ctx, "oldteam", "billing", "payments", "billing", time.Hour)
+	if err != nil {
+		t.Fatalf("RenameModule failed: %v", err)
+	}
+	if renamed.ID() != billing.ID() || renamed.Owner() != "payments" || renamed.OwnerID() == billing.OwnerID() {
+		t.Errorf("unexpected renamed module %s %s/%s", renamed.ID(), renamed.Owner(), renamed.Name())
+	}
+
+	// Commits and labels move with the module, and so does its usage
+	c, err := renamed.Commit(ctx, "v1")
+	if err != nil || c.ID != c1.ID || c.OwnerID != renamed.OwnerID() {
+		t.Errorf("Commit(v1) after rename = %+v, %v", c, err)
+	}
+	if u, err := reg.Usage().OwnerUsage(ctx, renamed.OwnerID()); err != nil || u.Commits != 1 || u.Labels != 2 {
+		t.Errorf("new owner usage = %+v, %v", u, err)
+	}
+	if u, err := reg.Usage().OwnerUsage(ctx, billing.OwnerID()); err != nil || u.Commits != 0 {
+		t.Errorf("former owner usage = %+v, %v", u, err)
+	}
+
+	// The former name resolves for reads only, and can't be taken while it redirects
+	if _, err := reg.Module(ctx, "oldteam", "billing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Module(former name): expected ErrNotFound, got %v", err)
+	}
+	if mod, err := reg.ModuleFollowingRedirect(ctx, "oldteam", "billing"); err != nil || mod.ID() != billing.ID() {
+		t.Errorf("ModuleFollowingRedirect = %v, %v", mod, err)
+	}
+	if res, err := reg.ResolveName(ctx, "oldteam", "billing", "v1", ""); err != nil || res.Module.Name() != "billing" || res.Module.Owner() != "payments" {
+		t.Errorf("ResolveName(former name) = %+v, %v", res, err)
+	}
+	if _, err := reg.CreateModule(ctx, "oldteam", "billing", ""); !errors.Is(err, ErrModuleRenamed) {
+		t.Errorf("CreateModule(former name): expected ErrModuleRenamed, got %v", err)
+	}
+
+	// Expired redirects stop resolving and are purged, freeing the name
+	if n, err := reg.PurgeExpiredRedirects(ctx, time.Now()); err != nil || n != 0 {
+		t.Fatalf("PurgeExpiredRedirects before expiry = %d, %v", n, err)
+	}
+	if n, err := reg.PurgeExpiredRedirects(ctx, time.Now().Add(2*time.Hour)); err != nil || n != 1 {
+		t.Fatalf("PurgeExpiredRedirects = %d, %v", n, err)
+	}
+	if _, err := reg.ModuleFollowingRedirect(ctx, "oldteam", "billing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("ModuleFollowingRedirect after expiry: expected ErrNotFound, got %v", err)
+	}
+	reused, err := reg.CreateModule(ctx, "oldteam", "billing", "")
+	if err != nil {
+		t.Fatalf("CreateModule(former name) after expiry failed: %v", err)
+	}
+	if reused.ID() == billing.ID() {
+		t.Error("new module reused the ID of the renamed module")
+	}
+	if mod, err := reg.Module(ctx, "payments", "billing"); err != nil || mod.ID() != billing.ID() {
+		t.Errorf("renamed module after reuse of its former name = %v, %v", mod, err)
+	}
+}
+
+// failingCommitUpdates fails the updates of one commit, to test rollbacks.
+type failingCommitUpdates struct {
+	storage.MetadataStore
+	id string
+}
+
+func (f *failingCommitUpdates) UpdateCommit(ctx context.Context, commit *storage.CommitRecord) error {
+	if commit.ID == f.id {
+		return errors.New("commit store unavailable")
+	}
+	return f.MetadataStore.UpdateCommit(ctx, commit)
+}
+
+func TestRegistry_RenameModuleRollsBack(t *testing.T) {
+	reg, cleanup := setupTestRegistry(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	billing, err := reg.CreateModule(ctx, "oldteam", "billing", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+	var commits []*Commit
+	for _, content := range []string{"// v1", "// v2"} {
+		c, err := billing.CreateCommit(ctx, []File{{Path: "billing.proto", Content: content}}, []string{"main"}, "", "", nil)
+		if err != nil {
+			t.Fatalf("CreateCommit failed: %v", err)
+		}
+		commits = append(commits, c)
+	}
+
+	// The transfer of the commits fails part-way, whichever order they are transferred in
+	metadata := reg.metadata
+	for _, c := range commits {
+		reg.metadata = &failingCommitUpdates{MetadataStore: metadata, id: c.ID}
+		_, err := reg.RenameModule(ctx, "oldteam", "billing", "payments", "billing", time.Hour)
+		reg.metadata = metadata
+		if err == nil {
+			t.Fatal("expected RenameModule to fail")
+		}
+		// The commits transferred before the failure are given back to the former owner
+		for _, c := range commits {
+			got, err := reg.CommitByID(ctx, c.ID)
+			if err != nil || got.OwnerID != billing.OwnerID() {
+				t.Errorf("commit after failed rename = %+v, %v, want owner %s", got, err, billing.OwnerID())
+			}
+		}
+	}
+	if mod, err := reg.Module(ctx, "oldteam", "billing"); err != nil || mod.ID() != billing.ID() {
+		t.Errorf("module after failed rename = %v, %v", mod, err)
 	}
 }
@@ -0,0 +1,255 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// ErrModuleRenamed is returned when creating a module under a former name of a renamed
+// module, while the name still redirects to it.
+var ErrModuleRenamed = errors.New("module was renamed")
+
+// WithRedirects keeps the former names of renamed modules resolving to them, until the
+// redirects expire. Without it, RenameModule leaves no redirect behind.
+func WithRedirects(redirects storage.RedirectStore) Option {
+	return func(r *Registry) {
+		r.redirects = redirects
+	}
+}
+
+// RenameModule renames a module, moving it to another owner if newOwner differs from owner.
+// The module keeps its ID, commits and labels. The former name redirects to the module for
+// redirectFor, unless it is zero, and is reserved until then. If any write fails, the writes
+// already made are undone. Renames aren't journaled: concurrent readers can see a rename
+// part-way, and a crash part-way leaves the writes made so far in place.
+func (r *Registry) RenameModule(ctx context.Context, owner, name, newOwner, newName string, redirectFor time.Duration) (_ *Module, err error) {
+	slog.DebugContext(ctx, "Registry.RenameModule", "owner", owner, "name", name, "newOwner", newOwner, "newName", newName)
+
+	ownerRecord, err := r.getOrCreateOrganization(ctx, newOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	r.namesMu.Lock()
+	defer r.namesMu.Unlock()
+
+	record, err := r.metadata.GetModuleByName(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	if isDeleted(record) {
+		return nil, storage.ErrNotFound
+	}
+	if _, err := r.metadata.GetModuleByName(ctx, newOwner, newName); err == nil {
+		return nil, storage.ErrAlreadyExists
+	} else if err != storage.ErrNotFound {
+		return nil, err
+	}
+	// The new name may be a former name of this module, which it takes back
+	if redirect, err := r.liveRedirect(ctx, newOwner, newName); err == nil && redirect.ModuleID != record.ID {
+		return nil, storage.ErrAlreadyExists
+	} else if err != nil && err != storage.ErrNotFound {
+		return nil, err
+	}
+
+	var undo []func(context.Context) error
+	defer func() {
+		if err == nil {
+			return
+		}
+		// Undo even if the request was canceled
+		ctx := context.WithoutCancel(ctx)
+		for i := len(undo) - 1; i >= 0; i-- {
+			if undoErr := undo[i](ctx); undoErr != nil {
+				slog.ErrorContext(ctx, "failed to roll back module rename", "moduleID", record.ID, "error", undoErr)
+			}
+		}
+	}()
+
+	// Commits are transferred before the module, so that a failure leaves the module
+	// with its former owner
+	transferred := ownerRecord.ID != record.OwnerID
+	if transferred {
+		if err := r.transferCommits(ctx, record.ID, ownerRecord.ID, &undo); err != nil {
+			return nil, err
+		}
+	}
+
+	renamed := *record
+	renamed.OwnerID = ownerRecord.ID
+	renamed.Owner = newOwner
+	renamed.Name = newName
+	renamed.UpdateTime = time.Now()
+	if err := r.metadata.UpdateModule(ctx, &renamed); err != nil {
+		return nil, fmt.Errorf("failed to rename module: %w", err)
+	}
+	undo = append(undo, func(ctx context.Context) error {
+		return r.metadata.UpdateModule(ctx, record)
+	})
+
+	if r.redirects != nil {
+		if err := r.moveRedirect(ctx, newOwner, newName, nil, &undo); err != nil {
+			return nil, fmt.Errorf("failed to delete redirect: %w", err)
+		}
+		if redirectFor > 0 {
+			err := r.moveRedirect(ctx, owner, name, &storage.Redirect{
+				Owner:      owner,
+				Name:       name,
+				ModuleID:   record.ID,
+				CreateTime: renamed.UpdateTime,
+				ExpireTime: renamed.UpdateTime.Add(redirectFor),
+			}, &undo)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create redirect: %w", err)
+			}
+		}
+	}
+
+	if transferred {
+		r.transferUsage(ctx, record, &renamed)
+	}
+	return &Module{record: &renamed, registry: r}, nil
+}
+
+// transferCommits records a new owner on the commits of a module, adding to undo a
+// function restoring the former owner of each commit transferred.
+func (r *Registry) transferCommits(ctx context.Context, moduleID, ownerID string, undo *[]func(context.Context) error) error {
+	commits, err := r.allCommits(ctx, moduleID)
+	if err != nil {
+		return fmt.Errorf("failed to list commits: %w", err)
+	}
+	for _, c := range commits {
+		prev := *c
+		c.OwnerID = ownerID
+		if err := r.metadata.UpdateCommit(ctx, c); err != nil {
+			return fmt.Errorf("failed to transfer commit %s: %w", c.ID, err)
+		}
+		*undo = append(*undo, func(ctx context.Context) error {
+			return r.metadata.UpdateCommit(ctx, &prev)
+		})
+	}
+	return nil
+}
+
+// moveRedirect replaces the redirect of a name with redirect, or deletes it if redirect is
+// nil, adding to undo a function putting back the redirect it had.
+func (r *Registry) moveRedirect(ctx context.Context, owner, name string, redirect *storage.Redirect, undo *[]func(context.Context) error) error {
+	prev, err := r.redirects.GetRedirect(ctx, owner, name)
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	if redirect == nil {
+		err = r.redirects.DeleteRedirect(ctx, owner, name)
+	} else {
+		err = r.redirects.PutRedirect(ctx, redirect)
+	}
+	if err != nil {
+		return err
+	}
+	*undo = append(*undo, func(ctx context.Context) error {
+		if prev == nil {
+			return r.redirects.DeleteRedirect(ctx, owner, name)
+		}
+		return r.redirects.PutRedirect(ctx, prev)
+	})
+	return nil
+}
+
+// transferUsage moves the usage of a module from its former owner to the new one.
+func (r *Registry) transferUsage(ctx context.Context, from, to *storage.ModuleRecord) {
+	if r.usage == nil {
+		return
+	}
+	r.accountModuleDeleted(ctx, from)
+	if err := r.buildModuleUsage(ctx, to, map[string]int64{}); err != nil {
+		slog.WarnContext(ctx, "failed to account transferred module usage", "moduleID", to.ID, "error", err)
+	}
+}
+
+// ModuleFollowingRedirect retrieves a module by owner and name like Module, and if no
+// module has that name, retrieves the module the name redirects to since it was renamed.
+// Redirects only serve reads: modules are not written to under their former names.
+func (r *Registry) ModuleFollowingRedirect(ctx context.Context, owner, name string) (*Module, error) {
+	mod, err := r.Module(ctx, owner, name)
+	if err != storage.ErrNotFound || r.redirects == nil {
+		return mod, err
+	}
+	redirect, err := r.liveRedirect(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	mod, err = r.ModuleByID(ctx, redirect.ModuleID)
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "module resolved through redirect", "from", owner+"/"+name, "to", mod.Owner()+"/"+mod.Name())
+	return mod, nil
+}
+
+// checkNotRedirected returns ErrModuleRenamed if a name redirects to an existing module.
+func (r *Registry) checkNotRedirected(ctx context.Context, owner, name string) error {
+	redirect, err := r.liveRedirect(ctx, owner, name)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	record, err := r.metadata.GetModule(ctx, redirect.ModuleID)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w to %s/%s", ErrModuleRenamed, record.Owner, record.Name)
+}
+
+// liveRedirect returns the redirect of a name, or ErrNotFound if there is none or it expired.
+func (r *Registry) liveRedirect(ctx context.Context, owner, name string) (*storage.Redirect, error) {
+	if r.redirects == nil {
+		return nil, storage.ErrNotFound
+	}
+	redirect, err := r.redirects.GetRedirect(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(redirect.ExpireTime) {
+		return nil, storage.ErrNotFound
+	}
+	return redirect, nil
+}
+
+// ListRedirects lists the redirects of renamed modules, expired ones included until
+// PurgeExpiredRedirects deletes them.
+func (r *Registry) ListRedirects(ctx context.Context) ([]*storage.Redirect, error) {
+	if r.redirects == nil {
+		return nil, nil
+	}
+	return r.redirects.ListRedirects(ctx)
+}
+
+// PurgeExpiredRedirects deletes the redirects expired before now, freeing their names,
+// and returns how many it deleted.
+func (r *Registry) PurgeExpiredRedirects(ctx context.Context, now time.Time) (int, error) {
+	redirects, err := r.ListRedirects(ctx)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, redirect := range redirects {
+		if now.Before(redirect.ExpireTime) {
+			continue
+		}
+		if err := r.redirects.DeleteRedirect(ctx, redirect.Owner, redirect.Name); err != nil {
+			return purged, fmt.Errorf("failed to delete redirect of %s/%s: %w", redirect.Owner, redirect.Name, err)
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,236 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// ErrAmbiguousRef is returned when a short commit ID matches more than one commit.
+var ErrAmbiguousRef = errors.New("ambiguous reference")
+
+// minShortCommitIDLength is the shortest commit ID prefix that is resolved.
+const minShortCommitIDLength = 4
+
+// ResolvedRef is the target of a resource reference: a module, a label of
+// that module, or a commit of that module. At most one of Label and Commit is set.
+type ResolvedRef struct {
+	Module *Module
+	Label  *storage.LabelRecord // set if the reference resolved to a label
+	Commit *Commit              // set if the reference resolved to a commit
+}
+
+// ResolveCommit returns the referenced commit. Labels resolve to the commit
+// they point to and modules to the commit of their default label.
+func (ref *ResolvedRef) ResolveCommit(ctx context.Context) (*Commit, error) {
+	if ref.Commit != nil {
+		return ref.Commit, nil
+	}
+	if ref.Label != nil {
+		return ref.Module.CommitByID(ctx, ref.Label.CommitID)
+	}
+	return ref.Module.Commit(ctx, "")
+}
+
+// ResolveID resolves a commit, module or label ID, in that order of precedence.
+func (r *Registry) ResolveID(ctx context.Context, id string) (*ResolvedRef, error) {
+	slog.DebugContext(ctx, "Registry.ResolveID", "id", id)
+
+	if commit, err := r.CommitByID(ctx, id); err == nil {
+		mod, err := r.ModuleByID(ctx, commit.ModuleID)
+		if err != nil {
+			return nil, fmt.Errorf("module of commit %s: %w", id, err)
+		}
+		return &ResolvedRef{Module: mod, Commit: commit}, nil
+	}
+	if mod, err := r.ModuleByID(ctx, id); err == nil {
+		return &ResolvedRef{Module: mod}, nil
+	}
+	// Label IDs are the module ID and the label name joined by a slash
+	if moduleID, name, ok := strings.Cut(id, "/"); ok {
+		if mod, err := r.ModuleByID(ctx, moduleID); err == nil {
+			if label, err := r.metadata.GetLabel(ctx, moduleID, name); err == nil {
+				return &ResolvedRef{Module: mod, Label: label}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("resource %s: %w", id, storage.ErrNotFound)
+}
+
+// ResolveName resolves a module by owner and name, optionally narrowed to a label or an untyped ref.
+//
+// A label name resolves to that label, or to the highest semver label matching it as a range.
+// An untyped ref resolves, in order of precedence, as:
+//   - a commit ID of the module
+//   - a label name
+//   - a module digest such as "b5:<hex>"
+//   - a unique commit ID prefix of at least four characters
+//   - a semver range over the module's labels, such as "^v1.2", "~v1.2.3", "v1.x" or ">=v1.0.0 <v2"
+//
+// Without either, the module itself is returned. Former names of renamed modules resolve
+// to the module while their redirect lasts.
+func (r *Registry) ResolveName(ctx context.Context, owner, module, labelName, ref string) (*ResolvedRef, error) {
+	slog.DebugContext(ctx, "Registry.ResolveName", "owner", owner, "module", module, "label", labelName, "ref", ref)
+
+	mod, err := r.ModuleFollowingRedirect(ctx, owner, module)
+	if err != nil {
+		return nil, fmt.Errorf("module %s/%s: %w", owner, module, err)
+	}
+
+	switch {
+	case labelName != "":
+		label, err := mod.resolveLabel(ctx, labelName)
+		if err != nil {
+			return nil, err
+		}
+		return &ResolvedRef{Module: mod, Label: label}, nil
+	case ref != "":
+		return mod.resolveRef(ctx, ref)
+	default:
+		return &ResolvedRef{Module: mod}, nil
+	}
+}
+
+func (m *Module) resolveRef(ctx context.Context, ref string) (*ResolvedRef, error) {
+	if commit, err := m.CommitByID(ctx, ref); err == nil {
+		return &ResolvedRef{Module: m, Commit: commit}, nil
+	}
+	if label, err := m.registry.metadata.GetLabel(ctx, m.record.ID, ref); err == nil {
+		return &ResolvedRef{Module: m, Label: label}, nil
+	}
+
+	if digest, err := storage.ParseModuleDigest(ref); err == nil {
+		commit, err := m.commitByModuleDigest(ctx, digest)
+		if err != nil {
+			return nil, err
+		}
+		return &ResolvedRef{Module: m, Commit: commit}, nil
+	}
+
+	if len(ref) >= minShortCommitIDLength && isHex(ref) {
+		commit, err := m.commitByIDPrefix(ctx, ref)
+		if err == nil {
+			return &ResolvedRef{Module: m, Commit: commit}, nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	if rng, err := parseSemverRange(ref); err == nil {
+		label, err := m.labelInRange(ctx, rng)
+		if err != nil {
+			return nil, fmt.Errorf("ref %q: %w", ref, err)
+		}
+		return &ResolvedRef{Module: m, Label: label}, nil
+	}
+
+	return nil, fmt.Errorf("ref %q: %w", ref, storage.ErrNotFound)
+}
+
+// resolveLabel returns the named label, falling back to treating the name as a semver range.
+func (m *Module) resolveLabel(ctx context.Context, name string) (*storage.LabelRecord, error) {
+	label, err := m.registry.metadata.GetLabel(ctx, m.record.ID, name)
+	if err == nil {
+		return label, nil
+	}
+	if err != storage.ErrNotFound {
+		return nil, err
+	}
+	if rng, rerr := parseSemverRange(name); rerr == nil {
+		if label, err := m.labelInRange(ctx, rng); err == nil {
+			return label, nil
+		}
+	}
+	return nil, fmt.Errorf("label %q: %w", name, storage.ErrNotFound)
+}
+
+// labelInRange returns the label with the highest release version in the range.
+func (m *Module) labelInRange(ctx context.Context, rng semverRange) (*storage.LabelRecord, error) {
+	labels, err := m.ListLabels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *storage.LabelRecord
+	var bestVersion semver
+	for _, label := range labels {
+		v, ok := parseSemver(label.Name)
+		if !ok || v.prerelease != "" || !rng.contains(v) {
+			continue
+		}
+		if best == nil || v.compare(bestVersion) > 0 {
+			best, bestVersion = label, v
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no label matches: %w", storage.ErrNotFound)
+	}
+	return best, nil
+}
+
+func (m *Module) commitByModuleDigest(ctx context.Context, digest storage.ModuleDigest) (*Commit, error) {
+	commits, err := m.allCommits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	want := digest.String()
+	for _, commit := range commits {
+		if commit.ModuleDigest.String() == want {
+			return commit, nil
+		}
+	}
+	return nil, fmt.Errorf("digest %s: %w", want, storage.ErrNotFound)
+}
+
+func (m *Module) commitByIDPrefix(ctx context.Context, prefix string) (*Commit, error) {
+	commits, err := m.allCommits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix = strings.ToLower(prefix)
+	var match *Commit
+	for _, commit := range commits {
+		if !strings.HasPrefix(commit.ID, prefix) {
+			continue
+		}
+		if match != nil {
+			return nil, fmt.Errorf("commit prefix %s: %w", prefix, ErrAmbiguousRef)
+		}
+		match = commit
+	}
+	if match == nil {
+		return nil, fmt.Errorf("commit prefix %s: %w", prefix, storage.ErrNotFound)
+	}
+	return match, nil
+}
+
+// allCommits lists every commit of the module, newest first.
+func (m *Module) allCommits(ctx context.Context) ([]*Commit, error) {
+	var all []*Commit
+	token := ""
+	for {
+		commits, next, err := m.ListCommits(ctx, 1000, token)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, commits...)
+		if next == "" {
+			return all, nil
+		}
+		token = next
+	}
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
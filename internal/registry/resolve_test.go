@@ -0,0 +1,174 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+func TestParseSemverRange(t *testing.T) {
+	tests := []struct {
+		rng      string
+		version  string
+		contains bool
+	}{
+		{"v1", "v1.9.0", true},
+		{"v1", "v2.0.0", false},
+		{"v1.x", "v1.0.0", true},
+		{"v1.2", "v1.2.7", true},
+		{"v1.2", "v1.3.0", false},
+		{"^v1.2", "v1.9.9", true},
+		{"^v1.2", "v1.1.0", false},
+		{"^v1.2", "v2.0.0", false},
+		{"v1.^2", "v1.4.0", true},
+		{"v1.^2", "v1.1.9", false},
+		{"^0.2.3", "v0.2.9", true},
+		{"^0.2.3", "v0.3.0", false},
+		{"~v1.2.3", "v1.2.9", true},
+		{"~v1.2.3", "v1.3.0", false},
+		{">=v1.0.0 <v2", "v1.5.0", true},
+		{">=v1.0.0 <v2", "v2.0.0", false},
+		{">v1.2", "v1.2.9", false},
+		{">v1.2", "v1.3.0", true},
+		{"<=v1.2", "v1.2.9", true},
+		{"v1 || v3", "v3.1.0", true},
+		{"v1 || v3", "v2.1.0", false},
+		{"v1.2.3", "v1.2.3", true},
+	}
+	for _, tt := range tests {
+		rng, err := parseSemverRange(tt.rng)
+		if err != nil {
+			t.Errorf("parseSemverRange(%q) failed: %v", tt.rng, err)
+			continue
+		}
+		v, ok := parseSemver(tt.version)
+		if !ok {
+			t.Fatalf("parseSemver(%q) failed", tt.version)
+		}
+		if got := rng.contains(v); got != tt.contains {
+			t.Errorf("%q contains %s = %v, want %v", tt.rng, tt.version, got, tt.contains)
+		}
+	}
+
+	for _, invalid := range []string{"main", "", "x", "v1.x.3", "^v1.^2", "release/1.0"} {
+		if _, err := parseSemverRange(invalid); err == nil {
+			t.Errorf("parseSemverRange(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestRegistry_Resolve(t *testing.T) {
+	reg, cleanup := setupTestRegistry(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	mod, err := reg.CreateModule(ctx, "acme", "petapis", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+
+	commits := map[string]*Commit{}
+	for _, label := range []string{"v1.0.0", "v1.2.0", "v1.3.0-rc.1", "v2.0.0", "main"} {
+		files := []File{{Path: "pet.proto", Content: fmt.Sprintf("syntax = \"proto3\"; // %s", label)}}
+		commit, err := mod.CreateCommit(ctx, files, []string{label}, "", "", nil)
+		if err != nil {
+			t.Fatalf("CreateCommit failed: %v", err)
+		}
+		commits[label] = commit
+	}
+
+	// Module default label
+	res, err := reg.ResolveName(ctx, "acme", "petapis", "", "")
+	if err != nil || res.Label != nil || res.Commit != nil {
+		t.Fatalf("expected module, got %+v (%v)", res, err)
+	}
+	if c, err := res.ResolveCommit(ctx); err != nil || c.ID != commits["main"].ID {
+		t.Errorf("expected default label commit, got %v (%v)", c, err)
+	}
+
+	tests := []struct {
+		ref        string
+		wantCommit string // label whose commit is expected
+		wantLabel  string // expected label, empty for commit refs
+	}{
+		{commits["v1.0.0"].ID, "v1.0.0", ""},
+		{commits["v1.0.0"].ID[:20], "v1.0.0", ""},
+		{commits["v2.0.0"].ModuleDigest.String(), "v2.0.0", ""},
+		{"v1.0.0", "v1.0.0", "v1.0.0"},
+		{"v1", "v1.2.0", "v1.2.0"},
+		{"v1.^2", "v1.2.0", "v1.2.0"},
+		{">=v1.0.0", "v2.0.0", "v2.0.0"},
+	}
+	for _, tt := range tests {
+		res, err := reg.ResolveName(ctx, "acme", "petapis", "", tt.ref)
+		if err != nil {
+			t.Errorf("ResolveName(%q) failed: %v", tt.ref, err)
+			continue
+		}
+		if tt.wantLabel != "" && (res.Label == nil || res.Label.Name != tt.wantLabel) {
+			t.Errorf("ResolveName(%q): expected label %s, got %+v", tt.ref, tt.wantLabel, res)
+			continue
+		}
+		if tt.wantLabel == "" && res.Commit == nil {
+			t.Errorf("ResolveName(%q): expected commit, got %+v", tt.ref, res)
+			continue
+		}
+		if c, err := res.ResolveCommit(ctx); err != nil || c.ID != commits[tt.wantCommit].ID {
+			t.Errorf("ResolveName(%q): expected commit of %s, got %v (%v)", tt.ref, tt.wantCommit, c, err)
+		}
+	}
+
+	// Label names may also be ranges
+	res, err = reg.ResolveName(ctx, "acme", "petapis", "^v1", "")
+	if err != nil || res.Label == nil || res.Label.Name != "v1.2.0" {
+		t.Errorf("expected label v1.2.0, got %+v (%v)", res, err)
+	}
+
+	for _, ref := range []string{"v3", "nope", "b5:" + commits["v1.0.0"].ModuleDigest.Hex()[:10]} {
+		if _, err := reg.ResolveName(ctx, "acme", "petapis", "", ref); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("ResolveName(%q): expected ErrNotFound, got %v", ref, err)
+		}
+	}
+
+	// IDs resolve commits, modules and labels
+	if res, err := reg.ResolveID(ctx, commits["main"].ID); err != nil || res.Commit == nil {
+		t.Errorf("ResolveID(commit) = %+v, %v", res, err)
+	}
+	if res, err := reg.ResolveID(ctx, mod.ID()); err != nil || res.Commit != nil || res.Label != nil {
+		t.Errorf("ResolveID(module) = %+v, %v", res, err)
+	}
+	if res, err := reg.ResolveID(ctx, mod.ID()+"/v2.0.0"); err != nil || res.Label == nil {
+		t.Errorf("ResolveID(label) = %+v, %v", res, err)
+	}
+}
+
+func TestRegistry_Resolve_AmbiguousPrefix(t *testing.T) {
+	reg, cleanup := setupTestRegistry(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	mod, err := reg.CreateModule(ctx, "acme", "petapis", "")
+	if err != nil {
+		t.Fatalf("CreateModule failed: %v", err)
+	}
+
+	// UUIDv7 commit IDs created in quick succession share their timestamp prefix
+	var first *Commit
+	for i := range 2 {
+		files := []File{{Path: "pet.proto", Content: fmt.Sprintf("// %d", i)}}
+		commit, err := mod.CreateCommit(ctx, files, nil, "", "", nil)
+		if err != nil {
+			t.Fatalf("CreateCommit failed: %v", err)
+		}
+		if first == nil {
+			first = commit
+		}
+	}
+
+	if _, err := reg.ResolveName(ctx, "acme", "petapis", "", first.ID[:4]); !errors.Is(err, ErrAmbiguousRef) {
+		t.Errorf("expected ErrAmbiguousRef, got %v", err)
+	}
+}
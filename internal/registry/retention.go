@@ -0,0 +1,301 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// RetentionPolicy selects the commits of a module to keep. Commits a label points at,
+// and commits another kept commit depends on, are always kept.
+type RetentionPolicy struct {
+	KeepFor  time.Duration // keep commits created within this duration
+	KeepLast int           // keep the newest commits, however old
+}
+
+// RetentionOptions configures ApplyRetention.
+type RetentionOptions struct {
+	// Policy returns the policy of a module, and false for modules whose commits are all kept.
+	Policy func(*Module) (RetentionPolicy, bool)
+	// Now is the time commit ages are computed at.
+	Now time.Time
+	// GracePeriod protects stored files younger than it from collection, as they may
+	// belong to an upload whose commit is not recorded yet.
+	GracePeriod time.Duration
+	// DryRun only lists what would be deleted.
+	DryRun bool
+}
+
+// RetentionResult lists what ApplyRetention deleted, or would delete in a dry run.
+type RetentionResult struct {
+	Pruned    []*Commit // newest first within each module
+	Manifests int
+	Blobs     int
+	Bytes     int64 // size of the deleted blobs and manifests
+}
+
+// ApplyRetention deletes the commits the retention policies of their modules don't keep,
+// and then collects the stored files and manifests no remaining commit references.
+func (r *Registry) ApplyRetention(ctx context.Context, opts RetentionOptions) (*RetentionResult, error) {
+	slog.DebugContext(ctx, "Registry.ApplyRetention", "dryRun", opts.DryRun)
+
+	recorded := r.recorded.Load()
+	modules, err := r.metadata.ListModules(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	commits := make(map[string][]*storage.CommitRecord, len(modules))
+	policies := make(map[string]RetentionPolicy)
+	labeled := make(map[string]bool)
+	for _, record := range modules {
+		mod := &Module{record: record, registry: r}
+		if commits[record.ID], err = r.allCommits(ctx, record.ID); err != nil {
+			return nil, fmt.Errorf("failed to list commits of %s/%s: %w", record.Owner, record.Name, err)
+		}
+		// Deleted modules keep all their commits, so that they can be restored
+		if isDeleted(record) {
+			continue
+		}
+		policy, ok := opts.Policy(mod)
+		if !ok {
+			continue
+		}
+		policies[record.ID] = policy
+		labels, err := r.metadata.ListLabels(ctx, record.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list labels of %s/%s: %w", record.Owner, record.Name, err)
+		}
+		for _, l := range labels {
+			labeled[l.CommitID] = true
+		}
+	}
+
+	// Pruning a commit can release the commits only it depended on, so the commits to
+	// prune are selected again until no more are found
+	pruned := make(map[string]bool)
+	for {
+		depended := make(map[string]bool)
+		for _, records := range commits {
+			for _, c := range records {
+				if pruned[c.ID] {
+					continue
+				}
+				for _, id := range c.DepCommitIDs {
+					depended[id] = true
+				}
+			}
+		}
+		found := false
+		for moduleID, policy := range policies {
+			for i, c := range commits[moduleID] {
+				if pruned[c.ID] || labeled[c.ID] || depended[c.ID] || i < policy.KeepLast || opts.Now.Sub(c.CreateTime) < policy.KeepFor {
+					continue
+				}
+				pruned[c.ID] = true
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+
+	result := &RetentionResult{}
+	if !opts.DryRun {
+		// Uploads wait while commits are deleted, so that they don't reuse them meanwhile
+		r.pruneMu.Lock()
+		err := r.deleteCommits(ctx, modules, commits, pruned, recorded)
+		r.pruneMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, record := range modules {
+		for _, c := range commits[record.ID] {
+			if pruned[c.ID] {
+				result.Pruned = append(result.Pruned, commitFromRecord(c))
+			}
+		}
+	}
+
+	if err := r.collectGarbage(ctx, modules, commits, pruned, opts, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// allCommits returns all commits of a module, newest first.
+func (r *Registry) allCommits(ctx context.Context, moduleID string) ([]*storage.CommitRecord, error) {
+	var all []*storage.CommitRecord
+	token := ""
+	for {
+		records, next, err := r.metadata.ListCommits(ctx, moduleID, 1000, token)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, records...)
+		if next == "" {
+			return all, nil
+		}
+		token = next
+	}
+}
+
+// deleteCommits deletes the commits selected for pruning. If commits were recorded or labels
+// moved since the selection, the commits they reference are kept. It must be called with
+// pruneMu held.
+func (r *Registry) deleteCommits(ctx context.Context, modules []*storage.ModuleRecord, commits map[string][]*storage.CommitRecord, pruned map[string]bool, recorded uint64) error {
+	if r.recorded.Load() != recorded {
+		if err := r.keepReferenced(ctx, pruned); err != nil {
+			return err
+		}
+	}
+	for _, record := range modules {
+		for _, c := range commits[record.ID] {
+			if !pruned[c.ID] {
+				continue
+			}
+			if err := r.metadata.DeleteCommit(ctx, c.ID); err != nil {
+				return fmt.Errorf("failed to delete commit %s: %w", c.ID, err)
+			}
+			r.unaccountCommit(ctx, c)
+		}
+	}
+	return nil
+}
+
+// keepReferenced unselects the commits selected for pruning that commits and labels
+// recorded since the selection reference, along with the selected commits they depend on.
+func (r *Registry) keepReferenced(ctx context.Context, pruned map[string]bool) error {
+	modules, err := r.metadata.ListModules(ctx, "")
+	if err != nil {
+		return err
+	}
+	var kept []*storage.CommitRecord
+	for _, record := range modules {
+		labels, err := r.metadata.ListLabels(ctx, record.ID)
+		if err != nil {
+			return fmt.Errorf("failed to list labels of %s/%s: %w", record.Owner, record.Name, err)
+		}
+		for _, l := range labels {
+			if pruned[l.CommitID] {
+				slog.InfoContext(ctx, "commit was labeled meanwhile, not pruned", "commitID", l.CommitID)
+				pruned[l.CommitID] = false
+			}
+		}
+		records, err := r.allCommits(ctx, record.ID)
+		if err != nil {
+			return fmt.Errorf("failed to list commits of %s/%s: %w", record.Owner, record.Name, err)
+		}
+		kept = append(kept, records...)
+	}
+
+	// Unselecting a commit keeps the commits it depends on too
+	for {
+		found := false
+		for _, c := range kept {
+			if pruned[c.ID] {
+				continue
+			}
+			for _, id := range c.DepCommitIDs {
+				if pruned[id] {
+					slog.InfoContext(ctx, "commit was depended on meanwhile, not pruned", "commitID", id)
+					pruned[id] = false
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+}
+
+// collectGarbage deletes the stored blobs and manifests that no commit references, other
+// than the pruned ones. Commits of deleted modules are kept alive by commits depending on them.
+func (r *Registry) collectGarbage(ctx context.Context, modules []*storage.ModuleRecord, commits map[string][]*storage.CommitRecord, pruned map[string]bool, opts RetentionOptions, result *RetentionResult) error {
+	manifests := make(map[string]bool)
+	blobs := make(map[string]bool)
+	marked := make(map[string]bool)
+	var mark func(c *storage.CommitRecord) error
+	mark = func(c *storage.CommitRecord) error {
+		if marked[c.ID] {
+			return nil
+		}
+		marked[c.ID] = true
+		if !manifests[c.FilesDigest.Hex()] {
+			manifests[c.FilesDigest.Hex()] = true
+			manifest, err := r.manifests.GetManifest(ctx, c.FilesDigest)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return fmt.Errorf("failed to get manifest of commit %s: %w", c.ID, err)
+			}
+			if manifest != nil {
+				for _, entry := range manifest.Entries {
+					blobs[entry.Digest.Hex()] = true
+				}
+			}
+		}
+		for _, dep := range commitFromRecord(c).Deps {
+			if dep.Registry != "" || marked[dep.CommitID] {
+				continue
+			}
+			depCommit, err := r.metadata.GetCommit(ctx, dep.CommitID)
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to get dependency %s: %w", dep.CommitID, err)
+			}
+			if err := mark(depCommit); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, record := range modules {
+		for _, c := range commits[record.ID] {
+			if pruned[c.ID] {
+				continue
+			}
+			if err := mark(c); err != nil {
+				return err
+			}
+		}
+	}
+
+	cutoff := opts.Now.Add(-opts.GracePeriod)
+	sweep := func(referenced map[string]bool, list func(context.Context, func(storage.StoredObject) error) error, del func(context.Context, storage.Digest) error) (int, error) {
+		var garbage []storage.StoredObject
+		err := list(ctx, func(obj storage.StoredObject) error {
+			if !referenced[obj.Digest.Hex()] && obj.ModTime.Before(cutoff) {
+				garbage = append(garbage, obj)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		for _, obj := range garbage {
+			if !opts.DryRun {
+				if err := del(ctx, obj.Digest); err != nil {
+					return 0, fmt.Errorf("failed to delete %s: %w", obj.Digest, err)
+				}
+			}
+			result.Bytes += obj.Size
+		}
+		return len(garbage), nil
+	}
+
+	var err error
+	if result.Manifests, err = sweep(manifests, r.manifests.List, r.manifests.Delete); err != nil {
+		return fmt.Errorf("failed to collect manifests: %w", err)
+	}
+	if result.Blobs, err = sweep(blobs, r.blobs.List, r.blobs.Delete); err != nil {
+		return fmt.Errorf("failed to collect blobs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package registry
+
+import (
+	"cmp"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// semver is a semantic version parsed from a label name such as "v1.2.3".
+type semver struct {
+	major, minor, patch int
+	prerelease          string
+}
+
+// parseSemver parses a full version with an optional "v" prefix. Build metadata is ignored.
+func parseSemver(s string) (semver, bool) {
+	parts, pre, ok := splitVersion(s)
+	if !ok || len(parts) != 3 {
+		return semver{}, false
+	}
+	var v semver
+	var err error
+	if v.major, err = strconv.Atoi(parts[0]); err != nil {
+		return semver{}, false
+	}
+	if v.minor, err = strconv.Atoi(parts[1]); err != nil {
+		return semver{}, false
+	}
+	if v.patch, err = strconv.Atoi(parts[2]); err != nil {
+		return semver{}, false
+	}
+	v.prerelease = pre
+	return v, true
+}
+
+// splitVersion splits "v1.2.3-rc.1+build" into its dot-separated core parts and prerelease.
+func splitVersion(s string) ([]string, string, bool) {
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+")
+	core, pre, _ := strings.Cut(s, "-")
+	if core == "" {
+		return nil, "", false
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return nil, "", false
+	}
+	return parts, pre, true
+}
+
+// compare orders versions by precedence. A prerelease sorts before its release.
+func (v semver) compare(o semver) int {
+	switch {
+	case v.major != o.major:
+		return cmp.Compare(v.major, o.major)
+	case v.minor != o.minor:
+		return cmp.Compare(v.minor, o.minor)
+	case v.patch != o.patch:
+		return cmp.Compare(v.patch, o.patch)
+	case v.prerelease == o.prerelease:
+		return 0
+	case v.prerelease == "":
+		return 1
+	case o.prerelease == "":
+		return -1
+	default:
+		return strings.Compare(v.prerelease, o.prerelease)
+	}
+}
+
+type comparator struct {
+	op string // one of ">=", ">", "<=", "<", "="
+	v  semver
+}
+
+func (c comparator) matches(v semver) bool {
+	n := v.compare(c.v)
+	switch c.op {
+	case ">=":
+		return n >= 0
+	case ">":
+		return n > 0
+	case "<=":
+		return n <= 0
+	case "<":
+		return n < 0
+	default:
+		return n == 0
+	}
+}
+
+// semverRange is a union of comparator sets; a version is in the range if it satisfies every comparator of any set.
+type semverRange [][]comparator
+
+func (r semverRange) contains(v semver) bool {
+	for _, set := range r {
+		ok := true
+		for _, c := range set {
+			if !c.matches(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+var errInvalidRange = errors.New("invalid semver range")
+
+// parseSemverRange parses npm-style ranges: "||"-separated alternatives of space-separated
+// comparators, each an optional operator (>=, >, <=, <, =, ^, ~) and a possibly partial version
+// where missing or x components are wildcards ("v1", "v1.2.x").
+// A caret or tilde may also prefix a component, so "v1.^2" is the same as "^v1.2".
+func parseSemverRange(s string) (semverRange, error) {
+	var rng semverRange
+	for _, alt := range strings.Split(s, "||") {
+		fields := strings.Fields(alt)
+		if len(fields) == 0 {
+			return nil, errInvalidRange
+		}
+		var set []comparator
+		for _, f := range fields {
+			cs, err := parseComparator(f)
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, cs...)
+		}
+		rng = append(rng, set)
+	}
+	return rng, nil
+}
+
+func parseComparator(s string) ([]comparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(s, prefix) {
+			op, s = prefix, s[len(prefix):]
+			break
+		}
+	}
+
+	parts, pre, ok := splitVersion(s)
+	if !ok {
+		return nil, errInvalidRange
+	}
+
+	// Move a component-level caret or tilde to the front: v1.^2 -> ^v1.2
+	for i, p := range parts {
+		if i > 0 && (strings.HasPrefix(p, "^") || strings.HasPrefix(p, "~")) {
+			if op != "" {
+				return nil, errInvalidRange
+			}
+			op, parts[i] = p[:1], p[1:]
+		}
+	}
+
+	// Parse components; -1 marks a wildcard
+	nums := []int{-1, -1, -1}
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			continue
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, errInvalidRange
+		}
+		if i > 0 && nums[i-1] == -1 {
+			return nil, errInvalidRange
+		}
+		nums[i] = n
+	}
+	known := 0
+	for _, n := range nums {
+		if n >= 0 {
+			known++
+		}
+	}
+	if known == 0 {
+		return nil, errInvalidRange
+	}
+
+	low := semver{major: max(nums[0], 0), minor: max(nums[1], 0), patch: max(nums[2], 0), prerelease: pre}
+	// next returns the lowest version above every version matching the first k components
+	next := func(k int) semver {
+		switch k {
+		case 1:
+			return semver{major: low.major + 1}
+		case 2:
+			return semver{major: low.major, minor: low.minor + 1}
+		default:
+			return semver{major: low.major, minor: low.minor, patch: low.patch + 1}
+		}
+	}
+
+	switch op {
+	case "", "=":
+		if known == 3 {
+			return []comparator{{"=", low}}, nil
+		}
+		return []comparator{{">=", low}, {"<", next(known)}}, nil
+	case "^":
+		// Allow changes that do not modify the leftmost non-zero component
+		switch {
+		case low.major > 0 || known == 1:
+			return []comparator{{">=", low}, {"<", next(1)}}, nil
+		case low.minor > 0 || known == 2:
+			return []comparator{{">=", low}, {"<", next(2)}}, nil
+		default:
+			return []comparator{{">=", low}, {"<", next(3)}}, nil
+		}
+	case "~":
+		if known == 1 {
+			return []comparator{{">=", low}, {"<", next(1)}}, nil
+		}
+		return []comparator{{">=", low}, {"<", next(2)}}, nil
+	case ">":
+		if known < 3 {
+			return []comparator{{">=", next(known)}}, nil
+		}
+		return []comparator{{">", low}}, nil
+	case "<=":
+		if known < 3 {
+			return []comparator{{"<", next(known)}}, nil
+		}
+		return []comparator{{"<=", low}}, nil
+	default:
+		return []comparator{{op, low}}, nil
+	}
+}
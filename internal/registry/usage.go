@@ -0,0 +1,180 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// WithUsage accounts the storage used by each owner and module, reported by Usage.
+func WithUsage(usage storage.UsageStore) Option {
+	return func(r *Registry) {
+		r.usage = usage
+	}
+}
+
+// Usage returns the usage store of the registry, nil if usage is not accounted.
+func (r *Registry) Usage() storage.UsageStore {
+	return r.usage
+}
+
+// commitBlobs returns the blobs referenced by a commit's manifest, each listed once,
+// sized from the files the manifest was stored from.
+func commitBlobs(manifest *storage.Manifest, files []File) []storage.BlobUsage {
+	seen := make(map[string]bool, len(manifest.Entries))
+	blobs := make([]storage.BlobUsage, 0, len(manifest.Entries))
+	for i, entry := range manifest.Entries {
+		digest := entry.Digest.Hex()
+		if seen[digest] {
+			continue
+		}
+		seen[digest] = true
+		blobs = append(blobs, storage.BlobUsage{Digest: digest, Size: int64(len(files[i].Content))})
+	}
+	return blobs
+}
+
+// accountCommit adds a new commit to the usage of its module. Usage only informs quotas
+// and reports, so failures are logged, not returned.
+func (r *Registry) accountCommit(ctx context.Context, record *storage.CommitRecord, blobs []storage.BlobUsage) {
+	if r.usage == nil {
+		return
+	}
+	if err := r.usage.AddCommit(ctx, record.OwnerID, record.ModuleID, blobs); err != nil {
+		slog.WarnContext(ctx, "failed to account commit usage", "commitID", record.ID, "error", err)
+	}
+}
+
+// unaccountCommit removes a deleted commit from the usage of its module.
+func (r *Registry) unaccountCommit(ctx context.Context, record *storage.CommitRecord) {
+	if r.usage == nil {
+		return
+	}
+	manifest, err := r.manifests.GetManifest(ctx, record.FilesDigest)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to get manifest to account commit deletion", "commitID", record.ID, "error", err)
+		return
+	}
+	var digests []string
+	for _, entry := range manifest.Entries {
+		if digest := entry.Digest.Hex(); !slices.Contains(digests, digest) {
+			digests = append(digests, digest)
+		}
+	}
+	if err := r.usage.RemoveCommit(ctx, record.OwnerID, record.ModuleID, digests); err != nil {
+		slog.WarnContext(ctx, "failed to account commit deletion", "commitID", record.ID, "error", err)
+	}
+}
+
+// accountLabels adds delta to the label count of a module.
+func (r *Registry) accountLabels(ctx context.Context, ownerID, moduleID string, delta int64) {
+	if r.usage == nil {
+		return
+	}
+	if err := r.usage.AddLabels(ctx, ownerID, moduleID, delta); err != nil {
+		slog.WarnContext(ctx, "failed to account label usage", "moduleID", moduleID, "error", err)
+	}
+}
+
+// accountModuleDeleted removes the usage of a deleted module.
+func (r *Registry) accountModuleDeleted(ctx context.Context, record *storage.ModuleRecord) {
+	if r.usage == nil {
+		return
+	}
+	if err := r.usage.RemoveModule(ctx, record.OwnerID, record.ID); err != nil {
+		slog.WarnContext(ctx, "failed to remove module usage", "moduleID", record.ID, "error", err)
+	}
+}
+
+// BuildUsage accounts the commits and labels of every module, once.
+// It brings registries created before usage was accounted up to date.
+func (r *Registry) BuildUsage(ctx context.Context) error {
+	if r.usage == nil {
+		return nil
+	}
+	built, err := r.usage.Built(ctx)
+	if err != nil || built {
+		return err
+	}
+
+	sizes := map[string]int64{}
+	modules, err := r.metadata.ListModules(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, mod := range modules {
+		if err := r.buildModuleUsage(ctx, mod, sizes); err != nil {
+			return fmt.Errorf("failed to account %s/%s: %w", mod.Owner, mod.Name, err)
+		}
+	}
+	return r.usage.MarkBuilt(ctx)
+}
+
+// buildModuleUsage accounts the commits and labels of one module. Blob sizes are
+// read from the blob store and cached in sizes.
+func (r *Registry) buildModuleUsage(ctx context.Context, mod *storage.ModuleRecord, sizes map[string]int64) error {
+	token := ""
+	for {
+		commits, next, err := r.metadata.ListCommits(ctx, mod.ID, 1000, token)
+		if err != nil {
+			return err
+		}
+		for _, commit := range commits {
+			manifest, err := r.manifests.GetManifest(ctx, commit.FilesDigest)
+			if err != nil {
+				return fmt.Errorf("failed to get manifest of commit %s: %w", commit.ID, err)
+			}
+			var blobs []storage.BlobUsage
+			seen := map[string]bool{}
+			for _, entry := range manifest.Entries {
+				digest := entry.Digest.Hex()
+				if seen[digest] {
+					continue
+				}
+				seen[digest] = true
+				size, ok := sizes[digest]
+				if !ok {
+					if size, err = r.blobSize(ctx, entry.Digest); err != nil {
+						return fmt.Errorf("failed to size %s: %w", entry.Path, err)
+					}
+					sizes[digest] = size
+				}
+				blobs = append(blobs, storage.BlobUsage{Digest: digest, Size: size})
+			}
+			if err := r.usage.AddCommit(ctx, mod.OwnerID, mod.ID, blobs); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	labels, err := r.metadata.ListLabels(ctx, mod.ID)
+	if err != nil {
+		return err
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return r.usage.AddLabels(ctx, mod.OwnerID, mod.ID, int64(len(labels)))
+}
+
+// blobSize returns the size of a stored blob, zero if it is missing.
+func (r *Registry) blobSize(ctx context.Context, digest storage.Digest) (int64, error) {
+	rc, err := r.blobs.Get(ctx, digest)
+	if errors.Is(err, storage.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(io.Discard, rc)
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/google/uuid"
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// AuditPath is the admin endpoint for querying the audit log.
+const AuditPath = "/admin/audit"
+
+// loginProcedure is recorded as the procedure of login audit records.
+const loginProcedure = "login"
+
+func newAuditID() string {
+	return strings.ReplaceAll(uuid.Must(uuid.NewV7()).String(), "-", "")
+}
+
+// auditedPrincipalKey carries the auditedPrincipal of a request through the interceptors.
+const auditedPrincipalKey contextKey = "auditedPrincipal"
+
+// auditedPrincipal is filled in by the auth interceptor, which runs after the audit
+// interceptor, so that requests it rejects are attributed to their principal.
+type auditedPrincipal struct {
+	username string
+}
+
+// setAuditedPrincipal records the authenticated principal of an audited request.
+func setAuditedPrincipal(ctx context.Context, principal *Principal) {
+	if audited, ok := ctx.Value(auditedPrincipalKey).(*auditedPrincipal); ok {
+		audited.username = principal.Username
+	}
+}
+
+// newAuditInterceptor records every mutating RPC to the audit log, including those rejected
+// by authentication or authorization. RPCs declared free of side effects
+// (idempotency_level = NO_SIDE_EFFECTS) are not recorded.
+func newAuditInterceptor(svc *Service) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if req.Spec().IdempotencyLevel == connect.IdempotencyNoSideEffects {
+				return next(ctx, req)
+			}
+
+			// Resolve targets before the call, deleted modules can't be resolved afterwards
+			resources := svc.requestResources(ctx, req.Any())
+
+			audited := &auditedPrincipal{username: userFromContext(ctx)}
+			resp, err := next(context.WithValue(ctx, auditedPrincipalKey, audited), req)
+
+			record := storage.AuditRecord{
+				RequestID: newAuditID(),
+				Time:      time.Now().UTC(),
+				Principal: audited.username,
+				Procedure: req.Spec().Procedure,
+				Outcome:   "ok",
+				ClientIP:  svc.clientIP(req.Header(), req.Peer().Addr),
+			}
+			if err != nil {
+				record.Outcome = connect.CodeOf(err).String()
+				record.Error = err.Error()
+			}
+			for _, res := range resources {
+				r := record
+				r.ID = newAuditID()
+				r.Owner, r.Module, r.Label = res.Owner, res.Module, res.Label
+				svc.appendAudit(ctx, &r)
+			}
+
+			return resp, err
+		}
+	}
+}
+
+// recordLogin writes a login event to the audit log.
+func (svc *Service) recordLogin(ctx context.Context, username string, r *http.Request, loginErr error) {
+	id := newAuditID()
+	record := &storage.AuditRecord{
+		ID:        id,
+		RequestID: id,
+		Time:      time.Now().UTC(),
+		Principal: username,
+		Procedure: loginProcedure,
+		Outcome:   "ok",
+		ClientIP:  svc.clientIP(r.Header, r.RemoteAddr),
+	}
+	if loginErr != nil {
+		record.Outcome = connect.CodeUnauthenticated.String()
+		record.Error = loginErr.Error()
+	}
+	svc.appendAudit(ctx, record)
+}
+
+// appendAudit appends a record to the audit log. Failures are logged but never fail the request.
+func (svc *Service) appendAudit(ctx context.Context, record *storage.AuditRecord) {
+	if svc.audit == nil {
+		return
+	}
+	if err := svc.audit.Append(ctx, record); err != nil {
+		slog.ErrorContext(ctx, "failed to write audit record", "procedure", record.Procedure, "principal", record.Principal, "error", err)
+	}
+}
+
+// auditAdmin records the calls to an admin endpoint that may change state, that is all
+// but GET requests, including those rejected. The target module is taken from the module
+// query parameter ("owner/name").
+func (svc *Service) auditAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		wrapped := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(wrapped, r)
+
+		id := newAuditID()
+		record := &storage.AuditRecord{
+			ID:        id,
+			RequestID: id,
+			Time:      time.Now().UTC(),
+			Procedure: r.Method + " " + r.URL.Path,
+			Outcome:   httpOutcome(wrapped.status),
+			ClientIP:  svc.clientIP(r.Header, r.RemoteAddr),
+		}
+		if principal, err := svc.authenticator.Authenticate(r.Context(), r.Header); err == nil && principal != nil {
+			record.Principal = principal.Username
+		}
+		if owner, name, ok := parseModuleName(r.URL.Query().Get("module")); ok {
+			record.Owner, record.Module = owner, name
+		}
+		svc.appendAudit(r.Context(), record)
+	})
+}
+
+// httpOutcome returns the audit outcome of an HTTP response status: "ok", or the
+// connect error code matching the status.
+func httpOutcome(status int) string {
+	var code connect.Code
+	switch {
+	case status < http.StatusBadRequest:
+		return "ok"
+	case status == http.StatusBadRequest:
+		code = connect.CodeInvalidArgument
+	case status == http.StatusUnauthorized:
+		code = connect.CodeUnauthenticated
+	case status == http.StatusForbidden:
+		code = connect.CodePermissionDenied
+	case status == http.StatusNotFound:
+		code = connect.CodeNotFound
+	case status == http.StatusConflict:
+		code = connect.CodeAlreadyExists
+	case status == http.StatusTooManyRequests:
+		code = connect.CodeResourceExhausted
+	case status == http.StatusMethodNotAllowed, status == http.StatusNotImplemented:
+		code = connect.CodeUnimplemented
+	case status == http.StatusServiceUnavailable:
+		code = connect.CodeUnavailable
+	case status < http.StatusInternalServerError:
+		code = connect.CodeFailedPrecondition
+	default:
+		code = connect.CodeInternal
+	}
+	return code.String()
+}
+
+// parseTrustedProxies parses the addresses and CIDR ranges of trusted proxies.
+func parseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, p := range proxies {
+		if strings.Contains(p, "/") {
+			prefix, err := netip.ParsePrefix(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// trustedProxy reports whether addr is one of the configured trusted proxies.
+func (svc *Service) trustedProxy(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range svc.proxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the originating client address. X-Forwarded-For is only honored when
+// the peer is a trusted proxy: its entries are walked from the right, skipping trusted
+// proxies, so that clients can't forge their address by sending the header themselves.
+func (svc *Service) clientIP(header http.Header, peerAddr string) string {
+	ip := peerAddr
+	if host, _, err := net.SplitHostPort(peerAddr); err == nil {
+		ip = host
+	}
+	if !svc.trustedProxy(ip) {
+		return ip
+	}
+	var hops []string
+	for _, fwd := range header.Values("X-Forwarded-For") {
+		for hop := range strings.SplitSeq(fwd, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i] == "" {
+			continue
+		}
+		ip = hops[i]
+		if !svc.trustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// auditHandler serves GET /admin/audit for the admin user.
+// Query parameters: since and until (RFC 3339), owner, principal and limit.
+// Records are returned newest first as a JSON array, or as JSON lines with format=jsonl.
+func (svc *Service) auditHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if svc.audit == nil {
+			http.Error(w, "audit log not configured", http.StatusNotImplemented)
+			return
+		}
+
+		if !svc.requireAdmin(w, r) {
+			return
+		}
+
+		q, err := parseAuditQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		records, err := svc.audit.Query(r.Context(), q)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to query audit log", "error", err)
+			http.Error(w, "failed to query audit log", http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "jsonl" {
+			w.Header().Set("Content-Type", "application/jsonl")
+			enc := json.NewEncoder(w)
+			for _, rec := range records {
+				enc.Encode(rec)
+			}
+			return
+		}
+
+		if records == nil {
+			records = []*storage.AuditRecord{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	})
+}
+
+func parseAuditQuery(r *http.Request) (storage.AuditQuery, error) {
+	params := r.URL.Query()
+	q := storage.AuditQuery{
+		Owner:     params.Get("owner"),
+		Principal: params.Get("principal"),
+		Limit:     1000,
+	}
+
+	var err error
+	if v := params.Get("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, errors.New("invalid since: expected RFC 3339 timestamp")
+		}
+	}
+	if v := params.Get("until"); v != "" {
+		if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, errors.New("invalid until: expected RFC 3339 timestamp")
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			return q, errors.New("invalid limit: expected positive integer")
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"buf.build/gen/go/bufbuild/registry/connectrpc/go/buf/registry/module/v1/modulev1connect"
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	ownerv1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/owner/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/storage"
+	"gocloud.dev/docstore/memdocstore"
+)
+
+func setupAuditService(t *testing.T) (*Service, *httptest.Server, func()) {
+	t.Helper()
+	svc, cleanup := setupTestService(t)
+
+	coll, err := memdocstore.OpenCollection("ID", nil)
+	if err != nil {
+		t.Fatalf("failed to open audit collection: %v", err)
+	}
+	svc.audit = storage.NewAuditStore(coll, nil)
+	svc.conf.AdminToken = "admintoken"
+	svc.tokens["admintoken"] = &tokenInfo{Username: "admin"}
+	svc.authenticator = &tokenAuthenticator{svc: svc}
+	svc.authorizer = allowAllAuthorizer{}
+
+	mux := http.NewServeMux()
+	interceptors := connect.WithInterceptors(newAuditInterceptor(svc), newAuthInterceptor(svc))
+	mux.Handle(modulev1connect.NewModuleServiceHandler(NewModuleService(svc), interceptors))
+	mux.Handle(AuditPath, svc.auditHandler())
+	mux.Handle(DeletedPath, svc.auditAdmin(svc.deletedHandler()))
+	srv := httptest.NewServer(mux)
+
+	return svc, srv, func() {
+		srv.Close()
+		coll.Close()
+		cleanup()
+	}
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	svc, srv, cleanup := setupAuditService(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// The test server connects from loopback, in front of it sits a proxy at 10.0.0.1
+	proxies, err := parseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("parseTrustedProxies failed: %v", err)
+	}
+	svc.proxies = proxies
+
+	client := modulev1connect.NewModuleServiceClient(srv.Client(), srv.URL)
+
+	create := connect.NewRequest(&v1.CreateModulesRequest{
+		Values: []*v1.CreateModulesRequest_Value{
+			{OwnerRef: &ownerv1.OwnerRef{Value: &ownerv1.OwnerRef_Name{Name: "acme"}}, Name: "petapis"},
+		},
+	})
+	create.Header().Set(authenticationHeader, "Bearer testtoken")
+	create.Header().Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7, 10.0.0.1")
+	if _, err := client.CreateModules(ctx, create); err != nil {
+		t.Fatalf("CreateModules failed: %v", err)
+	}
+
+	// Reads are not audited
+	get := connect.NewRequest(&v1.GetModulesRequest{
+		ModuleRefs: []*v1.ModuleRef{{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: "petapis"}}}},
+	})
+	get.Header().Set(authenticationHeader, "Bearer testtoken")
+	if _, err := client.GetModules(ctx, get); err != nil {
+		t.Fatalf("GetModules failed: %v", err)
+	}
+
+	records, err := svc.audit.Query(ctx, storage.AuditQuery{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 audit record, got %d", len(records))
+	}
+	r := records[0]
+	if r.Principal != "testuser" || r.Owner != "acme" || r.Module != "petapis" || r.Outcome != "ok" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	if r.Procedure != modulev1connect.ModuleServiceCreateModulesProcedure {
+		t.Errorf("procedure = %q", r.Procedure)
+	}
+	if r.ClientIP != "203.0.113.7" {
+		t.Errorf("client IP = %q, want 203.0.113.7", r.ClientIP)
+	}
+}
+
+func TestAuditInterceptor_UntrustedForwardedFor(t *testing.T) {
+	svc, srv, cleanup := setupAuditService(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	client := modulev1connect.NewModuleServiceClient(srv.Client(), srv.URL)
+	create := connect.NewRequest(&v1.CreateModulesRequest{
+		Values: []*v1.CreateModulesRequest_Value{
+			{OwnerRef: &ownerv1.OwnerRef{Value: &ownerv1.OwnerRef_Name{Name: "acme"}}, Name: "petapis"},
+		},
+	})
+	create.Header().Set(authenticationHeader, "Bearer testtoken")
+	create.Header().Set("X-Forwarded-For", "203.0.113.7")
+	if _, err := client.CreateModules(ctx, create); err != nil {
+		t.Fatalf("CreateModules failed: %v", err)
+	}
+
+	records, err := svc.audit.Query(ctx, storage.AuditQuery{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(records) != 1 || records[0].ClientIP != "127.0.0.1" {
+		t.Errorf("expected the peer address, X-Forwarded-For from an untrusted peer is ignored: %+v", records)
+	}
+}
+
+func TestAuditInterceptor_Denied(t *testing.T) {
+	svc, srv, cleanup := setupAuditService(t)
+	defer cleanup()
+	ctx := context.Background()
+	svc.authorizer = &recordingAuthorizer{deny: true}
+
+	client := modulev1connect.NewModuleServiceClient(srv.Client(), srv.URL)
+	newCreate := func(token string) *connect.Request[v1.CreateModulesRequest] {
+		req := connect.NewRequest(&v1.CreateModulesRequest{
+			Values: []*v1.CreateModulesRequest_Value{
+				{OwnerRef: &ownerv1.OwnerRef{Value: &ownerv1.OwnerRef_Name{Name: "acme"}}, Name: "petapis"},
+			},
+		})
+		req.Header().Set(authenticationHeader, "Bearer "+token)
+		return req
+	}
+	if _, err := client.CreateModules(ctx, newCreate("testtoken")); connect.CodeOf(err) != connect.CodePermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+	if _, err := client.CreateModules(ctx, newCreate("badtoken")); connect.CodeOf(err) != connect.CodeUnauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+
+	records, err := svc.audit.Query(ctx, storage.AuditQuery{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 audit records, got %d", len(records))
+	}
+	// Newest first
+	if r := records[0]; r.Principal != "" || r.Outcome != connect.CodeUnauthenticated.String() {
+		t.Errorf("unexpected unauthenticated record: %+v", r)
+	}
+	if r := records[1]; r.Principal != "testuser" || r.Outcome != connect.CodePermissionDenied.String() || r.Module != "petapis" {
+		t.Errorf("unexpected denied record: %+v", r)
+	}
+}
+
+func TestAuditAdmin(t *testing.T) {
+	svc, srv, cleanup := setupAuditService(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	do := func(method, token string) {
+		req, _ := http.NewRequest(method, srv.URL+DeletedPath+"?module=acme/petapis", nil)
+		req.Header.Set(authenticationHeader, "Bearer "+token)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	do(http.MethodGet, "admintoken")  // reads are not audited
+	do(http.MethodPost, "testtoken")  // rejected
+	do(http.MethodPost, "admintoken") // no such deleted module
+
+	records, err := svc.audit.Query(ctx, storage.AuditQuery{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 audit records, got %d", len(records))
+	}
+	want := []struct{ principal, outcome string }{
+		{"admin", connect.CodeNotFound.String()},
+		{"testuser", connect.CodePermissionDenied.String()},
+	}
+	for i, r := range records {
+		if r.Principal != want[i].principal || r.Outcome != want[i].outcome {
+			t.Errorf("record %d: got principal %q outcome %q, want %+v", i, r.Principal, r.Outcome, want[i])
+		}
+		if r.Procedure != "POST "+DeletedPath || r.Owner != "acme" || r.Module != "petapis" {
+			t.Errorf("record %d: unexpected target: %+v", i, r)
+		}
+	}
+}
+
+func TestAuditHandler(t *testing.T) {
+	svc, srv, cleanup := setupAuditService(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	svc.appendAudit(ctx, &storage.AuditRecord{ID: "a", Principal: "alice", Procedure: "/p/Upload", Owner: "acme", Outcome: "ok"})
+	svc.appendAudit(ctx, &storage.AuditRecord{ID: "b", Principal: "bob", Procedure: "/p/Upload", Owner: "other", Outcome: "ok"})
+
+	get := func(token, query string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+AuditPath+query, nil)
+		req.Header.Set(authenticationHeader, "Bearer "+token)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp
+	}
+
+	resp := get("testtoken", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("non-admin status = %d, want 403", resp.StatusCode)
+	}
+
+	resp = get("admintoken", "?since=yesterday")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid since status = %d, want 400", resp.StatusCode)
+	}
+
+	resp = get("admintoken", "?owner=acme")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	var records []*storage.AuditRecord
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(records) != 1 || records[0].Principal != "alice" {
+		t.Errorf("unexpected records: %+v", records)
+	}
+}
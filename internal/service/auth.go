@@ -0,0 +1,456 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	v1beta1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1beta1"
+	ownerv1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/owner/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/policy"
+)
+
+// Principal is the authenticated identity making a request.
+type Principal struct {
+	// Username is the unique name of the principal.
+	Username string
+	// Groups are the groups the principal is a member of.
+	Groups []string
+	// Scopes are the scopes granted to the token used for the request.
+	Scopes []string
+}
+
+// Resource identifies the registry object an RPC operates on.
+// Fields that cannot be determined from the request are left empty.
+type Resource struct {
+	Owner  string
+	Module string
+	Label  string
+}
+
+// Authenticator resolves the principal for an incoming request.
+// Returning an error rejects the request with CodeUnauthenticated,
+// unless the error is already a *connect.Error.
+type Authenticator interface {
+	Authenticate(ctx context.Context, headers http.Header) (*Principal, error)
+}
+
+// Authorizer decides whether a principal may perform an action on a resource.
+// The action is the full Connect procedure name (e.g. "/buf.registry.module.v1.UploadService/Upload").
+// Returning an error rejects the request with CodePermissionDenied,
+// unless the error is already a *connect.Error.
+type Authorizer interface {
+	Authorize(ctx context.Context, principal *Principal, action string, resource Resource) error
+}
+
+const principalContextKey contextKey = "principal"
+
+func contextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, principal)
+}
+
+// PrincipalFromContext returns the authenticated principal for the request, if any.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	if p, ok := ctx.Value(principalContextKey).(*Principal); ok {
+		return p
+	}
+	return nil
+}
+
+func contextWithUser(ctx context.Context, user string) context.Context {
+	return contextWithPrincipal(ctx, &Principal{Username: user})
+}
+
+func userFromContext(ctx context.Context) string {
+	if p := PrincipalFromContext(ctx); p != nil {
+		return p.Username
+	}
+	return ""
+}
+
+// tokenAuthenticator is the default Authenticator.
+// It validates bearer tokens against the static and OIDC-issued tokens held by the Service.
+type tokenAuthenticator struct {
+	svc *Service
+}
+
+func (a *tokenAuthenticator) Authenticate(ctx context.Context, headers http.Header) (*Principal, error) {
+	hdr := headers.Get(authenticationHeader)
+	if hdr == "" {
+		return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("no token provided"))
+	}
+
+	if !strings.HasPrefix(hdr, authenticationTokenPrefix) {
+		return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("invalid auth header"))
+	}
+
+	tokenString := strings.TrimSpace(strings.TrimPrefix(hdr, authenticationTokenPrefix))
+	if tokenString == "" {
+		return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("token missing"))
+	}
+
+	// Use mutex for thread-safe token lookup (tokens can be added dynamically via OIDC)
+	a.svc.mu.RLock()
+	info, ok := a.svc.tokens[tokenString]
+	a.svc.mu.RUnlock()
+	if !ok {
+		return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("invalid token"))
+	}
+
+	// Check if token is expired
+	if info.IsExpired() {
+		// Remove expired token
+		a.svc.mu.Lock()
+		delete(a.svc.tokens, tokenString)
+		a.svc.mu.Unlock()
+		return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("token expired"))
+	}
+
+	// Slide expiration for dynamic tokens (those with non-zero ExpiresAt)
+	if !info.ExpiresAt.IsZero() {
+		a.svc.mu.Lock()
+		info.ExpiresAt = time.Now().Add(a.svc.conf.GetTokenTTL())
+		a.svc.mu.Unlock()
+	}
+
+	return &Principal{
+		Username: info.Username,
+		Groups:   a.svc.conf.Authorization.GroupsFor(info.Username),
+	}, nil
+}
+
+// authenticate resolves the principal of a request. With NoLogin, requests that don't
+// authenticate are made by the anonymous principal, whose username is empty, and are
+// still subject to authorization.
+func (svc *Service) authenticate(ctx context.Context, headers http.Header) (*Principal, error) {
+	principal, err := svc.authenticator.Authenticate(ctx, headers)
+	if err == nil && principal == nil {
+		err = connect.NewError(connect.CodeUnauthenticated, errors.New("no principal"))
+	}
+	if err != nil {
+		if svc.conf != nil && svc.conf.NoLogin {
+			return &Principal{}, nil
+		}
+		return nil, asConnectError(err, connect.CodeUnauthenticated)
+	}
+	return principal, nil
+}
+
+// allowAllAuthorizer is the default Authorizer. Every authenticated principal may do anything.
+type allowAllAuthorizer struct{}
+
+func (allowAllAuthorizer) Authorize(context.Context, *Principal, string, Resource) error {
+	return nil
+}
+
+// asConnectError wraps err in a connect error with the given code, preserving existing connect errors.
+func asConnectError(err error, code connect.Code) error {
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) {
+		return connectErr
+	}
+	return connect.NewError(code, err)
+}
+
+// requestResources extracts the resources targeted by a request message.
+// It always returns at least one resource so that the authorizer is consulted
+// for requests that do not reference a specific owner or module.
+func (svc *Service) requestResources(ctx context.Context, msg any) []Resource {
+	var res []Resource
+
+	switch m := msg.(type) {
+	// v1 module API
+	case *v1.UploadRequest:
+		for _, content := range m.Contents {
+			r := svc.resourceFromModuleRef(ctx, content.ModuleRef)
+			labels := 0
+			for _, ref := range content.ScopedLabelRefs {
+				if name, ok := ref.Value.(*v1.ScopedLabelRef_Name); ok {
+					r.Label = name.Name
+					res = append(res, r)
+					labels++
+				}
+			}
+			if labels == 0 {
+				r.Label = svc.defaultLabelName(ctx, r.Owner, r.Module)
+				res = append(res, r)
+			}
+		}
+	case *v1.GetModulesRequest:
+		for _, ref := range m.ModuleRefs {
+			res = append(res, svc.resourceFromModuleRef(ctx, ref))
+		}
+	case *v1.ListModulesRequest:
+		for _, ref := range m.OwnerRefs {
+			res = append(res, svc.resourceFromOwnerRef(ctx, ref))
+		}
+	case *v1.CreateModulesRequest:
+		for _, value := range m.Values {
+			r := svc.resourceFromOwnerRef(ctx, value.OwnerRef)
+			r.Module = value.Name
+			res = append(res, r)
+		}
+	case *v1.UpdateModulesRequest:
+		for _, value := range m.Values {
+			res = append(res, svc.resourceFromModuleRef(ctx, value.ModuleRef))
+		}
+	case *v1.DeleteModulesRequest:
+		for _, ref := range m.ModuleRefs {
+			res = append(res, svc.resourceFromModuleRef(ctx, ref))
+		}
+	case *v1.GetCommitsRequest:
+		for _, ref := range m.ResourceRefs {
+			res = append(res, svc.resourceFromResourceRef(ctx, ref))
+		}
+	case *v1.ListCommitsRequest:
+		res = append(res, svc.resourceFromResourceRef(ctx, m.ResourceRef))
+	case *v1.GetGraphRequest:
+		for _, ref := range m.ResourceRefs {
+			res = append(res, svc.resourceFromResourceRef(ctx, ref))
+		}
+	case *v1.DownloadRequest:
+		for _, value := range m.Values {
+			res = append(res, svc.resourceFromResourceRef(ctx, value.ResourceRef))
+		}
+	case *v1.GetResourcesRequest:
+		for _, ref := range m.ResourceRefs {
+			res = append(res, svc.resourceFromResourceRef(ctx, ref))
+		}
+
+	// v1beta1 module API
+	case *v1beta1.UploadRequest:
+		for _, content := range m.Contents {
+			r := svc.resourceFromModuleRefV1beta1(ctx, content.ModuleRef)
+			labels := 0
+			for _, ref := range content.ScopedLabelRefs {
+				if name, ok := ref.Value.(*v1beta1.ScopedLabelRef_Name); ok {
+					r.Label = name.Name
+					res = append(res, r)
+					labels++
+				}
+			}
+			if labels == 0 {
+				r.Label = svc.defaultLabelName(ctx, r.Owner, r.Module)
+				res = append(res, r)
+			}
+		}
+	case *v1beta1.GetCommitsRequest:
+		for _, ref := range m.ResourceRefs {
+			res = append(res, svc.resourceFromResourceRefV1beta1(ctx, ref))
+		}
+	case *v1beta1.ListCommitsRequest:
+		res = append(res, svc.resourceFromResourceRefV1beta1(ctx, m.ResourceRef))
+	case *v1beta1.GetGraphRequest:
+		for _, ref := range m.ResourceRefs {
+			res = append(res, svc.resourceFromResourceRefV1beta1(ctx, ref.ResourceRef))
+		}
+	case *v1beta1.DownloadRequest:
+		for _, value := range m.Values {
+			res = append(res, svc.resourceFromResourceRefV1beta1(ctx, value.ResourceRef))
+		}
+
+	// owner API
+	case *ownerv1.GetOwnersRequest:
+		for _, ref := range m.OwnerRefs {
+			res = append(res, svc.resourceFromOwnerRef(ctx, ref))
+		}
+	case *ownerv1.CreateUsersRequest:
+		for _, value := range m.Values {
+			res = append(res, Resource{Owner: value.Name})
+		}
+	case *ownerv1.UpdateUsersRequest:
+		for _, value := range m.Values {
+			res = append(res, svc.resourceFromUserRef(ctx, value.UserRef))
+		}
+	case *ownerv1.DeleteUsersRequest:
+		for _, ref := range m.UserRefs {
+			res = append(res, svc.resourceFromUserRef(ctx, ref))
+		}
+	case *ownerv1.CreateOrganizationsRequest:
+		for _, value := range m.Values {
+			res = append(res, Resource{Owner: value.Name})
+		}
+	case *ownerv1.UpdateOrganizationsRequest:
+		for _, value := range m.Values {
+			res = append(res, svc.resourceFromOrganizationRef(ctx, value.OrganizationRef))
+		}
+	case *ownerv1.DeleteOrganizationsRequest:
+		for _, ref := range m.OrganizationRefs {
+			res = append(res, svc.resourceFromOrganizationRef(ctx, ref))
+		}
+	}
+
+	if len(res) == 0 {
+		res = append(res, Resource{})
+	}
+	return res
+}
+
+func (svc *Service) resourceFromOwnerRef(ctx context.Context, ref *ownerv1.OwnerRef) Resource {
+	if ref == nil {
+		return Resource{}
+	}
+	switch r := ref.Value.(type) {
+	case *ownerv1.OwnerRef_Name:
+		return Resource{Owner: r.Name}
+	case *ownerv1.OwnerRef_Id:
+		if svc.casReg != nil {
+			if owner, err := svc.casReg.Owner(ctx, r.Id); err == nil {
+				return Resource{Owner: owner.Name}
+			}
+		}
+	}
+	return Resource{}
+}
+
+func (svc *Service) resourceFromUserRef(ctx context.Context, ref *ownerv1.UserRef) Resource {
+	switch r := ref.GetValue().(type) {
+	case *ownerv1.UserRef_Name:
+		return Resource{Owner: r.Name}
+	case *ownerv1.UserRef_Id:
+		return svc.resourceFromOwnerRef(ctx, &ownerv1.OwnerRef{Value: &ownerv1.OwnerRef_Id{Id: r.Id}})
+	}
+	return Resource{}
+}
+
+func (svc *Service) resourceFromOrganizationRef(ctx context.Context, ref *ownerv1.OrganizationRef) Resource {
+	switch r := ref.GetValue().(type) {
+	case *ownerv1.OrganizationRef_Name:
+		return Resource{Owner: r.Name}
+	case *ownerv1.OrganizationRef_Id:
+		return svc.resourceFromOwnerRef(ctx, &ownerv1.OwnerRef{Value: &ownerv1.OwnerRef_Id{Id: r.Id}})
+	}
+	return Resource{}
+}
+
+func (svc *Service) resourceFromModuleRef(ctx context.Context, ref *v1.ModuleRef) Resource {
+	if ref == nil {
+		return Resource{}
+	}
+	switch r := ref.Value.(type) {
+	case *v1.ModuleRef_Name_:
+		if r.Name != nil {
+			return Resource{Owner: r.Name.Owner, Module: r.Name.Module}
+		}
+	case *v1.ModuleRef_Id:
+		return svc.resourceFromID(ctx, r.Id)
+	}
+	return Resource{}
+}
+
+func (svc *Service) resourceFromModuleRefV1beta1(ctx context.Context, ref *v1beta1.ModuleRef) Resource {
+	if ref == nil {
+		return Resource{}
+	}
+	switch r := ref.Value.(type) {
+	case *v1beta1.ModuleRef_Name_:
+		if r.Name != nil {
+			return Resource{Owner: r.Name.Owner, Module: r.Name.Module}
+		}
+	case *v1beta1.ModuleRef_Id:
+		return svc.resourceFromID(ctx, r.Id)
+	}
+	return Resource{}
+}
+
+func (svc *Service) resourceFromResourceRef(ctx context.Context, ref *v1.ResourceRef) Resource {
+	if ref == nil {
+		return Resource{}
+	}
+	switch r := ref.Value.(type) {
+	case *v1.ResourceRef_Name_:
+		if r.Name != nil {
+			return Resource{Owner: r.Name.Owner, Module: r.Name.Module, Label: r.Name.GetLabelName()}
+		}
+	case *v1.ResourceRef_Id:
+		return svc.resourceFromID(ctx, r.Id)
+	}
+	return Resource{}
+}
+
+func (svc *Service) resourceFromResourceRefV1beta1(ctx context.Context, ref *v1beta1.ResourceRef) Resource {
+	if ref == nil {
+		return Resource{}
+	}
+	switch r := ref.Value.(type) {
+	case *v1beta1.ResourceRef_Name_:
+		if r.Name != nil {
+			return Resource{Owner: r.Name.Owner, Module: r.Name.Module, Label: r.Name.GetLabelName()}
+		}
+	case *v1beta1.ResourceRef_Id:
+		return svc.resourceFromID(ctx, r.Id)
+	}
+	return Resource{}
+}
+
+// defaultLabelName returns the default label of a module, or "main" for modules that don't exist yet.
+func (svc *Service) defaultLabelName(ctx context.Context, owner, module string) string {
+	if svc.casReg != nil {
+		if mod, err := svc.casReg.Module(ctx, owner, module); err == nil {
+			return mod.DefaultLabelName()
+		}
+	}
+	return "main"
+}
+
+// resourceFromID resolves a module, commit or label ID to the owning module.
+func (svc *Service) resourceFromID(ctx context.Context, id string) Resource {
+	if svc.casReg == nil {
+		return Resource{}
+	}
+	if mod, err := svc.casReg.ModuleByID(ctx, id); err == nil {
+		return Resource{Owner: mod.Owner(), Module: mod.Name()}
+	}
+	if mod, err := svc.casReg.ModuleByCommitID(ctx, id); err == nil {
+		return Resource{Owner: mod.Owner(), Module: mod.Name()}
+	}
+	if moduleID, label, ok := strings.Cut(id, "/"); ok {
+		if mod, err := svc.casReg.ModuleByID(ctx, moduleID); err == nil {
+			return Resource{Owner: mod.Owner(), Module: mod.Name(), Label: label}
+		}
+	}
+	return Resource{}
+}
+
+// policyAuthorizer authorizes requests against a CEL policy and logs every decision.
+type policyAuthorizer struct {
+	policy *policy.Policy
+}
+
+func (a *policyAuthorizer) Authorize(ctx context.Context, principal *Principal, action string, resource Resource) error {
+	decision, err := a.policy.Evaluate(ctx, policy.Input{
+		Username:  principal.Username,
+		Groups:    principal.Groups,
+		Scopes:    principal.Scopes,
+		Procedure: action,
+		Owner:     resource.Owner,
+		Module:    resource.Module,
+		Label:     resource.Label,
+	})
+
+	attrs := []any{
+		"user", principal.Username,
+		"procedure", action,
+		"owner", resource.Owner,
+		"module", resource.Module,
+		"label", resource.Label,
+		"rule", decision.Rule,
+		"allowed", decision.Allowed,
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "authorization policy evaluation failed", append(attrs, "error", err)...)
+		return connect.NewError(connect.CodePermissionDenied, errors.New("authorization policy evaluation failed"))
+	}
+	slog.InfoContext(ctx, "authorization decision", attrs...)
+
+	if !decision.Allowed {
+		return connect.NewError(connect.CodePermissionDenied, fmt.Errorf("%s denied for %s", action, principal.Username))
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/policy"
+	"github.com/greatliontech/pbr/internal/ratelimit"
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+type staticAuthenticator struct {
+	principal *Principal
+	err       error
+}
+
+func (a *staticAuthenticator) Authenticate(ctx context.Context, headers http.Header) (*Principal, error) {
+	return a.principal, a.err
+}
+
+type recordingAuthorizer struct {
+	calls []Resource
+	deny  bool
+}
+
+func (a *recordingAuthorizer) Authorize(ctx context.Context, principal *Principal, action string, resource Resource) error {
+	a.calls = append(a.calls, resource)
+	if a.deny {
+		return errors.New("denied")
+	}
+	return nil
+}
+
+func callInterceptor(t *testing.T, svc *Service, req connect.AnyRequest) (context.Context, error) {
+	t.Helper()
+	var gotCtx context.Context
+	next := func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		gotCtx = ctx
+		return nil, nil
+	}
+	_, err := newAuthInterceptor(svc)(next)(context.Background(), req)
+	return gotCtx, err
+}
+
+func TestAuthInterceptor_DefaultTokenAuthenticator(t *testing.T) {
+	svc := &Service{
+		conf:   &config.Config{},
+		tokens: map[string]*tokenInfo{"testtoken": {Username: "testuser"}},
+	}
+	svc.authenticator = &tokenAuthenticator{svc: svc}
+	svc.authorizer = allowAllAuthorizer{}
+
+	tests := []struct {
+		name     string
+		header   string
+		wantUser string
+		wantErr  bool
+	}{
+		{name: "valid token", header: "Bearer testtoken", wantUser: "testuser"},
+		{name: "no header", header: "", wantErr: true},
+		{name: "wrong scheme", header: "Basic testtoken", wantErr: true},
+		{name: "unknown token", header: "Bearer nope", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := connect.NewRequest(&v1.GetModulesRequest{})
+			if tt.header != "" {
+				req.Header().Set(authenticationHeader, tt.header)
+			}
+
+			ctx, err := callInterceptor(t, svc, req)
+			if tt.wantErr {
+				if connect.CodeOf(err) != connect.CodeUnauthenticated {
+					t.Fatalf("expected CodeUnauthenticated, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := userFromContext(ctx); got != tt.wantUser {
+				t.Errorf("user = %q, want %q", got, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestAuthInterceptor_ExpiredToken(t *testing.T) {
+	svc := &Service{
+		conf: &config.Config{},
+		tokens: map[string]*tokenInfo{
+			"old": {Username: "olduser", ExpiresAt: time.Now().Add(-time.Minute)},
+		},
+	}
+	svc.authenticator = &tokenAuthenticator{svc: svc}
+	svc.authorizer = allowAllAuthorizer{}
+
+	req := connect.NewRequest(&v1.GetModulesRequest{})
+	req.Header().Set(authenticationHeader, "Bearer old")
+
+	if _, err := callInterceptor(t, svc, req); connect.CodeOf(err) != connect.CodeUnauthenticated {
+		t.Fatalf("expected CodeUnauthenticated, got %v", err)
+	}
+	if _, ok := svc.tokens["old"]; ok {
+		t.Error("expected expired token to be removed")
+	}
+}
+
+func TestAuthInterceptor_CustomAuthenticator(t *testing.T) {
+	svc := &Service{}
+	WithAuthenticator(&staticAuthenticator{
+		principal: &Principal{Username: "embedded", Groups: []string{"platform"}},
+	})(svc)
+	svc.authorizer = allowAllAuthorizer{}
+
+	ctx, err := callInterceptor(t, svc, connect.NewRequest(&v1.GetModulesRequest{}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p := PrincipalFromContext(ctx)
+	if p == nil || p.Username != "embedded" {
+		t.Fatalf("expected principal 'embedded', got %+v", p)
+	}
+	if len(p.Groups) != 1 || p.Groups[0] != "platform" {
+		t.Errorf("expected groups [platform], got %v", p.Groups)
+	}
+}
+
+func TestAuthInterceptor_AuthenticatorError(t *testing.T) {
+	svc := &Service{}
+	WithAuthenticator(&staticAuthenticator{err: errors.New("identity service down")})(svc)
+	svc.authorizer = allowAllAuthorizer{}
+
+	_, err := callInterceptor(t, svc, connect.NewRequest(&v1.GetModulesRequest{}))
+	if connect.CodeOf(err) != connect.CodeUnauthenticated {
+		t.Fatalf("expected CodeUnauthenticated, got %v", err)
+	}
+}
+
+func TestAuthInterceptor_Authorizer(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	files := []registry.File{{Path: "a.proto", Content: "syntax = \"proto3\";"}}
+	createTestModule(t, svc, "acme", "petapis", files, []string{"main"})
+	mod, err := svc.casReg.Module(context.Background(), "acme", "petapis")
+	if err != nil {
+		t.Fatalf("failed to get module: %v", err)
+	}
+
+	authz := &recordingAuthorizer{}
+	WithAuthenticator(&staticAuthenticator{principal: &Principal{Username: "alice"}})(svc)
+	WithAuthorizer(authz)(svc)
+
+	req := connect.NewRequest(&v1.UploadRequest{
+		Contents: []*v1.UploadRequest_Content{
+			{
+				ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Id{Id: mod.ID()}},
+				ScopedLabelRefs: []*v1.ScopedLabelRef{
+					{Value: &v1.ScopedLabelRef_Name{Name: "release/1.0"}},
+				},
+			},
+		},
+	})
+
+	if _, err := callInterceptor(t, svc, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(authz.calls) != 1 {
+		t.Fatalf("expected 1 authorize call, got %d", len(authz.calls))
+	}
+	want := Resource{Owner: "acme", Module: "petapis", Label: "release/1.0"}
+	if authz.calls[0] != want {
+		t.Errorf("resource = %+v, want %+v", authz.calls[0], want)
+	}
+
+	authz.deny = true
+	if _, err := callInterceptor(t, svc, req); connect.CodeOf(err) != connect.CodePermissionDenied {
+		t.Fatalf("expected CodePermissionDenied, got %v", err)
+	}
+}
+
+func TestAuthInterceptor_NoLoginAuthorizesAnonymous(t *testing.T) {
+	svc := &Service{conf: &config.Config{NoLogin: true}, tokens: map[string]*tokenInfo{}}
+	svc.authenticator = &tokenAuthenticator{svc: svc}
+	authz := &recordingAuthorizer{}
+	WithAuthorizer(authz)(svc)
+
+	req := connect.NewRequest(&v1.GetModulesRequest{
+		ModuleRefs: []*v1.ModuleRef{{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: "petapis"}}}},
+	})
+	ctx, err := callInterceptor(t, svc, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p := PrincipalFromContext(ctx); p == nil || p.Username != "" {
+		t.Errorf("expected the anonymous principal, got %+v", p)
+	}
+	if len(authz.calls) != 1 {
+		t.Fatalf("expected the policy to be consulted once, got %d calls", len(authz.calls))
+	}
+
+	authz.deny = true
+	if _, err := callInterceptor(t, svc, req); connect.CodeOf(err) != connect.CodePermissionDenied {
+		t.Fatalf("expected CodePermissionDenied, got %v", err)
+	}
+}
+
+func TestPolicyAuthorizer(t *testing.T) {
+	pol, err := policy.Parse([]byte(`
+default: allow
+rules:
+  - name: protect-release
+    effect: deny
+    condition: resource.label.startsWith("release/") && !("platform" in principal.groups)
+`))
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+	authz := &policyAuthorizer{policy: pol}
+	ctx := context.Background()
+	res := Resource{Owner: "acme", Module: "petapis", Label: "release/1.0"}
+
+	err = authz.Authorize(ctx, &Principal{Username: "bob"}, "/buf.registry.module.v1.UploadService/Upload", res)
+	if connect.CodeOf(err) != connect.CodePermissionDenied {
+		t.Errorf("expected CodePermissionDenied, got %v", err)
+	}
+
+	err = authz.Authorize(ctx, &Principal{Username: "alice", Groups: []string{"platform"}}, "/buf.registry.module.v1.UploadService/Upload", res)
+	if err != nil {
+		t.Errorf("expected platform member to be allowed, got %v", err)
+	}
+}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	limiter, err := ratelimit.New(&config.RateLimit{
+		PerPrincipal: &config.Limit{Rate: 0.001, Burst: 1},
+	})
+	if err != nil {
+		t.Fatalf("failed to create limiter: %v", err)
+	}
+	next := func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		return nil, nil
+	}
+	call := newRateLimitInterceptor(limiter)(next)
+	ctx := contextWithUser(context.Background(), "ci")
+
+	if _, err := call(ctx, connect.NewRequest(&v1.GetGraphRequest{})); err != nil {
+		t.Fatalf("first request failed: %v", err)
+	}
+
+	_, err = call(ctx, connect.NewRequest(&v1.GetGraphRequest{}))
+	var connectErr *connect.Error
+	if !errors.As(err, &connectErr) || connectErr.Code() != connect.CodeResourceExhausted {
+		t.Fatalf("expected CodeResourceExhausted, got %v", err)
+	}
+	if connectErr.Meta().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+	if len(connectErr.Details()) != 1 {
+		t.Errorf("expected RetryInfo detail, got %d details", len(connectErr.Details()))
+	}
+}
@@ -2,8 +2,7 @@ package service
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 
 	registryv1alpha1 "buf.build/gen/go/bufbuild/buf/protocolbuffers/go/buf/alpha/registry/v1alpha1"
 	"connectrpc.com/connect"
@@ -32,14 +31,20 @@ func (a *AuthnService) GetCurrentUser(
 		return nil, connect.NewError(connect.CodeUnauthenticated, nil)
 	}
 
-	// Generate a deterministic user ID from the username
-	userID := generateUserID(username)
+	if a.svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+
+	record, err := a.svc.casReg.GetOrCreateUser(ctx, username)
+	if err != nil {
+		return nil, provisionUserError(err)
+	}
 
 	user := &registryv1alpha1.User{
-		Id:                 userID,
-		Username:           username,
-		CreateTime:         timestamppb.Now(),
-		UpdateTime:         timestamppb.Now(),
+		Id:                 record.ID,
+		Username:           record.Name,
+		CreateTime:         timestamppb.New(record.CreateTime),
+		UpdateTime:         timestamppb.New(record.CreateTime),
 		Deactivated:        false,
 		VerificationStatus: registryv1alpha1.VerificationStatus_VERIFICATION_STATUS_UNSPECIFIED,
 		UserType:           registryv1alpha1.UserType_USER_TYPE_PERSONAL,
@@ -70,9 +75,3 @@ func (a *AuthnService) GetCurrentUserSubject(
 		Subject: subject,
 	}), nil
 }
-
-// generateUserID creates a deterministic user ID from a username.
-func generateUserID(username string) string {
-	hash := sha256.Sum256([]byte(username))
-	return hex.EncodeToString(hash[:16])
-}
@@ -6,18 +6,12 @@ import (
 
 	registryv1alpha1 "buf.build/gen/go/bufbuild/buf/protocolbuffers/go/buf/alpha/registry/v1alpha1"
 	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/storage"
 )
 
 func TestAuthnService_GetCurrentUser(t *testing.T) {
-	// Create a mock service
-	svc := &Service{
-		tokens: map[string]*tokenInfo{
-			"test-token": {Username: "testuser"},
-		},
-		users: map[string]string{
-			"testuser": "test-token",
-		},
-	}
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
 	authnSvc := NewAuthnService(svc)
 
 	tests := []struct {
@@ -130,42 +124,29 @@ func TestAuthnService_GetCurrentUserSubject(t *testing.T) {
 	}
 }
 
-func TestGenerateUserID(t *testing.T) {
-	tests := []struct {
-		name     string
-		username string
-	}{
-		{
-			name:     "simple username",
-			username: "testuser",
-		},
-		{
-			name:     "email as username",
-			username: "test@example.com",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			id1 := generateUserID(tt.username)
-			id2 := generateUserID(tt.username)
-
-			// IDs should be deterministic
-			if id1 != id2 {
-				t.Errorf("generateUserID() not deterministic: %v != %v", id1, id2)
-			}
+func TestAuthnService_GetCurrentUser_StableID(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	authnSvc := NewAuthnService(svc)
+	ctx := contextWithUser(context.Background(), "testuser")
 
-			// ID should be 32 chars (16 bytes hex encoded)
-			if len(id1) != 32 {
-				t.Errorf("generateUserID() length = %v, want 32", len(id1))
-			}
-		})
+	first, err := authnSvc.GetCurrentUser(ctx, connect.NewRequest(&registryv1alpha1.GetCurrentUserRequest{}))
+	if err != nil {
+		t.Fatalf("GetCurrentUser() unexpected error: %v", err)
+	}
+	second, err := authnSvc.GetCurrentUser(ctx, connect.NewRequest(&registryv1alpha1.GetCurrentUserRequest{}))
+	if err != nil {
+		t.Fatalf("GetCurrentUser() unexpected error: %v", err)
+	}
+	if first.Msg.User.Id == "" || first.Msg.User.Id != second.Msg.User.Id {
+		t.Errorf("GetCurrentUser() IDs not stable: %q != %q", first.Msg.User.Id, second.Msg.User.Id)
 	}
 
-	// Different usernames should produce different IDs
-	id1 := generateUserID("user1")
-	id2 := generateUserID("user2")
-	if id1 == id2 {
-		t.Errorf("generateUserID() produced same ID for different usernames")
+	record, err := svc.casReg.OwnerByName(ctx, "testuser")
+	if err != nil {
+		t.Fatalf("user record not provisioned: %v", err)
+	}
+	if record.ID != first.Msg.User.Id || record.Type != storage.OwnerTypeUser {
+		t.Errorf("unexpected user record: %+v", record)
 	}
 }
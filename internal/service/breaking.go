@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/bufbuild/protocompile/linker"
+	"github.com/greatliontech/pbr/internal/breaking"
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BreakingPath is the endpoint reporting the breaking changes between two commits.
+const BreakingPath = "/breaking"
+
+// breakingCheck is the check of findings recorded for breaking changes.
+const breakingCheck = "breaking"
+
+// validateBreaking checks the modes, rule sets and module globs of the breaking change policies.
+func validateBreaking(conf *config.Breaking) error {
+	if conf == nil {
+		return nil
+	}
+	policies := map[string]config.BreakingPolicy{"default": conf.Default}
+	for pattern, p := range conf.Modules {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("breaking: invalid module glob %q: %w", pattern, err)
+		}
+		policies[pattern] = p
+	}
+	for name, p := range policies {
+		switch p.Mode {
+		case "", config.BreakingOff, config.BreakingWarn, config.BreakingReject:
+		default:
+			return fmt.Errorf("breaking: %s: unknown mode %q, expected off, warn or reject", name, p.Mode)
+		}
+		if _, err := breaking.ParseRuleSet(p.Rules); err != nil {
+			return fmt.Errorf("breaking: %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// checkBreaking compares the descriptors of an uploaded module with those of the commits its
+// labels point to, following the module's breaking change policy. In reject mode, breaking
+// changes fail the upload with FailedPrecondition. Otherwise they are returned, to be
+// recorded on the new commit. Modules that weren't compiled are left to checkUncompiled.
+func (svc *Service) checkBreaking(ctx context.Context, mod *registry.Module, labels []string, compiled linker.Files) ([]storage.Finding, error) {
+	policy := svc.conf.Breaking.PolicyFor(mod.Owner() + "/" + mod.Name())
+	if policy.Mode == "" || policy.Mode == config.BreakingOff || compiled == nil {
+		return nil, nil
+	}
+	rules, err := breaking.ParseRuleSet(policy.Rules)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	var findings []storage.Finding
+	var report []string
+	checked := make(map[string]bool)
+	for _, label := range labels {
+		prev, err := mod.Commit(ctx, label)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("failed to get label %s: %w", label, err))
+		}
+		if checked[prev.ID] {
+			continue
+		}
+		checked[prev.ID] = true
+
+		previous, err := svc.commitDescriptors(ctx, mod, prev.ID)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("failed to load commit %s: %w", prev.ID, err))
+		}
+		if previous == nil {
+			slog.DebugContext(ctx, "skipping breaking change detection", "owner", mod.Owner(), "module", mod.Name(), "against", prev.ID)
+			continue
+		}
+		for _, f := range breaking.Check(compiled, previous, rules) {
+			findings = append(findings, storageFinding(f, prev.ID))
+			report = append(report, fmt.Sprintf("%s (label %s, commit %s)", f, label, prev.ID))
+		}
+	}
+
+	if len(findings) > 0 && policy.Mode == config.BreakingReject {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("%s/%s has breaking changes (%s rules):\n%s", mod.Owner(), mod.Name(), rules, strings.Join(report, "\n")))
+	}
+	return findings, nil
+}
+
+func storageFinding(f breaking.Finding, against string) storage.Finding {
+	return storage.Finding{
+		Check:   breakingCheck,
+		Rule:    f.Rule,
+		Path:    f.File,
+		Line:    f.Line,
+		Column:  f.Column,
+		Message: f.Message,
+		Against: against,
+	}
+}
+
+// breakingReport is the response of the breaking endpoint.
+type breakingReport struct {
+	Commit   string          `json:"commit"`
+	Against  string          `json:"against,omitempty"`
+	Rules    string          `json:"rules,omitempty"`
+	Findings []reportFinding `json:"findings"`
+}
+
+type reportFinding struct {
+	Rule    string `json:"rule"`
+	Path    string `json:"path"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+	Against string `json:"against,omitempty"`
+}
+
+// breakingHandler serves GET /breaking.
+// Query parameters: commit (required), against and rules (default: the rules of the module's policy).
+// With against, the breaking changes of commit against it are computed, otherwise those recorded
+// when commit was pushed are returned.
+// The BSR APIs served here have no procedure for breaking changes, so like the other
+// endpoints of this registry it is plain HTTP. It traces itself, and is rate limited
+// by rateLimitHandler under BreakingPath.
+func (svc *Service) breakingHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		params := r.URL.Query()
+		commitID := params.Get("commit")
+		if commitID == "" {
+			http.Error(w, "commit is required", http.StatusBadRequest)
+			return
+		}
+
+		ctx, span := tracer.Start(r.Context(), "service.breaking", trace.WithAttributes(
+			attribute.String("commit", commitID),
+			attribute.String("against", params.Get("against")),
+		))
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		principal, err := svc.authenticate(ctx, r.Header)
+		if err != nil {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+		// resolve looks up the module of a commit, checking the principal may read it
+		resolve := func(id string) (*registry.Module, bool) {
+			mod, err := svc.casReg.ModuleByCommitID(ctx, id)
+			if errors.Is(err, storage.ErrNotFound) {
+				http.Error(w, fmt.Sprintf("commit %s not found", id), http.StatusNotFound)
+				return nil, false
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to get module of commit", "commitID", id, "error", err)
+				http.Error(w, "failed to get commit", http.StatusInternalServerError)
+				return nil, false
+			}
+			if err := svc.authorizer.Authorize(ctx, principal, BreakingPath, Resource{Owner: mod.Owner(), Module: mod.Name()}); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return nil, false
+			}
+			return mod, true
+		}
+
+		mod, ok := resolve(commitID)
+		if !ok {
+			return
+		}
+		report := breakingReport{Commit: commitID, Findings: []reportFinding{}}
+
+		against := params.Get("against")
+		if against == "" {
+			commit, err := mod.CommitByID(ctx, commitID)
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to get commit", "commitID", commitID, "error", err)
+				http.Error(w, "failed to get commit", http.StatusInternalServerError)
+				return
+			}
+			for _, f := range commit.Findings {
+				if f.Check == breakingCheck {
+					report.Findings = append(report.Findings, reportFinding{
+						Rule: f.Rule, Path: f.Path, Line: f.Line, Column: f.Column, Message: f.Message, Against: f.Against,
+					})
+				}
+			}
+			writeJSON(w, report)
+			return
+		}
+
+		againstMod, ok := resolve(against)
+		if !ok {
+			return
+		}
+		rulesName := params.Get("rules")
+		if rulesName == "" {
+			rulesName = svc.conf.Breaking.PolicyFor(mod.Owner() + "/" + mod.Name()).Rules
+		}
+		rules, err := breaking.ParseRuleSet(rulesName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		report.Against = against
+		report.Rules = string(rules)
+
+		current, ok := svc.reportDescriptors(w, r, mod, commitID)
+		if !ok {
+			return
+		}
+		previous, ok := svc.reportDescriptors(w, r, againstMod, against)
+		if !ok {
+			return
+		}
+		for _, f := range breaking.Check(current, previous, rules) {
+			report.Findings = append(report.Findings, reportFinding{
+				Rule: f.Rule, Path: f.File, Line: f.Line, Column: f.Column, Message: f.Message,
+			})
+		}
+		writeJSON(w, report)
+	})
+}
+
+// reportDescriptors compiles a commit for the breaking endpoint, writing an error response on failure.
+func (svc *Service) reportDescriptors(w http.ResponseWriter, r *http.Request, mod *registry.Module, commitID string) (linker.Files, bool) {
+	compiled, err := svc.commitDescriptors(r.Context(), mod, commitID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load commit", "commitID", commitID, "error", err)
+		http.Error(w, "failed to load commit", http.StatusInternalServerError)
+		return nil, false
+	}
+	if compiled == nil {
+		http.Error(w, fmt.Sprintf("commit %s can't be compiled: it has dependencies on other registries or doesn't compile", commitID), http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	return compiled, true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/ratelimit"
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+const petV1 = "syntax = \"proto3\";\npackage acme.v1;\nmessage Pet {\n  string name = 1;\n  int32 age = 2;\n}\n"
+
+// petV2 deletes the age field
+const petV2 = "syntax = \"proto3\";\npackage acme.v1;\nmessage Pet {\n  string name = 1;\n}\n"
+
+func uploadPet(ctx context.Context, svc *Service, content string) (*v1.Commit, error) {
+	resp, err := NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+		Contents: []*v1.UploadRequest_Content{
+			{
+				ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: "pets"}}},
+				Files:     []*v1.File{{Path: "acme/v1/pet.proto", Content: []byte(content)}},
+			},
+		},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Msg.Commits[0], nil
+}
+
+func TestUpload_BreakingReject(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.conf.Breaking = &config.Breaking{Modules: map[string]config.BreakingPolicy{
+		"acme/*": {Mode: config.BreakingReject},
+	}}
+
+	ctx := contextWithUser(context.Background(), "alice")
+	createTestModule(t, svc, "acme", "pets", []registry.File{{Path: "acme/v1/pet.proto", Content: petV1}}, []string{"main"})
+
+	_, err := uploadPet(ctx, svc, petV2)
+	if connect.CodeOf(err) != connect.CodeFailedPrecondition || !strings.Contains(err.Error(), `field 2 "age" was deleted from message "acme.v1.Pet" (FIELD_NO_DELETE)`) {
+		t.Fatalf("expected FailedPrecondition listing the deleted field, got %v", err)
+	}
+
+	// Additions are not breaking
+	if _, err := uploadPet(ctx, svc, strings.Replace(petV1, "}\n", "  string owner = 3;\n}\n", 1)); err != nil {
+		t.Fatalf("Upload of compatible change failed: %v", err)
+	}
+}
+
+func TestUpload_BreakingWarn(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.conf.Breaking = &config.Breaking{Default: config.BreakingPolicy{Mode: config.BreakingWarn}}
+	svc.authorizer = allowAllAuthorizer{}
+	svc.authenticator = &tokenAuthenticator{svc: svc}
+
+	ctx := contextWithUser(context.Background(), "alice")
+	prev := createTestModule(t, svc, "acme", "pets", []registry.File{{Path: "acme/v1/pet.proto", Content: petV1}}, []string{"main"})
+
+	commit, err := uploadPet(ctx, svc, petV2)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	stored, err := svc.casReg.CommitByID(ctx, commit.Id)
+	if err != nil {
+		t.Fatalf("CommitByID failed: %v", err)
+	}
+	if len(stored.Findings) != 1 || stored.Findings[0].Rule != "FIELD_NO_DELETE" || stored.Findings[0].Against != prev.ID {
+		t.Fatalf("expected the deleted field to be recorded against %s, got %+v", prev.ID, stored.Findings)
+	}
+
+	srv := httptest.NewServer(svc.breakingHandler())
+	defer srv.Close()
+	get := func(query string) (int, breakingReport) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"?"+query, nil)
+		req.Header.Set("Authorization", "Bearer testtoken")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var report breakingReport
+		if resp.StatusCode == http.StatusOK {
+			json.NewDecoder(resp.Body).Decode(&report)
+		}
+		return resp.StatusCode, report
+	}
+
+	// Recorded findings
+	status, report := get("commit=" + commit.Id)
+	if status != http.StatusOK || len(report.Findings) != 1 || report.Findings[0].Against != prev.ID {
+		t.Errorf("recorded report = %d %+v", status, report)
+	}
+
+	// Computed between any two commits, with the requested rules
+	status, report = get("commit=" + commit.Id + "&against=" + prev.ID + "&rules=WIRE")
+	if status != http.StatusOK || report.Rules != "WIRE" || len(report.Findings) != 1 || report.Findings[0].Rule != "FIELD_NO_DELETE_UNLESS_NUMBER_RESERVED" {
+		t.Errorf("computed report = %d %+v", status, report)
+	}
+	status, report = get("commit=" + prev.ID + "&against=" + commit.Id)
+	if status != http.StatusOK || len(report.Findings) != 0 {
+		t.Errorf("reverse report = %d %+v", status, report)
+	}
+
+	if status, _ := get("commit=unknown"); status != http.StatusNotFound {
+		t.Errorf("unknown commit: status = %d, want 404", status)
+	}
+	if status, _ := get("commit=" + commit.Id + "&against=" + prev.ID + "&rules=STRICT"); status != http.StatusBadRequest {
+		t.Errorf("unknown rules: status = %d, want 400", status)
+	}
+}
+
+func TestBreakingHandler_RateLimited(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.authorizer = allowAllAuthorizer{}
+	svc.authenticator = &tokenAuthenticator{svc: svc}
+
+	limiter, err := ratelimit.New(&config.RateLimit{
+		Procedures: map[string]config.Limit{BreakingPath: {Rate: 0.01, Burst: 1}},
+	})
+	if err != nil {
+		t.Fatalf("ratelimit.New failed: %v", err)
+	}
+	srv := httptest.NewServer(svc.rateLimitHandler(limiter, BreakingPath, svc.breakingHandler()))
+	defer srv.Close()
+
+	get := func() *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"?commit=missing", nil)
+		req.Header.Set("Authorization", "Bearer testtoken")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := get(); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 within the limit, got %d", resp.StatusCode)
+	}
+	resp := get()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the limit, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+}
@@ -290,30 +290,29 @@ func TestListCommits_NoCASConfigured(t *testing.T) {
 	}
 }
 
-func TestListCommits_InvalidResourceRef(t *testing.T) {
+func TestListCommits_ByID(t *testing.T) {
 	svc, cleanup := setupTestService(t)
 	defer cleanup()
 
-	ctx := context.Background()
-	req := connect.NewRequest(&v1beta1.ListCommitsRequest{
-		ResourceRef: &v1beta1.ResourceRef{
-			Value: &v1beta1.ResourceRef_Id{
-				Id: "someid",
-			},
-		},
-	})
+	files := []registry.File{{Path: "test.proto", Content: "syntax = \"proto3\";"}}
+	commit := createTestModule(t, svc, "testowner", "testmodule", files, []string{"main"})
 
-	_, err := svc.ListCommits(ctx, req)
-	if err == nil {
-		t.Fatal("expected error for ResourceRef_Id")
+	ctx := context.Background()
+	resp, err := svc.ListCommits(ctx, connect.NewRequest(&v1beta1.ListCommitsRequest{
+		ResourceRef: &v1beta1.ResourceRef{Value: &v1beta1.ResourceRef_Id{Id: commit.ID}},
+	}))
+	if err != nil {
+		t.Fatalf("ListCommits failed: %v", err)
 	}
-
-	connectErr, ok := err.(*connect.Error)
-	if !ok {
-		t.Fatalf("expected connect error, got %T", err)
+	if len(resp.Msg.Commits) != 1 || resp.Msg.Commits[0].Id != commit.ID {
+		t.Errorf("unexpected commits: %v", resp.Msg.Commits)
 	}
-	if connectErr.Code() != connect.CodeInvalidArgument {
-		t.Errorf("expected CodeInvalidArgument, got %v", connectErr.Code())
+
+	_, err = svc.ListCommits(ctx, connect.NewRequest(&v1beta1.ListCommitsRequest{
+		ResourceRef: &v1beta1.ResourceRef{Value: &v1beta1.ResourceRef_Id{Id: "someid"}},
+	}))
+	if connect.CodeOf(err) != connect.CodeNotFound {
+		t.Errorf("expected CodeNotFound, got %v", err)
 	}
 }
 
@@ -395,7 +394,7 @@ func TestListCommits_WithPagination(t *testing.T) {
 		files := []registry.File{
 			{Path: "test.proto", Content: "syntax = \"proto3\";\npackage test" + string(rune('a'+i)) + ";"},
 		}
-		_, err := mod.CreateCommit(ctx, files, []string{"main"}, "", nil, nil)
+		_, err := mod.CreateCommit(ctx, files, []string{"main"}, "", "", nil)
 		if err != nil {
 			t.Fatalf("failed to create commit: %v", err)
 		}
@@ -3,14 +3,10 @@ package service
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log/slog"
 
 	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
 	"connectrpc.com/connect"
-	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/codes"
-	"go.opentelemetry.io/otel/trace"
 )
 
 // CommitServiceV1 implements the v1 CommitService interface by wrapping Service.
@@ -37,26 +33,11 @@ func (c *CommitServiceV1) GetCommits(ctx context.Context, req *connect.Request[v
 	resp.Msg = &v1.GetCommitsResponse{}
 
 	for _, ref := range req.Msg.ResourceRefs {
-		var commit *v1.Commit
-		var err error
-
-		switch r := ref.Value.(type) {
-		case *v1.ResourceRef_Id:
-			commit, err = c.getCommitByIDV1(ctx, r.Id)
-		case *v1.ResourceRef_Name_:
-			labelOrRef := ""
-			switch child := r.Name.Child.(type) {
-			case *v1.ResourceRef_Name_LabelName:
-				labelOrRef = child.LabelName
-			case *v1.ResourceRef_Name_Ref:
-				labelOrRef = child.Ref
-			}
-			commit, err = c.getCommitV1(ctx, r.Name.Owner, r.Name.Module, labelOrRef)
-		}
-
+		_, cmt, err := c.svc.resolveCommit(ctx, ref)
 		if err != nil {
 			return nil, err
 		}
+		commit := getCommitObjectV1(cmt)
 
 		resp.Msg.Commits = append(resp.Msg.Commits, commit)
 	}
@@ -64,61 +45,6 @@ func (c *CommitServiceV1) GetCommits(ctx context.Context, req *connect.Request[v
 	return resp, nil
 }
 
-func (c *CommitServiceV1) getCommitByIDV1(ctx context.Context, commitID string) (*v1.Commit, error) {
-	ctx, span := tracer.Start(ctx, "service.getCommitByIDV1", trace.WithAttributes(
-		attribute.String("commitID", commitID),
-	))
-	defer span.End()
-	slog.DebugContext(ctx, "CommitServiceV1.getCommitByIDV1", "commitID", commitID)
-
-	mod, err := c.svc.casReg.ModuleByCommitID(ctx, commitID)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "failed to get module by commit ID")
-		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("commit not found: %s", commitID))
-	}
-
-	commit, err := mod.CommitByID(ctx, commitID)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "failed to get commit by ID")
-		return nil, connect.NewError(connect.CodeInternal, err)
-	}
-
-	return getCommitObjectV1(commit), nil
-}
-
-func (c *CommitServiceV1) getCommitV1(ctx context.Context, owner, modl, ref string) (*v1.Commit, error) {
-	ctx, span := tracer.Start(ctx, "service.getCommitV1", trace.WithAttributes(
-		attribute.String("owner", owner),
-		attribute.String("module", modl),
-		attribute.String("ref", ref),
-	))
-	defer span.End()
-	slog.DebugContext(ctx, "CommitServiceV1.getCommitV1", "owner", owner, "module", modl, "ref", ref)
-
-	mod, err := c.svc.casReg.Module(ctx, owner, modl)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "failed to get module")
-		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("module not found: %s/%s", owner, modl))
-	}
-
-	// If no ref specified, default to main
-	if ref == "" {
-		ref = "main"
-	}
-
-	commit, err := mod.Commit(ctx, ref)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "failed to get commit")
-		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("commit not found for ref: %s", ref))
-	}
-
-	return getCommitObjectV1(commit), nil
-}
-
 // ListCommits lists commits for a given module, label, or commit.
 // This v1 endpoint returns commits with B5 digests (instead of B4 in v1beta1).
 func (c *CommitServiceV1) ListCommits(ctx context.Context, req *connect.Request[v1.ListCommitsRequest]) (*connect.Response[v1.ListCommitsResponse], error) {
@@ -128,20 +54,11 @@ func (c *CommitServiceV1) ListCommits(ctx context.Context, req *connect.Request[
 
 	slog.DebugContext(ctx, "ListCommitsV1", "resourceRef", req.Msg.ResourceRef)
 
-	// Parse resource ref to get module
-	var owner, modl string
-	switch ref := req.Msg.ResourceRef.Value.(type) {
-	case *v1.ResourceRef_Name_:
-		owner = ref.Name.Owner
-		modl = ref.Name.Module
-	default:
-		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("only ResourceRef_Name is supported for ListCommits"))
-	}
-
-	mod, err := c.svc.casReg.Module(ctx, owner, modl)
+	res, err := c.svc.resolveResourceRef(ctx, req.Msg.ResourceRef)
 	if err != nil {
-		return nil, connect.NewError(connect.CodeNotFound, err)
+		return nil, err
 	}
+	mod := res.Module
 
 	// Get page size
 	pageSize := int(req.Msg.PageSize)
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"github.com/bufbuild/protocompile/linker"
+	"github.com/greatliontech/pbr/internal/compiler"
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// compileCheck is the check of findings recorded for uploads that weren't compiled.
+const compileCheck = "compile"
+
+// CompileWarningHeader is the response header of upload RPCs carrying a warning for each module
+// accepted without being compiled, as "owner/module: message".
+const CompileWarningHeader = "Pbr-Compile-Warning"
+
+// compileModule compiles the files of an uploaded module against its dependency closure
+// and returns the descriptors of its proto files. Modules that don't compile are rejected
+// with InvalidArgument. Modules depending on commits of other registries, whose files are
+// not stored here, can't be compiled: it reports false for them, and they are handled by
+// checkUncompiled. Dependencies may be commits of the same upload that are not recorded
+// yet, given in pending by ID.
+func (svc *Service) compileModule(ctx context.Context, mod *registry.Module, files []registry.File, deps []storage.DepRecord, pending map[string]*registry.PendingCommit) (linker.Files, bool, error) {
+	roots := make([]depCommit, 0, len(deps))
+	for _, dep := range deps {
+		if !svc.isLocalRegistry(dep.Registry) {
+			return nil, false, nil
+		}
+		roots = append(roots, depCommit{moduleID: dep.ModuleID, commitID: dep.CommitID})
+	}
+	depFiles, ok, err := svc.depClosureFiles(ctx, roots, pending)
+	if err != nil {
+		return nil, false, connect.NewError(connect.CodeInternal, fmt.Errorf("failed to load dependencies: %w", err))
+	}
+	if !ok {
+		return nil, false, nil
+	}
+
+	compiled, err := compiler.Compile(ctx, files, depFiles)
+	var compileErr *compiler.Error
+	if errors.As(err, &compileErr) {
+		return nil, false, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%s/%s does not compile:\n%w", mod.Owner(), mod.Name(), compileErr))
+	}
+	if err != nil {
+		return nil, false, connect.NewError(connect.CodeInternal, fmt.Errorf("failed to compile %s/%s: %w", mod.Owner(), mod.Name(), err))
+	}
+	return compiled, true, nil
+}
+
+// checkUncompiled decides on the upload of a module that can't be compiled because it depends
+// on commits of other registries. It is rejected with FailedPrecondition in the reject mode of
+// remote dependencies, and whenever a breaking change policy in reject mode or lint error rules
+// apply to it, since they can't be checked. Otherwise the returned finding records on the new
+// commit that it was accepted unchecked.
+func (svc *Service) checkUncompiled(ctx context.Context, mod *registry.Module) (storage.Finding, error) {
+	name := mod.Owner() + "/" + mod.Name()
+	var reason string
+	switch {
+	case svc.conf.Compile.GetRemoteDeps() == config.RemoteDepsReject:
+		reason = "uploads with such dependencies are not accepted"
+	case svc.conf.Breaking.PolicyFor(name).Mode == config.BreakingReject:
+		reason = "its breaking change policy rejects uploads that can't be checked"
+	case len(svc.conf.Lint.PolicyFor(mod.Owner()).Error) > 0:
+		reason = "its lint policy has error rules, which can't be checked"
+	}
+	if reason != "" {
+		return storage.Finding{}, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("%s depends on commits of other registries and can't be compiled: %s", name, reason))
+	}
+	slog.InfoContext(ctx, "accepting upload without compiling it", "owner", mod.Owner(), "module", mod.Name())
+	return storage.Finding{
+		Check:   compileCheck,
+		Message: "not compiled, linted or checked for breaking changes: it depends on commits of other registries",
+	}, nil
+}
+
+// setCompileWarnings adds the warnings recorded on the commits of an upload that weren't
+// compiled to header. Commits are in the order of contents.
+func setCompileWarnings(header http.Header, contents []moduleContent, commits []*registry.Commit) {
+	for i, commit := range commits {
+		for _, f := range commit.Findings {
+			if f.Check == compileCheck {
+				header.Add(CompileWarningHeader, fmt.Sprintf("%s/%s: %s", contents[i].owner, contents[i].module, f.Message))
+			}
+		}
+	}
+}
+
+// commitDescriptors compiles a stored commit against its dependency closure. It returns
+// no descriptors for commits that depend on other registries or don't compile, such as
+// commits pushed before uploads were compiled.
+func (svc *Service) commitDescriptors(ctx context.Context, mod *registry.Module, commitID string) (linker.Files, error) {
+	files, _, err := mod.FilesAndCommitByCommitID(ctx, commitID)
+	if err != nil {
+		return nil, err
+	}
+	deps, err := svc.getStoredDeps(ctx, mod.Owner(), mod.Name(), commitID)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]depCommit, 0, len(deps))
+	for _, dep := range deps {
+		if dep.Owner == "" {
+			return nil, nil
+		}
+		roots = append(roots, depCommit{moduleID: dep.ModuleID, commitID: dep.Commit})
+	}
+	depFiles, ok, err := svc.depClosureFiles(ctx, roots, nil)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	compiled, err := compiler.Compile(ctx, files, depFiles)
+	if err != nil {
+		slog.DebugContext(ctx, "stored commit does not compile", "commitID", commitID, "error", err)
+		return nil, nil
+	}
+	return compiled, nil
+}
+
+// depCommit is a dependency commit of this registry.
+type depCommit struct {
+	moduleID string
+	commitID string
+}
+
+// depClosureFiles returns the files of the root commits and of their transitive dependencies,
+// taking the commits in pending from there rather than from storage. It reports false if the
+// closure includes commits of other registries.
+func (svc *Service) depClosureFiles(ctx context.Context, roots []depCommit, pending map[string]*registry.PendingCommit) ([]registry.File, bool, error) {
+	queue := roots
+	var files []registry.File
+	seen := make(map[string]bool)
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if seen[next.commitID] {
+			continue
+		}
+		seen[next.commitID] = true
+
+		if p, ok := pending[next.commitID]; ok {
+			files = append(files, p.Files()...)
+			for _, dep := range p.Commit().Deps {
+				if !svc.isLocalRegistry(dep.Registry) {
+					return nil, false, nil
+				}
+				queue = append(queue, depCommit{moduleID: dep.ModuleID, commitID: dep.CommitID})
+			}
+			continue
+		}
+
+		mod, err := svc.casReg.ModuleByID(ctx, next.moduleID)
+		if err != nil {
+			return nil, false, fmt.Errorf("module of commit %s: %w", next.commitID, err)
+		}
+		commitFiles, _, err := mod.FilesAndCommitByCommitID(ctx, next.commitID)
+		if err != nil {
+			return nil, false, fmt.Errorf("commit %s: %w", next.commitID, err)
+		}
+		files = append(files, commitFiles...)
+
+		transitive, err := svc.getStoredDeps(ctx, mod.Owner(), mod.Name(), next.commitID)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, dep := range transitive {
+			if dep.Owner == "" {
+				return nil, false, nil
+			}
+			queue = append(queue, depCommit{moduleID: dep.ModuleID, commitID: dep.Commit})
+		}
+	}
+	return files, true, nil
+}
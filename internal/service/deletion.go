@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// DeletedPath is the admin endpoint listing deleted modules, and restoring them.
+const DeletedPath = "/admin/deleted"
+
+// DeleteWarningHeader is the response header of DeleteModules carrying a warning for each
+// deleted module that commits of other modules still depend on.
+const DeleteWarningHeader = "Pbr-Delete-Warning"
+
+// purgeInterval is how often deleted modules past their grace period, and expired
+// redirects of renamed modules, are purged.
+const purgeInterval = time.Hour
+
+// deletedModule is a deleted module, as served by the deleted modules endpoint.
+type deletedModule struct {
+	Module     string    `json:"module"`
+	DeleteTime time.Time `json:"delete_time"`
+	PurgeTime  time.Time `json:"purge_time"`
+}
+
+// restoredModule is the response of a restore by the deleted modules endpoint.
+type restoredModule struct {
+	ID     string `json:"id"`
+	Module string `json:"module"`
+}
+
+// checkDependents checks the modules about to be deleted for modules depending on them,
+// other than the deleted modules themselves. Depending on the configuration, dependents
+// fail the deletion with FailedPrecondition, or are reported in the response header.
+func (svc *Service) checkDependents(ctx context.Context, header http.Header, mods []*registry.Module) error {
+	mode := svc.conf.Deletion.GetDependents()
+	if mode == config.DependentsOff {
+		return nil
+	}
+	deleting := make(map[string]bool, len(mods))
+	for _, mod := range mods {
+		deleting[mod.ID()] = true
+	}
+
+	var problems []string
+	for _, mod := range mods {
+		dependents, err := svc.casReg.ModuleDependents(ctx, mod)
+		if err != nil {
+			return connect.NewError(connect.CodeInternal, fmt.Errorf("failed to check dependents of %s/%s: %w", mod.Owner(), mod.Name(), err))
+		}
+		var names []string
+		for _, dep := range dependents {
+			if !deleting[dep.ID()] {
+				names = append(names, dep.Owner()+"/"+dep.Name())
+			}
+		}
+		if len(names) > 0 {
+			problems = append(problems, fmt.Sprintf("%s/%s is depended on by %s", mod.Owner(), mod.Name(), strings.Join(names, ", ")))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if mode == config.DependentsBlock {
+		return connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("modules have dependents:\n%s", strings.Join(problems, "\n")))
+	}
+	for _, p := range problems {
+		header.Add(DeleteWarningHeader, p)
+	}
+	return nil
+}
+
+// deletedModules lists the deleted modules along with when they will be purged.
+func (svc *Service) deletedModules(ctx context.Context) ([]deletedModule, error) {
+	mods, err := svc.casReg.ListDeletedModules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	grace := svc.conf.Deletion.GetGracePeriod()
+	deleted := make([]deletedModule, 0, len(mods))
+	for _, mod := range mods {
+		deleted = append(deleted, deletedModule{
+			Module:     mod.Owner() + "/" + mod.Name(),
+			DeleteTime: mod.DeleteTime(),
+			PurgeTime:  mod.DeleteTime().Add(grace),
+		})
+	}
+	return deleted, nil
+}
+
+// runPurge purges the deleted modules past their grace period and the expired redirects,
+// every purgeInterval, until ctx is done.
+func (svc *Service) runPurge(ctx context.Context) {
+	for {
+		now := time.Now()
+		purged, err := svc.casReg.PurgeDeletedModules(ctx, now.Add(-svc.conf.Deletion.GetGracePeriod()))
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to purge deleted modules", "error", err)
+		}
+		for _, mod := range purged {
+			slog.InfoContext(ctx, "deleted module purged", "owner", mod.Owner(), "module", mod.Name(), "deleteTime", mod.DeleteTime())
+		}
+		if n, err := svc.casReg.PurgeExpiredRedirects(ctx, now); err != nil {
+			slog.ErrorContext(ctx, "failed to purge expired redirects", "error", err)
+		} else if n > 0 {
+			slog.InfoContext(ctx, "expired redirects purged", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(purgeInterval):
+		}
+	}
+}
+
+// deletedHandler serves the deleted modules endpoint for the admin user. GET lists the
+// deleted modules, POST restores the one named by the module query parameter ("owner/name").
+func (svc *Service) deletedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if svc.casReg == nil {
+			http.Error(w, "CAS storage not configured", http.StatusNotImplemented)
+			return
+		}
+		if !svc.requireAdmin(w, r) {
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			deleted, err := svc.deletedModules(r.Context())
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to list deleted modules", "error", err)
+				http.Error(w, "failed to list deleted modules", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, deleted)
+			return
+		}
+
+		owner, name, ok := parseModuleName(r.URL.Query().Get("module"))
+		if !ok {
+			http.Error(w, "module must be given as owner/name", http.StatusBadRequest)
+			return
+		}
+		mod, err := svc.casReg.RestoreModule(r.Context(), owner, name)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "no deleted module "+owner+"/"+name, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to restore module", "owner", owner, "module", name, "error", err)
+			http.Error(w, "failed to restore module", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "module restored", "owner", owner, "module", name)
+		writeJSON(w, restoredModule{ID: mod.ID(), Module: mod.Owner() + "/" + mod.Name()})
+	})
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	v1beta1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1beta1"
+	ownerv1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/owner/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+func TestDeleteModules_SoftDeleteAndRestore(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.tokens["admintoken"] = &tokenInfo{Username: "admin"}
+	svc.authenticator = &tokenAuthenticator{svc: svc}
+
+	pets := createTestModule(t, svc, "acme", "pets", []registry.File{{Path: "pets.proto", Content: "// pets"}}, []string{"main"})
+	app := createTestModuleWithDeps(t, svc, "acme", "app", []registry.File{{Path: "app.proto", Content: "// app"}}, []string{"main"}, []string{pets.ID})
+
+	ctx := contextWithUser(context.Background(), "alice")
+	modules := NewModuleService(svc)
+	deleteModules := func(names ...string) (*connect.Response[v1.DeleteModulesResponse], error) {
+		var refs []*v1.ModuleRef
+		for _, name := range names {
+			refs = append(refs, &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: name}}})
+		}
+		return modules.DeleteModules(ctx, connect.NewRequest(&v1.DeleteModulesRequest{ModuleRefs: refs}))
+	}
+
+	// Dependents block the deletion by default
+	_, err := deleteModules("pets")
+	if connect.CodeOf(err) != connect.CodeFailedPrecondition || !strings.Contains(err.Error(), "acme/pets is depended on by acme/app") {
+		t.Fatalf("expected FailedPrecondition naming the dependent, got %v", err)
+	}
+	if _, err := svc.casReg.Module(ctx, "acme", "pets"); err != nil {
+		t.Fatalf("blocked deletion deleted the module: %v", err)
+	}
+
+	svc.conf.Deletion = &config.Deletion{Dependents: config.DependentsWarn}
+	resp, err := deleteModules("pets")
+	if err != nil {
+		t.Fatalf("DeleteModules failed: %v", err)
+	}
+	if got := resp.Header().Values(DeleteWarningHeader); len(got) != 1 || got[0] != "acme/pets is depended on by acme/app" {
+		t.Errorf("delete warnings = %q", got)
+	}
+	if _, err := svc.casReg.Module(ctx, "acme", "pets"); err == nil {
+		t.Fatal("deleted module is still found")
+	}
+
+	// Dependents' graphs don't silently drop the deleted dependency
+	getGraph := func() error {
+		_, err := svc.GetGraph(ctx, connect.NewRequest(&v1beta1.GetGraphRequest{
+			ResourceRefs: []*v1beta1.GetGraphRequest_ResourceRef{
+				{ResourceRef: &v1beta1.ResourceRef{Value: &v1beta1.ResourceRef_Id{Id: app.ID}}},
+			},
+		}))
+		return err
+	}
+	if err := getGraph(); connect.CodeOf(err) != connect.CodeFailedPrecondition || !strings.Contains(err.Error(), "deleted module acme/pets") {
+		t.Errorf("GetGraph with a deleted dependency: expected FailedPrecondition, got %v", err)
+	}
+
+	// The name stays reserved while the module can be restored
+	_, err = modules.CreateModules(ctx, connect.NewRequest(&v1.CreateModulesRequest{
+		Values: []*v1.CreateModulesRequest_Value{{
+			OwnerRef: &ownerv1.OwnerRef{Value: &ownerv1.OwnerRef_Name{Name: "acme"}},
+			Name:     "pets",
+		}},
+	}))
+	if connect.CodeOf(err) != connect.CodeFailedPrecondition {
+		t.Errorf("CreateModules of a deleted module: expected FailedPrecondition, got %v", err)
+	}
+
+	srv := httptest.NewServer(svc.deletedHandler())
+	defer srv.Close()
+	do := func(method, token, query string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+DeletedPath+query, nil)
+		req.Header.Set(authenticationHeader, "Bearer "+token)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp
+	}
+
+	r := do(http.MethodGet, "testtoken", "")
+	r.Body.Close()
+	if r.StatusCode != http.StatusForbidden {
+		t.Errorf("non-admin status = %d, want 403", r.StatusCode)
+	}
+
+	r = do(http.MethodGet, "admintoken", "")
+	var deleted []deletedModule
+	err = json.NewDecoder(r.Body).Decode(&deleted)
+	r.Body.Close()
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0].Module != "acme/pets" || !deleted[0].PurgeTime.Equal(deleted[0].DeleteTime.Add(config.DefaultDeletionGracePeriod)) {
+		t.Fatalf("unexpected deleted modules %+v", deleted)
+	}
+
+	r = do(http.MethodPost, "admintoken", "?module=acme/app")
+	r.Body.Close()
+	if r.StatusCode != http.StatusNotFound {
+		t.Errorf("restoring a module not deleted: status = %d, want 404", r.StatusCode)
+	}
+	r = do(http.MethodPost, "admintoken", "?module=acme/pets")
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("restore status = %d, want 200", r.StatusCode)
+	}
+	if _, err := svc.casReg.Module(ctx, "acme", "pets"); err != nil {
+		t.Fatalf("restored module not found: %v", err)
+	}
+	if err := getGraph(); err != nil {
+		t.Errorf("GetGraph after restore failed: %v", err)
+	}
+
+	// Modules deleted together don't block each other
+	svc.conf.Deletion = nil
+	if _, err := deleteModules("pets", "app"); err != nil {
+		t.Fatalf("DeleteModules of a module with its dependents failed: %v", err)
+	}
+}
+
+func TestGetGraph_PurgedDependency(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	pets := createTestModule(t, svc, "acme", "pets", []registry.File{{Path: "pets.proto", Content: "// pets"}}, []string{"main"})
+	app := createTestModuleWithDeps(t, svc, "acme", "app", []registry.File{{Path: "app.proto", Content: "// app"}}, []string{"main"}, []string{pets.ID})
+
+	ctx := contextWithUser(context.Background(), "alice")
+	if err := svc.casReg.DeleteModule(ctx, "acme", "pets"); err != nil {
+		t.Fatalf("DeleteModule failed: %v", err)
+	}
+
+	// The missing dependency is reported rather than dropped from the graph
+	_, err := svc.GetGraph(ctx, connect.NewRequest(&v1beta1.GetGraphRequest{
+		ResourceRefs: []*v1beta1.GetGraphRequest_ResourceRef{
+			{ResourceRef: &v1beta1.ResourceRef{Value: &v1beta1.ResourceRef_Id{Id: app.ID}}},
+		},
+	}))
+	if connect.CodeOf(err) != connect.CodeNotFound || !strings.Contains(err.Error(), pets.ID) {
+		t.Errorf("GetGraph with a purged dependency: expected NotFound naming %s, got %v", pets.ID, err)
+	}
+}
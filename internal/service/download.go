@@ -21,14 +21,11 @@ func (svc *Service) Download(ctx context.Context, req *connect.Request[v1beta1.D
 	}
 
 	for _, ref := range req.Msg.Values {
-		var commitId string
-
-		switch ref := ref.ResourceRef.Value.(type) {
-		case *v1beta1.ResourceRef_Id:
-			commitId = ref.Id
-		case *v1beta1.ResourceRef_Name_:
-			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("ResourceRef_Name_ not supported"))
+		_, cmt, err := svc.resolveCommitV1beta1(ctx, ref.ResourceRef)
+		if err != nil {
+			return nil, err
 		}
+		commitId := cmt.ID
 
 		slog.DebugContext(ctx, "download commit", "commitId", commitId)
 
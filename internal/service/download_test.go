@@ -223,10 +223,13 @@ func TestDownload_MultipleModules(t *testing.T) {
 	}
 }
 
-func TestDownload_ResourceRefNameNotSupported(t *testing.T) {
+func TestDownload_ByName(t *testing.T) {
 	svc, cleanup := setupTestService(t)
 	defer cleanup()
 
+	files := []registry.File{{Path: "test.proto", Content: "syntax = \"proto3\";"}}
+	commit := createTestModule(t, svc, "testowner", "testmodule", files, []string{"main"})
+
 	ctx := context.Background()
 	req := connect.NewRequest(&v1beta1.DownloadRequest{
 		Values: []*v1beta1.DownloadRequest_Value{
@@ -243,17 +246,19 @@ func TestDownload_ResourceRefNameNotSupported(t *testing.T) {
 		},
 	})
 
-	_, err := svc.Download(ctx, req)
-	if err == nil {
-		t.Fatal("expected error for ResourceRef_Name")
+	resp, err := svc.Download(ctx, req)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
 	}
-
-	connectErr, ok := err.(*connect.Error)
-	if !ok {
-		t.Fatalf("expected connect error, got %T", err)
+	if len(resp.Msg.Contents) != 1 || resp.Msg.Contents[0].Commit.Id != commit.ID {
+		t.Fatalf("expected content for commit %s, got %v", commit.ID, resp.Msg.Contents)
 	}
-	if connectErr.Code() != connect.CodeInvalidArgument {
-		t.Errorf("expected CodeInvalidArgument, got %v", connectErr.Code())
+
+	// Unknown modules are not found
+	req.Msg.Values[0].ResourceRef.GetName().Module = "nope"
+	_, err = svc.Download(ctx, req)
+	if connect.CodeOf(err) != connect.CodeNotFound {
+		t.Errorf("expected CodeNotFound, got %v", err)
 	}
 }
 
@@ -33,57 +33,14 @@ func (d *DownloadServiceV1) Download(ctx context.Context, req *connect.Request[v
 	}
 
 	for _, value := range req.Msg.Values {
-		var commitId string
-		var owner, modName string
-
-		ref := value.ResourceRef
-		if ref == nil {
+		if value.ResourceRef == nil {
 			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("resource_ref is required"))
 		}
-
-		switch r := ref.Value.(type) {
-		case *v1.ResourceRef_Id:
-			commitId = r.Id
-		case *v1.ResourceRef_Name_:
-			if r.Name == nil {
-				return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("name is required"))
-			}
-			owner = r.Name.Owner
-			modName = r.Name.Module
-
-			mod, err := d.svc.casReg.Module(ctx, owner, modName)
-			if err != nil {
-				return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("module not found: %s/%s", owner, modName))
-			}
-
-			var cmt *registry.Commit
-			switch child := r.Name.Child.(type) {
-			case *v1.ResourceRef_Name_LabelName:
-				cmt, err = mod.Commit(ctx, child.LabelName)
-				if err != nil {
-					return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("label not found: %s", child.LabelName))
-				}
-			case *v1.ResourceRef_Name_Ref:
-				// Ref could be a commit ID or a label name - try commit ID first
-				cmt, err = mod.CommitByID(ctx, child.Ref)
-				if err != nil {
-					// Try as a label
-					cmt, err = mod.Commit(ctx, child.Ref)
-					if err != nil {
-						return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("ref not found: %s", child.Ref))
-					}
-				}
-			default:
-				// No child specified - use default label (main)
-				cmt, err = mod.Commit(ctx, "main")
-				if err != nil {
-					return nil, connect.NewError(connect.CodeNotFound, errors.New("default label 'main' not found"))
-				}
-			}
-			commitId = cmt.ID
-		default:
-			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("unsupported resource_ref type"))
+		_, cmt, err := d.svc.resolveCommit(ctx, value.ResourceRef)
+		if err != nil {
+			return nil, err
 		}
+		commitId := cmt.ID
 
 		slog.DebugContext(ctx, "download commit v1", "commitId", commitId)
 
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -10,7 +11,7 @@ import (
 	v1beta1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1beta1"
 	"connectrpc.com/connect"
 	"github.com/greatliontech/pbr/internal/registry"
-	"github.com/greatliontech/pbr/internal/util"
+	"github.com/greatliontech/pbr/internal/storage"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -43,38 +44,20 @@ func (svc *Service) GetGraph(ctx context.Context, req *connect.Request[v1beta1.G
 	modules := []moduleEntry{}
 
 	for _, ref := range req.Msg.ResourceRefs {
-		switch r := ref.ResourceRef.Value.(type) {
-		case *v1beta1.ResourceRef_Id:
-			info, commit, err := svc.getModuleAndCommitByID(ctx, r.Id)
-			if err != nil {
-				slog.ErrorContext(ctx, "getModuleAndCommitByID", "err", err)
-				return nil, err
-			}
-			modules = append(modules, moduleEntry{info: info, commit: commit})
-			// Use module name as key for deduplication
-			key := info.Owner + "/" + info.Name
-			commitMap[key] = commit
-			slog.DebugContext(ctx, "top level dep", "id", commit.Id)
-			resp.Msg.Graph.Commits = append(resp.Msg.Graph.Commits, &v1beta1.Graph_Commit{
-				Commit:   commit,
-				Registry: svc.conf.Host,
-			})
-		case *v1beta1.ResourceRef_Name_:
-			info, commit, err := svc.getModuleAndCommitByName(ctx, r.Name)
-			if err != nil {
-				slog.ErrorContext(ctx, "getModuleAndCommitByName", "err", err)
-				return nil, err
-			}
-			modules = append(modules, moduleEntry{info: info, commit: commit})
-			// Use module name as key for deduplication
-			key := info.Owner + "/" + info.Name
-			commitMap[key] = commit
-			slog.DebugContext(ctx, "top level dep", "id", commit.Id)
-			resp.Msg.Graph.Commits = append(resp.Msg.Graph.Commits, &v1beta1.Graph_Commit{
-				Commit:   commit,
-				Registry: svc.conf.Host,
-			})
+		info, commit, err := svc.getModuleAndCommit(ctx, ref.ResourceRef)
+		if err != nil {
+			slog.ErrorContext(ctx, "getModuleAndCommit", "err", err)
+			return nil, err
 		}
+		modules = append(modules, moduleEntry{info: info, commit: commit})
+		// Use module name as key for deduplication
+		key := info.Owner + "/" + info.Name
+		commitMap[key] = commit
+		slog.DebugContext(ctx, "top level dep", "id", commit.Id)
+		resp.Msg.Graph.Commits = append(resp.Msg.Graph.Commits, &v1beta1.Graph_Commit{
+			Commit:   commit,
+			Registry: svc.conf.Host,
+		})
 	}
 
 	for i, entry := range modules {
@@ -94,15 +77,10 @@ func (svc *Service) GetGraph(ctx context.Context, req *connect.Request[v1beta1.G
 	return resp, nil
 }
 
-func (svc *Service) getModuleAndCommitByID(ctx context.Context, commitID string) (moduleInfo, *v1beta1.Commit, error) {
-	mod, err := svc.casReg.ModuleByCommitID(ctx, commitID)
+func (svc *Service) getModuleAndCommit(ctx context.Context, ref *v1beta1.ResourceRef) (moduleInfo, *v1beta1.Commit, error) {
+	mod, cmt, err := svc.resolveCommitV1beta1(ctx, ref)
 	if err != nil {
-		return moduleInfo{}, nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("commit not found: %s", commitID))
-	}
-
-	cmt, err := mod.CommitByID(ctx, commitID)
-	if err != nil {
-		return moduleInfo{}, nil, connect.NewError(connect.CodeInternal, err)
+		return moduleInfo{}, nil, err
 	}
 
 	commit, err := getCommitObject(cmt.OwnerID, cmt.ModuleID, cmt.ID, cmt.FilesDigest.Hex())
@@ -113,53 +91,6 @@ func (svc *Service) getModuleAndCommitByID(ctx context.Context, commitID string)
 	return moduleInfo{Owner: mod.Owner(), Name: mod.Name()}, commit, nil
 }
 
-func (svc *Service) getModuleAndCommitByName(ctx context.Context, name *v1beta1.ResourceRef_Name) (moduleInfo, *v1beta1.Commit, error) {
-	if name == nil {
-		return moduleInfo{}, nil, connect.NewError(connect.CodeInvalidArgument, errors.New("name is required"))
-	}
-
-	owner := name.Owner
-	modName := name.Module
-
-	mod, err := svc.casReg.Module(ctx, owner, modName)
-	if err != nil {
-		return moduleInfo{}, nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("module not found: %s/%s", owner, modName))
-	}
-
-	// Determine the ref (label name or commit ref)
-	var cmt *registry.Commit
-	switch child := name.Child.(type) {
-	case *v1beta1.ResourceRef_Name_LabelName:
-		cmt, err = mod.Commit(ctx, child.LabelName)
-		if err != nil {
-			return moduleInfo{}, nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("label not found: %s", child.LabelName))
-		}
-	case *v1beta1.ResourceRef_Name_Ref:
-		// Ref could be a commit ID or a label name - try commit ID first
-		cmt, err = mod.CommitByID(ctx, child.Ref)
-		if err != nil {
-			// Try as a label
-			cmt, err = mod.Commit(ctx, child.Ref)
-			if err != nil {
-				return moduleInfo{}, nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("ref not found: %s", child.Ref))
-			}
-		}
-	default:
-		// No child specified - use default label (main)
-		cmt, err = mod.Commit(ctx, "main")
-		if err != nil {
-			return moduleInfo{}, nil, connect.NewError(connect.CodeNotFound, errors.New("default label 'main' not found"))
-		}
-	}
-
-	commit, err := getCommitObject(cmt.OwnerID, cmt.ModuleID, cmt.ID, cmt.FilesDigest.Hex())
-	if err != nil {
-		return moduleInfo{}, nil, connect.NewError(connect.CodeInternal, err)
-	}
-
-	return moduleInfo{Owner: owner, Name: modName}, commit, nil
-}
-
 func (svc *Service) getGraphForModule(ctx context.Context, info moduleInfo, commit *v1beta1.Commit, commits map[string]*v1beta1.Commit, graph *v1beta1.Graph) error {
 	ctx, span := tracer.Start(ctx, "service.getGraphForModule", trace.WithAttributes(
 		attribute.String("owner", info.Owner),
@@ -185,14 +116,12 @@ func (svc *Service) getGraphForModule(ctx context.Context, info moduleInfo, comm
 		slog.DebugContext(ctx, "dep", "owner", dep.Owner, "repo", dep.Repository, "commit", dep.Commit, "digest", dep.Digest)
 
 		// Use module name as key for deduplication (buf CLI expects one version per module)
-		moduleKey := dep.Owner + "/" + dep.Repository
+		moduleKey := dep.moduleKey()
 
 		// Create the commit object for this specific dependency
-		ownerId := util.OwnerID(dep.Owner)
-		modId := util.ModuleID(ownerId, dep.Repository)
-		depCommit, err := getCommitObject(ownerId, modId, dep.Commit, strings.TrimPrefix(dep.Digest, "shake256:"))
+		depCommit, err := dep.commitObject()
 		if err != nil {
-			return err
+			return connect.NewError(connect.CodeInternal, err)
 		}
 
 		// Check if we've already seen this module
@@ -261,13 +190,46 @@ func (svc *Service) getGraphForModule(ctx context.Context, info moduleInfo, comm
 // bufLockDep represents a dependency (from stored commit metadata or buf.lock)
 type bufLockDep struct {
 	Remote     string
-	Owner      string
-	Repository string
+	Owner      string // empty for dependencies on other registries
+	Repository string // empty for dependencies on other registries
+	OwnerID    string
+	ModuleID   string
 	Commit     string
 	Digest     string
 }
 
-// getStoredDeps retrieves dependencies from the commit's stored DepCommitIDs.
+// moduleKey identifies the module of a dependency, for deduplication across the graph.
+func (d bufLockDep) moduleKey() string {
+	if d.Owner == "" {
+		return d.Remote + "/" + d.ModuleID
+	}
+	return d.Owner + "/" + d.Repository
+}
+
+// commitObject creates the v1beta1 commit of a dependency. Local dependencies carry
+// their B4 digest, but only the B5 digest of commits on other registries is known.
+func (d bufLockDep) commitObject() (*v1beta1.Commit, error) {
+	if digestHex, ok := strings.CutPrefix(d.Digest, "b5:"); ok {
+		digest, err := hex.DecodeString(digestHex)
+		if err != nil {
+			return nil, err
+		}
+		return &v1beta1.Commit{
+			Id:       d.Commit,
+			OwnerId:  d.OwnerID,
+			ModuleId: d.ModuleID,
+			Digest: &v1beta1.Digest{
+				Type:  v1beta1.DigestType_DIGEST_TYPE_B5,
+				Value: digest,
+			},
+		}, nil
+	}
+	return getCommitObject(d.OwnerID, d.ModuleID, d.Commit, strings.TrimPrefix(d.Digest, "shake256:"))
+}
+
+// getStoredDeps retrieves the dependencies recorded on a commit. Dependencies on
+// mirrored registries are pulled and returned as local ones; dependencies on other
+// registries are returned with their registry and B5 digest.
 func (svc *Service) getStoredDeps(ctx context.Context, owner, name, commitID string) ([]bufLockDep, error) {
 	mod, err := svc.casReg.Module(ctx, owner, name)
 	if err != nil {
@@ -279,34 +241,99 @@ func (svc *Service) getStoredDeps(ctx context.Context, owner, name, commitID str
 		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("commit not found: %s", commitID))
 	}
 
-	if len(commit.DepCommitIDs) == 0 {
-		return nil, nil
+	if len(commit.Deps) == 0 {
+		return svc.getBufLockDeps(ctx, mod, commitID)
 	}
 
-	deps := make([]bufLockDep, 0, len(commit.DepCommitIDs))
-	for _, depCommitID := range commit.DepCommitIDs {
-		// Look up the dependency commit to get module info
-		depMod, err := svc.casReg.ModuleByCommitID(ctx, depCommitID)
-		if err != nil {
-			slog.DebugContext(ctx, "dependency commit not found locally", "depCommitID", depCommitID)
+	deps := make([]bufLockDep, 0, len(commit.Deps))
+	for _, dep := range commit.Deps {
+		// Dependencies on mirrored registries are served from the local copy
+		if u := svc.mirror(dep.Registry); u != nil && !svc.isLocalRegistry(dep.Registry) {
+			if _, _, err := svc.pullCommit(ctx, u, dep.CommitID); err != nil {
+				slog.WarnContext(ctx, "failed to mirror dependency", "registry", dep.Registry, "depCommitID", dep.CommitID, "error", err)
+			} else {
+				dep.Registry = ""
+			}
+		}
+
+		if !svc.isLocalRegistry(dep.Registry) {
+			deps = append(deps, bufLockDep{
+				Remote:   dep.Registry,
+				OwnerID:  dep.OwnerID,
+				ModuleID: dep.ModuleID,
+				Commit:   dep.CommitID,
+				Digest:   dep.Digest.String(),
+			})
 			continue
 		}
 
-		depCommit, err := depMod.CommitByID(ctx, depCommitID)
+		local, err := svc.localDep(ctx, dep.CommitID)
 		if err != nil {
-			slog.DebugContext(ctx, "failed to get dependency commit", "depCommitID", depCommitID, "error", err)
-			continue
+			return nil, err
 		}
+		deps = append(deps, local)
+	}
 
-		deps = append(deps, bufLockDep{
-			Remote:     svc.conf.Host,
-			Owner:      depMod.Owner(),
-			Repository: depMod.Name(),
-			Commit:     depCommitID,
-			Digest:     "shake256:" + depCommit.FilesDigest.Hex(),
-		})
+	return deps, nil
+}
+
+// getBufLockDeps returns the dependencies pinned by the buf.lock of a commit that has none recorded.
+// Only dependencies on this registry can be resolved.
+func (svc *Service) getBufLockDeps(ctx context.Context, mod *registry.Module, commitID string) ([]bufLockDep, error) {
+	lock, err := mod.BufLockCommitID(ctx, commitID)
+	if err != nil {
+		return nil, nil
 	}
 
+	var deps []bufLockDep
+	for _, dep := range lock.Deps {
+		if !svc.isLocalRegistry(dep.Remote) {
+			slog.DebugContext(ctx, "skipping buf.lock dependency on other registry", "dep", dep.Name())
+			continue
+		}
+		local, err := svc.localDep(ctx, dep.Commit)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, local)
+	}
 	return deps, nil
 }
 
+// localDep looks up a dependency commit of this registry. Commits that are missing, such as
+// commits of purged modules, fail with NotFound, and commits of deleted modules that are not
+// purged yet with FailedPrecondition, rather than leaving the graphs of their dependents
+// incomplete.
+func (svc *Service) localDep(ctx context.Context, commitID string) (bufLockDep, error) {
+	depMod, err := svc.casReg.ModuleByCommitID(ctx, commitID)
+	if errors.Is(err, storage.ErrNotFound) {
+		deleted, err := svc.casReg.DeletedModuleByCommitID(ctx, commitID)
+		if err == nil {
+			return bufLockDep{}, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("dependency commit %s is in deleted module %s/%s, restore it to use it", commitID, deleted.Owner(), deleted.Name()))
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			return bufLockDep{}, connect.NewError(connect.CodeNotFound, fmt.Errorf("dependency commit %s not found", commitID))
+		}
+	}
+	if err != nil {
+		return bufLockDep{}, connect.NewError(connect.CodeInternal, fmt.Errorf("failed to get module of dependency commit %s: %w", commitID, err))
+	}
+
+	depCommit, err := depMod.CommitByID(ctx, commitID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return bufLockDep{}, connect.NewError(connect.CodeNotFound, fmt.Errorf("dependency commit %s not found", commitID))
+	}
+	if err != nil {
+		return bufLockDep{}, connect.NewError(connect.CodeInternal, fmt.Errorf("failed to get dependency commit %s: %w", commitID, err))
+	}
+
+	return bufLockDep{
+		Remote:     svc.conf.Host,
+		Owner:      depMod.Owner(),
+		Repository: depMod.Name(),
+		OwnerID:    depCommit.OwnerID,
+		ModuleID:   depCommit.ModuleID,
+		Commit:     commitID,
+		Digest:     "shake256:" + depCommit.FilesDigest.Hex(),
+	}, nil
+}
@@ -26,8 +26,13 @@ func setupTestService(t *testing.T) (*Service, func()) {
 	commits, _ := memdocstore.OpenCollection("ID", nil)
 	labels, _ := memdocstore.OpenCollection("ID", nil)
 	metadataStore := storage.NewMetadataStore(owners, modules, commits, labels)
+	files, _ := memdocstore.OpenCollection("ID", nil)
+	usage, _ := memdocstore.OpenCollection("ID", nil)
+	redirects, _ := memdocstore.OpenCollection("ID", nil)
 
-	casReg := registry.New(blobStore, manifestStore, metadataStore, "test.registry.com")
+	casReg := registry.New(blobStore, manifestStore, metadataStore, "test.registry.com",
+		registry.WithFileIndex(storage.NewFileIndex(files)), registry.WithUsage(storage.NewUsageStore(usage)),
+		registry.WithRedirects(storage.NewRedirectStore(redirects)))
 
 	svc := &Service{
 		conf: &config.Config{
@@ -44,6 +49,9 @@ func setupTestService(t *testing.T) (*Service, func()) {
 		modules.Close()
 		commits.Close()
 		labels.Close()
+		files.Close()
+		usage.Close()
+		redirects.Close()
 	}
 
 	return svc, cleanup
@@ -63,13 +71,12 @@ func createTestModuleWithDeps(t *testing.T, svc *Service, owner, name string, fi
 		t.Fatalf("failed to create module: %v", err)
 	}
 
-	// Get module digests for dependencies
-	depDigests, err := svc.casReg.GetDepModuleDigests(ctx, depCommitIDs)
+	deps, err := svc.casReg.LocalDeps(ctx, depCommitIDs)
 	if err != nil {
-		t.Fatalf("failed to get dependency digests: %v", err)
+		t.Fatalf("failed to get dependencies: %v", err)
 	}
 
-	commit, err := mod.CreateCommit(ctx, files, labels, "", depCommitIDs, depDigests)
+	commit, err := mod.CreateCommit(ctx, files, labels, "", "", deps)
 	if err != nil {
 		t.Fatalf("failed to create commit: %v", err)
 	}
@@ -672,9 +679,8 @@ func TestGetGraph_ExternalDependency(t *testing.T) {
 	defer cleanup()
 
 	// Create module with external dependency (different registry)
-	// Note: External dependencies are not tracked via DepCommitIDs since they're
-	// not in our registry. The buf CLI resolves external deps directly from their
-	// registries. Our graph only tracks local dependencies via stored DepCommitIDs.
+	// Note: Dependencies are only taken from those recorded on upload, not from
+	// the buf.lock file, so this dependency does not appear in the graph.
 	mainFiles := []registry.File{
 		{Path: "main.proto", Content: "syntax = \"proto3\";\npackage main;"},
 		{Path: "buf.yaml", Content: "version: v1\nname: buf.build/testowner/mainmodule"},
@@ -706,7 +712,7 @@ deps:
 		t.Fatalf("GetGraph failed: %v", err)
 	}
 
-	// Should have 1 commit (main only - external deps are not tracked via DepCommitIDs)
+	// Should have 1 commit (main only - the buf.lock dependency was not recorded on upload)
 	if len(resp.Msg.Graph.Commits) != 1 {
 		t.Errorf("expected 1 commit, got %d", len(resp.Msg.Graph.Commits))
 	}
@@ -1496,3 +1502,35 @@ func TestGetGraph_TransitiveDependencyVersionConflict(t *testing.T) {
 		t.Error("base@v2 (newer) should be in commits")
 	}
 }
+
+func TestGetGraph_BufLockDepsOfCommitWithoutRecordedDeps(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	depCommit := createTestModule(t, svc, "testowner", "depmodule", []registry.File{
+		{Path: "dep.proto", Content: "syntax = \"proto3\";\npackage dep;"},
+	}, []string{"main"})
+
+	// The v2 buf.lock is the only record of the dependency
+	mainCommit := createTestModule(t, svc, "testowner", "mainmodule", []registry.File{
+		{Path: "main.proto", Content: "syntax = \"proto3\";\npackage main;"},
+		{Path: "buf.lock", Content: `version: v2
+deps:
+  - name: test.registry.com/testowner/depmodule
+    commit: ` + depCommit.ID + `
+    digest: ` + depCommit.ModuleDigest.String()},
+	}, []string{"main"})
+
+	ctx := contextWithUser(context.Background(), "testuser")
+	resp, err := svc.GetGraph(ctx, connect.NewRequest(&v1beta1.GetGraphRequest{
+		ResourceRefs: []*v1beta1.GetGraphRequest_ResourceRef{
+			{ResourceRef: &v1beta1.ResourceRef{Value: &v1beta1.ResourceRef_Id{Id: mainCommit.ID}}},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("GetGraph failed: %v", err)
+	}
+	if len(resp.Msg.Graph.Edges) != 1 || resp.Msg.Graph.Edges[0].ToNode.CommitId != depCommit.ID {
+		t.Errorf("expected edge to %s, got %v", depCommit.ID, resp.Msg.Graph.Edges)
+	}
+}
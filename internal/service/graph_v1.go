@@ -4,14 +4,12 @@ import (
 	"context"
 	"encoding/hex"
 	"errors"
-	"fmt"
 	"log/slog"
 	"strings"
 
 	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
 	"connectrpc.com/connect"
 	"github.com/greatliontech/pbr/internal/registry"
-	"github.com/greatliontech/pbr/internal/util"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -51,30 +49,16 @@ func (g *GraphServiceV1) GetGraph(ctx context.Context, req *connect.Request[v1.G
 	modules := []moduleEntry{}
 
 	for _, ref := range req.Msg.ResourceRefs {
-		switch r := ref.Value.(type) {
-		case *v1.ResourceRef_Id:
-			info, commit, err := g.getModuleAndCommitByIDV1(ctx, r.Id)
-			if err != nil {
-				slog.ErrorContext(ctx, "getModuleAndCommitByIDV1", "err", err)
-				return nil, err
-			}
-			modules = append(modules, moduleEntry{info: info, commit: commit})
-			key := info.Owner + "/" + info.Name
-			commitMap[key] = commit
-			slog.DebugContext(ctx, "top level dep v1", "id", commit.Id)
-			resp.Msg.Graph.Commits = append(resp.Msg.Graph.Commits, commit)
-		case *v1.ResourceRef_Name_:
-			info, commit, err := g.getModuleAndCommitByNameV1(ctx, r.Name)
-			if err != nil {
-				slog.ErrorContext(ctx, "getModuleAndCommitByNameV1", "err", err)
-				return nil, err
-			}
-			modules = append(modules, moduleEntry{info: info, commit: commit})
-			key := info.Owner + "/" + info.Name
-			commitMap[key] = commit
-			slog.DebugContext(ctx, "top level dep v1", "id", commit.Id)
-			resp.Msg.Graph.Commits = append(resp.Msg.Graph.Commits, commit)
+		info, commit, err := g.getModuleAndCommitV1(ctx, ref)
+		if err != nil {
+			slog.ErrorContext(ctx, "getModuleAndCommitV1", "err", err)
+			return nil, err
 		}
+		modules = append(modules, moduleEntry{info: info, commit: commit})
+		key := info.Owner + "/" + info.Name
+		commitMap[key] = commit
+		slog.DebugContext(ctx, "top level dep v1", "id", commit.Id)
+		resp.Msg.Graph.Commits = append(resp.Msg.Graph.Commits, commit)
 	}
 
 	for i, entry := range modules {
@@ -94,63 +78,16 @@ func (g *GraphServiceV1) GetGraph(ctx context.Context, req *connect.Request[v1.G
 	return resp, nil
 }
 
-func (g *GraphServiceV1) getModuleAndCommitByIDV1(ctx context.Context, commitID string) (moduleInfo, *v1.Commit, error) {
-	mod, err := g.svc.casReg.ModuleByCommitID(ctx, commitID)
+func (g *GraphServiceV1) getModuleAndCommitV1(ctx context.Context, ref *v1.ResourceRef) (moduleInfo, *v1.Commit, error) {
+	mod, cmt, err := g.svc.resolveCommit(ctx, ref)
 	if err != nil {
-		return moduleInfo{}, nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("commit not found: %s", commitID))
-	}
-
-	cmt, err := mod.CommitByID(ctx, commitID)
-	if err != nil {
-		return moduleInfo{}, nil, connect.NewError(connect.CodeInternal, err)
+		return moduleInfo{}, nil, err
 	}
 
 	commit := getCommitObjectV1(cmt)
 	return moduleInfo{Owner: mod.Owner(), Name: mod.Name()}, commit, nil
 }
 
-func (g *GraphServiceV1) getModuleAndCommitByNameV1(ctx context.Context, name *v1.ResourceRef_Name) (moduleInfo, *v1.Commit, error) {
-	if name == nil {
-		return moduleInfo{}, nil, connect.NewError(connect.CodeInvalidArgument, errors.New("name is required"))
-	}
-
-	owner := name.Owner
-	modName := name.Module
-
-	mod, err := g.svc.casReg.Module(ctx, owner, modName)
-	if err != nil {
-		return moduleInfo{}, nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("module not found: %s/%s", owner, modName))
-	}
-
-	var cmt *registry.Commit
-	switch child := name.Child.(type) {
-	case *v1.ResourceRef_Name_LabelName:
-		cmt, err = mod.Commit(ctx, child.LabelName)
-		if err != nil {
-			return moduleInfo{}, nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("label not found: %s", child.LabelName))
-		}
-	case *v1.ResourceRef_Name_Ref:
-		// Ref could be a commit ID or a label name - try commit ID first
-		cmt, err = mod.CommitByID(ctx, child.Ref)
-		if err != nil {
-			// Try as a label
-			cmt, err = mod.Commit(ctx, child.Ref)
-			if err != nil {
-				return moduleInfo{}, nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("ref not found: %s", child.Ref))
-			}
-		}
-	default:
-		// No child specified - use default label (main)
-		cmt, err = mod.Commit(ctx, "main")
-		if err != nil {
-			return moduleInfo{}, nil, connect.NewError(connect.CodeNotFound, errors.New("default label 'main' not found"))
-		}
-	}
-
-	commit := getCommitObjectV1(cmt)
-	return moduleInfo{Owner: owner, Name: modName}, commit, nil
-}
-
 func (g *GraphServiceV1) getGraphForModuleV1(ctx context.Context, info moduleInfo, commit *v1.Commit, commits map[string]*v1.Commit, graph *v1.Graph) error {
 	ctx, span := tracer.Start(ctx, "service.getGraphForModuleV1", trace.WithAttributes(
 		attribute.String("owner", info.Owner),
@@ -176,7 +113,7 @@ func (g *GraphServiceV1) getGraphForModuleV1(ctx context.Context, info moduleInf
 		slog.DebugContext(ctx, "dep v1", "owner", dep.Owner, "repo", dep.Repository, "commit", dep.Commit, "digest", dep.Digest)
 
 		// Use module name as key for deduplication
-		moduleKey := dep.Owner + "/" + dep.Repository
+		moduleKey := dep.moduleKey()
 
 		// Get the commit info for B5 digest
 		depCommit := g.createV1CommitFromDep(dep)
@@ -238,26 +175,25 @@ func (g *GraphServiceV1) getGraphForModuleV1(ctx context.Context, info moduleInf
 // createV1CommitFromDep creates a v1.Commit from a bufLockDep.
 // For v1 API, we need to look up the actual commit to get the B5 module digest.
 func (g *GraphServiceV1) createV1CommitFromDep(dep bufLockDep) *v1.Commit {
-	ownerId := util.OwnerID(dep.Owner)
-	modId := util.ModuleID(ownerId, dep.Repository)
-
 	// Try to get the actual commit to get the B5 module digest
-	if mod, err := g.svc.casReg.Module(context.Background(), dep.Owner, dep.Repository); err == nil {
-		if cmt, err := mod.CommitByID(context.Background(), dep.Commit); err == nil {
-			return getCommitObjectV1(cmt)
+	if dep.Owner != "" {
+		if mod, err := g.svc.casReg.Module(context.Background(), dep.Owner, dep.Repository); err == nil {
+			if cmt, err := mod.CommitByID(context.Background(), dep.Commit); err == nil {
+				return getCommitObjectV1(cmt)
+			}
 		}
 	}
 
-	// Fallback: create commit with files digest as B5
-	// This is for external deps or when lookup fails
+	// Fallback: use the stored digest
+	// This is for deps on other registries or when lookup fails
 	digestHex := strings.TrimPrefix(dep.Digest, "shake256:")
 	digestHex = strings.TrimPrefix(digestHex, "b5:")
 	digestBytes, _ := hex.DecodeString(digestHex)
 
 	return &v1.Commit{
 		Id:       dep.Commit,
-		OwnerId:  ownerId,
-		ModuleId: modId,
+		OwnerId:  dep.OwnerID,
+		ModuleId: dep.ModuleID,
 		Digest: &v1.Digest{
 			Type:  v1.DigestType_DIGEST_TYPE_B5,
 			Value: digestBytes,
@@ -276,5 +212,7 @@ func getCommitObjectV1(commit *registry.Commit) *v1.Commit {
 			Type:  v1.DigestType_DIGEST_TYPE_B5,
 			Value: commit.ModuleDigest.Value,
 		},
+		CreatedByUserId:  commit.CreatedByUserID,
+		SourceControlUrl: commit.SourceControlURL,
 	}
 }
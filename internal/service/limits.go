@@ -0,0 +1,98 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxReportedViolations is the number of invalid files listed in an upload's error.
+const maxReportedViolations = 20
+
+// moduleFiles are the files allowed at the root of a module regardless of the allowed extensions.
+var moduleFiles = []string{"LICENSE", "README.markdown", "README.md", "buf.lock", "buf.md", "buf.yaml"}
+
+// validateContents checks the paths of uploaded files and the upload limits of their owners.
+// Limits on bytes and files apply to all modules an upload pushes for an owner together.
+func (svc *Service) validateContents(contents []moduleContent) error {
+	var violations []string
+	type usage struct {
+		bytes int64
+		files int
+	}
+	owners := make(map[string]*usage)
+	var ownerOrder []string
+
+	for _, content := range contents {
+		name := content.owner + "/" + content.module
+		limits := svc.conf.Limits.For(content.owner)
+		u, ok := owners[content.owner]
+		if !ok {
+			u = &usage{}
+			owners[content.owner] = u
+			ownerOrder = append(ownerOrder, content.owner)
+		}
+
+		seen := make(map[string]bool, len(content.files))
+		for _, f := range content.files {
+			size := int64(len(f.Content))
+			u.bytes += size
+			u.files++
+
+			if err := validatePath(f.Path); err != nil {
+				violations = append(violations, fmt.Sprintf("%s: %q: %v", name, f.Path, err))
+				continue
+			}
+			if seen[f.Path] {
+				violations = append(violations, fmt.Sprintf("%s: %q: duplicate path", name, f.Path))
+				continue
+			}
+			seen[f.Path] = true
+			if !slices.Contains(moduleFiles, f.Path) && !slices.Contains(limits.Extensions, path.Ext(f.Path)) {
+				violations = append(violations, fmt.Sprintf("%s: %q: file type not allowed, expected one of %s", name, f.Path, strings.Join(limits.Extensions, ", ")))
+			}
+			if limits.MaxFileBytes >= 0 && size > limits.MaxFileBytes {
+				violations = append(violations, fmt.Sprintf("%s: %q: file is %d bytes, larger than the limit of %d", name, f.Path, size, limits.MaxFileBytes))
+			}
+		}
+	}
+
+	for _, owner := range ownerOrder {
+		limits, u := svc.conf.Limits.For(owner), owners[owner]
+		if limits.MaxFiles >= 0 && u.files > limits.MaxFiles {
+			violations = append(violations, fmt.Sprintf("%s: upload has %d files, more than the limit of %d", owner, u.files, limits.MaxFiles))
+		}
+		if limits.MaxBytes >= 0 && u.bytes > limits.MaxBytes {
+			violations = append(violations, fmt.Sprintf("%s: upload is %d bytes, larger than the limit of %d", owner, u.bytes, limits.MaxBytes))
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	if len(violations) > maxReportedViolations {
+		more := len(violations) - maxReportedViolations
+		violations = append(violations[:maxReportedViolations], fmt.Sprintf("and %d more", more))
+	}
+	return errors.New("invalid upload:\n" + strings.Join(violations, "\n"))
+}
+
+// validatePath checks that a file path is a normalized path within the module.
+func validatePath(p string) error {
+	switch {
+	case p == "":
+		return errors.New("path is empty")
+	case !utf8.ValidString(p) || strings.ContainsAny(p, "\\\x00"):
+		return errors.New("path must be valid UTF-8 without backslashes or NUL bytes")
+	case strings.HasPrefix(p, "/"):
+		return errors.New("path must be relative")
+	case p == "." || p == ".." || strings.HasPrefix(p, "../"):
+		return errors.New("path must be within the module")
+	case path.Clean(p) != p:
+		return fmt.Errorf("path must be normalized, as %q", path.Clean(p))
+	}
+	return nil
+}
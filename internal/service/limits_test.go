@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/config"
+)
+
+func TestValidatePath(t *testing.T) {
+	valid := []string{"a.proto", "acme/v1/pet.proto", "buf.yaml", "a..b.proto"}
+	for _, p := range valid {
+		if err := validatePath(p); err != nil {
+			t.Errorf("%q: unexpected error %v", p, err)
+		}
+	}
+	invalid := []string{"", "/etc/pet.proto", "../pet.proto", "..", ".", "a/../../b.proto", "./a.proto", "a//b.proto", "a/", "a\\b.proto", "a\x00.proto", "\xff.proto"}
+	for _, p := range invalid {
+		if err := validatePath(p); err == nil {
+			t.Errorf("%q: expected error", p)
+		}
+	}
+}
+
+func TestUpload_Limits(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.conf.Limits = &config.Limits{
+		Default: config.UploadLimits{MaxFiles: 4, MaxFileBytes: 100},
+		Owners: map[string]config.UploadLimits{
+			"acme": {MaxBytes: 150, Extensions: []string{".proto", ".json"}},
+		},
+	}
+
+	ctx := contextWithUser(context.Background(), "alice")
+	upload := func(owner string, files ...*v1.File) error {
+		var contents []*v1.UploadRequest_Content
+		for _, module := range []string{"a", "b"} {
+			contents = append(contents, &v1.UploadRequest_Content{
+				ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: owner, Module: module}}},
+				Files:     files,
+			})
+		}
+		_, err := NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{Contents: contents}))
+		return err
+	}
+	file := func(path string, size int) *v1.File {
+		return &v1.File{Path: path, Content: []byte("//" + strings.Repeat("x", size-2))}
+	}
+
+	if err := upload("acme", file("a.proto", 50), file("schema.json", 20)); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	tests := []struct {
+		owner string
+		files []*v1.File
+		want  string
+	}{
+		{"acme", []*v1.File{file("../a.proto", 10)}, `acme/a: "../a.proto": path must be within the module`},
+		{"acme", []*v1.File{file("a.proto", 10), file("a.proto", 10)}, `acme/a: "a.proto": duplicate path`},
+		{"acme", []*v1.File{file("run.sh", 10)}, `acme/a: "run.sh": file type not allowed, expected one of .proto, .json`},
+		{"other", []*v1.File{file("schema.json", 10)}, `other/a: "schema.json": file type not allowed, expected one of .proto`},
+		{"acme", []*v1.File{file("a.proto", 101)}, `acme/a: "a.proto": file is 101 bytes, larger than the limit of 100`},
+		// Limits on totals apply to both modules together
+		{"acme", []*v1.File{file("a.proto", 80)}, `acme: upload is 160 bytes, larger than the limit of 150`},
+		{"other", []*v1.File{file("a.proto", 2), file("b.proto", 2), file("c.proto", 2)}, `other: upload has 6 files, more than the limit of 4`},
+	}
+	for _, tt := range tests {
+		err := upload(tt.owner, tt.files...)
+		if connect.CodeOf(err) != connect.CodeInvalidArgument || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("expected InvalidArgument with %q, got %v", tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/bufbuild/protocompile/linker"
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/lint"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// LintWarningHeader is the response header of upload RPCs carrying each lint warning,
+// as "owner/module: file:line:column: message (RULE)".
+const LintWarningHeader = "Pbr-Lint-Warning"
+
+// lintCheck is the check of findings recorded for lint warnings.
+const lintCheck = "lint"
+
+// validateLint checks that the lint policies only name supported rules.
+func validateLint(conf *config.Lint) error {
+	if conf == nil {
+		return nil
+	}
+	policies := map[string]config.LintPolicy{"default": conf.Default}
+	for owner, p := range conf.Owners {
+		policies[owner] = p
+	}
+	for name, p := range policies {
+		for _, rule := range slices.Concat(p.Error, p.Warn) {
+			if err := lint.ValidateRule(rule); err != nil {
+				return fmt.Errorf("lint: %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkLint checks the descriptors of an uploaded module against the lint policy of its owner.
+// Violations of error rules fail the upload with InvalidArgument. Violations of warning rules
+// are returned, to be recorded on the new commit. Modules that weren't compiled are left to
+// checkUncompiled.
+func (svc *Service) checkLint(ctx context.Context, mod *registry.Module, compiled linker.Files) ([]storage.Finding, error) {
+	policy := svc.conf.Lint.PolicyFor(mod.Owner())
+	if len(policy.Error)+len(policy.Warn) == 0 || compiled == nil {
+		return nil, nil
+	}
+
+	var errs []string
+	var warnings []storage.Finding
+	for _, f := range lint.Check(compiled, slices.Concat(policy.Error, policy.Warn)) {
+		if slices.Contains(policy.Error, f.Rule) {
+			errs = append(errs, f.String())
+			continue
+		}
+		warnings = append(warnings, storage.Finding{
+			Check:   lintCheck,
+			Rule:    f.Rule,
+			Path:    f.File,
+			Line:    f.Line,
+			Column:  f.Column,
+			Message: f.Message,
+		})
+	}
+	if len(errs) > 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%s/%s violates lint rules:\n%s", mod.Owner(), mod.Name(), strings.Join(errs, "\n")))
+	}
+	return warnings, nil
+}
+
+// setLintWarnings adds the lint warnings recorded on the commits of an upload to header.
+// Commits are in the order of contents.
+func setLintWarnings(header http.Header, contents []moduleContent, commits []*registry.Commit) {
+	for i, commit := range commits {
+		for _, f := range commit.Findings {
+			if f.Check != lintCheck {
+				continue
+			}
+			pos := f.Path
+			if f.Line > 0 {
+				pos = fmt.Sprintf("%s:%d:%d", f.Path, f.Line, f.Column)
+			}
+			header.Add(LintWarningHeader, fmt.Sprintf("%s/%s: %s: %s (%s)", contents[i].owner, contents[i].module, pos, f.Message, f.Rule))
+		}
+	}
+}
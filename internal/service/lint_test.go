@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/config"
+)
+
+func TestUpload_Lint(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.conf.Lint = &config.Lint{Owners: map[string]config.LintPolicy{
+		"acme": {Error: []string{"FIELD_NO_PROTO3_OPTIONAL"}, Warn: []string{"COMMENT_SERVICE", "FIELD_LOWER_SNAKE_CASE"}},
+	}}
+
+	ctx := contextWithUser(context.Background(), "alice")
+	upload := func(owner, content string) (*connect.Response[v1.UploadResponse], error) {
+		return NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+			Contents: []*v1.UploadRequest_Content{
+				{
+					ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: owner, Module: "pets"}}},
+					Files:     []*v1.File{{Path: "pet.proto", Content: []byte(content)}},
+				},
+			},
+		}))
+	}
+
+	_, err := upload("acme", "syntax = \"proto3\";\nmessage Pet {\n  optional string name = 1;\n}\n")
+	if connect.CodeOf(err) != connect.CodeInvalidArgument || !strings.Contains(err.Error(), `pet.proto:3:3: field "name" should not be optional (FIELD_NO_PROTO3_OPTIONAL)`) {
+		t.Fatalf("expected InvalidArgument with the optional field, got %v", err)
+	}
+
+	resp, err := upload("acme", "syntax = \"proto3\";\nmessage Pet {\n  string Name = 1;\n}\nservice PetService {}\n")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	warnings := resp.Header().Values(LintWarningHeader)
+	want := []string{
+		`acme/pets: pet.proto:3:3: field "Name" should be lower_snake_case (FIELD_LOWER_SNAKE_CASE)`,
+		`acme/pets: pet.proto:5:1: service "PetService" should have a comment (COMMENT_SERVICE)`,
+	}
+	if strings.Join(warnings, "\n") != strings.Join(want, "\n") {
+		t.Errorf("warnings:\n%s\nwant:\n%s", strings.Join(warnings, "\n"), strings.Join(want, "\n"))
+	}
+	stored, err := svc.casReg.CommitByID(ctx, resp.Msg.Commits[0].Id)
+	if err != nil {
+		t.Fatalf("CommitByID failed: %v", err)
+	}
+	if len(stored.Findings) != 2 || stored.Findings[0].Check != lintCheck {
+		t.Errorf("expected the warnings to be recorded on the commit, got %+v", stored.Findings)
+	}
+
+	// Other owners have no policy
+	resp, err = upload("other", "syntax = \"proto3\";\nmessage Pet {\n  optional string Name = 1;\n}\n")
+	if err != nil || len(resp.Header().Values(LintWarningHeader)) != 0 {
+		t.Errorf("expected no lint for other owners, got %v %v", err, resp.Header())
+	}
+}
@@ -0,0 +1,426 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"buf.build/gen/go/bufbuild/registry/connectrpc/go/buf/registry/module/v1/modulev1connect"
+	"buf.build/gen/go/bufbuild/registry/connectrpc/go/buf/registry/owner/v1/ownerv1connect"
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	ownerv1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/owner/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// upstream is a registry mirrored through this one.
+type upstream struct {
+	registry string
+	conf     config.Mirror
+	commits  modulev1connect.CommitServiceClient
+	graph    modulev1connect.GraphServiceClient
+	download modulev1connect.DownloadServiceClient
+	modules  modulev1connect.ModuleServiceClient
+	owners   ownerv1connect.OwnerServiceClient
+
+	mu        sync.Mutex           // protects refreshed
+	refreshed map[string]time.Time // when each mirrored label was last refreshed
+}
+
+func newUpstream(m config.Mirror, client *http.Client) *upstream {
+	url := m.URL
+	if url == "" {
+		url = "https://" + m.Registry
+	}
+	url = strings.TrimSuffix(url, "/")
+
+	var opts []connect.ClientOption
+	if m.Token != "" {
+		opts = append(opts, connect.WithInterceptors(bearerTokenInterceptor(m.Token)))
+	}
+
+	return &upstream{
+		registry: m.Registry,
+		conf:     m,
+		commits:  modulev1connect.NewCommitServiceClient(client, url, opts...),
+		graph:    modulev1connect.NewGraphServiceClient(client, url, opts...),
+		download: modulev1connect.NewDownloadServiceClient(client, url, opts...),
+		modules:  modulev1connect.NewModuleServiceClient(client, url, opts...),
+		owners:   ownerv1connect.NewOwnerServiceClient(client, url, opts...),
+
+		refreshed: map[string]time.Time{},
+	}
+}
+
+// refreshDue reports whether a mirrored label is due to be refreshed from the upstream,
+// and if so marks it as refreshed, so that only one request refreshes it.
+func (u *upstream) refreshDue(owner, module, labelName string) bool {
+	key := owner + "/" + module + ":" + labelName
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if last, ok := u.refreshed[key]; ok && time.Since(last) < u.conf.GetRefreshInterval() {
+		return false
+	}
+	u.refreshed[key] = time.Now()
+	return true
+}
+
+// markRefreshed records that a mirrored label was just pulled from the upstream.
+func (u *upstream) markRefreshed(owner, module, labelName string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.refreshed[owner+"/"+module+":"+labelName] = time.Now()
+}
+
+// bearerTokenInterceptor authenticates outgoing requests with a static token.
+func bearerTokenInterceptor(token string) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			req.Header().Set("Authorization", "Bearer "+token)
+			return next(ctx, req)
+		}
+	}
+}
+
+// mirror returns the upstream configured for a registry, or nil if it is not mirrored.
+func (svc *Service) mirror(registry string) *upstream {
+	for _, u := range svc.mirrors {
+		if u.registry == registry {
+			return u
+		}
+	}
+	return nil
+}
+
+// resolveWithMirrors resolves a reference locally, going to the mirrored upstreams for
+// references to modules that are missing here and for names of modules that are mirrored.
+// Former names of renamed modules resolve to their current name. Missing names are only
+// looked up on the mirrors configured for their owner.
+func (svc *Service) resolveWithMirrors(ctx context.Context, id, owner, module, labelName, ref string) (*registry.ResolvedRef, error) {
+	var err error
+	if id != "" {
+		var res *registry.ResolvedRef
+		if res, err = svc.casReg.ResolveID(ctx, id); !errors.Is(err, storage.ErrNotFound) {
+			return res, err
+		}
+	} else {
+		var mod *registry.Module
+		mod, err = svc.casReg.ModuleFollowingRedirect(ctx, owner, module)
+		if err == nil {
+			// The former names of renamed modules resolve to their current name
+			owner, module = mod.Owner(), mod.Name()
+			u := svc.mirror(mod.Mirror())
+			if u == nil {
+				// Hosted here, or no longer mirrored
+				return svc.casReg.ResolveName(ctx, owner, module, labelName, ref)
+			}
+			return svc.resolveMirrored(ctx, u, mod, labelName, ref)
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	for _, u := range svc.mirrors {
+		var res *registry.ResolvedRef
+		var uerr error
+		if id != "" {
+			res, uerr = svc.pullID(ctx, u, id)
+		} else if u.conf.MirrorsOwner(owner) {
+			res, uerr = svc.pullName(ctx, u, owner, module, labelName, ref)
+		} else {
+			// Names are only looked up upstream for the configured owners, so that
+			// names that are missing here can't be claimed upstream
+			continue
+		}
+		if uerr == nil {
+			return res, nil
+		}
+		slog.DebugContext(ctx, "not resolved by mirror", "registry", u.registry, "id", id, "owner", owner, "module", module, "error", uerr)
+	}
+	return nil, err
+}
+
+// resolveMirrored resolves a name of a module mirrored from u. Names that resolve here are
+// served from storage, so that requests don't wait on the upstream, and the labels they
+// resolve to are refreshed in the background once the mirror's refresh interval has
+// passed. Labels and refs that aren't stored yet are pulled from the upstream.
+func (svc *Service) resolveMirrored(ctx context.Context, u *upstream, mod *registry.Module, labelName, ref string) (*registry.ResolvedRef, error) {
+	owner, module := mod.Owner(), mod.Name()
+	lookup := labelName
+	if labelName == "" && ref == "" {
+		// Modules pulled as dependencies have no labels until their name is requested
+		lookup = mod.DefaultLabelName()
+	}
+	res, err := svc.casReg.ResolveName(ctx, owner, module, lookup, ref)
+	if errors.Is(err, storage.ErrNotFound) {
+		return svc.pullName(ctx, u, owner, module, labelName, ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Commits are immutable, labels move
+	if res.Label != nil {
+		refresh := labelName
+		if ref != "" {
+			refresh = res.Label.Name
+		}
+		if u.refreshDue(owner, module, refresh) {
+			svc.refreshes.Add(1)
+			go func() {
+				defer svc.refreshes.Done()
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), upstreamTimeout)
+				defer cancel()
+				if _, err := svc.pullName(ctx, u, owner, module, refresh, ""); err != nil {
+					slog.WarnContext(ctx, "failed to refresh mirrored label", "registry", u.registry, "owner", owner, "module", module, "label", refresh, "error", err)
+				}
+			}()
+		}
+	}
+	if labelName == "" && ref == "" {
+		return &registry.ResolvedRef{Module: res.Module}, nil
+	}
+	return res, nil
+}
+
+// pullID pulls the commit an upstream resource ID resolves to.
+func (svc *Service) pullID(ctx context.Context, u *upstream, id string) (*registry.ResolvedRef, error) {
+	commit, err := u.commit(ctx, &v1.ResourceRef{Value: &v1.ResourceRef_Id{Id: id}})
+	if err != nil {
+		return nil, err
+	}
+	mod, local, err := svc.pullCommit(ctx, u, commit.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &registry.ResolvedRef{Module: mod, Commit: local}, nil
+}
+
+// pullName pulls the commit an upstream module name resolves to. Labels, including the
+// default label when no label or ref is given, are updated to point at the pulled commit.
+func (svc *Service) pullName(ctx context.Context, u *upstream, owner, module, labelName, ref string) (*registry.ResolvedRef, error) {
+	name := &v1.ResourceRef_Name{Owner: owner, Module: module}
+	switch {
+	case labelName != "":
+		name.Child = &v1.ResourceRef_Name_LabelName{LabelName: labelName}
+	case ref != "":
+		name.Child = &v1.ResourceRef_Name_Ref{Ref: ref}
+	}
+	commit, err := u.commit(ctx, &v1.ResourceRef{Value: &v1.ResourceRef_Name_{Name: name}})
+	if err != nil {
+		return nil, err
+	}
+	mod, local, err := svc.pullCommit(ctx, u, commit.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case ref != "":
+		return &registry.ResolvedRef{Module: mod, Commit: local}, nil
+	case labelName == "":
+		if err := mod.SetLabel(ctx, mod.DefaultLabelName(), local.ID); err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		u.markRefreshed(owner, module, labelName)
+		return &registry.ResolvedRef{Module: mod}, nil
+	default:
+		if err := mod.SetLabel(ctx, labelName, local.ID); err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		u.markRefreshed(owner, module, labelName)
+		return svc.casReg.ResolveName(ctx, owner, module, labelName, "")
+	}
+}
+
+// pullCommit makes an upstream commit and its dependencies available locally.
+// Commits that are already stored are not fetched again.
+func (svc *Service) pullCommit(ctx context.Context, u *upstream, commitID string) (*registry.Module, *registry.Commit, error) {
+	if commit, err := svc.casReg.CommitByID(ctx, commitID); err == nil {
+		mod, err := svc.casReg.ModuleByID(ctx, commit.ModuleID)
+		if err != nil {
+			return nil, nil, connect.NewError(connect.CodeInternal, err)
+		}
+		return mod, commit, nil
+	}
+
+	slog.InfoContext(ctx, "pulling commit from upstream", "registry", u.registry, "commitID", commitID)
+
+	graph, err := u.graph.GetGraph(ctx, connect.NewRequest(&v1.GetGraphRequest{
+		ResourceRefs: []*v1.ResourceRef{{Value: &v1.ResourceRef_Id{Id: commitID}}},
+	}))
+	if err != nil {
+		return nil, nil, upstreamError(u, err)
+	}
+
+	commits := make(map[string]*v1.Commit, len(graph.Msg.Graph.Commits))
+	for _, c := range graph.Msg.Graph.Commits {
+		commits[c.Id] = c
+	}
+	edges := make(map[string][]string)
+	for _, e := range graph.Msg.Graph.Edges {
+		edges[e.FromNode.CommitId] = append(edges[e.FromNode.CommitId], e.ToNode.CommitId)
+	}
+
+	// Download the content of every commit that isn't stored yet
+	var missing []*v1.DownloadRequest_Value
+	for id := range commits {
+		if _, err := svc.casReg.CommitByID(ctx, id); err != nil {
+			missing = append(missing, &v1.DownloadRequest_Value{ResourceRef: &v1.ResourceRef{Value: &v1.ResourceRef_Id{Id: id}}})
+		}
+	}
+	files := make(map[string][]registry.File, len(missing))
+	if len(missing) > 0 {
+		download, err := u.download.Download(ctx, connect.NewRequest(&v1.DownloadRequest{Values: missing}))
+		if err != nil {
+			return nil, nil, upstreamError(u, err)
+		}
+		for _, content := range download.Msg.Contents {
+			var fs []registry.File
+			for _, f := range content.Files {
+				fs = append(fs, registry.File{Path: f.Path, Content: string(f.Content)})
+			}
+			files[content.Commit.Id] = fs
+		}
+	}
+
+	// Import dependencies before the commits that depend on them
+	pulled := make(map[string]*registry.Commit)
+	modules := make(map[string]*registry.Module)
+	var visit func(id string) error
+	visit = func(id string) error {
+		if _, ok := pulled[id]; ok {
+			return nil
+		}
+		if local, err := svc.casReg.CommitByID(ctx, id); err == nil {
+			pulled[id] = local
+			return nil
+		}
+		c, ok := commits[id]
+		if !ok {
+			return fmt.Errorf("commit %s missing from upstream graph", id)
+		}
+		fs, ok := files[id]
+		if !ok {
+			return fmt.Errorf("commit %s missing from upstream download", id)
+		}
+
+		// Record all transitive dependencies, as uploads do
+		var deps []storage.DepRecord
+		seen := make(map[string]bool)
+		for _, depID := range edges[id] {
+			if err := visit(depID); err != nil {
+				return err
+			}
+			dep := pulled[depID]
+			for _, d := range append([]storage.DepRecord{dep.AsDep()}, dep.Deps...) {
+				if !seen[d.CommitID] {
+					seen[d.CommitID] = true
+					deps = append(deps, d)
+				}
+			}
+		}
+
+		mod, err := svc.mirrorModule(ctx, u, c.ModuleId, modules)
+		if err != nil {
+			return err
+		}
+		digest := storage.ModuleDigest{Type: storage.DigestTypeB5, Value: c.Digest.GetValue()}
+		local, err := mod.ImportCommit(ctx, id, c.CreateTime.AsTime(), fs, deps, digest)
+		if errors.Is(err, registry.ErrDigestMismatch) {
+			return connect.NewError(connect.CodeDataLoss, fmt.Errorf("%s served a commit that doesn't match its digest: %w", u.registry, err))
+		}
+		if err != nil {
+			return err
+		}
+		pulled[id] = local
+		return nil
+	}
+	if err := visit(commitID); err != nil {
+		return nil, nil, asConnectError(err, connect.CodeInternal)
+	}
+
+	commit := pulled[commitID]
+	mod, err := svc.casReg.ModuleByID(ctx, commit.ModuleID)
+	if err != nil {
+		return nil, nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return mod, commit, nil
+}
+
+// mirrorModule returns the local copy of an upstream module, looking up its name upstream.
+// cache holds the modules already looked up, by upstream module ID.
+func (svc *Service) mirrorModule(ctx context.Context, u *upstream, upstreamModuleID string, cache map[string]*registry.Module) (*registry.Module, error) {
+	if mod, ok := cache[upstreamModuleID]; ok {
+		return mod, nil
+	}
+
+	modules, err := u.modules.GetModules(ctx, connect.NewRequest(&v1.GetModulesRequest{
+		ModuleRefs: []*v1.ModuleRef{{Value: &v1.ModuleRef_Id{Id: upstreamModuleID}}},
+	}))
+	if err != nil {
+		return nil, upstreamError(u, err)
+	}
+	if len(modules.Msg.Modules) != 1 {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("expected 1 module from %s, got %d", u.registry, len(modules.Msg.Modules)))
+	}
+	upstreamModule := modules.Msg.Modules[0]
+
+	owners, err := u.owners.GetOwners(ctx, connect.NewRequest(&ownerv1.GetOwnersRequest{
+		OwnerRefs: []*ownerv1.OwnerRef{{Value: &ownerv1.OwnerRef_Id{Id: upstreamModule.OwnerId}}},
+	}))
+	if err != nil {
+		return nil, upstreamError(u, err)
+	}
+	if len(owners.Msg.Owners) != 1 {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("expected 1 owner from %s, got %d", u.registry, len(owners.Msg.Owners)))
+	}
+	owner := owners.Msg.Owners[0]
+	ownerName := owner.GetUser().GetName()
+	if ownerName == "" {
+		ownerName = owner.GetOrganization().GetName()
+	}
+	if ownerName == "" || upstreamModule.Name == "" {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("module %s from %s has no name", upstreamModuleID, u.registry))
+	}
+
+	mod, err := svc.casReg.MirrorModule(ctx, u.registry, ownerName, upstreamModule.Name, upstreamModule.DefaultLabelName)
+	if errors.Is(err, registry.ErrNotMirrored) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cache[upstreamModuleID] = mod
+	return mod, nil
+}
+
+// commit resolves a reference to a commit of the upstream.
+func (u *upstream) commit(ctx context.Context, ref *v1.ResourceRef) (*v1.Commit, error) {
+	resp, err := u.commits.GetCommits(ctx, connect.NewRequest(&v1.GetCommitsRequest{
+		ResourceRefs: []*v1.ResourceRef{ref},
+	}))
+	if err != nil {
+		return nil, upstreamError(u, err)
+	}
+	if len(resp.Msg.Commits) != 1 {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("expected 1 commit from %s, got %d", u.registry, len(resp.Msg.Commits)))
+	}
+	return resp.Msg.Commits[0], nil
+}
+
+// upstreamError keeps NotFound from the upstream and reports anything else as Unavailable.
+func upstreamError(u *upstream, err error) error {
+	if connect.CodeOf(err) == connect.CodeNotFound {
+		return connect.NewError(connect.CodeNotFound, fmt.Errorf("%s: %w", u.registry, err))
+	}
+	return connect.NewError(connect.CodeUnavailable, fmt.Errorf("mirror %s: %w", u.registry, err))
+}
@@ -0,0 +1,327 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"buf.build/gen/go/bufbuild/registry/connectrpc/go/buf/registry/module/v1/modulev1connect"
+	"buf.build/gen/go/bufbuild/registry/connectrpc/go/buf/registry/owner/v1/ownerv1connect"
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	v1beta1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1beta1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+// newUpstreamServer serves the v1 module and owner APIs of svc, for use as a mirror upstream.
+func newUpstreamServer(t *testing.T, svc *Service) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.Handle(modulev1connect.NewCommitServiceHandler(NewCommitServiceV1(svc)))
+	mux.Handle(modulev1connect.NewGraphServiceHandler(NewGraphServiceV1(svc)))
+	mux.Handle(modulev1connect.NewDownloadServiceHandler(NewDownloadServiceV1(svc)))
+	mux.Handle(modulev1connect.NewModuleServiceHandler(NewModuleService(svc)))
+	mux.Handle(ownerv1connect.NewOwnerServiceHandler(svc))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMirror_PullThrough(t *testing.T) {
+	up, upCleanup := setupTestService(t)
+	defer upCleanup()
+
+	dep := createTestModule(t, up, "googleapis", "googleapis", []registry.File{
+		{Path: "google/type/date.proto", Content: `syntax = "proto3";`},
+	}, []string{"main"})
+	api := createTestModuleWithDeps(t, up, "acme", "api", []registry.File{
+		{Path: "acme/api.proto", Content: "syntax = \"proto3\";\nimport \"google/type/date.proto\";"},
+	}, []string{"main", "v1.0.0"}, []string{dep.ID})
+
+	srv := newUpstreamServer(t, up)
+
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.mirrors = []*upstream{newUpstream(config.Mirror{Registry: "buf.build", URL: srv.URL, Owners: []string{"acme", "googleapis"}}, srv.Client())}
+
+	ctx := contextWithUser(context.Background(), "testuser")
+	graphSvc := NewGraphServiceV1(svc)
+	getGraph := func() *v1.Graph {
+		t.Helper()
+		resp, err := graphSvc.GetGraph(ctx, connect.NewRequest(&v1.GetGraphRequest{
+			ResourceRefs: []*v1.ResourceRef{{Value: &v1.ResourceRef_Name_{Name: &v1.ResourceRef_Name{Owner: "acme", Module: "api"}}}},
+		}))
+		if err != nil {
+			t.Fatalf("GetGraph failed: %v", err)
+		}
+		return resp.Msg.Graph
+	}
+
+	// The first request pulls the module and its dependency from the upstream
+	graph := getGraph()
+	digests := map[string][]byte{}
+	for _, c := range graph.Commits {
+		digests[c.Id] = c.Digest.Value
+	}
+	if !bytes.Equal(digests[api.ID], api.ModuleDigest.Value) || !bytes.Equal(digests[dep.ID], dep.ModuleDigest.Value) {
+		t.Fatalf("expected upstream commits %s and %s with their digests, got %v", api.ID, dep.ID, graph.Commits)
+	}
+	if len(graph.Edges) != 1 {
+		t.Errorf("expected 1 edge, got %d", len(graph.Edges))
+	}
+
+	// Local modules can depend on mirrored commits, which are reported as local
+	_, err := svc.Upload(ctx, connect.NewRequest(&v1beta1.UploadRequest{
+		Contents: []*v1beta1.UploadRequest_Content{
+			{
+				ModuleRef: &v1beta1.ModuleRef{Value: &v1beta1.ModuleRef_Name_{Name: &v1beta1.ModuleRef_Name{Owner: "mine", Module: "app"}}},
+				Files:     []*v1beta1.File{{Path: "app.proto", Content: []byte(`syntax = "proto3";`)}},
+			},
+		},
+		DepRefs: []*v1beta1.UploadRequest_DepRef{{CommitId: dep.ID, Registry: "buf.build"}},
+	}))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	// Mirrored modules are read-only
+	_, err = NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+		Contents: []*v1.UploadRequest_Content{
+			{
+				ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: "api"}}},
+				Files:     []*v1.File{{Path: "acme/api.proto", Content: []byte(`syntax = "proto3";`)}},
+			},
+		},
+	}))
+	if connect.CodeOf(err) != connect.CodeFailedPrecondition {
+		t.Errorf("expected FailedPrecondition uploading to a mirrored module, got %v", err)
+	}
+
+	// Once pulled, everything is served without the upstream
+	srv.Close()
+
+	graph = getGraph()
+	if len(graph.Commits) != 2 {
+		t.Errorf("expected 2 commits offline, got %d", len(graph.Commits))
+	}
+
+	download, err := NewDownloadServiceV1(svc).Download(ctx, connect.NewRequest(&v1.DownloadRequest{
+		Values: []*v1.DownloadRequest_Value{
+			{ResourceRef: &v1.ResourceRef{Value: &v1.ResourceRef_Id{Id: dep.ID}}},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if got := download.Msg.Contents[0].Commit.Id; got != dep.ID {
+		t.Errorf("downloaded commit %s, want %s", got, dep.ID)
+	}
+}
+
+func TestMirror_RefreshesLabels(t *testing.T) {
+	up, upCleanup := setupTestService(t)
+	defer upCleanup()
+	first := createTestModule(t, up, "acme", "api", []registry.File{
+		{Path: "acme/api.proto", Content: `syntax = "proto3";`},
+	}, []string{"main"})
+
+	srv := newUpstreamServer(t, up)
+
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.mirrors = []*upstream{newUpstream(config.Mirror{Registry: "buf.build", URL: srv.URL, Owners: []string{"acme"}, RefreshInterval: "0"}, srv.Client())}
+
+	ctx := contextWithUser(context.Background(), "testuser")
+	commitSvc := NewCommitServiceV1(svc)
+	resolve := func() string {
+		t.Helper()
+		resp, err := commitSvc.GetCommits(ctx, connect.NewRequest(&v1.GetCommitsRequest{
+			ResourceRefs: []*v1.ResourceRef{{Value: &v1.ResourceRef_Name_{Name: &v1.ResourceRef_Name{
+				Owner: "acme", Module: "api", Child: &v1.ResourceRef_Name_LabelName{LabelName: "main"},
+			}}}},
+		}))
+		if err != nil {
+			t.Fatalf("GetCommits failed: %v", err)
+		}
+		return resp.Msg.Commits[0].Id
+	}
+
+	if got := resolve(); got != first.ID {
+		t.Fatalf("expected upstream commit %s, got %s", first.ID, got)
+	}
+
+	// A moved label is served from storage until the background refresh picks it up
+	second := createTestModule(t, up, "acme", "api", []registry.File{
+		{Path: "acme/api.proto", Content: "syntax = \"proto3\";\nmessage Api {}"},
+	}, []string{"main"})
+	if got := resolve(); got != first.ID {
+		t.Errorf("expected stored commit %s before the refresh, got %s", first.ID, got)
+	}
+	svc.refreshes.Wait()
+	if got := resolve(); got != second.ID {
+		t.Errorf("expected refreshed commit %s, got %s", second.ID, got)
+	}
+	svc.refreshes.Wait()
+
+	// Without the upstream, stored labels are still served
+	srv.Close()
+	if got := resolve(); got != second.ID {
+		t.Errorf("expected stored commit %s offline, got %s", second.ID, got)
+	}
+	svc.refreshes.Wait()
+}
+
+func TestMirror_UnlistedOwner(t *testing.T) {
+	up, upCleanup := setupTestService(t)
+	defer upCleanup()
+	api := createTestModule(t, up, "acme", "api", []registry.File{
+		{Path: "acme/api.proto", Content: `syntax = "proto3";`},
+	}, []string{"main"})
+	srv := newUpstreamServer(t, up)
+
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.mirrors = []*upstream{newUpstream(config.Mirror{Registry: "buf.build", URL: srv.URL, Owners: []string{"googleapis"}}, srv.Client())}
+
+	// Names of owners the mirror isn't configured for are not looked up upstream
+	ctx := contextWithUser(context.Background(), "testuser")
+	commitSvc := NewCommitServiceV1(svc)
+	_, err := commitSvc.GetCommits(ctx, connect.NewRequest(&v1.GetCommitsRequest{
+		ResourceRefs: []*v1.ResourceRef{{Value: &v1.ResourceRef_Name_{Name: &v1.ResourceRef_Name{Owner: "acme", Module: "api"}}}},
+	}))
+	if connect.CodeOf(err) != connect.CodeNotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+
+	// Commits are still pulled by ID
+	resp, err := commitSvc.GetCommits(ctx, connect.NewRequest(&v1.GetCommitsRequest{
+		ResourceRefs: []*v1.ResourceRef{{Value: &v1.ResourceRef_Id{Id: api.ID}}},
+	}))
+	if err != nil {
+		t.Fatalf("GetCommits failed: %v", err)
+	}
+	if resp.Msg.Commits[0].Id != api.ID {
+		t.Errorf("got commit %s, want %s", resp.Msg.Commits[0].Id, api.ID)
+	}
+}
+
+func TestMirror_NotFoundUpstream(t *testing.T) {
+	up, upCleanup := setupTestService(t)
+	defer upCleanup()
+	srv := newUpstreamServer(t, up)
+
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.mirrors = []*upstream{newUpstream(config.Mirror{Registry: "buf.build", URL: srv.URL, Owners: []string{"acme", "googleapis"}}, srv.Client())}
+
+	ctx := contextWithUser(context.Background(), "testuser")
+	_, err := NewCommitServiceV1(svc).GetCommits(ctx, connect.NewRequest(&v1.GetCommitsRequest{
+		ResourceRefs: []*v1.ResourceRef{{Value: &v1.ResourceRef_Name_{Name: &v1.ResourceRef_Name{Owner: "acme", Module: "missing"}}}},
+	}))
+	if connect.CodeOf(err) != connect.CodeNotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
+
+// noModules is an upstream ModuleService that answers every lookup with no modules.
+type noModules struct {
+	*ModuleService
+}
+
+func (noModules) GetModules(context.Context, *connect.Request[v1.GetModulesRequest]) (*connect.Response[v1.GetModulesResponse], error) {
+	return connect.NewResponse(&v1.GetModulesResponse{}), nil
+}
+
+func TestMirror_EmptyUpstreamResponse(t *testing.T) {
+	up, upCleanup := setupTestService(t)
+	defer upCleanup()
+	api := createTestModule(t, up, "acme", "api", []registry.File{
+		{Path: "acme/api.proto", Content: `syntax = "proto3";`},
+	}, []string{"main"})
+
+	mux := http.NewServeMux()
+	mux.Handle(modulev1connect.NewCommitServiceHandler(NewCommitServiceV1(up)))
+	mux.Handle(modulev1connect.NewGraphServiceHandler(NewGraphServiceV1(up)))
+	mux.Handle(modulev1connect.NewDownloadServiceHandler(NewDownloadServiceV1(up)))
+	mux.Handle(modulev1connect.NewModuleServiceHandler(noModules{NewModuleService(up)}))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.mirrors = []*upstream{newUpstream(config.Mirror{Registry: "buf.build", URL: srv.URL, Owners: []string{"acme", "googleapis"}}, srv.Client())}
+
+	ctx := contextWithUser(context.Background(), "testuser")
+	_, err := svc.Upload(ctx, connect.NewRequest(&v1beta1.UploadRequest{
+		Contents: []*v1beta1.UploadRequest_Content{
+			{
+				ModuleRef: &v1beta1.ModuleRef{Value: &v1beta1.ModuleRef_Name_{Name: &v1beta1.ModuleRef_Name{Owner: "mine", Module: "app"}}},
+				Files:     []*v1beta1.File{{Path: "app.proto", Content: []byte(`syntax = "proto3";`)}},
+			},
+		},
+		DepRefs: []*v1beta1.UploadRequest_DepRef{{CommitId: api.ID, Registry: "buf.build"}},
+	}))
+	if connect.CodeOf(err) != connect.CodeInternal {
+		t.Errorf("expected Internal, got %v", err)
+	}
+}
+
+// tamperedDownloads is an upstream DownloadService that alters the content of every file.
+type tamperedDownloads struct {
+	*DownloadServiceV1
+}
+
+func (d tamperedDownloads) Download(ctx context.Context, req *connect.Request[v1.DownloadRequest]) (*connect.Response[v1.DownloadResponse], error) {
+	resp, err := d.DownloadServiceV1.Download(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, content := range resp.Msg.Contents {
+		for _, f := range content.Files {
+			f.Content = append(f.Content, "\nmessage Injected {}"...)
+		}
+	}
+	return resp, nil
+}
+
+func TestMirror_TamperedContent(t *testing.T) {
+	up, upCleanup := setupTestService(t)
+	defer upCleanup()
+	api := createTestModule(t, up, "acme", "api", []registry.File{
+		{Path: "acme/api.proto", Content: `syntax = "proto3";`},
+	}, []string{"main"})
+
+	mux := http.NewServeMux()
+	mux.Handle(modulev1connect.NewCommitServiceHandler(NewCommitServiceV1(up)))
+	mux.Handle(modulev1connect.NewGraphServiceHandler(NewGraphServiceV1(up)))
+	mux.Handle(modulev1connect.NewDownloadServiceHandler(tamperedDownloads{NewDownloadServiceV1(up)}))
+	mux.Handle(modulev1connect.NewModuleServiceHandler(NewModuleService(up)))
+	mux.Handle(ownerv1connect.NewOwnerServiceHandler(up))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.mirrors = []*upstream{newUpstream(config.Mirror{Registry: "buf.build", URL: srv.URL, Owners: []string{"acme"}}, srv.Client())}
+
+	// Content that doesn't hash to the upstream digest is not cached
+	ctx := contextWithUser(context.Background(), "testuser")
+	_, err := svc.Upload(ctx, connect.NewRequest(&v1beta1.UploadRequest{
+		Contents: []*v1beta1.UploadRequest_Content{
+			{
+				ModuleRef: &v1beta1.ModuleRef{Value: &v1beta1.ModuleRef_Name_{Name: &v1beta1.ModuleRef_Name{Owner: "mine", Module: "app"}}},
+				Files:     []*v1beta1.File{{Path: "app.proto", Content: []byte(`syntax = "proto3";`)}},
+			},
+		},
+		DepRefs: []*v1beta1.UploadRequest_DepRef{{CommitId: api.ID, Registry: "buf.build"}},
+	}))
+	if connect.CodeOf(err) != connect.CodeDataLoss {
+		t.Errorf("expected DataLoss, got %v", err)
+	}
+	if _, err := svc.casReg.CommitByID(ctx, api.ID); err == nil {
+		t.Error("tampered commit was stored")
+	}
+}
@@ -9,9 +9,19 @@ import (
 	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
 	ownerv1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/owner/v1"
 	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/registry"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+const (
+	defaultModulePageSize = 10
+	maxModulePageSize     = 250
+)
+
+// ModuleNamePrefixHeader optionally restricts ListModules to modules whose name starts with its value.
+// ListModulesRequest has no field for this filter.
+const ModuleNamePrefixHeader = "Pbr-Module-Name-Prefix"
+
 // ModuleService implements the v1 ModuleService interface by wrapping Service.
 type ModuleService struct {
 	svc *Service
@@ -57,37 +67,36 @@ func (m *ModuleService) GetModules(ctx context.Context, req *connect.Request[v1.
 	return resp, nil
 }
 
-func (m *ModuleService) getModuleByID(ctx context.Context, id string) (*v1.Module, error) {
-	mod, err := m.svc.casReg.ModuleByID(ctx, id)
-	if err != nil {
-		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("module not found: %s", id))
-	}
-
+func moduleToProto(mod *registry.Module) *v1.Module {
 	return &v1.Module{
 		Id:          mod.ID(),
 		OwnerId:     mod.OwnerID(),
 		Name:        mod.Name(),
 		Description: mod.Description(),
 		CreateTime:  timestamppb.New(mod.CreateTime()),
-	}, nil
+	}
+}
+
+func (m *ModuleService) getModuleByID(ctx context.Context, id string) (*v1.Module, error) {
+	mod, err := m.svc.casReg.ModuleByID(ctx, id)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("module not found: %s", id))
+	}
+
+	return moduleToProto(mod), nil
 }
 
 func (m *ModuleService) getModuleByName(ctx context.Context, owner, name string) (*v1.Module, error) {
-	mod, err := m.svc.casReg.Module(ctx, owner, name)
+	mod, err := m.svc.casReg.ModuleFollowingRedirect(ctx, owner, name)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("module not found: %s/%s", owner, name))
 	}
 
-	return &v1.Module{
-		Id:          mod.ID(),
-		OwnerId:     mod.OwnerID(),
-		Name:        mod.Name(),
-		Description: mod.Description(),
-		CreateTime:  timestamppb.New(mod.CreateTime()),
-	}, nil
+	return moduleToProto(mod), nil
 }
 
-// ListModules lists modules for a specific owner (v1 API).
+// ListModules lists modules for the given owners, or for all owners if none are given (v1 API).
+// The optional ModuleNamePrefixHeader request header restricts the listing to matching module names.
 func (m *ModuleService) ListModules(ctx context.Context, req *connect.Request[v1.ListModulesRequest]) (*connect.Response[v1.ListModulesResponse], error) {
 	if m.svc.casReg == nil {
 		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
@@ -95,42 +104,36 @@ func (m *ModuleService) ListModules(ctx context.Context, req *connect.Request[v1
 
 	slog.DebugContext(ctx, "ListModulesV1", "ownerRefs", len(req.Msg.OwnerRefs))
 
-	resp := connect.NewResponse(&v1.ListModulesResponse{})
-
-	// If no owner refs, list all (not supported yet)
-	if len(req.Msg.OwnerRefs) == 0 {
-		return resp, nil
+	query := registry.ModuleQuery{
+		NamePrefix: req.Header().Get(ModuleNamePrefixHeader),
+		Descending: req.Msg.Order != v1.ListModulesRequest_ORDER_CREATE_TIME_ASC,
+		PageSize:   int(req.Msg.PageSize),
+		PageToken:  req.Msg.PageToken,
 	}
+	if query.PageSize == 0 {
+		query.PageSize = defaultModulePageSize
+	}
+	query.PageSize = min(query.PageSize, maxModulePageSize)
 
 	for _, ownerRef := range req.Msg.OwnerRefs {
-		var ownerName string
-
-		switch r := ownerRef.Value.(type) {
-		case *ownerv1.OwnerRef_Id:
-			// For ID lookup, we need to find owner by ID
-			// Since we don't have OwnerByID, skip for now
-			continue
-		case *ownerv1.OwnerRef_Name:
-			ownerName = r.Name
-		default:
-			continue
-		}
-
-		modules, err := m.svc.casReg.ListModules(ctx, ownerName)
+		owner, err := m.svc.ownerFromRef(ctx, ownerRef)
 		if err != nil {
-			slog.ErrorContext(ctx, "failed to list modules", "owner", ownerName, "error", err)
-			continue
+			return nil, err
 		}
+		query.OwnerIDs = append(query.OwnerIDs, owner.ID)
+	}
 
-		for _, mod := range modules {
-			resp.Msg.Modules = append(resp.Msg.Modules, &v1.Module{
-				Id:          mod.ID(),
-				OwnerId:     mod.OwnerID(),
-				Name:        mod.Name(),
-				Description: mod.Description(),
-				CreateTime:  timestamppb.New(mod.CreateTime()),
-			})
-		}
+	modules, next, err := m.svc.casReg.QueryModules(ctx, query)
+	if errors.Is(err, registry.ErrInvalidPageToken) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	resp := connect.NewResponse(&v1.ListModulesResponse{NextPageToken: next})
+	for _, mod := range modules {
+		resp.Msg.Modules = append(resp.Msg.Modules, moduleToProto(mod))
 	}
 
 	return resp, nil
@@ -147,12 +150,15 @@ func (m *ModuleService) CreateModules(ctx context.Context, req *connect.Request[
 	resp := connect.NewResponse(&v1.CreateModulesResponse{})
 
 	for _, value := range req.Msg.Values {
-		// Resolve owner
+		// Resolve owner. Owners referenced by name are created on demand, owners referenced by ID must exist.
 		var ownerName string
-		switch r := value.OwnerRef.Value.(type) {
+		switch r := value.OwnerRef.GetValue().(type) {
 		case *ownerv1.OwnerRef_Id:
-			// For ID lookup, we'd need OwnerByID - skip for now
-			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("owner lookup by ID not supported"))
+			owner, err := m.svc.ownerFromRef(ctx, value.OwnerRef)
+			if err != nil {
+				return nil, err
+			}
+			ownerName = owner.Name
 		case *ownerv1.OwnerRef_Name:
 			ownerName = r.Name
 		default:
@@ -162,17 +168,17 @@ func (m *ModuleService) CreateModules(ctx context.Context, req *connect.Request[
 		description := value.Description
 
 		mod, err := m.svc.casReg.CreateModule(ctx, ownerName, value.Name, description)
+		if errors.Is(err, registry.ErrModuleDeleted) {
+			return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("module %s/%s is deleted and can be restored until it is purged", ownerName, value.Name))
+		}
+		if errors.Is(err, registry.ErrModuleRenamed) {
+			return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("module %s/%s: %w", ownerName, value.Name, err))
+		}
 		if err != nil {
 			return nil, connect.NewError(connect.CodeInternal, err)
 		}
 
-		resp.Msg.Modules = append(resp.Msg.Modules, &v1.Module{
-			Id:          mod.ID(),
-			OwnerId:     mod.OwnerID(),
-			Name:        mod.Name(),
-			Description: mod.Description(),
-			CreateTime:  timestamppb.New(mod.CreateTime()),
-		})
+		resp.Msg.Modules = append(resp.Msg.Modules, moduleToProto(mod))
 	}
 
 	return resp, nil
@@ -217,7 +223,9 @@ func (m *ModuleService) UpdateModules(ctx context.Context, req *connect.Request[
 	return resp, nil
 }
 
-// DeleteModules deletes existing modules (v1 API).
+// DeleteModules deletes existing modules (v1 API). Deleted modules can be restored
+// by the admin until their grace period ends. Modules that commits of other modules
+// depend on are checked as configured; either all modules are deleted or none is.
 func (m *ModuleService) DeleteModules(ctx context.Context, req *connect.Request[v1.DeleteModulesRequest]) (*connect.Response[v1.DeleteModulesResponse], error) {
 	if m.svc.casReg == nil {
 		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
@@ -227,29 +235,34 @@ func (m *ModuleService) DeleteModules(ctx context.Context, req *connect.Request[
 
 	resp := connect.NewResponse(&v1.DeleteModulesResponse{})
 
+	var mods []*registry.Module
 	for _, ref := range req.Msg.ModuleRefs {
-		var owner, name string
+		var mod *registry.Module
+		var err error
 
 		switch r := ref.Value.(type) {
 		case *v1.ModuleRef_Id:
-			mod, err := m.svc.casReg.ModuleByID(ctx, r.Id)
-			if err != nil {
-				return nil, connect.NewError(connect.CodeNotFound, err)
-			}
-			owner = mod.Owner()
-			name = mod.Name()
+			mod, err = m.svc.casReg.ModuleByID(ctx, r.Id)
 		case *v1.ModuleRef_Name_:
-			if r.Name != nil {
-				owner = r.Name.Owner
-				name = r.Name.Module
-			} else {
+			if r.Name == nil {
 				return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("module name is nil"))
 			}
+			mod, err = m.svc.casReg.Module(ctx, r.Name.Owner, r.Name.Module)
 		default:
 			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("unknown module ref type"))
 		}
+		if err != nil {
+			return nil, connect.NewError(connect.CodeNotFound, err)
+		}
+		mods = append(mods, mod)
+	}
+
+	if err := m.svc.checkDependents(ctx, resp.Header(), mods); err != nil {
+		return nil, err
+	}
 
-		if err := m.svc.casReg.DeleteModule(ctx, owner, name); err != nil {
+	for _, mod := range mods {
+		if _, err := m.svc.casReg.SoftDeleteModule(ctx, mod.Owner(), mod.Name()); err != nil {
 			return nil, connect.NewError(connect.CodeInternal, err)
 		}
 	}
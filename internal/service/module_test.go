@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	ownerv1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/owner/v1"
+	"connectrpc.com/connect"
+)
+
+func createModules(t *testing.T, modSvc *ModuleService, owner string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		_, err := modSvc.CreateModules(context.Background(), connect.NewRequest(&v1.CreateModulesRequest{
+			Values: []*v1.CreateModulesRequest_Value{{
+				OwnerRef: &ownerv1.OwnerRef{Value: &ownerv1.OwnerRef_Name{Name: owner}},
+				Name:     name,
+			}},
+		}))
+		if err != nil {
+			t.Fatalf("CreateModules %s/%s failed: %v", owner, name, err)
+		}
+	}
+}
+
+func moduleNames(modules []*v1.Module) []string {
+	names := make([]string, len(modules))
+	for i, m := range modules {
+		names[i] = m.Name
+	}
+	return names
+}
+
+func TestModuleService_OwnerByID(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	modSvc := NewModuleService(svc)
+	createModules(t, modSvc, "acme", "petapis")
+
+	owner, err := svc.casReg.OwnerByName(ctx, "acme")
+	if err != nil {
+		t.Fatalf("OwnerByName failed: %v", err)
+	}
+	byID := &ownerv1.OwnerRef{Value: &ownerv1.OwnerRef_Id{Id: owner.ID}}
+
+	created, err := modSvc.CreateModules(ctx, connect.NewRequest(&v1.CreateModulesRequest{
+		Values: []*v1.CreateModulesRequest_Value{{OwnerRef: byID, Name: "storeapis"}},
+	}))
+	if err != nil {
+		t.Fatalf("CreateModules by owner ID failed: %v", err)
+	}
+	if created.Msg.Modules[0].OwnerId != owner.ID {
+		t.Errorf("owner ID = %s, want %s", created.Msg.Modules[0].OwnerId, owner.ID)
+	}
+
+	list, err := modSvc.ListModules(ctx, connect.NewRequest(&v1.ListModulesRequest{
+		OwnerRefs: []*ownerv1.OwnerRef{byID},
+		Order:     v1.ListModulesRequest_ORDER_CREATE_TIME_ASC,
+	}))
+	if err != nil {
+		t.Fatalf("ListModules by owner ID failed: %v", err)
+	}
+	if names := moduleNames(list.Msg.Modules); len(names) != 2 || names[0] != "petapis" || names[1] != "storeapis" {
+		t.Errorf("unexpected modules: %v", names)
+	}
+
+	unknown := &ownerv1.OwnerRef{Value: &ownerv1.OwnerRef_Id{Id: "doesnotexist"}}
+	_, err = modSvc.CreateModules(ctx, connect.NewRequest(&v1.CreateModulesRequest{
+		Values: []*v1.CreateModulesRequest_Value{{OwnerRef: unknown, Name: "x"}},
+	}))
+	if connect.CodeOf(err) != connect.CodeNotFound {
+		t.Errorf("expected CodeNotFound for CreateModules, got %v", err)
+	}
+	_, err = modSvc.ListModules(ctx, connect.NewRequest(&v1.ListModulesRequest{
+		OwnerRefs: []*ownerv1.OwnerRef{unknown},
+	}))
+	if connect.CodeOf(err) != connect.CodeNotFound {
+		t.Errorf("expected CodeNotFound for ListModules, got %v", err)
+	}
+}
+
+func TestModuleService_ListAllModules(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	modSvc := NewModuleService(svc)
+	createModules(t, modSvc, "acme", "petapis", "storeapis")
+	createModules(t, modSvc, "globex", "petapis", "billing")
+
+	// Newest first by default, paged
+	var names []string
+	token := ""
+	pages := 0
+	for {
+		resp, err := modSvc.ListModules(ctx, connect.NewRequest(&v1.ListModulesRequest{
+			PageSize:  3,
+			PageToken: token,
+		}))
+		if err != nil {
+			t.Fatalf("ListModules failed: %v", err)
+		}
+		names = append(names, moduleNames(resp.Msg.Modules)...)
+		pages++
+		token = resp.Msg.NextPageToken
+		if token == "" {
+			break
+		}
+	}
+	want := []string{"billing", "petapis", "storeapis", "petapis"}
+	if pages != 2 || len(names) != len(want) {
+		t.Fatalf("got %v in %d pages, want %v in 2 pages", names, pages, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("names[%d] = %s, want %s", i, names[i], want[i])
+		}
+	}
+
+	// Name prefix filter, optionally qualified by owner
+	for prefix, want := range map[string]int{"pet": 2, "globex/pet": 1, "none": 0} {
+		req := connect.NewRequest(&v1.ListModulesRequest{})
+		req.Header().Set(ModuleNamePrefixHeader, prefix)
+		resp, err := modSvc.ListModules(ctx, req)
+		if err != nil {
+			t.Fatalf("ListModules failed: %v", err)
+		}
+		if len(resp.Msg.Modules) != want {
+			t.Errorf("prefix %q: got %d modules, want %d", prefix, len(resp.Msg.Modules), want)
+		}
+	}
+}
+
+func TestModuleService_ListModules_PageToken(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	modSvc := NewModuleService(svc)
+	createModules(t, modSvc, "acme", "a", "b", "c", "d")
+
+	list := func(token string) (*v1.ListModulesResponse, error) {
+		resp, err := modSvc.ListModules(ctx, connect.NewRequest(&v1.ListModulesRequest{
+			PageSize:  2,
+			PageToken: token,
+			Order:     v1.ListModulesRequest_ORDER_CREATE_TIME_ASC,
+		}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.Msg, nil
+	}
+	first, err := list("")
+	if err != nil {
+		t.Fatalf("ListModules failed: %v", err)
+	}
+
+	// Deleting the last module of a page doesn't restart the listing
+	if _, err := svc.casReg.SoftDeleteModule(ctx, "acme", "b"); err != nil {
+		t.Fatalf("SoftDeleteModule failed: %v", err)
+	}
+	second, err := list(first.NextPageToken)
+	if err != nil {
+		t.Fatalf("ListModules failed: %v", err)
+	}
+	if got := moduleNames(second.Modules); len(got) != 2 || got[0] != "c" || got[1] != "d" {
+		t.Errorf("second page = %v, want [c d]", got)
+	}
+
+	if _, err := list("bogus"); connect.CodeOf(err) != connect.CodeInvalidArgument {
+		t.Errorf("expected CodeInvalidArgument for an invalid token, got %v", err)
+	}
+}
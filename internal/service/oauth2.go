@@ -4,12 +4,15 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/greatliontech/pbr/internal/registry"
 )
 
 const (
@@ -336,6 +339,7 @@ func (o *OAuth2Service) handleDeviceToken(w http.ResponseWriter, r *http.Request
 	userInfo, err := o.oidc.GetUserInfo(r.Context(), oidcTokenResp.AccessToken)
 	if err != nil {
 		slog.Error("Failed to get user info", "error", err)
+		o.svc.recordLogin(r.Context(), "", r, err)
 		writeOAuth2Error(w, http.StatusInternalServerError, "server_error", "failed to get user info")
 		return
 	}
@@ -347,6 +351,19 @@ func (o *OAuth2Service) handleDeviceToken(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Provision the user owner on first login. A name taken by an organization is refused,
+	// logging in must not take over its modules.
+	if o.svc.casReg != nil {
+		if _, err := o.svc.casReg.GetOrCreateUser(r.Context(), username); errors.Is(err, registry.ErrOwnerIsOrganization) {
+			slog.Warn("Login refused, username is an organization", "username", username)
+			o.svc.recordLogin(r.Context(), username, r, err)
+			writeOAuth2Error(w, http.StatusForbidden, "access_denied", "username is taken by an organization")
+			return
+		} else if err != nil {
+			slog.Error("Failed to provision user", "username", username, "error", err)
+		}
+	}
+
 	// Generate a PBR token for this user
 	pbrToken := generateRandomString(64)
 	expiresAt := time.Now().Add(o.svc.conf.GetTokenTTL())
@@ -366,6 +383,8 @@ func (o *OAuth2Service) handleDeviceToken(w http.ResponseWriter, r *http.Request
 
 	slog.Info("User authenticated via OIDC", "username", username, "expires_at", expiresAt)
 
+	o.svc.recordLogin(r.Context(), username, r, nil)
+
 	// Return our PBR token instead of the OIDC token
 	pbrResp := DeviceAccessTokenResponse{
 		AccessToken: pbrToken,
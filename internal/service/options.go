@@ -0,0 +1,27 @@
+package service
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithAuthenticator replaces the default token authenticator.
+func WithAuthenticator(a Authenticator) Option {
+	return func(svc *Service) {
+		svc.authenticator = a
+	}
+}
+
+// WithAuthorizer replaces the default authorizer, which allows every authenticated request.
+func WithAuthorizer(a Authorizer) Option {
+	return func(svc *Service) {
+		svc.authorizer = a
+	}
+}
+
+// WithRemoteCommitFetcher replaces how commits of other registries are looked up
+// when they are uploaded as dependencies. By default they are fetched over HTTPS from
+// the configured remote registries, and not at all if there are none.
+func WithRemoteCommitFetcher(f RemoteCommitFetcher) Option {
+	return func(svc *Service) {
+		svc.remoteCommits = f
+	}
+}
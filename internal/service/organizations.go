@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/owner/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// OrganizationService implements the v1 owner OrganizationService interface by wrapping Service.
+type OrganizationService struct {
+	svc *Service
+}
+
+// NewOrganizationService creates a new v1 OrganizationService wrapper.
+func NewOrganizationService(svc *Service) *OrganizationService {
+	return &OrganizationService{svc: svc}
+}
+
+// GetOrganizations retrieves organizations by id or name.
+func (o *OrganizationService) GetOrganizations(ctx context.Context, req *connect.Request[v1.GetOrganizationsRequest]) (*connect.Response[v1.GetOrganizationsResponse], error) {
+	if o.svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+
+	resp := connect.NewResponse(&v1.GetOrganizationsResponse{})
+	for _, ref := range req.Msg.OrganizationRefs {
+		record, err := o.organizationByRef(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		resp.Msg.Organizations = append(resp.Msg.Organizations, organizationToProto(record))
+	}
+	return resp, nil
+}
+
+// ListOrganizations lists all organizations. Organization membership is not modeled, so filtering by user is not supported.
+func (o *OrganizationService) ListOrganizations(ctx context.Context, req *connect.Request[v1.ListOrganizationsRequest]) (*connect.Response[v1.ListOrganizationsResponse], error) {
+	if o.svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+	if len(req.Msg.UserRefs) > 0 {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("organization membership is not supported"))
+	}
+
+	records, err := o.svc.casReg.ListOwnersByType(ctx, storage.OwnerTypeOrganization)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	page, next := pageOwners(records, req.Msg.Order != v1.ListOrganizationsRequest_ORDER_CREATE_TIME_ASC, req.Msg.PageSize, req.Msg.PageToken)
+
+	resp := connect.NewResponse(&v1.ListOrganizationsResponse{NextPageToken: next})
+	for _, r := range page {
+		resp.Msg.Organizations = append(resp.Msg.Organizations, organizationToProto(r))
+	}
+	return resp, nil
+}
+
+// CreateOrganizations creates organizations.
+func (o *OrganizationService) CreateOrganizations(ctx context.Context, req *connect.Request[v1.CreateOrganizationsRequest]) (*connect.Response[v1.CreateOrganizationsResponse], error) {
+	if o.svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+
+	slog.DebugContext(ctx, "CreateOrganizations", "values", len(req.Msg.Values))
+
+	// Validate all values first so that either all organizations are created or none
+	for _, value := range req.Msg.Values {
+		if value.Name == "" {
+			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("organization name is required"))
+		}
+		if _, err := o.svc.casReg.OwnerByName(ctx, value.Name); err == nil {
+			return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("owner already exists: %s", value.Name))
+		}
+	}
+
+	resp := connect.NewResponse(&v1.CreateOrganizationsResponse{})
+	for _, value := range req.Msg.Values {
+		record, err := o.svc.casReg.CreateOwner(ctx, &storage.OwnerRecord{
+			Name:        value.Name,
+			Type:        storage.OwnerTypeOrganization,
+			Description: value.Description,
+			URL:         value.Url,
+		})
+		if err == storage.ErrAlreadyExists {
+			return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("owner already exists: %s", value.Name))
+		}
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		resp.Msg.Organizations = append(resp.Msg.Organizations, organizationToProto(record))
+	}
+	return resp, nil
+}
+
+// UpdateOrganizations updates organizations. Organization membership is not modeled, so only the admin may update them.
+func (o *OrganizationService) UpdateOrganizations(ctx context.Context, req *connect.Request[v1.UpdateOrganizationsRequest]) (*connect.Response[v1.UpdateOrganizationsResponse], error) {
+	if o.svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+	if user := userFromContext(ctx); user != "" && user != adminUsername {
+		return nil, connect.NewError(connect.CodePermissionDenied, errors.New("only the admin may update organizations"))
+	}
+
+	slog.DebugContext(ctx, "UpdateOrganizations", "values", len(req.Msg.Values))
+
+	records := make([]*storage.OwnerRecord, 0, len(req.Msg.Values))
+	for _, value := range req.Msg.Values {
+		record, err := o.organizationByRef(ctx, value.OrganizationRef)
+		if err != nil {
+			return nil, err
+		}
+		if value.Description != nil {
+			record.Description = *value.Description
+		}
+		if value.Url != nil {
+			record.URL = *value.Url
+		}
+		records = append(records, record)
+	}
+
+	resp := connect.NewResponse(&v1.UpdateOrganizationsResponse{})
+	for _, record := range records {
+		if err := o.svc.casReg.UpdateOwner(ctx, record); err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		resp.Msg.Organizations = append(resp.Msg.Organizations, organizationToProto(record))
+	}
+	return resp, nil
+}
+
+// DeleteOrganizations deletes organizations that own no modules. Only the admin may delete them.
+func (o *OrganizationService) DeleteOrganizations(ctx context.Context, req *connect.Request[v1.DeleteOrganizationsRequest]) (*connect.Response[v1.DeleteOrganizationsResponse], error) {
+	if o.svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+	if user := userFromContext(ctx); user != "" && user != adminUsername {
+		return nil, connect.NewError(connect.CodePermissionDenied, errors.New("only the admin may delete organizations"))
+	}
+
+	slog.DebugContext(ctx, "DeleteOrganizations", "organizationRefs", len(req.Msg.OrganizationRefs))
+
+	records := map[string]*storage.OwnerRecord{}
+	for _, ref := range req.Msg.OrganizationRefs {
+		record, err := o.organizationByRef(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		records[record.ID] = record
+	}
+
+	for _, record := range records {
+		if err := o.svc.casReg.DeleteOwner(ctx, record.ID); err != nil {
+			return nil, deleteOwnerError(record.Name, err)
+		}
+	}
+	return connect.NewResponse(&v1.DeleteOrganizationsResponse{}), nil
+}
+
+func (o *OrganizationService) organizationByRef(ctx context.Context, ref *v1.OrganizationRef) (*storage.OwnerRecord, error) {
+	switch r := ref.GetValue().(type) {
+	case *v1.OrganizationRef_Id:
+		return o.svc.ownerByIDOrName(ctx, storage.OwnerTypeOrganization, r.Id, "")
+	case *v1.OrganizationRef_Name:
+		return o.svc.ownerByIDOrName(ctx, storage.OwnerTypeOrganization, "", r.Name)
+	default:
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("invalid organization ref"))
+	}
+}
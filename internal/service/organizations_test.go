@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/owner/v1"
+	"connectrpc.com/connect"
+)
+
+func TestOrganizationService(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	orgSvc := NewOrganizationService(svc)
+
+	created, err := orgSvc.CreateOrganizations(ctx, connect.NewRequest(&v1.CreateOrganizationsRequest{
+		Values: []*v1.CreateOrganizationsRequest_Value{{Name: "acme"}, {Name: "globex"}},
+	}))
+	if err != nil {
+		t.Fatalf("CreateOrganizations failed: %v", err)
+	}
+	if len(created.Msg.Organizations) != 2 {
+		t.Fatalf("expected 2 organizations, got %d", len(created.Msg.Organizations))
+	}
+
+	// Names are unique across users and organizations
+	if _, err := svc.casReg.GetOrCreateUser(ctx, "alice"); err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	_, err = orgSvc.CreateOrganizations(ctx, connect.NewRequest(&v1.CreateOrganizationsRequest{
+		Values: []*v1.CreateOrganizationsRequest_Value{{Name: "initech"}, {Name: "alice"}},
+	}))
+	if connect.CodeOf(err) != connect.CodeAlreadyExists {
+		t.Fatalf("expected CodeAlreadyExists, got %v", err)
+	}
+	if _, err := svc.casReg.OwnerByName(ctx, "initech"); err == nil {
+		t.Error("expected no organization to be created when one value fails")
+	}
+
+	// Only the admin may update and delete organizations
+	admin := contextWithUser(ctx, adminUsername)
+	bob := contextWithUser(ctx, "bob")
+	desc := "Acme Corp"
+	update := &v1.UpdateOrganizationsRequest{
+		Values: []*v1.UpdateOrganizationsRequest_Value{
+			{OrganizationRef: &v1.OrganizationRef{Value: &v1.OrganizationRef_Id{Id: created.Msg.Organizations[0].Id}}, Description: &desc},
+		},
+	}
+	if _, err := orgSvc.UpdateOrganizations(bob, connect.NewRequest(update)); connect.CodeOf(err) != connect.CodePermissionDenied {
+		t.Fatalf("expected CodePermissionDenied, got %v", err)
+	}
+	updated, err := orgSvc.UpdateOrganizations(admin, connect.NewRequest(update))
+	if err != nil {
+		t.Fatalf("UpdateOrganizations failed: %v", err)
+	}
+	if updated.Msg.Organizations[0].Description != desc {
+		t.Errorf("description = %q, want %q", updated.Msg.Organizations[0].Description, desc)
+	}
+
+	list, err := orgSvc.ListOrganizations(ctx, connect.NewRequest(&v1.ListOrganizationsRequest{}))
+	if err != nil {
+		t.Fatalf("ListOrganizations failed: %v", err)
+	}
+	if len(list.Msg.Organizations) != 2 {
+		t.Errorf("expected 2 organizations, got %d", len(list.Msg.Organizations))
+	}
+
+	del := &v1.DeleteOrganizationsRequest{
+		OrganizationRefs: []*v1.OrganizationRef{{Value: &v1.OrganizationRef_Name{Name: "globex"}}},
+	}
+	if _, err := orgSvc.DeleteOrganizations(bob, connect.NewRequest(del)); connect.CodeOf(err) != connect.CodePermissionDenied {
+		t.Fatalf("expected CodePermissionDenied, got %v", err)
+	}
+	if _, err := orgSvc.DeleteOrganizations(admin, connect.NewRequest(del)); err != nil {
+		t.Fatalf("DeleteOrganizations failed: %v", err)
+	}
+	_, err = orgSvc.GetOrganizations(ctx, connect.NewRequest(&v1.GetOrganizationsRequest{
+		OrganizationRefs: []*v1.OrganizationRef{{Value: &v1.OrganizationRef_Name{Name: "globex"}}},
+	}))
+	if connect.CodeOf(err) != connect.CodeNotFound {
+		t.Fatalf("expected CodeNotFound, got %v", err)
+	}
+}
@@ -5,9 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 
 	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/owner/v1"
 	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // Get Users or Organizations by id or name.
@@ -29,36 +34,14 @@ func (svc *Service) GetOwners(ctx context.Context, req *connect.Request[v1.GetOw
 			if err != nil {
 				return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("owner not found: %s", ref.Id))
 			}
-			owner = &v1.Owner{
-				Value: &v1.Owner_Organization{
-					Organization: &v1.Organization{
-						Id:   ownerRecord.ID,
-						Name: ownerRecord.Name,
-					},
-				},
-			}
+			owner = ownerToProto(ownerRecord)
 		case *v1.OwnerRef_Name:
 			slog.Debug("GetOwners by name", "name", ref.Name)
 			ownerRecord, err := svc.casReg.OwnerByName(ctx, ref.Name)
 			if err != nil {
-				// Owner might not exist in metadata yet, create a virtual one
-				owner = &v1.Owner{
-					Value: &v1.Owner_Organization{
-						Organization: &v1.Organization{
-							Name: ref.Name,
-						},
-					},
-				}
-			} else {
-				owner = &v1.Owner{
-					Value: &v1.Owner_Organization{
-						Organization: &v1.Organization{
-							Id:   ownerRecord.ID,
-							Name: ownerRecord.Name,
-						},
-					},
-				}
+				return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("owner not found: %s", ref.Name))
 			}
+			owner = ownerToProto(ownerRecord)
 		default:
 			err = errors.New("unknown owner reference type")
 		}
@@ -72,3 +55,132 @@ func (svc *Service) GetOwners(ctx context.Context, req *connect.Request[v1.GetOw
 
 	return resp, nil
 }
+
+// ownerToProto converts an owner record to a User or Organization owner.
+func ownerToProto(record *storage.OwnerRecord) *v1.Owner {
+	if record.Type == storage.OwnerTypeUser {
+		return &v1.Owner{Value: &v1.Owner_User{User: userToProto(record)}}
+	}
+	return &v1.Owner{Value: &v1.Owner_Organization{Organization: organizationToProto(record)}}
+}
+
+func userToProto(record *storage.OwnerRecord) *v1.User {
+	state := v1.UserState_USER_STATE_ACTIVE
+	if record.Deactivated {
+		state = v1.UserState_USER_STATE_INACTIVE
+	}
+	return &v1.User{
+		Id:          record.ID,
+		CreateTime:  timestamppb.New(record.CreateTime),
+		UpdateTime:  timestamppb.New(record.UpdateTime),
+		Name:        record.Name,
+		Type:        v1.UserType_USER_TYPE_STANDARD,
+		State:       state,
+		Description: record.Description,
+		Url:         record.URL,
+	}
+}
+
+func organizationToProto(record *storage.OwnerRecord) *v1.Organization {
+	return &v1.Organization{
+		Id:          record.ID,
+		CreateTime:  timestamppb.New(record.CreateTime),
+		UpdateTime:  timestamppb.New(record.UpdateTime),
+		Name:        record.Name,
+		Description: record.Description,
+		Url:         record.URL,
+	}
+}
+
+// ownerFromRef looks up a user or organization, returning CodeNotFound if there is none.
+func (svc *Service) ownerFromRef(ctx context.Context, ref *v1.OwnerRef) (*storage.OwnerRecord, error) {
+	var record *storage.OwnerRecord
+	var err error
+	switch r := ref.GetValue().(type) {
+	case *v1.OwnerRef_Id:
+		record, err = svc.casReg.Owner(ctx, r.Id)
+	case *v1.OwnerRef_Name:
+		record, err = svc.casReg.OwnerByName(ctx, r.Name)
+	default:
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("invalid owner ref"))
+	}
+	if err == storage.ErrNotFound {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("owner not found: %s", ref.GetId()+ref.GetName()))
+	}
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return record, nil
+}
+
+// ownerByIDOrName looks up an owner of the given type, returning CodeNotFound if there is none.
+func (svc *Service) ownerByIDOrName(ctx context.Context, ownerType storage.OwnerType, id, name string) (*storage.OwnerRecord, error) {
+	var record *storage.OwnerRecord
+	var err error
+	if id != "" {
+		record, err = svc.casReg.Owner(ctx, id)
+	} else {
+		record, err = svc.casReg.OwnerByName(ctx, name)
+	}
+	if err == storage.ErrNotFound || (err == nil && record.Type != ownerType) {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("%s not found: %s", ownerType, id+name))
+	}
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return record, nil
+}
+
+// pageOwners sorts owners by creation time and returns one page, continuing after the owner ID in pageToken.
+func pageOwners(owners []*storage.OwnerRecord, desc bool, pageSize uint32, pageToken string) ([]*storage.OwnerRecord, string) {
+	slices.SortFunc(owners, func(a, b *storage.OwnerRecord) int {
+		c := a.CreateTime.Compare(b.CreateTime)
+		if c == 0 {
+			c = strings.Compare(a.ID, b.ID)
+		}
+		if desc {
+			return -c
+		}
+		return c
+	})
+
+	if pageSize == 0 || pageSize > 250 {
+		pageSize = 250
+	}
+
+	start := 0
+	if pageToken != "" {
+		for i, o := range owners {
+			if o.ID == pageToken {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	end := min(start+int(pageSize), len(owners))
+	if start >= end {
+		return nil, ""
+	}
+	var next string
+	if end < len(owners) {
+		next = owners[end-1].ID
+	}
+	return owners[start:end], next
+}
+
+// deleteOwnerError maps registry errors from owner deletion to connect errors.
+func deleteOwnerError(name string, err error) error {
+	if errors.Is(err, registry.ErrOwnerHasModules) {
+		return connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("%s still owns modules", name))
+	}
+	return connect.NewError(connect.CodeInternal, err)
+}
+
+// provisionUserError maps registry errors from user provisioning to connect errors.
+func provisionUserError(err error) error {
+	if errors.Is(err, registry.ErrOwnerIsOrganization) {
+		return connect.NewError(connect.CodeAlreadyExists, err)
+	}
+	return connect.NewError(connect.CodeInternal, err)
+}
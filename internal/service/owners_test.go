@@ -52,7 +52,7 @@ func TestGetOwners_EmptyRequest(t *testing.T) {
 	}
 }
 
-func TestGetOwners_ByName_NewOwner(t *testing.T) {
+func TestGetOwners_ByName_NotFound(t *testing.T) {
 	svc, cleanup := setupTestService(t)
 	defer cleanup()
 
@@ -67,23 +67,9 @@ func TestGetOwners_ByName_NewOwner(t *testing.T) {
 		},
 	})
 
-	resp, err := svc.GetOwners(ctx, req)
-	if err != nil {
-		t.Fatalf("GetOwners failed: %v", err)
-	}
-
-	if len(resp.Msg.Owners) != 1 {
-		t.Fatalf("expected 1 owner, got %d", len(resp.Msg.Owners))
-	}
-
-	owner := resp.Msg.Owners[0]
-	org, ok := owner.Value.(*v1.Owner_Organization)
-	if !ok {
-		t.Fatalf("expected organization, got %T", owner.Value)
-	}
-
-	if org.Organization.Name != "newowner" {
-		t.Errorf("expected name 'newowner', got %q", org.Organization.Name)
+	_, err := svc.GetOwners(ctx, req)
+	if connect.CodeOf(err) != connect.CodeNotFound {
+		t.Fatalf("expected CodeNotFound, got %v", err)
 	}
 }
 
@@ -318,18 +304,28 @@ func TestGetOwners_MixedRefs(t *testing.T) {
 			},
 			{
 				Value: &v1.OwnerRef_Name{
-					Name: "virtualowner",
+					Name: "someuser",
 				},
 			},
 		},
 	})
 
+	if _, err := svc.casReg.GetOrCreateUser(ctx, "someuser"); err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
 	resp, err := svc.GetOwners(ctx, req)
 	if err != nil {
 		t.Fatalf("GetOwners failed: %v", err)
 	}
 
 	if len(resp.Msg.Owners) != 2 {
-		t.Errorf("expected 2 owners, got %d", len(resp.Msg.Owners))
+		t.Fatalf("expected 2 owners, got %d", len(resp.Msg.Owners))
+	}
+	if resp.Msg.Owners[0].GetOrganization() == nil {
+		t.Errorf("expected organization, got %v", resp.Msg.Owners[0])
+	}
+	if user := resp.Msg.Owners[1].GetUser(); user == nil || user.Name != "someuser" {
+		t.Errorf("expected user someuser, got %v", resp.Msg.Owners[1])
 	}
 }
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"buf.build/gen/go/bufbuild/registry/connectrpc/go/buf/registry/module/v1/modulev1connect"
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	"connectrpc.com/connect"
+)
+
+// RemoteCommitFetcher looks up commits hosted by other registries, so that
+// dependencies on them can be recorded with their module and digest.
+type RemoteCommitFetcher interface {
+	FetchCommit(ctx context.Context, registry, commitID string) (*v1.Commit, error)
+}
+
+// upstreamTimeout bounds each request to another registry.
+const upstreamTimeout = time.Minute
+
+// httpCommitFetcher queries the v1 CommitService of the remote registry over HTTPS.
+// Only the configured registries are contacted.
+type httpCommitFetcher struct {
+	client     *http.Client
+	registries []string
+}
+
+func (f *httpCommitFetcher) FetchCommit(ctx context.Context, registry, commitID string) (*v1.Commit, error) {
+	if !slices.Contains(f.registries, registry) {
+		return nil, fmt.Errorf("registry %s is not configured in remote_registries", registry)
+	}
+	client := modulev1connect.NewCommitServiceClient(f.client, "https://"+registry)
+	resp, err := client.GetCommits(ctx, connect.NewRequest(&v1.GetCommitsRequest{
+		ResourceRefs: []*v1.ResourceRef{{Value: &v1.ResourceRef_Id{Id: commitID}}},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Msg.Commits) != 1 {
+		return nil, fmt.Errorf("expected 1 commit from %s, got %d", registry, len(resp.Msg.Commits))
+	}
+	return resp.Msg.Commits[0], nil
+}
+
+// isLocalRegistry reports whether registry refers to this registry.
+func (svc *Service) isLocalRegistry(registry string) bool {
+	return registry == "" || registry == svc.conf.Host
+}
+
+// remoteCommit fetches a commit of another registry.
+func (svc *Service) remoteCommit(ctx context.Context, registry, commitID string) (*v1.Commit, error) {
+	if svc.remoteCommits == nil {
+		return nil, errors.New("no remote registries are configured")
+	}
+	return svc.remoteCommits.FetchCommit(ctx, registry, commitID)
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// RenamesPath is the admin endpoint renaming modules, and listing the redirects of their
+// former names.
+const RenamesPath = "/admin/renames"
+
+// redirectReport is a redirect of a former module name, as served by the renames endpoint.
+type redirectReport struct {
+	From       string    `json:"from"`
+	To         string    `json:"to,omitempty"` // empty if the module no longer exists
+	CreateTime time.Time `json:"create_time"`
+	ExpireTime time.Time `json:"expire_time"`
+}
+
+// renamedModule is the response of a rename by the renames endpoint.
+type renamedModule struct {
+	ID             string `json:"id"`
+	Module         string `json:"module"`
+	RedirectPeriod string `json:"redirect_period"` // "0s" if no redirect was left
+}
+
+// parseModuleName splits an "owner/name" module name.
+func parseModuleName(s string) (owner, name string, ok bool) {
+	owner, name, ok = strings.Cut(s, "/")
+	return owner, name, ok && owner != "" && name != "" && !strings.Contains(name, "/")
+}
+
+// renamesHandler serves the renames endpoint for the admin user. GET lists the redirects
+// of renamed modules. POST renames the module given by the module query parameter to the
+// name given by the to parameter, both as "owner/name".
+func (svc *Service) renamesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if svc.casReg == nil {
+			http.Error(w, "CAS storage not configured", http.StatusNotImplemented)
+			return
+		}
+		if !svc.requireAdmin(w, r) {
+			return
+		}
+		ctx := r.Context()
+
+		if r.Method == http.MethodGet {
+			redirects, err := svc.casReg.ListRedirects(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to list redirects", "error", err)
+				http.Error(w, "failed to list redirects", http.StatusInternalServerError)
+				return
+			}
+			reports := make([]redirectReport, 0, len(redirects))
+			for _, redirect := range redirects {
+				report := redirectReport{
+					From:       redirect.Owner + "/" + redirect.Name,
+					CreateTime: redirect.CreateTime,
+					ExpireTime: redirect.ExpireTime,
+				}
+				if mod, err := svc.casReg.ModuleByID(ctx, redirect.ModuleID); err == nil {
+					report.To = mod.Owner() + "/" + mod.Name()
+				}
+				reports = append(reports, report)
+			}
+			writeJSON(w, reports)
+			return
+		}
+
+		owner, name, ok := parseModuleName(r.URL.Query().Get("module"))
+		newOwner, newName, newOK := parseModuleName(r.URL.Query().Get("to"))
+		if !ok || !newOK {
+			http.Error(w, "module and to must be given as owner/name", http.StatusBadRequest)
+			return
+		}
+		period := svc.conf.Renames.GetRedirectPeriod()
+		mod, err := svc.casReg.RenameModule(ctx, owner, name, newOwner, newName, period)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			http.Error(w, "module "+owner+"/"+name+" not found", http.StatusNotFound)
+			return
+		case errors.Is(err, storage.ErrAlreadyExists):
+			http.Error(w, "module name "+newOwner+"/"+newName+" is taken", http.StatusConflict)
+			return
+		case err != nil:
+			slog.ErrorContext(ctx, "failed to rename module", "owner", owner, "module", name, "error", err)
+			http.Error(w, "failed to rename module", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(ctx, "module renamed", "from", owner+"/"+name, "to", newOwner+"/"+newName, "redirectPeriod", period)
+
+		writeJSON(w, renamedModule{ID: mod.ID(), Module: mod.Owner() + "/" + mod.Name(), RedirectPeriod: period.String()})
+	})
+}
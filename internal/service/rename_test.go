@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+func TestRenameModule_Redirects(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.tokens["admintoken"] = &tokenInfo{Username: "admin"}
+	svc.authenticator = &tokenAuthenticator{svc: svc}
+
+	c1 := createTestModule(t, svc, "oldteam", "billing", []registry.File{{Path: "billing.proto", Content: "// billing"}}, []string{"main"})
+
+	srv := httptest.NewServer(svc.renamesHandler())
+	defer srv.Close()
+	do := func(method, token, query string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+RenamesPath+query, nil)
+		req.Header.Set(authenticationHeader, "Bearer "+token)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp
+	}
+
+	r := do(http.MethodPost, "testtoken", "?module=oldteam/billing&to=payments/billing")
+	r.Body.Close()
+	if r.StatusCode != http.StatusForbidden {
+		t.Errorf("non-admin status = %d, want 403", r.StatusCode)
+	}
+	r = do(http.MethodPost, "admintoken", "?module=oldteam/missing&to=payments/billing")
+	r.Body.Close()
+	if r.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown module status = %d, want 404", r.StatusCode)
+	}
+	r = do(http.MethodPost, "admintoken", "?module=oldteam/billing&to=payments/billing")
+	var renamed renamedModule
+	err := json.NewDecoder(r.Body).Decode(&renamed)
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("rename status = %d, %v", r.StatusCode, err)
+	}
+	if renamed.Module != "payments/billing" {
+		t.Errorf("unexpected renamed module %+v", renamed)
+	}
+
+	r = do(http.MethodGet, "admintoken", "")
+	var redirects []redirectReport
+	err = json.NewDecoder(r.Body).Decode(&redirects)
+	r.Body.Close()
+	if err != nil || len(redirects) != 1 || redirects[0].From != "oldteam/billing" || redirects[0].To != "payments/billing" {
+		t.Fatalf("unexpected redirects %+v, %v", redirects, err)
+	}
+
+	// The former name still resolves in GetModules, Download and GetGraph
+	ctx := contextWithUser(context.Background(), "testuser")
+	oldRef := &v1.ResourceRef{Value: &v1.ResourceRef_Name_{Name: &v1.ResourceRef_Name{Owner: "oldteam", Module: "billing"}}}
+	modules, err := NewModuleService(svc).GetModules(ctx, connect.NewRequest(&v1.GetModulesRequest{
+		ModuleRefs: []*v1.ModuleRef{{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "oldteam", Module: "billing"}}}},
+	}))
+	if err != nil || modules.Msg.Modules[0].Id != c1.ModuleID {
+		t.Errorf("GetModules(former name) = %v, %v", modules, err)
+	}
+	download, err := NewDownloadServiceV1(svc).Download(ctx, connect.NewRequest(&v1.DownloadRequest{
+		Values: []*v1.DownloadRequest_Value{{ResourceRef: oldRef}},
+	}))
+	if err != nil || download.Msg.Contents[0].Commit.Id != c1.ID {
+		t.Errorf("Download(former name) = %v, %v", download, err)
+	}
+	graph, err := NewGraphServiceV1(svc).GetGraph(ctx, connect.NewRequest(&v1.GetGraphRequest{
+		ResourceRefs: []*v1.ResourceRef{oldRef},
+	}))
+	if err != nil || len(graph.Msg.Graph.Commits) != 1 || graph.Msg.Graph.Commits[0].Id != c1.ID {
+		t.Errorf("GetGraph(former name) = %v, %v", graph, err)
+	}
+
+	// Uploads under the former name are refused while it redirects
+	_, err = NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+		Contents: []*v1.UploadRequest_Content{{
+			ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "oldteam", Module: "billing"}}},
+			Files:     []*v1.File{{Path: "billing.proto", Content: []byte("// v2")}},
+		}},
+	}))
+	if connect.CodeOf(err) != connect.CodeFailedPrecondition {
+		t.Errorf("Upload to the former name: expected FailedPrecondition, got %v", err)
+	} else if !strings.Contains(err.Error(), "module was renamed to payments/billing") {
+		t.Errorf("unexpected upload error %v", err)
+	}
+
+	// Renaming onto a taken name conflicts
+	createTestModule(t, svc, "payments", "ledger", []registry.File{{Path: "ledger.proto", Content: "// ledger"}}, []string{"main"})
+	r = do(http.MethodPost, "admintoken", "?module=payments/billing&to=payments/ledger")
+	r.Body.Close()
+	if r.StatusCode != http.StatusConflict {
+		t.Errorf("rename onto a taken name status = %d, want 409", r.StatusCode)
+	}
+}
+
+func TestRenameModule_RedirectsWithMirrors(t *testing.T) {
+	up, upCleanup := setupTestService(t)
+	defer upCleanup()
+	srv := newUpstreamServer(t, up)
+
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.mirrors = []*upstream{newUpstream(config.Mirror{Registry: "buf.build", URL: srv.URL, Owners: []string{"*"}}, srv.Client())}
+
+	c1 := createTestModule(t, svc, "oldteam", "billing", []registry.File{{Path: "billing.proto", Content: "// billing"}}, []string{"main"})
+	ctx := contextWithUser(context.Background(), "testuser")
+	if _, err := svc.casReg.RenameModule(ctx, "oldteam", "billing", "payments", "billing", time.Hour); err != nil {
+		t.Fatalf("RenameModule failed: %v", err)
+	}
+
+	// The former name resolves locally rather than going to the mirror
+	oldRef := &v1.ResourceRef{Value: &v1.ResourceRef_Name_{Name: &v1.ResourceRef_Name{Owner: "oldteam", Module: "billing"}}}
+	download, err := NewDownloadServiceV1(svc).Download(ctx, connect.NewRequest(&v1.DownloadRequest{
+		Values: []*v1.DownloadRequest_Value{{ResourceRef: oldRef}},
+	}))
+	if err != nil || download.Msg.Contents[0].Commit.Id != c1.ID {
+		t.Errorf("Download(former name) = %v, %v", download, err)
+	}
+	graph, err := NewGraphServiceV1(svc).GetGraph(ctx, connect.NewRequest(&v1.GetGraphRequest{
+		ResourceRefs: []*v1.ResourceRef{oldRef},
+	}))
+	if err != nil || len(graph.Msg.Graph.Commits) != 1 || graph.Msg.Graph.Commits[0].Id != c1.ID {
+		t.Errorf("GetGraph(former name) = %v, %v", graph, err)
+	}
+	commits, err := NewCommitServiceV1(svc).GetCommits(ctx, connect.NewRequest(&v1.GetCommitsRequest{
+		ResourceRefs: []*v1.ResourceRef{oldRef},
+	}))
+	if err != nil || commits.Msg.Commits[0].Id != c1.ID {
+		t.Errorf("GetCommits(former name) = %v, %v", commits, err)
+	}
+}
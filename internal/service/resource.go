@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	v1beta1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1beta1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// ResourceService implements the v1 ResourceService interface by wrapping Service.
+type ResourceService struct {
+	svc *Service
+}
+
+// NewResourceService creates a new v1 ResourceService wrapper.
+func NewResourceService(svc *Service) *ResourceService {
+	return &ResourceService{svc: svc}
+}
+
+// GetResources resolves each resource reference to a Module, Label or Commit.
+func (r *ResourceService) GetResources(ctx context.Context, req *connect.Request[v1.GetResourcesRequest]) (*connect.Response[v1.GetResourcesResponse], error) {
+	if r.svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+
+	resp := connect.NewResponse(&v1.GetResourcesResponse{})
+	for _, ref := range req.Msg.ResourceRefs {
+		res, err := r.svc.resolveResourceRef(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		var value *v1.Resource
+		switch {
+		case res.Commit != nil:
+			value = &v1.Resource{Value: &v1.Resource_Commit{Commit: getCommitObjectV1(res.Commit)}}
+		case res.Label != nil:
+			value = &v1.Resource{Value: &v1.Resource_Label{Label: labelToProto(res.Module, res.Label)}}
+		default:
+			value = &v1.Resource{Value: &v1.Resource_Module{Module: moduleToProto(res.Module)}}
+		}
+		resp.Msg.Resources = append(resp.Msg.Resources, value)
+	}
+	return resp, nil
+}
+
+// resolveResourceRef resolves a v1 ResourceRef with the registry's resolver.
+func (svc *Service) resolveResourceRef(ctx context.Context, ref *v1.ResourceRef) (*registry.ResolvedRef, error) {
+	switch r := ref.GetValue().(type) {
+	case *v1.ResourceRef_Id:
+		return svc.resolve(ctx, r.Id, "", "", "", "")
+	case *v1.ResourceRef_Name_:
+		if r.Name == nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("name is required"))
+		}
+		return svc.resolve(ctx, "", r.Name.Owner, r.Name.Module, r.Name.GetLabelName(), r.Name.GetRef())
+	default:
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("resource_ref is required"))
+	}
+}
+
+// resolveResourceRefV1beta1 resolves a v1beta1 ResourceRef with the registry's resolver.
+func (svc *Service) resolveResourceRefV1beta1(ctx context.Context, ref *v1beta1.ResourceRef) (*registry.ResolvedRef, error) {
+	switch r := ref.GetValue().(type) {
+	case *v1beta1.ResourceRef_Id:
+		return svc.resolve(ctx, r.Id, "", "", "", "")
+	case *v1beta1.ResourceRef_Name_:
+		if r.Name == nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("name is required"))
+		}
+		return svc.resolve(ctx, "", r.Name.Owner, r.Name.Module, r.Name.GetLabelName(), r.Name.GetRef())
+	default:
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("resource_ref is required"))
+	}
+}
+
+func (svc *Service) resolve(ctx context.Context, id, owner, module, labelName, ref string) (*registry.ResolvedRef, error) {
+	var res *registry.ResolvedRef
+	var err error
+	if len(svc.mirrors) > 0 {
+		res, err = svc.resolveWithMirrors(ctx, id, owner, module, labelName, ref)
+	} else if id != "" {
+		res, err = svc.casReg.ResolveID(ctx, id)
+	} else {
+		res, err = svc.casReg.ResolveName(ctx, owner, module, labelName, ref)
+	}
+	if err != nil {
+		return nil, resolveError(err)
+	}
+	return res, nil
+}
+
+// resolveCommit resolves a v1 ResourceRef to a commit. Modules resolve to the commit of their default label.
+func (svc *Service) resolveCommit(ctx context.Context, ref *v1.ResourceRef) (*registry.Module, *registry.Commit, error) {
+	res, err := svc.resolveResourceRef(ctx, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	return commitOf(ctx, res)
+}
+
+// resolveCommitV1beta1 resolves a v1beta1 ResourceRef to a commit. Modules resolve to the commit of their default label.
+func (svc *Service) resolveCommitV1beta1(ctx context.Context, ref *v1beta1.ResourceRef) (*registry.Module, *registry.Commit, error) {
+	res, err := svc.resolveResourceRefV1beta1(ctx, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	return commitOf(ctx, res)
+}
+
+func commitOf(ctx context.Context, res *registry.ResolvedRef) (*registry.Module, *registry.Commit, error) {
+	cmt, err := res.ResolveCommit(ctx)
+	if err != nil {
+		return nil, nil, resolveError(err)
+	}
+	return res.Module, cmt, nil
+}
+
+// resolveError maps resolver errors to connect errors.
+func resolveError(err error) error {
+	var connectErr *connect.Error
+	switch {
+	case errors.As(err, &connectErr):
+		return connectErr
+	case errors.Is(err, storage.ErrNotFound):
+		return connect.NewError(connect.CodeNotFound, err)
+	case errors.Is(err, registry.ErrAmbiguousRef):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	default:
+		return connect.NewError(connect.CodeInternal, err)
+	}
+}
+
+func labelToProto(mod *registry.Module, label *storage.LabelRecord) *v1.Label {
+	return &v1.Label{
+		Id:       label.ID,
+		Name:     label.Name,
+		OwnerId:  mod.OwnerID(),
+		ModuleId: mod.ID(),
+		CommitId: label.CommitID,
+		CommitCheckState: &v1.CommitCheckState{
+			Status: v1.CommitCheckStatus_COMMIT_CHECK_STATUS_DISABLED,
+		},
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+func nameRef(owner, module string) *v1.ResourceRef_Name {
+	return &v1.ResourceRef_Name{Owner: owner, Module: module}
+}
+
+func TestGetResources(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	files := []registry.File{{Path: "pet.proto", Content: "syntax = \"proto3\";"}}
+	commit := createTestModule(t, svc, "acme", "petapis", files, []string{"main", "v1.0.0"})
+	mod, err := svc.casReg.Module(ctx, "acme", "petapis")
+	if err != nil {
+		t.Fatalf("Module failed: %v", err)
+	}
+
+	withLabel := nameRef("acme", "petapis")
+	withLabel.Child = &v1.ResourceRef_Name_LabelName{LabelName: "v1.0.0"}
+	withCommitRef := nameRef("acme", "petapis")
+	withCommitRef.Child = &v1.ResourceRef_Name_Ref{Ref: commit.ID}
+	withLabelRef := nameRef("acme", "petapis")
+	withLabelRef.Child = &v1.ResourceRef_Name_Ref{Ref: "main"}
+
+	refs := []*v1.ResourceRef{
+		{Value: &v1.ResourceRef_Name_{Name: nameRef("acme", "petapis")}},
+		{Value: &v1.ResourceRef_Name_{Name: withLabel}},
+		{Value: &v1.ResourceRef_Name_{Name: withCommitRef}},
+		{Value: &v1.ResourceRef_Name_{Name: withLabelRef}},
+		{Value: &v1.ResourceRef_Id{Id: commit.ID}},
+		{Value: &v1.ResourceRef_Id{Id: mod.ID()}},
+		{Value: &v1.ResourceRef_Id{Id: mod.ID() + "/v1.0.0"}},
+	}
+
+	resp, err := NewResourceService(svc).GetResources(ctx, connect.NewRequest(&v1.GetResourcesRequest{ResourceRefs: refs}))
+	if err != nil {
+		t.Fatalf("GetResources failed: %v", err)
+	}
+	res := resp.Msg.Resources
+	if len(res) != len(refs) {
+		t.Fatalf("expected %d resources, got %d", len(refs), len(res))
+	}
+
+	if res[0].GetModule().GetId() != mod.ID() {
+		t.Errorf("resource 0: expected module, got %v", res[0])
+	}
+	if l := res[1].GetLabel(); l.GetName() != "v1.0.0" || l.GetCommitId() != commit.ID {
+		t.Errorf("resource 1: expected label v1.0.0, got %v", res[1])
+	}
+	if res[2].GetCommit().GetId() != commit.ID {
+		t.Errorf("resource 2: expected commit, got %v", res[2])
+	}
+	if res[3].GetLabel().GetName() != "main" {
+		t.Errorf("resource 3: expected label main, got %v", res[3])
+	}
+	if res[4].GetCommit().GetId() != commit.ID {
+		t.Errorf("resource 4: expected commit, got %v", res[4])
+	}
+	if res[5].GetModule().GetName() != "petapis" {
+		t.Errorf("resource 5: expected module, got %v", res[5])
+	}
+	if res[6].GetLabel().GetName() != "v1.0.0" {
+		t.Errorf("resource 6: expected label, got %v", res[6])
+	}
+}
+
+func TestGetResources_NotFound(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	createTestModule(t, svc, "acme", "petapis", []registry.File{{Path: "pet.proto", Content: "syntax = \"proto3\";"}}, []string{"main"})
+
+	missingLabel := nameRef("acme", "petapis")
+	missingLabel.Child = &v1.ResourceRef_Name_LabelName{LabelName: "nope"}
+
+	for name, ref := range map[string]*v1.ResourceRef{
+		"module": {Value: &v1.ResourceRef_Name_{Name: nameRef("acme", "nope")}},
+		"label":  {Value: &v1.ResourceRef_Name_{Name: missingLabel}},
+		"id":     {Value: &v1.ResourceRef_Id{Id: "deadbeef"}},
+	} {
+		_, err := NewResourceService(svc).GetResources(context.Background(), connect.NewRequest(&v1.GetResourcesRequest{
+			ResourceRefs: []*v1.ResourceRef{ref},
+		}))
+		if connect.CodeOf(err) != connect.CodeNotFound {
+			t.Errorf("%s: expected CodeNotFound, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+// RetentionPath is the admin endpoint listing, or applying, the effect of the retention policies.
+const RetentionPath = "/admin/retention"
+
+// retentionReport is the outcome of applying retention, as served by the retention endpoint.
+type retentionReport struct {
+	DryRun    bool           `json:"dry_run"`
+	Pruned    []prunedCommit `json:"pruned"`
+	Manifests int            `json:"manifests"`
+	Blobs     int            `json:"blobs"`
+	Bytes     int64          `json:"bytes"`
+}
+
+type prunedCommit struct {
+	Module     string    `json:"module"`
+	Commit     string    `json:"commit"`
+	CreateTime time.Time `json:"create_time"`
+}
+
+// applyRetention prunes the commits the retention policies don't keep and collects the
+// files no commit references. With dryRun, nothing is deleted. Runs are serialized.
+func (svc *Service) applyRetention(ctx context.Context, dryRun bool) (*retentionReport, error) {
+	svc.retentionMu.Lock()
+	defer svc.retentionMu.Unlock()
+
+	conf := svc.conf.Retention
+	result, err := svc.casReg.ApplyRetention(ctx, registry.RetentionOptions{
+		Policy: func(mod *registry.Module) (registry.RetentionPolicy, bool) {
+			p, ok := conf.PolicyFor(mod.Owner() + "/" + mod.Name())
+			return registry.RetentionPolicy{
+				KeepFor:  time.Duration(p.KeepDays) * 24 * time.Hour,
+				KeepLast: p.KeepLast,
+			}, ok
+		},
+		Now:         time.Now(),
+		GracePeriod: conf.GetGracePeriod(),
+		DryRun:      dryRun,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &retentionReport{
+		DryRun:    dryRun,
+		Pruned:    make([]prunedCommit, 0, len(result.Pruned)),
+		Manifests: result.Manifests,
+		Blobs:     result.Blobs,
+		Bytes:     result.Bytes,
+	}
+	names := make(map[string]string)
+	for _, c := range result.Pruned {
+		name, ok := names[c.ModuleID]
+		if !ok {
+			if mod, err := svc.casReg.ModuleByID(ctx, c.ModuleID); err == nil {
+				name = mod.Owner() + "/" + mod.Name()
+			}
+			names[c.ModuleID] = name
+		}
+		report.Pruned = append(report.Pruned, prunedCommit{Module: name, Commit: c.ID, CreateTime: c.CreateTime})
+	}
+	return report, nil
+}
+
+// runRetention applies retention on every configured interval, until ctx is done.
+func (svc *Service) runRetention(ctx context.Context) {
+	interval := svc.conf.Retention.GetInterval()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		report, err := svc.applyRetention(ctx, svc.conf.Retention.DryRun)
+		if err != nil {
+			slog.ErrorContext(ctx, "retention failed", "error", err)
+			continue
+		}
+		for _, c := range report.Pruned {
+			slog.DebugContext(ctx, "commit pruned", "module", c.Module, "commit", c.Commit, "dryRun", report.DryRun)
+		}
+		slog.InfoContext(ctx, "retention applied", "dryRun", report.DryRun, "commits", len(report.Pruned),
+			"manifests", report.Manifests, "blobs", report.Blobs, "bytes", report.Bytes)
+	}
+}
+
+// retentionHandler serves the retention endpoint for the admin user. GET lists what
+// retention would delete, POST applies it, as a dry run if retention is configured so.
+func (svc *Service) retentionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if svc.conf.Retention == nil {
+			http.Error(w, "retention not configured", http.StatusNotImplemented)
+			return
+		}
+		if !svc.requireAdmin(w, r) {
+			return
+		}
+
+		dryRun := r.Method == http.MethodGet || svc.conf.Retention.DryRun
+		report, err := svc.applyRetention(r.Context(), dryRun)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "retention failed", "error", err)
+			http.Error(w, "retention failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, report)
+	})
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+func TestRetentionHandler(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.conf.Retention = &config.Retention{Modules: map[string]config.RetentionPolicy{
+		"acme/*": {KeepLast: 1},
+	}}
+	svc.tokens["admintoken"] = &tokenInfo{Username: "admin"}
+	svc.authenticator = &tokenAuthenticator{svc: svc}
+	ctx := context.Background()
+
+	old := createTestModule(t, svc, "acme", "pets", []registry.File{{Path: "a.proto", Content: "// v1"}}, nil)
+	createTestModule(t, svc, "acme", "pets", []registry.File{{Path: "a.proto", Content: "// v2"}}, []string{"main"})
+	createTestModule(t, svc, "other", "pets", []registry.File{{Path: "a.proto", Content: "// v1"}}, nil)
+
+	srv := httptest.NewServer(svc.retentionHandler())
+	defer srv.Close()
+	do := func(method, token string) (int, retentionReport) {
+		req, _ := http.NewRequest(method, srv.URL+RetentionPath, nil)
+		req.Header.Set(authenticationHeader, "Bearer "+token)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var report retentionReport
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+		}
+		return resp.StatusCode, report
+	}
+
+	if code, _ := do(http.MethodPost, "testtoken"); code != http.StatusForbidden {
+		t.Errorf("non-admin status = %d, want 403", code)
+	}
+
+	code, report := do(http.MethodGet, "admintoken")
+	if code != http.StatusOK || !report.DryRun || len(report.Pruned) != 1 || report.Pruned[0].Commit != old.ID || report.Pruned[0].Module != "acme/pets" {
+		t.Fatalf("dry run: status %d, report %+v", code, report)
+	}
+	if _, err := svc.casReg.CommitByID(ctx, old.ID); err != nil {
+		t.Fatalf("dry run deleted the commit: %v", err)
+	}
+
+	// Files stored within the grace period are not collected
+	code, report = do(http.MethodPost, "admintoken")
+	if code != http.StatusOK || report.DryRun || len(report.Pruned) != 1 || report.Blobs != 0 {
+		t.Fatalf("apply: status %d, report %+v", code, report)
+	}
+	if _, err := svc.casReg.CommitByID(ctx, old.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected the commit to be pruned, got %v", err)
+	}
+}
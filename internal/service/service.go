@@ -3,13 +3,16 @@ package service
 import (
 	"context"
 	"crypto/tls"
-	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -24,8 +27,12 @@ import (
 	"github.com/greatliontech/ocifs"
 	"github.com/greatliontech/pbr/internal/codegen"
 	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/gitsync"
+	"github.com/greatliontech/pbr/internal/policy"
+	"github.com/greatliontech/pbr/internal/ratelimit"
 	"github.com/greatliontech/pbr/internal/registry"
 	"github.com/greatliontech/pbr/internal/storage"
+	"github.com/greatliontech/pbr/internal/util"
 	"go.opentelemetry.io/otel"
 	"gocloud.dev/blob"
 	_ "gocloud.dev/blob/fileblob"
@@ -35,25 +42,17 @@ import (
 	"gocloud.dev/docstore/memdocstore"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
-var tracer = otel.Tracer("pbr.dev/internal/service")
+var (
+	tracer = otel.Tracer("pbr.dev/internal/service")
+	meter  = otel.Meter("pbr.dev/internal/service")
+)
 
 type contextKey string
 
-const userContextKey contextKey = "user"
-
-func contextWithUser(ctx context.Context, user string) context.Context {
-	return context.WithValue(ctx, userContextKey, user)
-}
-
-func userFromContext(ctx context.Context) string {
-	if user, ok := ctx.Value(userContextKey).(string); ok {
-		return user
-	}
-	return ""
-}
-
 // tokenInfo holds information about an authentication token.
 type tokenInfo struct {
 	Username  string
@@ -80,9 +79,21 @@ type Service struct {
 	ofs      *ocifs.OCIFS
 	regCreds map[string]authn.AuthConfig
 	casReg   *registry.Registry
+	audit    storage.AuditStore
+	proxies  []netip.Prefix // trusted to set X-Forwarded-For
+	syncer   *gitsync.Syncer
+
+	retentionMu sync.Mutex                        // serializes retention runs
+	quotaLocks  util.SyncMap[string, *sync.Mutex] // by owner ID, held from quota checks until uploads are applied
+
+	authenticator Authenticator
+	authorizer    Authorizer
+	remoteCommits RemoteCommitFetcher
+	mirrors       []*upstream
+	refreshes     sync.WaitGroup // background refreshes of mirrored labels
 }
 
-func New(c *config.Config) (*Service, error) {
+func New(c *config.Config, opts ...Option) (*Service, error) {
 	// CAS storage is required
 	if c.CacheDir == "" {
 		return nil, fmt.Errorf("cache_dir is required for CAS storage")
@@ -95,6 +106,40 @@ func New(c *config.Config) (*Service, error) {
 		regCreds: map[string]authn.AuthConfig{},
 		plugins:  map[string]*codegen.Plugin{},
 	}
+	svc.authenticator = &tokenAuthenticator{svc: svc}
+	svc.authorizer = allowAllAuthorizer{}
+	upstreamClient := &http.Client{Timeout: upstreamTimeout}
+	if len(c.RemoteRegistries) > 0 {
+		svc.remoteCommits = &httpCommitFetcher{client: upstreamClient, registries: c.RemoteRegistries}
+	}
+	for _, m := range c.Mirrors {
+		for _, pattern := range m.Owners {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("mirror %s: invalid owner glob %q: %w", m.Registry, pattern, err)
+			}
+		}
+		svc.mirrors = append(svc.mirrors, newUpstream(m, upstreamClient))
+	}
+
+	if err := validateBreaking(c.Breaking); err != nil {
+		return nil, err
+	}
+	if err := validateLint(c.Lint); err != nil {
+		return nil, err
+	}
+
+	if c.Authorization != nil && c.Authorization.PolicyFile != "" {
+		pol, err := policy.FromFile(c.Authorization.PolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load authorization policy: %w", err)
+		}
+		svc.authorizer = &policyAuthorizer{policy: pol}
+		slog.Info("Authorization policy loaded", "file", c.Authorization.PolicyFile, "rules", len(pol.Rules))
+	}
+
+	for _, opt := range opts {
+		opt(svc)
+	}
 
 	if svc.conf.Address == "" {
 		svc.conf.Address = ":443"
@@ -135,9 +180,9 @@ func New(c *config.Config) (*Service, error) {
 	}
 
 	if svc.conf.AdminToken != "" {
-		svc.users["admin"] = svc.conf.AdminToken
+		svc.users[adminUsername] = svc.conf.AdminToken
 		// Admin token never expires
-		svc.tokens[svc.conf.AdminToken] = &tokenInfo{Username: "admin"}
+		svc.tokens[svc.conf.AdminToken] = &tokenInfo{Username: adminUsername}
 	}
 
 	for k, v := range svc.users {
@@ -184,9 +229,68 @@ func New(c *config.Config) (*Service, error) {
 	metadataStore := storage.NewMetadataStore(owners, modules, commits, labels)
 	slog.Info("Metadata storage initialized", "url", docstoreURL)
 
-	svc.casReg = registry.New(blobStore, manifestStore, metadataStore, c.Host)
+	fileIndex, err := openFileIndex(docstoreURL, c.CacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file index: %w", err)
+	}
+
+	usage, err := openUsageStore(docstoreURL, c.CacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open usage store: %w", err)
+	}
+
+	redirects, err := openRedirectStore(docstoreURL, c.CacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open redirect store: %w", err)
+	}
+
+	batches, err := openBatchStore(docstoreURL, c.CacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open batch store: %w", err)
+	}
+
+	svc.casReg = registry.New(blobStore, manifestStore, metadataStore, c.Host,
+		registry.WithFileIndex(fileIndex), registry.WithUsage(usage), registry.WithRedirects(redirects),
+		registry.WithBatches(batches))
 	slog.Info("CAS registry initialized")
 
+	// Undo the uploads interrupted by a crash before anything is derived from the metadata
+	if n, err := svc.casReg.RecoverBatches(context.Background(), time.Now().Add(-abandonedBatchAge)); err != nil {
+		return nil, fmt.Errorf("failed to recover commit batches: %w", err)
+	} else if n > 0 {
+		slog.Warn("Interrupted commit batches undone", "count", n)
+	}
+
+	if err := svc.casReg.BuildFileIndex(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to build file index: %w", err)
+	}
+	if err := svc.casReg.BuildUsage(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to build usage: %w", err)
+	}
+	if err := svc.registerUsageMetrics(); err != nil {
+		return nil, fmt.Errorf("failed to register usage metrics: %w", err)
+	}
+
+	syncer, err := gitsync.New(svc.casReg, c, gitsync.WithDepResolver(svc.resolveDep))
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure git sync: %w", err)
+	}
+	svc.syncer = syncer
+	if len(c.Modules) > 0 {
+		slog.Info("Git sync configured", "modules", len(c.Modules), "interval", c.GetSyncInterval())
+	}
+
+	audit, err := openAuditStore(docstoreURL, c.CacheDir, c.Audit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	svc.audit = audit
+	if c.Audit != nil {
+		if svc.proxies, err = parseTrustedProxies(c.Audit.TrustedProxies); err != nil {
+			return nil, err
+		}
+	}
+
 	mux := http.NewServeMux()
 
 	intcptrs := []connect.Interceptor{}
@@ -197,8 +301,19 @@ func New(c *config.Config) (*Service, error) {
 	}
 	intcptrs = append(intcptrs, otelInt)
 
-	if !c.NoLogin {
-		intcptrs = append(intcptrs, newAuthInterceptor(svc))
+	// Audit runs before auth so that rejected requests are recorded too
+	intcptrs = append(intcptrs, newAuditInterceptor(svc))
+
+	intcptrs = append(intcptrs, newAuthInterceptor(svc))
+
+	var limiter *ratelimit.Limiter
+	if c.RateLimit != nil {
+		limiter, err = ratelimit.New(c.RateLimit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create rate limiter: %w", err)
+		}
+		intcptrs = append(intcptrs, newRateLimitInterceptor(limiter))
+		slog.Info("Rate limiting enabled")
 	}
 
 	interceptors := connect.WithInterceptors(intcptrs...)
@@ -208,12 +323,25 @@ func New(c *config.Config) (*Service, error) {
 	mux.Handle(modulev1beta1connect.NewCommitServiceHandler(svc, interceptors))
 	mux.Handle(modulev1beta1connect.NewGraphServiceHandler(svc, interceptors))
 	mux.Handle(modulev1beta1connect.NewDownloadServiceHandler(svc, interceptors))
+	mux.Handle(modulev1beta1connect.NewUploadServiceHandler(svc, interceptors))
 	mux.Handle(modulev1connect.NewModuleServiceHandler(NewModuleService(svc), interceptors))
 	mux.Handle(modulev1connect.NewUploadServiceHandler(NewUploadService(svc), interceptors))
 	mux.Handle(modulev1connect.NewGraphServiceHandler(NewGraphServiceV1(svc), interceptors))
 	mux.Handle(modulev1connect.NewDownloadServiceHandler(NewDownloadServiceV1(svc), interceptors))
 	mux.Handle(modulev1connect.NewCommitServiceHandler(NewCommitServiceV1(svc), interceptors))
+	mux.Handle(modulev1connect.NewResourceServiceHandler(NewResourceService(svc), interceptors))
 	mux.Handle(ownerv1connect.NewOwnerServiceHandler(svc, interceptors))
+	mux.Handle(ownerv1connect.NewUserServiceHandler(NewUserService(svc), interceptors))
+	mux.Handle(ownerv1connect.NewOrganizationServiceHandler(NewOrganizationService(svc), interceptors))
+
+	mux.Handle(AuditPath, svc.auditHandler())
+	mux.Handle(SyncPath, svc.auditAdmin(svc.syncHandler()))
+	mux.Handle(ImportPath, svc.auditAdmin(svc.importHandler()))
+	mux.Handle(BreakingPath, svc.rateLimitHandler(limiter, BreakingPath, svc.breakingHandler()))
+	mux.Handle(UsagePath, svc.usageHandler())
+	mux.Handle(RetentionPath, svc.auditAdmin(svc.retentionHandler()))
+	mux.Handle(DeletedPath, svc.auditAdmin(svc.deletedHandler()))
+	mux.Handle(RenamesPath, svc.auditAdmin(svc.renamesHandler()))
 
 	mux.Handle("/readyz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, "ready")
@@ -248,6 +376,16 @@ func New(c *config.Config) (*Service, error) {
 }
 
 func (svc *Service) Serve(ctx context.Context) error {
+	if len(svc.conf.Modules) > 0 {
+		go svc.syncer.Run(ctx)
+	}
+	if svc.conf.Retention != nil {
+		go svc.runRetention(ctx)
+	}
+	if svc.casReg != nil {
+		go svc.runPurge(ctx)
+		go svc.runBatchRecovery(ctx)
+	}
 	if svc.cert != nil {
 		svc.server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{*svc.cert}}
 		if err := http2.ConfigureServer(svc.server, nil); err != nil {
@@ -265,7 +403,15 @@ func (svc *Service) Serve(ctx context.Context) error {
 }
 
 func (svc *Service) Shutdown(ctx context.Context) error {
-	return svc.server.Shutdown(ctx)
+	err := svc.server.Shutdown(ctx)
+	svc.refreshes.Wait()
+	// Flush the audit log (memdocstore persists on close)
+	if c, ok := svc.audit.(io.Closer); ok {
+		if cerr := c.Close(); cerr != nil {
+			slog.Error("failed to close audit log", "error", cerr)
+		}
+	}
+	return err
 }
 
 func loadTLSCert(tlsConf *config.TLS) (*tls.Certificate, error) {
@@ -300,49 +446,90 @@ const (
 func newAuthInterceptor(svc *Service) connect.UnaryInterceptorFunc {
 	return func(next connect.UnaryFunc) connect.UnaryFunc {
 		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
-			hdr := req.Header().Get(authenticationHeader)
-			if hdr == "" {
-				return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("no token provided"))
+			principal, err := svc.authenticate(ctx, req.Header())
+			if err != nil {
+				return nil, err
 			}
 
-			if !strings.HasPrefix(hdr, authenticationTokenPrefix) {
-				return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("invalid auth header"))
-			}
+			ctx = contextWithPrincipal(ctx, principal)
+			setAuditedPrincipal(ctx, principal)
 
-			tokenString := strings.TrimSpace(strings.TrimPrefix(hdr, authenticationTokenPrefix))
-			if tokenString == "" {
-				return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("token missing"))
+			// Skip resource extraction when every authenticated request is allowed
+			if _, ok := svc.authorizer.(allowAllAuthorizer); ok {
+				return next(ctx, req)
 			}
 
-			// Use mutex for thread-safe token lookup (tokens can be added dynamically via OIDC)
-			svc.mu.RLock()
-			info, ok := svc.tokens[tokenString]
-			svc.mu.RUnlock()
-			if !ok {
-				return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("invalid token"))
+			action := req.Spec().Procedure
+			for _, res := range svc.requestResources(ctx, req.Any()) {
+				if err := svc.authorizer.Authorize(ctx, principal, action, res); err != nil {
+					return nil, asConnectError(err, connect.CodePermissionDenied)
+				}
 			}
 
-			// Check if token is expired
-			if info.IsExpired() {
-				// Remove expired token
-				svc.mu.Lock()
-				delete(svc.tokens, tokenString)
-				svc.mu.Unlock()
-				return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("token expired"))
-			}
+			return next(ctx, req)
+		}
+	}
+}
 
-			// Slide expiration for dynamic tokens (those with non-zero ExpiresAt)
-			if !info.ExpiresAt.IsZero() {
-				svc.mu.Lock()
-				info.ExpiresAt = time.Now().Add(svc.conf.GetTokenTTL())
-				svc.mu.Unlock()
+// newRateLimitInterceptor rejects requests exceeding the configured rate limits with CodeResourceExhausted.
+// Rejections carry a Retry-After header and a RetryInfo error detail.
+func newRateLimitInterceptor(limiter *ratelimit.Limiter) connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			key := rateLimitKey(userFromContext(ctx), req.Peer().Addr)
+			procedure := req.Spec().Procedure
+			ok, retryAfter, scope := limiter.Allow(ctx, key, procedure)
+			if ok {
+				return next(ctx, req)
 			}
 
-			ctx = contextWithUser(ctx, info.Username)
+			slog.WarnContext(ctx, "rate limit exceeded", "key", key, "procedure", procedure, "scope", scope, "retry_after", retryAfter)
 
-			return next(ctx, req)
+			connectErr := connect.NewError(connect.CodeResourceExhausted,
+				fmt.Errorf("%s rate limit exceeded, retry after %s", scope, retryAfter.Round(time.Millisecond)))
+			connectErr.Meta().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			if detail, err := connect.NewErrorDetail(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)}); err == nil {
+				connectErr.AddDetail(detail)
+			}
+			return nil, connectErr
+		}
+	}
+}
+
+// rateLimitHandler applies the rate limits to an endpoint served outside of Connect,
+// with its path as the procedure. Rejected requests fail with 429 Too Many Requests.
+func (svc *Service) rateLimitHandler(limiter *ratelimit.Limiter, procedure string, next http.Handler) http.Handler {
+	if limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var username string
+		if principal, err := svc.authenticator.Authenticate(ctx, r.Header); err == nil && principal != nil {
+			username = principal.Username
+		}
+		key := rateLimitKey(username, r.RemoteAddr)
+		ok, retryAfter, scope := limiter.Allow(ctx, key, procedure)
+		if ok {
+			next.ServeHTTP(w, r)
+			return
 		}
+
+		slog.WarnContext(ctx, "rate limit exceeded", "key", key, "procedure", procedure, "scope", scope, "retry_after", retryAfter)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, fmt.Sprintf("%s rate limit exceeded, retry after %s", scope, retryAfter.Round(time.Millisecond)), http.StatusTooManyRequests)
+	})
+}
+
+// rateLimitKey is the bucket key of a caller: its username, or its address when it is anonymous.
+func rateLimitKey(username, addr string) string {
+	if username != "" {
+		return username
 	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return "addr:" + host
+	}
+	return "addr:" + addr
 }
 
 // debugMiddleware logs all HTTP requests for debugging.
@@ -456,3 +643,102 @@ func openDocstoreCollections(urlBase, cacheDir string) (owners, modules, commits
 	}
 	return owners, modules, commits, labels, nil
 }
+
+// openFileIndex opens the file path index collection alongside the metadata collections.
+func openFileIndex(urlBase, cacheDir string) (*storage.FileIndexImpl, error) {
+	var coll *docstore.Collection
+	var err error
+	if strings.HasPrefix(urlBase, "mem://") {
+		coll, err = memdocstore.OpenCollection("ID", &memdocstore.Options{
+			Filename: cacheDir + "/cas/metadata/files.json",
+		})
+	} else {
+		coll, err = docstore.OpenCollection(context.Background(), urlBase+"/files?name_field=id")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open files collection: %w", err)
+	}
+	return storage.NewFileIndex(coll), nil
+}
+
+// openUsageStore opens the usage accounting collection alongside the metadata collections.
+func openUsageStore(urlBase, cacheDir string) (*storage.UsageStoreImpl, error) {
+	var coll *docstore.Collection
+	var err error
+	if strings.HasPrefix(urlBase, "mem://") {
+		coll, err = memdocstore.OpenCollection("ID", &memdocstore.Options{
+			Filename: cacheDir + "/cas/metadata/usage.json",
+		})
+	} else {
+		coll, err = docstore.OpenCollection(context.Background(), urlBase+"/usage?name_field=id")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open usage collection: %w", err)
+	}
+	return storage.NewUsageStore(coll), nil
+}
+
+// openRedirectStore opens the collection of the redirects of renamed modules alongside
+// the metadata collections.
+func openRedirectStore(urlBase, cacheDir string) (*storage.RedirectStoreImpl, error) {
+	var coll *docstore.Collection
+	var err error
+	if strings.HasPrefix(urlBase, "mem://") {
+		coll, err = memdocstore.OpenCollection("ID", &memdocstore.Options{
+			Filename: cacheDir + "/cas/metadata/redirects.json",
+		})
+	} else {
+		coll, err = docstore.OpenCollection(context.Background(), urlBase+"/redirects?name_field=id")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open redirects collection: %w", err)
+	}
+	return storage.NewRedirectStore(coll), nil
+}
+
+// openBatchStore opens the collection of the journals of commit batches alongside the
+// metadata collections.
+func openBatchStore(urlBase, cacheDir string) (*storage.BatchStoreImpl, error) {
+	var coll *docstore.Collection
+	var err error
+	if strings.HasPrefix(urlBase, "mem://") {
+		coll, err = memdocstore.OpenCollection("ID", &memdocstore.Options{
+			Filename: cacheDir + "/cas/metadata/batches.json",
+		})
+	} else {
+		coll, err = docstore.OpenCollection(context.Background(), urlBase+"/batches?name_field=id")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open batches collection: %w", err)
+	}
+	return storage.NewBatchStore(coll), nil
+}
+
+// openAuditStore opens the audit log collection alongside the metadata collections.
+// If configured, records are also appended as JSON lines to the export file.
+func openAuditStore(urlBase, cacheDir string, conf *config.Audit) (*storage.AuditStoreImpl, error) {
+	var coll *docstore.Collection
+	var err error
+	if strings.HasPrefix(urlBase, "mem://") {
+		coll, err = memdocstore.OpenCollection("ID", &memdocstore.Options{
+			Filename: cacheDir + "/cas/metadata/audit.json",
+		})
+	} else {
+		coll, err = docstore.OpenCollection(context.Background(), urlBase+"/audit?name_field=id")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit collection: %w", err)
+	}
+
+	var export io.Writer
+	if conf != nil && conf.ExportFile != "" {
+		f, err := os.OpenFile(conf.ExportFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit export file: %w", err)
+		}
+		export = f
+		slog.Info("Audit log export enabled", "file", conf.ExportFile)
+	}
+
+	return storage.NewAuditStore(coll, export), nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/gitsync"
+)
+
+// SyncPath is the admin endpoint for syncing git-backed modules on demand.
+const SyncPath = "/admin/sync"
+
+// ImportPath is the admin endpoint for importing the history of a git repository.
+const ImportPath = "/admin/import"
+
+// syncHandler serves POST /admin/sync for the admin user.
+// The module query parameter ("owner/module") syncs a single module, otherwise all are synced.
+// The sync completes before the response is written.
+func (svc *Service) syncHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if svc.syncer == nil {
+			http.Error(w, "git sync not configured", http.StatusNotImplemented)
+			return
+		}
+		if !svc.requireAdmin(w, r) {
+			return
+		}
+
+		var err error
+		if name := r.URL.Query().Get("module"); name != "" {
+			err = svc.syncer.Sync(r.Context(), name)
+		} else {
+			err = svc.syncer.SyncAll(r.Context())
+		}
+		switch {
+		case errors.Is(err, gitsync.ErrUnknownModule):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			slog.ErrorContext(r.Context(), "git sync failed", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			fmt.Fprint(w, "synced")
+		}
+	})
+}
+
+// importHandler serves POST /admin/import for the admin user.
+// Query parameters: module ("owner/module", required), remote, path, filter (repeatable) and range.
+// Without remote, the repository and settings of the configured module are used.
+// The imported revisions are returned oldest first as a JSON array.
+func (svc *Service) importHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if svc.syncer == nil {
+			http.Error(w, "git sync not configured", http.StatusNotImplemented)
+			return
+		}
+		if !svc.requireAdmin(w, r) {
+			return
+		}
+
+		params := r.URL.Query()
+		name := params.Get("module")
+		if name == "" {
+			http.Error(w, "module is required", http.StatusBadRequest)
+			return
+		}
+		conf, _ := svc.syncer.Module(name)
+		if remote := params.Get("remote"); remote != "" {
+			if err := gitsync.ValidateRemote(remote); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			conf = config.Module{Remote: remote, Path: params.Get("path"), Filters: params["filter"]}
+		}
+		if conf.Remote == "" {
+			http.Error(w, "remote is required for modules not configured for git sync", http.StatusBadRequest)
+			return
+		}
+
+		revisions, err := svc.syncer.Import(r.Context(), name, conf, params.Get("range"))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "git import failed", "module", name, "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if revisions == nil {
+			revisions = []gitsync.Revision{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revisions)
+	})
+}
+
+// requireAdmin writes an error response and returns false unless the request carries the admin token.
+func (svc *Service) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	principal, err := svc.authenticator.Authenticate(r.Context(), r.Header)
+	if err != nil || principal == nil {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return false
+	}
+	if principal.Username != adminUsername {
+		http.Error(w, "admin token required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/gitsync"
+)
+
+// newTestGitRepo creates a local repository with a single proto file on main, tagged v1.0.0.
+func newTestGitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repo, "pets.proto"), []byte("syntax = \"proto3\";\npackage pets;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet", "-b", "main"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "init"},
+		{"tag", "v1.0.0"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	return repo
+}
+
+// postAdmin posts to an admin endpoint and returns the response status and body.
+func postAdmin(t *testing.T, srv *httptest.Server, token, target string) (int, []byte) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+target, nil)
+	req.Header.Set(authenticationHeader, "Bearer "+token)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, body
+}
+
+func TestSyncHandler(t *testing.T) {
+	repo := newTestGitRepo(t)
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.tokens["admintoken"] = &tokenInfo{Username: "admin"}
+	svc.authenticator = &tokenAuthenticator{svc: svc}
+
+	syncer, err := gitsync.New(svc.casReg, &config.Config{
+		CacheDir: t.TempDir(),
+		Modules:  map[string]config.Module{"acme/pets": {Remote: repo}},
+	})
+	if err != nil {
+		t.Fatalf("gitsync.New failed: %v", err)
+	}
+	svc.syncer = syncer
+
+	mux := http.NewServeMux()
+	mux.Handle(SyncPath, svc.syncHandler())
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	post := func(token, query string) int {
+		code, _ := postAdmin(t, srv, token, SyncPath+query)
+		return code
+	}
+
+	if code := post("testtoken", ""); code != http.StatusForbidden {
+		t.Errorf("non-admin sync: got %d, want 403", code)
+	}
+	if code := post("admintoken", "?module=acme/other"); code != http.StatusNotFound {
+		t.Errorf("unknown module: got %d, want 404", code)
+	}
+	if code := post("admintoken", "?module=acme/pets"); code != http.StatusOK {
+		t.Fatalf("sync: got %d, want 200", code)
+	}
+
+	mod, err := svc.casReg.Module(context.Background(), "acme", "pets")
+	if err != nil {
+		t.Fatalf("module not synced: %v", err)
+	}
+	if _, err := mod.Commit(context.Background(), "main"); err != nil {
+		t.Errorf("main label not synced: %v", err)
+	}
+}
+
+func TestImportHandler(t *testing.T) {
+	repo := newTestGitRepo(t)
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.tokens["admintoken"] = &tokenInfo{Username: "admin"}
+	svc.authenticator = &tokenAuthenticator{svc: svc}
+
+	// Local repositories may only be configured, not given as remote
+	syncer, err := gitsync.New(svc.casReg, &config.Config{
+		CacheDir: t.TempDir(),
+		Modules:  map[string]config.Module{"acme/pets": {Remote: repo}},
+	})
+	if err != nil {
+		t.Fatalf("gitsync.New failed: %v", err)
+	}
+	svc.syncer = syncer
+
+	mux := http.NewServeMux()
+	mux.Handle(ImportPath, svc.importHandler())
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	if code, _ := postAdmin(t, srv, "admintoken", ImportPath+"?module=acme/other"); code != http.StatusBadRequest {
+		t.Errorf("import without remote: got %d, want 400", code)
+	}
+	for _, remote := range []string{repo, "file://" + repo, "--upload-pack=touch /tmp/pwned", "ext::sh -c touch% /tmp/pwned"} {
+		code, body := postAdmin(t, srv, "admintoken", ImportPath+"?"+url.Values{"module": {"acme/pets"}, "remote": {remote}}.Encode())
+		if code != http.StatusBadRequest {
+			t.Errorf("import from %q: got %d: %s, want 400", remote, code, body)
+		}
+	}
+
+	code, body := postAdmin(t, srv, "admintoken", ImportPath+"?module=acme/pets")
+	if code != http.StatusOK {
+		t.Fatalf("import: got %d: %s", code, body)
+	}
+	var revisions []gitsync.Revision
+	if err := json.Unmarshal(body, &revisions); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(revisions) != 1 || len(revisions[0].Labels) != 1 || revisions[0].Labels[0] != "v1.0.0" {
+		t.Fatalf("unexpected revisions: %+v", revisions)
+	}
+
+	mod, err := svc.casReg.Module(context.Background(), "acme", "pets")
+	if err != nil {
+		t.Fatalf("module not imported: %v", err)
+	}
+	if commit, err := mod.Commit(context.Background(), "v1.0.0"); err != nil || commit.ID != revisions[0].CommitID {
+		t.Errorf("v1.0.0 label not imported: %v", err)
+	}
+}
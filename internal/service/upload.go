@@ -5,44 +5,41 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"regexp"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
 
 	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
 	"connectrpc.com/connect"
 	"github.com/greatliontech/pbr/internal/registry"
-	"google.golang.org/protobuf/types/known/timestamppb"
+	"github.com/greatliontech/pbr/internal/storage"
 )
 
-// protoImportRegex matches import statements in proto files.
-var protoImportRegex = regexp.MustCompile(`import\s+"([^"]+)"`)
-
-// extractProtoImports extracts import paths from proto files.
-func extractProtoImports(files []registry.File) []string {
-	imports := make([]string, 0)
-	seen := make(map[string]bool)
+// fileImport is an import statement of an uploaded proto file.
+type fileImport struct {
+	file string
+	registry.ProtoImport
+}
 
+// protoImports returns the imports of the proto files, except those of well-known types.
+func protoImports(files []registry.File) ([]fileImport, error) {
+	var imports []fileImport
 	for _, f := range files {
-		if len(f.Path) < 6 || f.Path[len(f.Path)-6:] != ".proto" {
+		if !strings.HasSuffix(f.Path, ".proto") {
 			continue
 		}
-
-		matches := protoImportRegex.FindAllStringSubmatch(f.Content, -1)
-		for _, match := range matches {
-			if len(match) > 1 {
-				imp := match[1]
-				// Skip google protobuf imports (standard library)
-				if len(imp) > 7 && imp[:7] == "google/" {
-					continue
-				}
-				if !seen[imp] {
-					seen[imp] = true
-					imports = append(imports, imp)
-				}
+		fileImports, err := registry.ParseProtoImports(f.Content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Path, err)
+		}
+		for _, imp := range fileImports {
+			if !registry.WellKnownImport(imp.Path) {
+				imports = append(imports, fileImport{file: f.Path, ProtoImport: imp})
 			}
 		}
 	}
-
-	return imports
+	return imports, nil
 }
 
 // UploadService implements the v1 UploadService interface by wrapping Service.
@@ -66,143 +63,651 @@ func (u *UploadService) Upload(
 
 	slog.DebugContext(ctx, "UploadV1", "contents", len(req.Msg.Contents), "depCommitIds", len(req.Msg.DepCommitIds))
 
+	contents := make([]moduleContent, 0, len(req.Msg.Contents))
+	for _, content := range req.Msg.Contents {
+		owner, modName, err := u.resolveModuleRef(content.ModuleRef)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid module reference: %w", err))
+		}
+		files := make([]registry.File, 0, len(content.Files))
+		for _, f := range content.Files {
+			files = append(files, registry.File{Path: f.Path, Content: string(f.Content)})
+		}
+		contents = append(contents, moduleContent{
+			owner:            owner,
+			module:           modName,
+			files:            files,
+			labels:           u.extractLabels(content.ScopedLabelRefs),
+			sourceControlURL: content.SourceControlUrl,
+		})
+	}
+
+	if err := parseLabelExpectations(req.Header(), contents); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	// v1 dependencies are always commits of this registry
+	refs := make([]depRef, 0, len(req.Msg.DepCommitIds))
+	for _, id := range req.Msg.DepCommitIds {
+		refs = append(refs, depRef{commitID: id})
+	}
+
+	commits, err := u.upload(ctx, contents, refs)
+	if err != nil {
+		return nil, err
+	}
+
 	resp := &v1.UploadResponse{
-		Commits: make([]*v1.Commit, 0, len(req.Msg.Contents)),
+		Commits: make([]*v1.Commit, 0, len(commits)),
+	}
+	for _, commit := range commits {
+		resp.Commits = append(resp.Commits, getCommitObjectV1(commit))
 	}
+	res := connect.NewResponse(resp)
+	setLintWarnings(res.Header(), contents, commits)
+	setCompileWarnings(res.Header(), contents, commits)
+	u.svc.setQuotaWarnings(ctx, res.Header(), contents)
+	return res, nil
+}
 
-	for _, content := range req.Msg.Contents {
-		commit, err := u.uploadContent(ctx, content, req.Msg.DepCommitIds)
-		if err != nil {
-			return nil, connect.NewError(connect.CodeInternal, err)
+// moduleContent is the content of one module in an upload, independent of the API version.
+type moduleContent struct {
+	owner            string
+	module           string
+	files            []registry.File
+	labels           []string
+	sourceControlURL string
+	// expect maps labels to the commit they must point at to be moved, empty if they must not exist
+	expect map[string]string
+}
+
+// ExpectLabelHeader is the request header of upload RPCs making label moves conditional.
+// Each value is "label=commitID", optionally prefixed with "owner/module:" to apply to one
+// module of the upload. A label is only moved if it points at commitID, or doesn't exist if
+// commitID is empty. Otherwise the upload fails with Aborted.
+const ExpectLabelHeader = "Pbr-Expect-Label"
+
+// parseLabelExpectations sets the label conditions of contents from the ExpectLabelHeader values of header.
+func parseLabelExpectations(header http.Header, contents []moduleContent) error {
+	for _, value := range header.Values(ExpectLabelHeader) {
+		module, cond, scoped := strings.Cut(value, ":")
+		if !scoped {
+			module, cond = "", value
+		}
+		label, commitID, ok := strings.Cut(cond, "=")
+		if !ok || label == "" {
+			return fmt.Errorf("invalid %s %q, expected [owner/module:]label=commitID", ExpectLabelHeader, value)
+		}
+		matched := false
+		for i := range contents {
+			if module != "" && module != contents[i].owner+"/"+contents[i].module {
+				continue
+			}
+			if contents[i].expect == nil {
+				contents[i].expect = make(map[string]string)
+			}
+			contents[i].expect[label] = commitID
+			matched = true
+		}
+		if !matched {
+			return fmt.Errorf("invalid %s %q: module %s is not uploaded", ExpectLabelHeader, value, module)
 		}
-		resp.Commits = append(resp.Commits, commit)
 	}
+	return nil
+}
 
-	return connect.NewResponse(resp), nil
+// depRef is a dependency of an upload. An empty registry means this registry.
+type depRef struct {
+	commitID string
+	registry string
 }
 
-func (u *UploadService) uploadContent(ctx context.Context, content *v1.UploadRequest_Content, depCommitIDs []string) (*v1.Commit, error) {
-	// Resolve module reference
-	owner, modName, err := u.resolveModuleRef(content.ModuleRef)
-	if err != nil {
-		return nil, fmt.Errorf("invalid module reference: %w", err)
+// upload commits the contents of an upload and returns their commits in the same order.
+// The commits and label moves are applied all or nothing.
+// Every content depends on the given deps, except on other commits of its own module,
+// and on the contents of the same upload whose files it imports.
+func (u *UploadService) upload(ctx context.Context, contents []moduleContent, refs []depRef) ([]*registry.Commit, error) {
+	if err := u.svc.validateContents(contents); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	for _, content := range contents {
+		if err := registry.ValidateBufConfig(content.files); err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%s/%s: %w", content.owner, content.module, err))
+		}
 	}
 
-	slog.DebugContext(ctx, "uploading content v1", "owner", owner, "module", modName, "files", len(content.Files), "depCommitIds", len(depCommitIDs))
+	// Record the pushing user on new commits
+	var createdByUserID string
+	if username := userFromContext(ctx); username != "" {
+		user, err := u.svc.casReg.GetOrCreateUser(ctx, username)
+		if err != nil {
+			return nil, provisionUserError(err)
+		}
+		createdByUserID = user.ID
+	}
 
-	// Get or create module
-	mod, err := u.svc.casReg.GetOrCreateModule(ctx, owner, modName)
+	deps, err := u.resolveDeps(ctx, refs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get or create module: %w", err)
+		return nil, err
 	}
 
-	// Convert files
-	files := make([]registry.File, 0, len(content.Files))
-	for _, f := range content.Files {
-		files = append(files, registry.File{
-			Path:    f.Path,
-			Content: string(f.Content),
-		})
+	imports, order, err := contentImports(contents)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
 
-	// Extract labels from scoped label refs
-	labels := u.extractLabels(content.ScopedLabelRefs)
-	if len(labels) == 0 {
-		// Default to "main" if no labels specified
-		labels = []string{"main"}
+	// Imports of files in the upload are satisfied by its own contents
+	uploadedFiles := make(map[string]bool)
+	for _, content := range contents {
+		for _, f := range content.files {
+			uploadedFiles[f.Path] = true
+		}
 	}
 
-	// If no dependencies provided, try to detect from proto imports
-	if len(depCommitIDs) == 0 {
-		detectedDeps := u.detectDependenciesFromImports(ctx, files)
-		if len(detectedDeps) > 0 {
-			slog.DebugContext(ctx, "detected dependencies from imports", "count", len(detectedDeps))
-			depCommitIDs = detectedDeps
+	// Commits are prepared first and then recorded together, so that a failing content
+	// leaves neither commits nor moved labels behind
+	batch := &uploadBatch{pending: make(map[string]*registry.PendingCommit)}
+	prepared := make([]*registry.PendingCommit, len(contents))
+	// uploaded holds the dependencies on each uploaded content, including its own transitive ones
+	uploaded := make([][]storage.DepRecord, len(contents))
+	// The dependencies of an upload of several contents are shared, each content only
+	// depends on those its files reach
+	var depFiles [][]registry.File
+	if len(contents) > 1 && len(deps) > 0 {
+		if depFiles, err = u.svc.depFileSets(ctx, deps); err != nil {
+			return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("failed to load dependencies: %w", err))
 		}
 	}
+	for _, i := range order {
+		contentDeps := slices.Clone(deps)
+		if depFiles != nil {
+			contentDeps = reachedDeps(contents[i].files, deps, depFiles)
+		}
+		for _, j := range imports[i] {
+			contentDeps = append(contentDeps, uploaded[j]...)
+		}
 
-	// Get module digests for all dependencies
-	depDigests, err := u.svc.casReg.GetDepModuleDigests(ctx, depCommitIDs)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get dependency digests: %w", err)
+		var inferFrom map[string]bool
+		if len(refs) == 0 {
+			inferFrom = uploadedFiles
+		}
+		pending, err := u.prepareContent(ctx, batch, contents[i], contentDeps, inferFrom, createdByUserID)
+		if err != nil {
+			u.rollback(ctx, batch)
+			return nil, asConnectError(err, connect.CodeInternal)
+		}
+		prepared[i] = pending
+
+		uploaded[i] = []storage.DepRecord{pending.Commit().AsDep()}
+		for _, j := range imports[i] {
+			uploaded[i] = append(uploaded[i], uploaded[j]...)
+		}
 	}
 
-	// Create commit with dependency commit IDs and their module digests
-	commit, err := mod.CreateCommit(ctx, files, labels, content.SourceControlUrl, depCommitIDs, depDigests)
+	// Commits are applied in dependency order
+	ordered := make([]*registry.PendingCommit, 0, len(order))
+	for _, i := range order {
+		ordered = append(ordered, prepared[i])
+	}
+	unlock, err := u.svc.checkQuotas(ctx, ordered)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create commit: %w", err)
+		u.rollback(ctx, batch)
+		return nil, asConnectError(err, connect.CodeInternal)
+	}
+	err = u.svc.casReg.ApplyCommits(ctx, ordered)
+	unlock()
+	if err != nil {
+		u.rollback(ctx, batch)
+		if errors.Is(err, storage.ErrConflict) || errors.Is(err, registry.ErrLabelMoved) {
+			return nil, connect.NewError(connect.CodeAborted, err)
+		}
+		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
-	// Build response commit
-	return u.commitToProto(commit)
+	commits := make([]*registry.Commit, len(contents))
+	for i, pending := range prepared {
+		commits[i] = pending.Commit()
+	}
+	return commits, nil
 }
 
-// detectDependenciesFromImports parses proto imports and tries to resolve them to known modules.
-func (u *UploadService) detectDependenciesFromImports(ctx context.Context, files []registry.File) []string {
-	imports := extractProtoImports(files)
-	if len(imports) == 0 {
-		return nil
+// resolveDeps looks up the module and digest of each dependency, asking the hosting registry for remote ones.
+// Dependencies on mirrored registries are pulled and recorded as local ones.
+func (u *UploadService) resolveDeps(ctx context.Context, refs []depRef) ([]storage.DepRecord, error) {
+	deps := make([]storage.DepRecord, 0, len(refs))
+	for _, ref := range refs {
+		dep, err := u.svc.resolveDep(ctx, ref.registry, ref.commitID)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, dep)
 	}
+	return deps, nil
+}
 
-	slog.DebugContext(ctx, "extracted proto imports", "imports", imports)
+// depFileSets loads the files of each dependency, or nil for commits of other registries,
+// whose files are not stored here.
+func (svc *Service) depFileSets(ctx context.Context, deps []storage.DepRecord) ([][]registry.File, error) {
+	sets := make([][]registry.File, len(deps))
+	for i, dep := range deps {
+		if !svc.isLocalRegistry(dep.Registry) {
+			continue
+		}
+		mod, err := svc.casReg.ModuleByID(ctx, dep.ModuleID)
+		if err != nil {
+			return nil, fmt.Errorf("module of commit %s: %w", dep.CommitID, err)
+		}
+		files, _, err := mod.FilesAndCommitByCommitID(ctx, dep.CommitID)
+		if err != nil {
+			return nil, fmt.Errorf("commit %s: %w", dep.CommitID, err)
+		}
+		sets[i] = files
+	}
+	return sets, nil
+}
 
-	// Try to resolve each import to a known module
-	depCommitIDs := make([]string, 0)
-	seen := make(map[string]bool)
+// reachedDeps returns the dependencies whose files the given files import, directly or
+// through the files of other dependencies, in the order of deps. Dependencies without
+// files, on other registries, can't be traced and are always kept.
+func reachedDeps(files []registry.File, deps []storage.DepRecord, depFiles [][]registry.File) []storage.DepRecord {
+	provider := make(map[string]int)
+	for i, set := range depFiles {
+		for _, f := range set {
+			provider[f.Path] = i
+		}
+	}
 
-	for _, imp := range imports {
-		// Look for modules that have this file
-		commitID := u.findModuleWithFile(ctx, imp)
-		if commitID != "" && !seen[commitID] {
-			seen[commitID] = true
-			depCommitIDs = append(depCommitIDs, commitID)
-			slog.DebugContext(ctx, "resolved import to commit", "import", imp, "commitID", commitID)
+	reached := make([]bool, len(deps))
+	queue := files
+	for len(queue) > 0 {
+		f := queue[0]
+		queue = queue[1:]
+		// Files that don't parse import nothing here, the compiler reports them
+		imports, _ := protoImports([]registry.File{f})
+		for _, imp := range imports {
+			if i, ok := provider[imp.Path]; ok && !reached[i] {
+				reached[i] = true
+				queue = append(queue, depFiles[i]...)
+			}
 		}
 	}
 
-	return depCommitIDs
+	var kept []storage.DepRecord
+	for i, dep := range deps {
+		if reached[i] || depFiles[i] == nil {
+			kept = append(kept, dep)
+		}
+	}
+	return kept
 }
 
-// findModuleWithFile searches for a module that contains the given file path.
-func (u *UploadService) findModuleWithFile(ctx context.Context, filePath string) string {
-	// This is a simple implementation that searches all known modules.
-	// In production, this could be optimized with an index.
+// resolveDep returns the dependency record of a commit of this registry, a mirrored registry or a remote one.
+func (svc *Service) resolveDep(ctx context.Context, registry, commitID string) (storage.DepRecord, error) {
+	if svc.isLocalRegistry(registry) {
+		commit, err := svc.casReg.CommitByID(ctx, commitID)
+		if err != nil {
+			return storage.DepRecord{}, connect.NewError(connect.CodeNotFound, fmt.Errorf("dependency commit not found: %s", commitID))
+		}
+		return commit.AsDep(), nil
+	}
 
-	// List all owners and their modules
-	owners, err := u.svc.casReg.ListOwners(ctx)
+	if mirror := svc.mirror(registry); mirror != nil {
+		_, commit, err := svc.pullCommit(ctx, mirror, commitID)
+		if err != nil {
+			return storage.DepRecord{}, err
+		}
+		return commit.AsDep(), nil
+	}
+
+	slog.DebugContext(ctx, "fetching remote dependency", "registry", registry, "commitID", commitID)
+	commit, err := svc.remoteCommit(ctx, registry, commitID)
 	if err != nil {
-		slog.DebugContext(ctx, "failed to list owners", "error", err)
-		return ""
+		return storage.DepRecord{}, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("dependency %s on %s: %w", commitID, registry, err))
+	}
+	if commit.Digest.GetType() != v1.DigestType_DIGEST_TYPE_B5 {
+		return storage.DepRecord{}, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("dependency %s on %s has no b5 digest", commitID, registry))
+	}
+	return storage.DepRecord{
+		CommitID: commit.Id,
+		Registry: registry,
+		OwnerID:  commit.OwnerId,
+		ModuleID: commit.ModuleId,
+		Digest:   storage.ModuleDigest{Type: storage.DigestTypeB5, Value: commit.Digest.Value},
+	}, nil
+}
+
+// contentImports finds, for each content, the other contents whose files it imports,
+// and returns an order in which every content comes after the contents it imports.
+func contentImports(contents []moduleContent) ([][]int, []int, error) {
+	fileContent := make(map[string]int)
+	for i, content := range contents {
+		for _, f := range content.files {
+			fileContent[f.Path] = i
+		}
 	}
 
-	for _, owner := range owners {
-		modules, err := u.svc.casReg.ListModules(ctx, owner.Name)
+	imports := make([][]int, len(contents))
+	for i, content := range contents {
+		contentImports, err := protoImports(content.files)
 		if err != nil {
+			return nil, nil, fmt.Errorf("%s/%s: %w", content.owner, content.module, err)
+		}
+		for _, imp := range contentImports {
+			if j, ok := fileContent[imp.Path]; ok && j != i && !slices.Contains(imports[i], j) {
+				imports[i] = append(imports[i], j)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(contents))
+	order := make([]int, 0, len(contents))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("import cycle between uploaded modules involving %s/%s", contents[i].owner, contents[i].module)
+		case done:
+			return nil
+		}
+		state[i] = visiting
+		for _, j := range imports[i] {
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		state[i] = done
+		order = append(order, i)
+		return nil
+	}
+	for i := range contents {
+		if err := visit(i); err != nil {
+			return nil, nil, err
+		}
+	}
+	return imports, order, nil
+}
+
+// uploadBatch holds the commits prepared for an upload until they are applied together.
+type uploadBatch struct {
+	// pending are the prepared commits by ID
+	pending map[string]*registry.PendingCommit
+	// created are the modules created by the upload, deleted again if it fails
+	created []*registry.Module
+}
+
+// prepareContent prepares the commit of one content. If uploadedFiles is set, the content's
+// dependencies are inferred and its imports of these files are taken as satisfied.
+func (u *UploadService) prepareContent(ctx context.Context, batch *uploadBatch, content moduleContent, deps []storage.DepRecord, uploadedFiles map[string]bool, createdByUserID string) (*registry.PendingCommit, error) {
+	slog.DebugContext(ctx, "uploading content", "owner", content.owner, "module", content.module, "files", len(content.files), "deps", len(deps))
+
+	// Get or create module
+	mod, err := u.svc.casReg.Module(ctx, content.owner, content.module)
+	created := false
+	if errors.Is(err, storage.ErrNotFound) {
+		mod, err = u.svc.casReg.CreateModule(ctx, content.owner, content.module, "")
+		if err == nil {
+			batch.created = append(batch.created, mod)
+			created = true
+		}
+	}
+	if errors.Is(err, registry.ErrModuleDeleted) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("module %s/%s is deleted and can be restored until it is purged", content.owner, content.module))
+	}
+	if errors.Is(err, registry.ErrModuleRenamed) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("module %s/%s: %w, upload to its new name", content.owner, content.module, err))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get or create module: %w", err)
+	}
+
+	if mod.Mirror() != "" {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("module %s/%s is mirrored from %s and can't be uploaded to", content.owner, content.module, mod.Mirror()))
+	}
+
+	labels := content.labels
+	if len(labels) == 0 {
+		// Default to the module's default label if no labels specified
+		labels = []string{mod.DefaultLabelName()}
+	}
+
+	if uploadedFiles != nil {
+		inferred, err := u.inferDeps(ctx, mod, content.files, uploadedFiles)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, inferred...)
+	}
+
+	// A module never depends on itself, and each dependency is recorded once
+	seen := make(map[string]bool)
+	deps = slices.DeleteFunc(deps, func(dep storage.DepRecord) bool {
+		if dep.ModuleID == mod.ID() || seen[dep.CommitID] {
+			return true
+		}
+		seen[dep.CommitID] = true
+		return false
+	})
+
+	compiled, ok, err := u.svc.compileModule(ctx, mod, content.files, deps, batch.pending)
+	if err != nil {
+		return nil, err
+	}
+	var compileFindings []storage.Finding
+	if !ok {
+		finding, err := u.svc.checkUncompiled(ctx, mod)
+		if err != nil {
+			return nil, err
+		}
+		compileFindings = append(compileFindings, finding)
+	}
+	breakingFindings, err := u.svc.checkBreaking(ctx, mod, labels, compiled)
+	if err != nil {
+		return nil, err
+	}
+	lintFindings, err := u.svc.checkLint(ctx, mod, compiled)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := mod.PrepareCommit(ctx, time.Now(), content.files, labels, content.sourceControlURL, createdByUserID, deps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create commit: %w", err)
+	}
+	if created {
+		pending.ModuleCreated()
+	}
+	if len(breakingFindings) > 0 {
+		pending.SetFindings(breakingCheck, breakingFindings)
+	}
+	if len(lintFindings) > 0 {
+		pending.SetFindings(lintCheck, lintFindings)
+	}
+	if len(compileFindings) > 0 {
+		pending.SetFindings(compileCheck, compileFindings)
+	}
+	for label, commitID := range content.expect {
+		if !slices.Contains(labels, label) {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%s/%s: %s names label %s, which the upload doesn't move", content.owner, content.module, ExpectLabelHeader, label))
+		}
+		pending.ExpectLabel(label, commitID)
+	}
+	batch.pending[pending.Commit().ID] = pending
+	return pending, nil
+}
+
+// rollback deletes the modules created by a failed upload, unless commits were pushed to them meanwhile.
+func (u *UploadService) rollback(ctx context.Context, batch *uploadBatch) {
+	ctx = context.WithoutCancel(ctx)
+	for _, mod := range batch.created {
+		if commits, _, err := mod.ListCommits(ctx, 1, ""); err != nil || len(commits) > 0 {
 			continue
 		}
+		if err := u.svc.casReg.DeleteModule(ctx, mod.Owner(), mod.Name()); err != nil {
+			slog.ErrorContext(ctx, "failed to delete module of failed upload", "owner", mod.Owner(), "module", mod.Name(), "error", err)
+		}
+	}
+}
 
-		for _, mod := range modules {
-			// Get the latest commit (main label)
-			commit, err := mod.Commit(ctx, "main")
+// abandonedBatchAge is how long ago an upload must have started applying its commits for
+// its leftover journal to be taken as interrupted. Younger ones may still be applied by
+// another replica.
+const abandonedBatchAge = 5 * time.Minute
+
+// runBatchRecovery undoes the uploads interrupted by a crash, every abandonedBatchAge,
+// until ctx is done.
+func (svc *Service) runBatchRecovery(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(abandonedBatchAge):
+		}
+		n, err := svc.casReg.RecoverBatches(ctx, time.Now().Add(-abandonedBatchAge))
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to recover commit batches", "error", err)
+		} else if n > 0 {
+			slog.WarnContext(ctx, "interrupted commit batches undone", "count", n)
+		}
+	}
+}
+
+// inferDeps infers the dependencies of a module uploaded without any. They are taken from
+// its buf.lock if present, else from the deps of its buf.yaml, else its proto imports are
+// resolved with the registry's file index. Imports of uploadedFiles are skipped.
+func (u *UploadService) inferDeps(ctx context.Context, mod *registry.Module, files []registry.File, uploadedFiles map[string]bool) ([]storage.DepRecord, error) {
+	var lock *registry.BufLock
+	var conf *registry.BufYAML
+	for _, f := range files {
+		var err error
+		switch f.Path {
+		case "buf.lock":
+			lock, err = registry.ParseBufLock(f.Content)
+		case "buf.yaml":
+			conf, err = registry.ParseBufYAML(f.Content)
+		}
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
+
+	name := mod.Owner() + "/" + mod.Name()
+	var deps []storage.DepRecord
+	switch {
+	case lock != nil:
+		for _, dep := range lock.Deps {
+			record, err := u.svc.resolveDep(ctx, dep.Remote, dep.Commit)
 			if err != nil {
-				continue
+				return nil, asConnectError(fmt.Errorf("%s: buf.lock dependency %s: %w", name, dep.Name(), err), connect.CodeFailedPrecondition)
 			}
+			deps = append(deps, record)
+		}
+		slog.DebugContext(ctx, "dependencies from buf.lock", "module", name, "count", len(deps))
+		return deps, nil
 
-			// Check if this commit has the file
-			files, _, err := mod.FilesAndCommitByCommitID(ctx, commit.ID)
+	case conf != nil && len(conf.Deps) > 0:
+		for _, dep := range conf.Deps {
+			record, err := u.bufYAMLDep(ctx, dep)
 			if err != nil {
-				continue
+				return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("%s: buf.yaml dependency %s: %w", name, dep, err))
 			}
+			deps = append(deps, record)
+		}
+		slog.DebugContext(ctx, "dependencies from buf.yaml", "module", name, "count", len(deps))
+		return deps, nil
+	}
 
-			for _, f := range files {
-				if f.Path == filePath {
-					return commit.ID
-				}
+	imports, err := protoImports(files)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("%s: %w", name, err))
+	}
+	var problems []string
+	seen := make(map[string]bool)
+	for _, imp := range imports {
+		if uploadedFiles[imp.Path] || seen[imp.Path] {
+			continue
+		}
+		seen[imp.Path] = true
+
+		candidates, err := u.importCandidates(ctx, mod, imp.Path)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		switch {
+		case len(candidates) == 1:
+			slog.DebugContext(ctx, "resolved import to commit", "import", imp.Path, "commitID", candidates[0].ID)
+			deps = append(deps, candidates[0].AsDep())
+		case len(candidates) > 1:
+			var names []string
+			for _, c := range candidates {
+				names = append(names, c.Owner+"/"+c.Module)
 			}
+			problems = append(problems, fmt.Sprintf("%s:%d: import %q is ambiguous, provided by %s", imp.file, imp.Line, imp.Path, strings.Join(names, ", ")))
+		case !imp.Weak:
+			problems = append(problems, fmt.Sprintf("%s:%d: import %q not found in any module", imp.file, imp.Line, imp.Path))
 		}
 	}
+	if len(problems) > 0 {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf(
+			"%s: cannot infer dependencies, add a buf.lock or pass dependencies explicitly: %s", name, strings.Join(problems, "; ")))
+	}
+	return deps, nil
+}
 
-	return ""
+// importCandidate is the default label commit of a module providing an imported file.
+type importCandidate struct {
+	*registry.Commit
+	Owner  string
+	Module string
+}
+
+// importCandidates returns the default label commits of the modules, other than mod,
+// that provide an imported file.
+func (u *UploadService) importCandidates(ctx context.Context, mod *registry.Module, path string) ([]importCandidate, error) {
+	modules, err := u.svc.casReg.ModulesWithFile(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up %s: %w", path, err)
+	}
+	var candidates []importCandidate
+	for _, m := range modules {
+		if m.ID() == mod.ID() {
+			continue
+		}
+		commit, err := m.Commit(ctx, "")
+		if err != nil {
+			// Modules without a default label commit are not resolved to
+			continue
+		}
+		candidates = append(candidates, importCandidate{Commit: commit, Owner: m.Owner(), Module: m.Name()})
+	}
+	return candidates, nil
+}
+
+// bufYAMLDep resolves a buf.yaml dependency, "remote/owner/repository" with an optional
+// ":ref", to a commit of this registry. Dependencies on other registries are pinned by
+// buf.lock only.
+func (u *UploadService) bufYAMLDep(ctx context.Context, dep string) (storage.DepRecord, error) {
+	name, ref, _ := strings.Cut(dep, ":")
+	remote, owner, repository, err := registry.ParseModuleName(name)
+	if err != nil {
+		return storage.DepRecord{}, err
+	}
+	if !u.svc.isLocalRegistry(remote) {
+		return storage.DepRecord{}, errors.New("dependencies on other registries require a buf.lock")
+	}
+	mod, err := u.svc.casReg.Module(ctx, owner, repository)
+	if err != nil {
+		return storage.DepRecord{}, fmt.Errorf("module not found: %w", err)
+	}
+	commit, err := mod.Commit(ctx, ref)
+	if err != nil {
+		return storage.DepRecord{}, err
+	}
+	return commit.AsDep(), nil
 }
 
 func (u *UploadService) resolveModuleRef(ref *v1.ModuleRef) (owner, name string, err error) {
@@ -243,16 +748,3 @@ func (u *UploadService) extractLabels(refs []*v1.ScopedLabelRef) []string {
 	}
 	return labels
 }
-
-func (u *UploadService) commitToProto(commit *registry.Commit) (*v1.Commit, error) {
-	return &v1.Commit{
-		Id:         commit.ID,
-		OwnerId:    commit.OwnerID,
-		ModuleId:   commit.ModuleID,
-		CreateTime: timestamppb.New(commit.CreateTime),
-		Digest: &v1.Digest{
-			Type:  v1.DigestType_DIGEST_TYPE_B5,
-			Value: commit.ModuleDigest.Value,
-		},
-	}, nil
-}
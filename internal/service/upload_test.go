@@ -0,0 +1,680 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	v1beta1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1beta1"
+	ownerv1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/owner/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+func TestUpload_RecordsPushingUser(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := contextWithUser(context.Background(), "alice")
+	uploadSvc := NewUploadService(svc)
+
+	resp, err := uploadSvc.Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+		Contents: []*v1.UploadRequest_Content{
+			{
+				ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: "petapis"}}},
+				Files:     []*v1.File{{Path: "pet.proto", Content: []byte(`syntax = "proto3";`)}},
+			},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	user, err := svc.casReg.OwnerByName(ctx, "alice")
+	if err != nil {
+		t.Fatalf("user not provisioned: %v", err)
+	}
+	commit := resp.Msg.Commits[0]
+	if commit.CreatedByUserId != user.ID {
+		t.Errorf("created_by_user_id = %q, want %q", commit.CreatedByUserId, user.ID)
+	}
+
+	// The stored commit resolves through the commit and owner services
+	commits, err := NewCommitServiceV1(svc).GetCommits(ctx, connect.NewRequest(&v1.GetCommitsRequest{
+		ResourceRefs: []*v1.ResourceRef{{Value: &v1.ResourceRef_Id{Id: commit.Id}}},
+	}))
+	if err != nil {
+		t.Fatalf("GetCommits failed: %v", err)
+	}
+	if got := commits.Msg.Commits[0].CreatedByUserId; got != user.ID {
+		t.Errorf("GetCommits created_by_user_id = %q, want %q", got, user.ID)
+	}
+
+	owners, err := svc.GetOwners(ctx, connect.NewRequest(&ownerv1.GetOwnersRequest{
+		OwnerRefs: []*ownerv1.OwnerRef{{Value: &ownerv1.OwnerRef_Id{Id: commit.CreatedByUserId}}},
+	}))
+	if err != nil {
+		t.Fatalf("GetOwners failed: %v", err)
+	}
+	if u := owners.Msg.Owners[0].GetUser(); u == nil || u.Name != "alice" {
+		t.Errorf("expected user alice, got %v", owners.Msg.Owners[0])
+	}
+}
+
+// fakeRemoteCommits serves commits of other registries from memory.
+type fakeRemoteCommits map[string]*v1.Commit
+
+func (f fakeRemoteCommits) FetchCommit(_ context.Context, registry, commitID string) (*v1.Commit, error) {
+	if c, ok := f[registry+"/"+commitID]; ok {
+		return c, nil
+	}
+	return nil, connect.NewError(connect.CodeNotFound, errors.New("commit not found"))
+}
+
+func TestUploadV1beta1_RemoteDepRefs(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	remote := &v1.Commit{
+		Id:       "cc916c31859748a68fd229a3c8d7a2e8",
+		OwnerId:  "remoteowner",
+		ModuleId: "remotemodule",
+		Digest:   &v1.Digest{Type: v1.DigestType_DIGEST_TYPE_B5, Value: bytes.Repeat([]byte{7}, 64)},
+	}
+	svc.remoteCommits = fakeRemoteCommits{"buf.build/" + remote.Id: remote}
+
+	local := createTestModule(t, svc, "testowner", "common", []registry.File{
+		{Path: "common.proto", Content: `syntax = "proto3";`},
+	}, []string{"main"})
+
+	ctx := contextWithUser(context.Background(), "testuser")
+	resp, err := svc.Upload(ctx, connect.NewRequest(&v1beta1.UploadRequest{
+		Contents: []*v1beta1.UploadRequest_Content{
+			{
+				ModuleRef: &v1beta1.ModuleRef{Value: &v1beta1.ModuleRef_Name_{Name: &v1beta1.ModuleRef_Name{Owner: "testowner", Module: "app"}}},
+				Files:     []*v1beta1.File{{Path: "app.proto", Content: []byte(`syntax = "proto3";`)}},
+			},
+		},
+		DepRefs: []*v1beta1.UploadRequest_DepRef{
+			{CommitId: local.ID, Registry: "test.registry.com"},
+			{CommitId: remote.Id, Registry: "buf.build"},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	graph, err := svc.GetGraph(ctx, connect.NewRequest(&v1beta1.GetGraphRequest{
+		ResourceRefs: []*v1beta1.GetGraphRequest_ResourceRef{
+			{ResourceRef: &v1beta1.ResourceRef{Value: &v1beta1.ResourceRef_Id{Id: resp.Msg.Commits[0].Id}}},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("GetGraph failed: %v", err)
+	}
+
+	registries := map[string]string{}
+	for _, c := range graph.Msg.Graph.Commits {
+		registries[c.Commit.Id] = c.Registry
+	}
+	if registries[local.ID] != "test.registry.com" {
+		t.Errorf("local dependency registry = %q, want test.registry.com", registries[local.ID])
+	}
+	if registries[remote.Id] != "buf.build" {
+		t.Errorf("remote dependency registry = %q, want buf.build", registries[remote.Id])
+	}
+	if len(graph.Msg.Graph.Edges) != 2 {
+		t.Errorf("expected 2 edges, got %d", len(graph.Msg.Graph.Edges))
+	}
+
+	// The v1 graph includes the remote commit with its B5 digest
+	graphV1, err := NewGraphServiceV1(svc).GetGraph(ctx, connect.NewRequest(&v1.GetGraphRequest{
+		ResourceRefs: []*v1.ResourceRef{{Value: &v1.ResourceRef_Id{Id: resp.Msg.Commits[0].Id}}},
+	}))
+	if err != nil {
+		t.Fatalf("GetGraph v1 failed: %v", err)
+	}
+	var found bool
+	for _, c := range graphV1.Msg.Graph.Commits {
+		if c.Id == remote.Id {
+			found = true
+			if c.ModuleId != remote.ModuleId || !bytes.Equal(c.Digest.Value, remote.Digest.Value) {
+				t.Errorf("remote commit = %v, want module %s and its digest", c, remote.ModuleId)
+			}
+		}
+	}
+	if !found {
+		t.Error("remote dependency missing from v1 graph")
+	}
+}
+
+func TestUploadV1beta1_UnknownRemoteDep(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.remoteCommits = fakeRemoteCommits{}
+
+	ctx := contextWithUser(context.Background(), "testuser")
+	_, err := svc.Upload(ctx, connect.NewRequest(&v1beta1.UploadRequest{
+		Contents: []*v1beta1.UploadRequest_Content{
+			{
+				ModuleRef: &v1beta1.ModuleRef{Value: &v1beta1.ModuleRef_Name_{Name: &v1beta1.ModuleRef_Name{Owner: "testowner", Module: "app"}}},
+				Files:     []*v1beta1.File{{Path: "app.proto", Content: []byte(`syntax = "proto3";`)}},
+			},
+		},
+		DepRefs: []*v1beta1.UploadRequest_DepRef{{CommitId: "cc916c31859748a68fd229a3c8d7a2e8", Registry: "buf.build"}},
+	}))
+	if connect.CodeOf(err) != connect.CodeFailedPrecondition {
+		t.Errorf("expected FailedPrecondition, got %v", err)
+	}
+}
+
+func TestUploadV1beta1_RemoteDepsUncompiled(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	remote := &v1.Commit{
+		Id:       "cc916c31859748a68fd229a3c8d7a2e8",
+		OwnerId:  "remoteowner",
+		ModuleId: "remotemodule",
+		Digest:   &v1.Digest{Type: v1.DigestType_DIGEST_TYPE_B5, Value: bytes.Repeat([]byte{7}, 64)},
+	}
+	svc.remoteCommits = fakeRemoteCommits{"buf.build/" + remote.Id: remote}
+
+	ctx := contextWithUser(context.Background(), "testuser")
+	upload := func() (*connect.Response[v1beta1.UploadResponse], error) {
+		return svc.Upload(ctx, connect.NewRequest(&v1beta1.UploadRequest{
+			Contents: []*v1beta1.UploadRequest_Content{
+				{
+					ModuleRef: &v1beta1.ModuleRef{Value: &v1beta1.ModuleRef_Name_{Name: &v1beta1.ModuleRef_Name{Owner: "testowner", Module: "app"}}},
+					Files:     []*v1beta1.File{{Path: "app.proto", Content: []byte("syntax = \"proto3\";\nmessage App {\n  optional string name = 1;\n}\n")}},
+				},
+			},
+			DepRefs: []*v1beta1.UploadRequest_DepRef{{CommitId: remote.Id, Registry: "buf.build"}},
+		}))
+	}
+
+	// By default the upload is accepted unchecked, with a warning
+	resp, err := upload()
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	want := "testowner/app: not compiled, linted or checked for breaking changes: it depends on commits of other registries"
+	if got := resp.Header().Values(CompileWarningHeader); len(got) != 1 || got[0] != want {
+		t.Errorf("warnings = %q, want %q", got, want)
+	}
+	stored, err := svc.casReg.CommitByID(ctx, resp.Msg.Commits[0].Id)
+	if err != nil {
+		t.Fatalf("CommitByID failed: %v", err)
+	}
+	if len(stored.Findings) != 1 || stored.Findings[0].Check != compileCheck {
+		t.Errorf("expected the warning to be recorded on the commit, got %+v", stored.Findings)
+	}
+
+	// Error-level rules are never bypassed
+	svc.conf.Lint = &config.Lint{Default: config.LintPolicy{Error: []string{"FIELD_NO_PROTO3_OPTIONAL"}}}
+	if _, err := upload(); connect.CodeOf(err) != connect.CodeFailedPrecondition {
+		t.Errorf("expected FailedPrecondition with lint error rules, got %v", err)
+	}
+	svc.conf.Lint = nil
+	svc.conf.Breaking = &config.Breaking{Default: config.BreakingPolicy{Mode: config.BreakingReject}}
+	if _, err := upload(); connect.CodeOf(err) != connect.CodeFailedPrecondition {
+		t.Errorf("expected FailedPrecondition with breaking changes rejected, got %v", err)
+	}
+	svc.conf.Breaking = nil
+
+	svc.conf.Compile = &config.Compile{RemoteDeps: config.RemoteDepsReject}
+	if _, err := upload(); connect.CodeOf(err) != connect.CodeFailedPrecondition {
+		t.Errorf("expected FailedPrecondition in reject mode, got %v", err)
+	}
+}
+
+func TestUploadV1beta1_DepRefsPerContent(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	common := createTestModule(t, svc, "testowner", "common", []registry.File{
+		{Path: "common.proto", Content: `syntax = "proto3";`},
+	}, []string{"main"})
+	types := createTestModuleWithDeps(t, svc, "testowner", "types", []registry.File{
+		{Path: "types.proto", Content: "syntax = \"proto3\";\nimport \"common.proto\";"},
+	}, []string{"main"}, []string{common.ID})
+	other := createTestModule(t, svc, "testowner", "other", []registry.File{
+		{Path: "other.proto", Content: `syntax = "proto3";`},
+	}, []string{"main"})
+
+	content := func(module, file, imp string) *v1beta1.UploadRequest_Content {
+		return &v1beta1.UploadRequest_Content{
+			ModuleRef: &v1beta1.ModuleRef{Value: &v1beta1.ModuleRef_Name_{Name: &v1beta1.ModuleRef_Name{Owner: "testowner", Module: module}}},
+			Files:     []*v1beta1.File{{Path: file, Content: []byte("syntax = \"proto3\";\nimport \"" + imp + "\";")}},
+		}
+	}
+	ctx := contextWithUser(context.Background(), "testuser")
+	resp, err := svc.Upload(ctx, connect.NewRequest(&v1beta1.UploadRequest{
+		Contents: []*v1beta1.UploadRequest_Content{
+			content("app", "app.proto", "types.proto"),
+			content("tool", "tool.proto", "other.proto"),
+		},
+		DepRefs: []*v1beta1.UploadRequest_DepRef{
+			{CommitId: common.ID, Registry: "test.registry.com"},
+			{CommitId: types.ID, Registry: "test.registry.com"},
+			{CommitId: other.ID, Registry: "test.registry.com"},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	// Each content depends on the commits its imports reach, transitively
+	want := map[string][]string{
+		resp.Msg.Commits[0].Id: {common.ID, types.ID},
+		resp.Msg.Commits[1].Id: {other.ID},
+	}
+	for commitID, wantDeps := range want {
+		commit, err := svc.casReg.CommitByID(ctx, commitID)
+		if err != nil {
+			t.Fatalf("CommitByID failed: %v", err)
+		}
+		var got []string
+		for _, dep := range commit.Deps {
+			got = append(got, dep.CommitID)
+		}
+		if !slices.Equal(got, wantDeps) {
+			t.Errorf("deps of %s = %v, want %v", commitID, got, wantDeps)
+		}
+	}
+}
+
+// failingTransport fails the test on any outgoing request.
+type failingTransport struct{ t *testing.T }
+
+func (f failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.t.Errorf("unexpected request to %s", req.URL)
+	return nil, errors.New("no requests expected")
+}
+
+func TestUploadV1beta1_UnlistedRemoteRegistry(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.remoteCommits = &httpCommitFetcher{client: &http.Client{Transport: failingTransport{t}}, registries: []string{"buf.build"}}
+
+	ctx := contextWithUser(context.Background(), "testuser")
+	_, err := svc.Upload(ctx, connect.NewRequest(&v1beta1.UploadRequest{
+		Contents: []*v1beta1.UploadRequest_Content{
+			{
+				ModuleRef: &v1beta1.ModuleRef{Value: &v1beta1.ModuleRef_Name_{Name: &v1beta1.ModuleRef_Name{Owner: "testowner", Module: "app"}}},
+				Files:     []*v1beta1.File{{Path: "app.proto", Content: []byte(`syntax = "proto3";`)}},
+			},
+		},
+		DepRefs: []*v1beta1.UploadRequest_DepRef{{CommitId: "cc916c31859748a68fd229a3c8d7a2e8", Registry: "169.254.169.254"}},
+	}))
+	if connect.CodeOf(err) != connect.CodeFailedPrecondition {
+		t.Errorf("expected FailedPrecondition, got %v", err)
+	}
+}
+
+func TestUpload_DepsBetweenContents(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := contextWithUser(context.Background(), "testuser")
+	moduleRef := func(name string) *v1.ModuleRef {
+		return &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "testowner", Module: name}}}
+	}
+
+	// The importing module comes first, so commits must be created in import order
+	resp, err := NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+		Contents: []*v1.UploadRequest_Content{
+			{
+				ModuleRef: moduleRef("app"),
+				Files:     []*v1.File{{Path: "app.proto", Content: []byte("syntax = \"proto3\";\nimport \"types.proto\";")}},
+			},
+			{
+				ModuleRef: moduleRef("types"),
+				Files:     []*v1.File{{Path: "types.proto", Content: []byte(`syntax = "proto3";`)}},
+			},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	app, err := svc.casReg.CommitByID(ctx, resp.Msg.Commits[0].Id)
+	if err != nil {
+		t.Fatalf("CommitByID failed: %v", err)
+	}
+	if len(app.Deps) != 1 || app.Deps[0].CommitID != resp.Msg.Commits[1].Id {
+		t.Errorf("expected app to depend on the uploaded types commit %s, got %+v", resp.Msg.Commits[1].Id, app.Deps)
+	}
+
+	types, err := svc.casReg.CommitByID(ctx, resp.Msg.Commits[1].Id)
+	if err != nil {
+		t.Fatalf("CommitByID failed: %v", err)
+	}
+	if len(types.Deps) != 0 {
+		t.Errorf("expected types to have no dependencies, got %+v", types.Deps)
+	}
+}
+
+func TestUpload_InvalidBufConfig(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := contextWithUser(context.Background(), "alice")
+	for name, file := range map[string]*v1.File{
+		"buf.yaml": {Path: "buf.yaml", Content: []byte("version: v9\n")},
+		"buf.lock": {Path: "buf.lock", Content: []byte("version: v2\ndeps:\n  - name: acme\n")},
+	} {
+		_, err := NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+			Contents: []*v1.UploadRequest_Content{
+				{
+					ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: "petapis"}}},
+					Files:     []*v1.File{{Path: "pet.proto", Content: []byte(`syntax = "proto3";`)}, file},
+				},
+			},
+		}))
+		if connect.CodeOf(err) != connect.CodeInvalidArgument {
+			t.Errorf("%s: expected InvalidArgument, got %v", name, err)
+		}
+	}
+}
+
+func TestUpload_InferDepsFromImports(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := contextWithUser(context.Background(), "alice")
+	upload := func(module string, files ...*v1.File) (*v1.Commit, error) {
+		resp, err := NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+			Contents: []*v1.UploadRequest_Content{
+				{
+					ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: module}}},
+					Files:     files,
+				},
+			},
+		}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.Msg.Commits[0], nil
+	}
+	proto := func(path, content string) *v1.File {
+		return &v1.File{Path: path, Content: []byte(content)}
+	}
+
+	types, err := upload("types", proto("acme/types.proto", `syntax = "proto3";`))
+	if err != nil {
+		t.Fatalf("Upload types failed: %v", err)
+	}
+
+	// Commented imports and well-known types need no module
+	app, err := upload("app", proto("acme/app.proto", `syntax = "proto3";
+// import "acme/missing.proto";
+import "google/protobuf/timestamp.proto";
+import public "acme/types.proto";`))
+	if err != nil {
+		t.Fatalf("Upload app failed: %v", err)
+	}
+	commit, err := svc.casReg.CommitByID(ctx, app.Id)
+	if err != nil {
+		t.Fatalf("CommitByID failed: %v", err)
+	}
+	if len(commit.Deps) != 1 || commit.Deps[0].CommitID != types.Id {
+		t.Errorf("expected app to depend on types commit %s, got %+v", types.Id, commit.Deps)
+	}
+
+	_, err = upload("unresolved", proto("u.proto", "syntax = \"proto3\";\nimport \"acme/missing.proto\";"))
+	if connect.CodeOf(err) != connect.CodeFailedPrecondition || !strings.Contains(err.Error(), `u.proto:2: import "acme/missing.proto" not found`) {
+		t.Errorf("unresolved import: expected FailedPrecondition naming the import, got %v", err)
+	}
+
+	if _, err := upload("types2", proto("acme/types.proto", `syntax = "proto3";`)); err != nil {
+		t.Fatalf("Upload types2 failed: %v", err)
+	}
+	_, err = upload("ambiguous", proto("a.proto", `syntax = "proto3"; import "acme/types.proto";`))
+	if connect.CodeOf(err) != connect.CodeFailedPrecondition || !strings.Contains(err.Error(), "acme/types, acme/types2") {
+		t.Errorf("ambiguous import: expected FailedPrecondition naming both modules, got %v", err)
+	}
+}
+
+func TestFileIndex_DefaultLabel(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	modules := func(path string) []string {
+		t.Helper()
+		mods, err := svc.casReg.ModulesWithFile(ctx, path)
+		if err != nil {
+			t.Fatalf("ModulesWithFile failed: %v", err)
+		}
+		var names []string
+		for _, mod := range mods {
+			names = append(names, mod.Owner()+"/"+mod.Name())
+		}
+		return names
+	}
+
+	main := createTestModule(t, svc, "acme", "types", []registry.File{{Path: "acme/types.proto", Content: `syntax = "proto3";`}}, []string{"main"})
+
+	// Commits on other labels don't change the index
+	createTestModule(t, svc, "acme", "types", []registry.File{{Path: "acme/next.proto", Content: `syntax = "proto3";`}}, []string{"next"})
+	if got := modules("acme/types.proto"); len(got) != 1 {
+		t.Errorf("expected acme/types to still provide acme/types.proto, got %v", got)
+	}
+	if got := modules("acme/next.proto"); len(got) != 0 {
+		t.Errorf("expected no module to provide acme/next.proto, got %v", got)
+	}
+
+	// Moving the default label does
+	createTestModule(t, svc, "acme", "types", []registry.File{{Path: "acme/v2.proto", Content: `syntax = "proto3";`}}, []string{"main"})
+	if got := modules("acme/types.proto"); len(got) != 0 {
+		t.Errorf("expected no module to provide acme/types.proto after main moved from %s, got %v", main.ID, got)
+	}
+	if got := modules("acme/v2.proto"); len(got) != 1 {
+		t.Errorf("expected acme/types to provide acme/v2.proto, got %v", got)
+	}
+}
+
+func TestUpload_InferDepsFromBufLock(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := contextWithUser(context.Background(), "alice")
+	types := createTestModule(t, svc, "acme", "types", []registry.File{{Path: "acme/types.proto", Content: `syntax = "proto3";`}}, nil)
+	// Another module provides the imported file, but buf.lock takes precedence over imports
+	createTestModule(t, svc, "acme", "types2", []registry.File{{Path: "acme/types.proto", Content: `syntax = "proto3";`}}, nil)
+
+	lock := fmt.Sprintf("version: v1\ndeps:\n  - remote: test.registry.com\n    owner: acme\n    repository: types\n    commit: %s\n", types.ID)
+	resp, err := NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+		Contents: []*v1.UploadRequest_Content{
+			{
+				ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: "app"}}},
+				Files: []*v1.File{
+					{Path: "app.proto", Content: []byte(`syntax = "proto3"; import "acme/types.proto";`)},
+					{Path: "buf.lock", Content: []byte(lock)},
+				},
+			},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	commit, err := svc.casReg.CommitByID(ctx, resp.Msg.Commits[0].Id)
+	if err != nil {
+		t.Fatalf("CommitByID failed: %v", err)
+	}
+	if len(commit.Deps) != 1 || commit.Deps[0].CommitID != types.ID {
+		t.Errorf("expected the buf.lock dependency %s, got %+v", types.ID, commit.Deps)
+	}
+}
+
+func TestUpload_CompilesAgainstDependencyClosure(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := contextWithUser(context.Background(), "alice")
+	money := createTestModule(t, svc, "acme", "money", []registry.File{
+		{Path: "acme/money.proto", Content: "syntax = \"proto3\";\npackage acme;\nmessage Money {}\n"},
+	}, nil)
+	types := createTestModuleWithDeps(t, svc, "acme", "types", []registry.File{
+		{Path: "acme/types.proto", Content: "syntax = \"proto3\";\npackage acme;\nimport \"acme/money.proto\";\nmessage Price { Money amount = 1; }\n"},
+	}, nil, []string{money.ID})
+
+	upload := func(content string) error {
+		_, err := NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+			Contents: []*v1.UploadRequest_Content{
+				{
+					ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: "shop"}}},
+					Files:     []*v1.File{{Path: "acme/shop.proto", Content: []byte(content)}},
+				},
+			},
+			DepCommitIds: []string{types.ID},
+		}))
+		return err
+	}
+
+	// Money is only a transitive dependency
+	if err := upload("syntax = \"proto3\";\npackage acme;\nimport \"acme/types.proto\";\nimport \"acme/money.proto\";\nmessage Item { Price price = 1; Money cost = 2; }\n"); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	err := upload("syntax = \"proto3\";\npackage acme;\nimport \"acme/types.proto\";\nmessage Item { Unknown price = 1; }\n")
+	if connect.CodeOf(err) != connect.CodeInvalidArgument || !strings.Contains(err.Error(), "acme/shop.proto:4:16:") {
+		t.Errorf("expected InvalidArgument with the position of the unknown type, got %v", err)
+	}
+
+	err = upload("syntax = \"proto3\";\nmessage Price {\n")
+	if connect.CodeOf(err) != connect.CodeInvalidArgument || !strings.Contains(err.Error(), "acme/shop.proto:3:") {
+		t.Errorf("expected InvalidArgument with the position of the syntax error, got %v", err)
+	}
+}
+
+func TestUpload_AllOrNothing(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := contextWithUser(context.Background(), "alice")
+	before := createTestModule(t, svc, "acme", "types", []registry.File{
+		{Path: "acme/types.proto", Content: "syntax = \"proto3\";\npackage acme;\nmessage Money {}\n"},
+	}, []string{"main"})
+
+	content := func(module, path, proto string) *v1.UploadRequest_Content {
+		return &v1.UploadRequest_Content{
+			ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: module}}},
+			Files:     []*v1.File{{Path: path, Content: []byte(proto)}},
+		}
+	}
+	types := content("types", "acme/types.proto", "syntax = \"proto3\";\npackage acme;\nmessage Money {}\nmessage Price { Money amount = 1; }\n")
+
+	// The second module doesn't compile, so the first isn't published either
+	_, err := NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+		Contents: []*v1.UploadRequest_Content{
+			types,
+			content("shop", "acme/shop.proto", "syntax = \"proto3\";\npackage acme;\nimport \"acme/types.proto\";\nmessage Item { Unknown price = 1; }\n"),
+		},
+	}))
+	if connect.CodeOf(err) != connect.CodeInvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	mod, err := svc.casReg.Module(ctx, "acme", "types")
+	if err != nil {
+		t.Fatalf("Module failed: %v", err)
+	}
+	if got, err := mod.Commit(ctx, "main"); err != nil || got.ID != before.ID {
+		t.Errorf("expected main to stay at %s, got %v %v", before.ID, got, err)
+	}
+	if commits, _, _ := mod.ListCommits(ctx, 10, ""); len(commits) != 1 {
+		t.Errorf("expected no new commit of acme/types, got %d commits", len(commits))
+	}
+	if _, err := svc.casReg.Module(ctx, "acme", "shop"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected acme/shop not to be created, got %v", err)
+	}
+
+	// A module compiles against another module of the same upload
+	resp, err := NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+		Contents: []*v1.UploadRequest_Content{
+			types,
+			content("shop", "acme/shop.proto", "syntax = \"proto3\";\npackage acme;\nimport \"acme/types.proto\";\nmessage Item { Price price = 1; }\n"),
+		},
+	}))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got, err := mod.Commit(ctx, "main"); err != nil || got.ID != resp.Msg.Commits[0].Id {
+		t.Errorf("expected main to move to %s, got %v %v", resp.Msg.Commits[0].Id, got, err)
+	}
+}
+
+func TestUpload_ExpectLabel(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := contextWithUser(context.Background(), "alice")
+	first := createTestModule(t, svc, "acme", "pets", []registry.File{{Path: "pet.proto", Content: `syntax = "proto3";`}}, []string{"main"})
+
+	upload := func(content string, expect ...string) (*connect.Response[v1.UploadResponse], error) {
+		req := connect.NewRequest(&v1.UploadRequest{
+			Contents: []*v1.UploadRequest_Content{
+				{
+					ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: "pets"}}},
+					Files:     []*v1.File{{Path: "pet.proto", Content: []byte(content)}},
+				},
+			},
+		})
+		for _, e := range expect {
+			req.Header().Add(ExpectLabelHeader, e)
+		}
+		return NewUploadService(svc).Upload(ctx, req)
+	}
+
+	_, err := upload("syntax = \"proto3\";\nmessage A {}", "main=0123456789abcdef0123456789abcdef")
+	if connect.CodeOf(err) != connect.CodeAborted {
+		t.Fatalf("expected Aborted for a label at another commit, got %v", err)
+	}
+	if _, err := upload("syntax = \"proto3\";\nmessage A {}", "acme/pets:main="); connect.CodeOf(err) != connect.CodeAborted {
+		t.Errorf("expected Aborted for an existing label expected not to exist, got %v", err)
+	}
+	mod, _ := svc.casReg.Module(ctx, "acme", "pets")
+	if got, err := mod.Commit(ctx, "main"); err != nil || got.ID != first.ID {
+		t.Errorf("expected main to stay at %s, got %v %v", first.ID, got, err)
+	}
+
+	resp, err := upload("syntax = \"proto3\";\nmessage A {}", "main="+first.ID)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got, err := mod.Commit(ctx, "main"); err != nil || got.ID != resp.Msg.Commits[0].Id {
+		t.Errorf("expected main to move to %s, got %v %v", resp.Msg.Commits[0].Id, got, err)
+	}
+
+	for _, bad := range []string{"main", "other/pets:main=", "v1="} {
+		if _, err := upload("syntax = \"proto3\";\nmessage B {}", bad); connect.CodeOf(err) != connect.CodeInvalidArgument {
+			t.Errorf("%s: expected InvalidArgument, got %v", bad, err)
+		}
+	}
+
+	// A module created by an upload that fails when it is applied is deleted again
+	req := connect.NewRequest(&v1.UploadRequest{
+		Contents: []*v1.UploadRequest_Content{
+			{
+				ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: "toys"}}},
+				Files:     []*v1.File{{Path: "toy.proto", Content: []byte(`syntax = "proto3";`)}},
+			},
+		},
+	})
+	req.Header().Add(ExpectLabelHeader, "acme/toys:main="+first.ID)
+	if _, err := NewUploadService(svc).Upload(ctx, req); connect.CodeOf(err) != connect.CodeAborted {
+		t.Fatalf("expected Aborted for a new module, got %v", err)
+	}
+	if _, err := svc.casReg.Module(ctx, "acme", "toys"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected acme/toys to be deleted, got %v", err)
+	}
+}
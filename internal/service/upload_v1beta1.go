@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	v1beta1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1beta1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/registry"
+)
+
+// Upload implements the v1beta1 UploadService.Upload method.
+// Unlike v1, dependencies are given as DepRefs and may be hosted by other registries.
+func (svc *Service) Upload(ctx context.Context, req *connect.Request[v1beta1.UploadRequest]) (*connect.Response[v1beta1.UploadResponse], error) {
+	if svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+
+	slog.DebugContext(ctx, "Upload", "contents", len(req.Msg.Contents), "depRefs", len(req.Msg.DepRefs))
+
+	contents := make([]moduleContent, 0, len(req.Msg.Contents))
+	for _, content := range req.Msg.Contents {
+		owner, modName, err := svc.moduleFromRefV1beta1(ctx, content.ModuleRef)
+		if err != nil {
+			return nil, err
+		}
+		files := make([]registry.File, 0, len(content.Files))
+		for _, f := range content.Files {
+			files = append(files, registry.File{Path: f.Path, Content: string(f.Content)})
+		}
+		var labels []string
+		for _, ref := range content.ScopedLabelRefs {
+			if name, ok := ref.Value.(*v1beta1.ScopedLabelRef_Name); ok {
+				labels = append(labels, name.Name)
+			}
+		}
+		contents = append(contents, moduleContent{
+			owner:            owner,
+			module:           modName,
+			files:            files,
+			labels:           labels,
+			sourceControlURL: content.SourceControlUrl,
+		})
+	}
+
+	if err := parseLabelExpectations(req.Header(), contents); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	refs := make([]depRef, 0, len(req.Msg.DepRefs))
+	for _, ref := range req.Msg.DepRefs {
+		refs = append(refs, depRef{commitID: ref.CommitId, registry: ref.Registry})
+	}
+
+	commits, err := NewUploadService(svc).upload(ctx, contents, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &v1beta1.UploadResponse{
+		Commits: make([]*v1beta1.Commit, 0, len(commits)),
+	}
+	for _, commit := range commits {
+		c, err := getCommitObject(commit.OwnerID, commit.ModuleID, commit.ID, commit.FilesDigest.Hex())
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		resp.Commits = append(resp.Commits, c)
+	}
+	res := connect.NewResponse(resp)
+	setLintWarnings(res.Header(), contents, commits)
+	setCompileWarnings(res.Header(), contents, commits)
+	svc.setQuotaWarnings(ctx, res.Header(), contents)
+	return res, nil
+}
+
+func (svc *Service) moduleFromRefV1beta1(ctx context.Context, ref *v1beta1.ModuleRef) (owner, name string, err error) {
+	switch v := ref.GetValue().(type) {
+	case *v1beta1.ModuleRef_Id:
+		mod, err := svc.casReg.ModuleByID(ctx, v.Id)
+		if err != nil {
+			return "", "", connect.NewError(connect.CodeNotFound, fmt.Errorf("module not found: %s", v.Id))
+		}
+		return mod.Owner(), mod.Name(), nil
+	case *v1beta1.ModuleRef_Name_:
+		if v.Name == nil {
+			return "", "", connect.NewError(connect.CodeInvalidArgument, errors.New("module name is required"))
+		}
+		return v.Name.Owner, v.Name.Module, nil
+	default:
+		return "", "", connect.NewError(connect.CodeInvalidArgument, errors.New("module reference is required"))
+	}
+}
@@ -0,0 +1,316 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// UsagePath is the admin endpoint reporting the storage used by each owner and module.
+const UsagePath = "/admin/usage"
+
+// QuotaWarningHeader is the response header of upload RPCs carrying a warning for each
+// owner over a soft quota after the upload, as "owner: message".
+const QuotaWarningHeader = "Pbr-Quota-Warning"
+
+// checkQuotas rejects an upload with ResourceExhausted if its new commits would take an
+// owner over a hard quota. Files the owner already stores don't count again.
+//
+// Owners with a hard quota stay locked until the returned function is called, which must
+// be once the commits are applied, so that concurrent uploads of an owner are checked
+// against the usage of each other.
+func (svc *Service) checkQuotas(ctx context.Context, pending []*registry.PendingCommit) (func(), error) {
+	store := svc.casReg.Usage()
+	if store == nil || svc.conf.Quotas == nil {
+		return func() {}, nil
+	}
+
+	type added struct {
+		owner   string
+		commits int64
+		blobs   []storage.BlobUsage
+		seen    map[string]bool
+	}
+	owners := make(map[string]*added)
+	var ownerIDs []string
+	for _, p := range pending {
+		if p.Blobs() == nil {
+			continue
+		}
+		mod := p.Module()
+		a, ok := owners[mod.OwnerID()]
+		if !ok {
+			a = &added{owner: mod.Owner(), seen: map[string]bool{}}
+			owners[mod.OwnerID()] = a
+			ownerIDs = append(ownerIDs, mod.OwnerID())
+		}
+		a.commits++
+		for _, b := range p.Blobs() {
+			if !a.seen[b.Digest] {
+				a.seen[b.Digest] = true
+				a.blobs = append(a.blobs, b)
+			}
+		}
+	}
+
+	// Owners are locked in order, so that uploads spanning owners don't deadlock
+	slices.Sort(ownerIDs)
+	var locked []*sync.Mutex
+	unlock := func() {
+		for _, mu := range slices.Backward(locked) {
+			mu.Unlock()
+		}
+	}
+	var violations []string
+	for _, ownerID := range ownerIDs {
+		a := owners[ownerID]
+		quota := svc.conf.Quotas.For(a.owner)
+		if quota.HardBytes == 0 && quota.HardCommits == 0 {
+			continue
+		}
+		mu, _ := svc.quotaLocks.LoadOrStore(ownerID, &sync.Mutex{})
+		mu.Lock()
+		locked = append(locked, mu)
+		usage, err := store.OwnerUsage(ctx, ownerID)
+		if err != nil {
+			unlock()
+			return nil, fmt.Errorf("failed to get usage of %s: %w", a.owner, err)
+		}
+		if quota.HardCommits > 0 && usage.Commits+a.commits > quota.HardCommits {
+			violations = append(violations, fmt.Sprintf("%s: upload would bring the owner to %d commits, over the hard quota of %d", a.owner, usage.Commits+a.commits, quota.HardCommits))
+		}
+		if quota.HardBytes > 0 {
+			newBytes, err := store.NewBytes(ctx, ownerID, a.blobs)
+			if err != nil {
+				unlock()
+				return nil, fmt.Errorf("failed to get usage of %s: %w", a.owner, err)
+			}
+			if usage.Bytes+newBytes > quota.HardBytes {
+				violations = append(violations, fmt.Sprintf("%s: upload would bring the owner to %d bytes, over the hard quota of %d", a.owner, usage.Bytes+newBytes, quota.HardBytes))
+			}
+		}
+	}
+	if len(violations) > 0 {
+		unlock()
+		return nil, connect.NewError(connect.CodeResourceExhausted, errors.New("quota exceeded:\n"+strings.Join(violations, "\n")))
+	}
+	return unlock, nil
+}
+
+// setQuotaWarnings adds a warning to header for each owner of contents over a soft quota.
+// Usage only informs the warnings, so failures to read it are logged.
+func (svc *Service) setQuotaWarnings(ctx context.Context, header http.Header, contents []moduleContent) {
+	store := svc.casReg.Usage()
+	if store == nil || svc.conf.Quotas == nil {
+		return
+	}
+	var owners []string
+	for _, content := range contents {
+		if !slices.Contains(owners, content.owner) {
+			owners = append(owners, content.owner)
+		}
+	}
+	for _, owner := range owners {
+		quota := svc.conf.Quotas.For(owner)
+		if quota.SoftBytes == 0 && quota.SoftCommits == 0 {
+			continue
+		}
+		record, err := svc.casReg.OwnerByName(ctx, owner)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to get owner for quota warnings", "owner", owner, "error", err)
+			continue
+		}
+		usage, err := store.OwnerUsage(ctx, record.ID)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to get usage for quota warnings", "owner", owner, "error", err)
+			continue
+		}
+		if quota.SoftCommits > 0 && usage.Commits > quota.SoftCommits {
+			header.Add(QuotaWarningHeader, fmt.Sprintf("%s: %d commits, over the soft quota of %d", owner, usage.Commits, quota.SoftCommits))
+		}
+		if quota.SoftBytes > 0 && usage.Bytes > quota.SoftBytes {
+			header.Add(QuotaWarningHeader, fmt.Sprintf("%s: %d bytes, over the soft quota of %d", owner, usage.Bytes, quota.SoftBytes))
+		}
+	}
+}
+
+// registerUsageMetrics reports the usage of each owner as gauges.
+func (svc *Service) registerUsageMetrics() error {
+	bytes, err := meter.Int64ObservableGauge("pbr.usage.bytes",
+		metric.WithDescription("Bytes of the unique files of each owner's commits"),
+		metric.WithUnit("By"))
+	if err != nil {
+		return err
+	}
+	commits, err := meter.Int64ObservableGauge("pbr.usage.commits",
+		metric.WithDescription("Commits of each owner"))
+	if err != nil {
+		return err
+	}
+	labels, err := meter.Int64ObservableGauge("pbr.usage.labels",
+		metric.WithDescription("Labels of each owner"))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		reports, err := svc.usageReports(ctx, "", false)
+		if err != nil {
+			return err
+		}
+		for _, r := range reports {
+			attrs := metric.WithAttributes(attribute.String("owner", r.Owner))
+			o.ObserveInt64(bytes, r.Bytes, attrs)
+			o.ObserveInt64(commits, r.Commits, attrs)
+			o.ObserveInt64(labels, r.Labels, attrs)
+		}
+		return nil
+	}, bytes, commits, labels)
+	return err
+}
+
+// ownerUsageReport is the usage of an owner as reported by the usage endpoint.
+type ownerUsageReport struct {
+	Owner   string              `json:"owner"`
+	Bytes   int64               `json:"bytes"`
+	Commits int64               `json:"commits"`
+	Labels  int64               `json:"labels"`
+	Quota   quotaReport         `json:"quota"`
+	Modules []moduleUsageReport `json:"modules,omitempty"`
+}
+
+type moduleUsageReport struct {
+	Module  string `json:"module"`
+	Bytes   int64  `json:"bytes"`
+	Commits int64  `json:"commits"`
+	Labels  int64  `json:"labels"`
+}
+
+type quotaReport struct {
+	SoftBytes   int64 `json:"soft_bytes,omitempty"`
+	HardBytes   int64 `json:"hard_bytes,omitempty"`
+	SoftCommits int64 `json:"soft_commits,omitempty"`
+	HardCommits int64 `json:"hard_commits,omitempty"`
+}
+
+// usageHandler serves the usage of all owners, or of the owner named by the owner
+// parameter, with the usage of their modules. Only the admin may read it.
+func (svc *Service) usageHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if svc.casReg.Usage() == nil {
+			http.Error(w, "usage accounting not configured", http.StatusNotImplemented)
+			return
+		}
+		if !svc.requireAdmin(w, r) {
+			return
+		}
+
+		owner := r.URL.Query().Get("owner")
+		if owner != "" {
+			if _, err := svc.casReg.OwnerByName(r.Context(), owner); errors.Is(err, storage.ErrNotFound) {
+				http.Error(w, fmt.Sprintf("owner %s not found", owner), http.StatusNotFound)
+				return
+			}
+		}
+		reports, err := svc.usageReports(r.Context(), owner, true)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to report usage", "error", err)
+			http.Error(w, "failed to report usage", http.StatusInternalServerError)
+			return
+		}
+		if owner != "" && len(reports) == 0 {
+			reports = []ownerUsageReport{{Owner: owner, Quota: newQuotaReport(svc.conf.Quotas.For(owner))}}
+		}
+		if reports == nil {
+			reports = []ownerUsageReport{}
+		}
+		writeJSON(w, reports)
+	})
+}
+
+// usageReports returns the usage of all owners with any, or of the named owner, ordered by name.
+func (svc *Service) usageReports(ctx context.Context, owner string, withModules bool) ([]ownerUsageReport, error) {
+	store := svc.casReg.Usage()
+	owners, err := svc.casReg.ListOwners(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(owners))
+	for _, o := range owners {
+		names[o.ID] = o.Name
+	}
+
+	usage, err := store.ListOwnerUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var reports []ownerUsageReport
+	for _, u := range usage {
+		name, ok := names[u.OwnerID]
+		if !ok || owner != "" && name != owner {
+			continue
+		}
+		report := ownerUsageReport{
+			Owner:   name,
+			Bytes:   u.Bytes,
+			Commits: u.Commits,
+			Labels:  u.Labels,
+			Quota:   newQuotaReport(svc.conf.Quotas.For(name)),
+		}
+		if withModules {
+			if report.Modules, err = svc.moduleUsageReports(ctx, u.OwnerID); err != nil {
+				return nil, err
+			}
+		}
+		reports = append(reports, report)
+	}
+	slices.SortFunc(reports, func(a, b ownerUsageReport) int { return cmp.Compare(a.Owner, b.Owner) })
+	return reports, nil
+}
+
+// moduleUsageReports returns the usage of the modules of an owner, ordered by name.
+func (svc *Service) moduleUsageReports(ctx context.Context, ownerID string) ([]moduleUsageReport, error) {
+	usage, err := svc.casReg.Usage().ListModuleUsage(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]moduleUsageReport, 0, len(usage))
+	for _, u := range usage {
+		mod, err := svc.casReg.ModuleByID(ctx, u.ModuleID)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, moduleUsageReport{
+			Module:  mod.Name(),
+			Bytes:   u.Bytes,
+			Commits: u.Commits,
+			Labels:  u.Labels,
+		})
+	}
+	slices.SortFunc(reports, func(a, b moduleUsageReport) int { return cmp.Compare(a.Module, b.Module) })
+	return reports, nil
+}
+
+func newQuotaReport(q config.Quota) quotaReport {
+	return quotaReport{SoftBytes: q.SoftBytes, HardBytes: q.HardBytes, SoftCommits: q.SoftCommits, HardCommits: q.HardCommits}
+}
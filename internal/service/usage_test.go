@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/module/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/config"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/docstore"
+	"gocloud.dev/docstore/memdocstore"
+)
+
+func TestUpload_Quotas(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.conf.Quotas = &config.Quotas{Owners: map[string]config.Quota{
+		"acme": {SoftBytes: 30, HardBytes: 60, HardCommits: 2},
+	}}
+	svc.tokens["admintoken"] = &tokenInfo{Username: "admin"}
+	svc.authenticator = &tokenAuthenticator{svc: svc}
+
+	ctx := contextWithUser(context.Background(), "alice")
+	upload := func(owner string, files ...*v1.File) (*connect.Response[v1.UploadResponse], error) {
+		return NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+			Contents: []*v1.UploadRequest_Content{{
+				ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: owner, Module: "pets"}}},
+				Files:     files,
+			}},
+		}))
+	}
+	file := func(path string, size int) *v1.File {
+		return &v1.File{Path: path, Content: []byte("//" + strings.Repeat(path[:1], size-2))}
+	}
+
+	resp, err := upload("acme", file("a.proto", 25))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if warnings := resp.Header().Values(QuotaWarningHeader); len(warnings) != 0 {
+		t.Errorf("unexpected quota warnings %v", warnings)
+	}
+
+	// Unchanged files don't count again
+	resp, err = upload("acme", file("a.proto", 25), file("b.proto", 10))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got, want := resp.Header().Values(QuotaWarningHeader), []string{"acme: 35 bytes, over the soft quota of 30"}; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("quota warnings = %q, want %q", got, want)
+	}
+
+	_, err = upload("acme", file("c.proto", 30))
+	if connect.CodeOf(err) != connect.CodeResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+	for _, want := range []string{
+		"acme: upload would bring the owner to 3 commits, over the hard quota of 2",
+		"acme: upload would bring the owner to 65 bytes, over the hard quota of 60",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+
+	// Other owners have no quota
+	if _, err := upload("other", file("c.proto", 100)); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	srv := httptest.NewServer(svc.usageHandler())
+	defer srv.Close()
+	get := func(token, query string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+UsagePath+query, nil)
+		req.Header.Set(authenticationHeader, "Bearer "+token)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp
+	}
+
+	r := get("testtoken", "")
+	r.Body.Close()
+	if r.StatusCode != http.StatusForbidden {
+		t.Errorf("non-admin status = %d, want 403", r.StatusCode)
+	}
+	r = get("admintoken", "?owner=nobody")
+	r.Body.Close()
+	if r.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown owner status = %d, want 404", r.StatusCode)
+	}
+
+	r = get("admintoken", "")
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", r.StatusCode)
+	}
+	var reports []ownerUsageReport
+	if err := json.NewDecoder(r.Body).Decode(&reports); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(reports) != 2 || reports[0].Owner != "acme" || reports[1].Owner != "other" {
+		t.Fatalf("unexpected reports %+v", reports)
+	}
+	acme := reports[0]
+	if acme.Bytes != 35 || acme.Commits != 2 || acme.Labels != 1 || acme.Quota.HardBytes != 60 {
+		t.Errorf("unexpected acme usage %+v", acme)
+	}
+	if len(acme.Modules) != 1 || acme.Modules[0] != (moduleUsageReport{Module: "pets", Bytes: 35, Commits: 2, Labels: 1}) {
+		t.Errorf("unexpected acme module usage %+v", acme.Modules)
+	}
+}
+
+// usageBarrier holds callers of OwnerUsage once read until parties of them are waiting,
+// or for at most a tenth of a second, so that concurrent quota checks read the same usage.
+type usageBarrier struct {
+	storage.UsageStore
+	parties int32
+	waiting atomic.Int32
+	release chan struct{}
+}
+
+func (u *usageBarrier) OwnerUsage(ctx context.Context, ownerID string) (*storage.Usage, error) {
+	usage, err := u.UsageStore.OwnerUsage(ctx, ownerID)
+	if u.waiting.Add(1) == u.parties {
+		close(u.release)
+	}
+	select {
+	case <-u.release:
+	case <-time.After(100 * time.Millisecond):
+	}
+	return usage, err
+}
+
+func TestUpload_QuotasConcurrent(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+	svc.conf.Quotas = &config.Quotas{Owners: map[string]config.Quota{
+		"acme": {HardCommits: 2},
+	}}
+
+	// Concurrent quota checks read the usage of the owner at the same time
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	colls := make([]*docstore.Collection, 5)
+	for i := range colls {
+		colls[i], _ = memdocstore.OpenCollection("ID", nil)
+		defer colls[i].Close()
+	}
+	usage := &usageBarrier{UsageStore: storage.NewUsageStore(colls[4]), parties: 2, release: make(chan struct{})}
+	svc.casReg = registry.New(storage.NewBlobStore(bucket), storage.NewManifestStore(bucket),
+		storage.NewMetadataStore(colls[0], colls[1], colls[2], colls[3]), "test.registry.com", registry.WithUsage(usage))
+
+	ctx := contextWithUser(context.Background(), "alice")
+	upload := func(module string) error {
+		_, err := NewUploadService(svc).Upload(ctx, connect.NewRequest(&v1.UploadRequest{
+			Contents: []*v1.UploadRequest_Content{{
+				ModuleRef: &v1.ModuleRef{Value: &v1.ModuleRef_Name_{Name: &v1.ModuleRef_Name{Owner: "acme", Module: module}}},
+				Files:     []*v1.File{{Path: "a.proto", Content: []byte("// " + module)}},
+			}},
+		}))
+		return err
+	}
+	if err := upload("first"); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	usage.waiting.Store(0)
+
+	// Both uploads fit the quota alone, only one fits with the other
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = upload(fmt.Sprintf("mod%d", i))
+		}()
+	}
+	wg.Wait()
+
+	var succeeded int
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else if connect.CodeOf(err) != connect.CodeResourceExhausted {
+			t.Errorf("expected ResourceExhausted, got %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d uploads succeeded, want 1", succeeded)
+	}
+	owner, err := svc.casReg.OwnerByName(ctx, "acme")
+	if err != nil {
+		t.Fatalf("OwnerByName failed: %v", err)
+	}
+	u, err := usage.UsageStore.OwnerUsage(ctx, owner.ID)
+	if err != nil {
+		t.Fatalf("OwnerUsage failed: %v", err)
+	}
+	if u.Commits != 2 {
+		t.Errorf("owner has %d commits, want 2", u.Commits)
+	}
+}
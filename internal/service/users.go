@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/owner/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+// adminUsername is the principal authenticated by the configured admin token.
+const adminUsername = "admin"
+
+// UserService implements the v1 owner UserService interface by wrapping Service.
+type UserService struct {
+	svc *Service
+}
+
+// NewUserService creates a new v1 UserService wrapper.
+func NewUserService(svc *Service) *UserService {
+	return &UserService{svc: svc}
+}
+
+// GetUsers retrieves users by id or name.
+func (u *UserService) GetUsers(ctx context.Context, req *connect.Request[v1.GetUsersRequest]) (*connect.Response[v1.GetUsersResponse], error) {
+	if u.svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+
+	resp := connect.NewResponse(&v1.GetUsersResponse{})
+	for _, ref := range req.Msg.UserRefs {
+		record, err := u.userByRef(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		resp.Msg.Users = append(resp.Msg.Users, userToProto(record))
+	}
+	return resp, nil
+}
+
+// GetCurrentUser returns the authenticated user, provisioning it on first sight.
+func (u *UserService) GetCurrentUser(ctx context.Context, req *connect.Request[v1.GetCurrentUserRequest]) (*connect.Response[v1.GetCurrentUserResponse], error) {
+	if u.svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+
+	username := userFromContext(ctx)
+	if username == "" {
+		return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("no authenticated user"))
+	}
+
+	record, err := u.svc.casReg.GetOrCreateUser(ctx, username)
+	if err != nil {
+		return nil, provisionUserError(err)
+	}
+	return connect.NewResponse(&v1.GetCurrentUserResponse{User: userToProto(record)}), nil
+}
+
+// ListUsers lists all users. Organization membership is not modeled, so filtering by organization is not supported.
+func (u *UserService) ListUsers(ctx context.Context, req *connect.Request[v1.ListUsersRequest]) (*connect.Response[v1.ListUsersResponse], error) {
+	if u.svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+	if len(req.Msg.OrganizationRefs) > 0 {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("organization membership is not supported"))
+	}
+
+	records, err := u.svc.casReg.ListOwnersByType(ctx, storage.OwnerTypeUser)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	// Only standard users exist; filter by state
+	if len(req.Msg.HasTypes) > 0 && !slices.Contains(req.Msg.HasTypes, v1.UserType_USER_TYPE_STANDARD) {
+		records = nil
+	}
+	if len(req.Msg.HasStates) > 0 {
+		var filtered []*storage.OwnerRecord
+		for _, r := range records {
+			if slices.Contains(req.Msg.HasStates, userToProto(r).State) {
+				filtered = append(filtered, r)
+			}
+		}
+		records = filtered
+	}
+
+	page, next := pageOwners(records, req.Msg.Order != v1.ListUsersRequest_ORDER_CREATE_TIME_ASC, req.Msg.PageSize, req.Msg.PageToken)
+
+	resp := connect.NewResponse(&v1.ListUsersResponse{NextPageToken: next})
+	for _, r := range page {
+		resp.Msg.Users = append(resp.Msg.Users, userToProto(r))
+	}
+	return resp, nil
+}
+
+// CreateUsers creates users. Only the admin may create users; others are provisioned on login.
+func (u *UserService) CreateUsers(ctx context.Context, req *connect.Request[v1.CreateUsersRequest]) (*connect.Response[v1.CreateUsersResponse], error) {
+	if u.svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+	if user := userFromContext(ctx); user != "" && user != adminUsername {
+		return nil, connect.NewError(connect.CodePermissionDenied, errors.New("only the admin may create users"))
+	}
+
+	slog.DebugContext(ctx, "CreateUsers", "values", len(req.Msg.Values))
+
+	// Validate all values first so that either all users are created or none
+	for _, value := range req.Msg.Values {
+		if value.Name == "" {
+			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("user name is required"))
+		}
+		if _, err := u.svc.casReg.OwnerByName(ctx, value.Name); err == nil {
+			return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("owner already exists: %s", value.Name))
+		}
+	}
+
+	resp := connect.NewResponse(&v1.CreateUsersResponse{})
+	for _, value := range req.Msg.Values {
+		record, err := u.svc.casReg.CreateOwner(ctx, &storage.OwnerRecord{
+			Name:        value.Name,
+			Type:        storage.OwnerTypeUser,
+			Description: value.Description,
+			URL:         value.Url,
+		})
+		if err == storage.ErrAlreadyExists {
+			return nil, connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("owner already exists: %s", value.Name))
+		}
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		resp.Msg.Users = append(resp.Msg.Users, userToProto(record))
+	}
+	return resp, nil
+}
+
+// UpdateUsers updates users. Users may update themselves; the admin may update anyone.
+func (u *UserService) UpdateUsers(ctx context.Context, req *connect.Request[v1.UpdateUsersRequest]) (*connect.Response[v1.UpdateUsersResponse], error) {
+	if u.svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+
+	slog.DebugContext(ctx, "UpdateUsers", "values", len(req.Msg.Values))
+
+	records := make([]*storage.OwnerRecord, 0, len(req.Msg.Values))
+	for _, value := range req.Msg.Values {
+		record, err := u.userByRef(ctx, value.UserRef)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkAdminOrSelf(ctx, record.Name); err != nil {
+			return nil, err
+		}
+		if value.State != nil {
+			record.Deactivated = *value.State == v1.UserState_USER_STATE_INACTIVE
+		}
+		if value.Description != nil {
+			record.Description = *value.Description
+		}
+		if value.Url != nil {
+			record.URL = *value.Url
+		}
+		records = append(records, record)
+	}
+
+	resp := connect.NewResponse(&v1.UpdateUsersResponse{})
+	for _, record := range records {
+		if err := u.svc.casReg.UpdateOwner(ctx, record); err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+		resp.Msg.Users = append(resp.Msg.Users, userToProto(record))
+	}
+	return resp, nil
+}
+
+// DeleteUsers deletes users that own no modules. Users may delete themselves; the admin may delete anyone.
+func (u *UserService) DeleteUsers(ctx context.Context, req *connect.Request[v1.DeleteUsersRequest]) (*connect.Response[v1.DeleteUsersResponse], error) {
+	if u.svc.casReg == nil {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("CAS storage not configured"))
+	}
+
+	slog.DebugContext(ctx, "DeleteUsers", "userRefs", len(req.Msg.UserRefs))
+
+	records := map[string]*storage.OwnerRecord{}
+	for _, ref := range req.Msg.UserRefs {
+		record, err := u.userByRef(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkAdminOrSelf(ctx, record.Name); err != nil {
+			return nil, err
+		}
+		records[record.ID] = record
+	}
+
+	for _, record := range records {
+		if err := u.svc.casReg.DeleteOwner(ctx, record.ID); err != nil {
+			return nil, deleteOwnerError(record.Name, err)
+		}
+	}
+	return connect.NewResponse(&v1.DeleteUsersResponse{}), nil
+}
+
+func (u *UserService) userByRef(ctx context.Context, ref *v1.UserRef) (*storage.OwnerRecord, error) {
+	switch r := ref.GetValue().(type) {
+	case *v1.UserRef_Id:
+		return u.svc.ownerByIDOrName(ctx, storage.OwnerTypeUser, r.Id, "")
+	case *v1.UserRef_Name:
+		return u.svc.ownerByIDOrName(ctx, storage.OwnerTypeUser, "", r.Name)
+	default:
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("invalid user ref"))
+	}
+}
+
+// checkAdminOrSelf allows the admin, the named user, and any caller when login is disabled.
+func checkAdminOrSelf(ctx context.Context, name string) error {
+	user := userFromContext(ctx)
+	if user == "" || user == adminUsername || user == name {
+		return nil
+	}
+	return connect.NewError(connect.CodePermissionDenied, fmt.Errorf("%s may not modify user %s", user, name))
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	v1 "buf.build/gen/go/bufbuild/registry/protocolbuffers/go/buf/registry/owner/v1"
+	"connectrpc.com/connect"
+	"github.com/greatliontech/pbr/internal/registry"
+	"github.com/greatliontech/pbr/internal/storage"
+)
+
+func TestUserService_CreateGetUpdateDelete(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	userSvc := NewUserService(svc)
+	admin := contextWithUser(context.Background(), adminUsername)
+
+	// Only the admin may create users
+	_, err := userSvc.CreateUsers(contextWithUser(context.Background(), "bob"), connect.NewRequest(&v1.CreateUsersRequest{
+		Values: []*v1.CreateUsersRequest_Value{{Name: "alice"}},
+	}))
+	if connect.CodeOf(err) != connect.CodePermissionDenied {
+		t.Fatalf("expected CodePermissionDenied, got %v", err)
+	}
+
+	created, err := userSvc.CreateUsers(admin, connect.NewRequest(&v1.CreateUsersRequest{
+		Values: []*v1.CreateUsersRequest_Value{{Name: "alice", Description: "Alice"}},
+	}))
+	if err != nil {
+		t.Fatalf("CreateUsers failed: %v", err)
+	}
+	alice := created.Msg.Users[0]
+	if alice.Id == "" || alice.State != v1.UserState_USER_STATE_ACTIVE {
+		t.Errorf("unexpected user: %v", alice)
+	}
+
+	_, err = userSvc.CreateUsers(admin, connect.NewRequest(&v1.CreateUsersRequest{
+		Values: []*v1.CreateUsersRequest_Value{{Name: "alice"}},
+	}))
+	if connect.CodeOf(err) != connect.CodeAlreadyExists {
+		t.Fatalf("expected CodeAlreadyExists, got %v", err)
+	}
+
+	got, err := userSvc.GetUsers(admin, connect.NewRequest(&v1.GetUsersRequest{
+		UserRefs: []*v1.UserRef{{Value: &v1.UserRef_Id{Id: alice.Id}}},
+	}))
+	if err != nil {
+		t.Fatalf("GetUsers failed: %v", err)
+	}
+	if got.Msg.Users[0].Name != "alice" || got.Msg.Users[0].Description != "Alice" {
+		t.Errorf("unexpected user: %v", got.Msg.Users[0])
+	}
+
+	// Users may update themselves, but not others
+	url := "https://example.com/alice"
+	_, err = userSvc.UpdateUsers(contextWithUser(context.Background(), "bob"), connect.NewRequest(&v1.UpdateUsersRequest{
+		Values: []*v1.UpdateUsersRequest_Value{{UserRef: &v1.UserRef{Value: &v1.UserRef_Name{Name: "alice"}}, Url: &url}},
+	}))
+	if connect.CodeOf(err) != connect.CodePermissionDenied {
+		t.Fatalf("expected CodePermissionDenied, got %v", err)
+	}
+	updated, err := userSvc.UpdateUsers(contextWithUser(context.Background(), "alice"), connect.NewRequest(&v1.UpdateUsersRequest{
+		Values: []*v1.UpdateUsersRequest_Value{{UserRef: &v1.UserRef{Value: &v1.UserRef_Name{Name: "alice"}}, Url: &url}},
+	}))
+	if err != nil {
+		t.Fatalf("UpdateUsers failed: %v", err)
+	}
+	if updated.Msg.Users[0].Url != url {
+		t.Errorf("url = %q, want %q", updated.Msg.Users[0].Url, url)
+	}
+
+	// Users owning modules cannot be deleted
+	createTestModule(t, svc, "alice", "petapis", []registry.File{{Path: "a.proto", Content: "syntax = \"proto3\";"}}, []string{"main"})
+	_, err = userSvc.DeleteUsers(admin, connect.NewRequest(&v1.DeleteUsersRequest{
+		UserRefs: []*v1.UserRef{{Value: &v1.UserRef_Name{Name: "alice"}}},
+	}))
+	if connect.CodeOf(err) != connect.CodeFailedPrecondition {
+		t.Fatalf("expected CodeFailedPrecondition, got %v", err)
+	}
+
+	if err := svc.casReg.DeleteModule(admin, "alice", "petapis"); err != nil {
+		t.Fatalf("DeleteModule failed: %v", err)
+	}
+	if _, err := userSvc.DeleteUsers(admin, connect.NewRequest(&v1.DeleteUsersRequest{
+		UserRefs: []*v1.UserRef{{Value: &v1.UserRef_Name{Name: "alice"}}},
+	})); err != nil {
+		t.Fatalf("DeleteUsers failed: %v", err)
+	}
+	_, err = userSvc.GetUsers(admin, connect.NewRequest(&v1.GetUsersRequest{
+		UserRefs: []*v1.UserRef{{Value: &v1.UserRef_Name{Name: "alice"}}},
+	}))
+	if connect.CodeOf(err) != connect.CodeNotFound {
+		t.Fatalf("expected CodeNotFound, got %v", err)
+	}
+}
+
+func TestUserService_GetUsers_Organization(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	createTestModule(t, svc, "acme", "petapis", []registry.File{{Path: "a.proto", Content: "syntax = \"proto3\";"}}, []string{"main"})
+
+	_, err := NewUserService(svc).GetUsers(context.Background(), connect.NewRequest(&v1.GetUsersRequest{
+		UserRefs: []*v1.UserRef{{Value: &v1.UserRef_Name{Name: "acme"}}},
+	}))
+	if connect.CodeOf(err) != connect.CodeNotFound {
+		t.Fatalf("expected CodeNotFound for organization, got %v", err)
+	}
+}
+
+func TestUserService_GetCurrentUser_Organization(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	createTestModule(t, svc, "acme", "petapis", []registry.File{{Path: "a.proto", Content: "syntax = \"proto3\";"}}, []string{"main"})
+
+	// A principal named like an organization doesn't take it over
+	_, err := NewUserService(svc).GetCurrentUser(contextWithUser(context.Background(), "acme"), connect.NewRequest(&v1.GetCurrentUserRequest{}))
+	if connect.CodeOf(err) != connect.CodeAlreadyExists {
+		t.Fatalf("expected CodeAlreadyExists, got %v", err)
+	}
+	owner, err := svc.casReg.OwnerByName(context.Background(), "acme")
+	if err != nil {
+		t.Fatalf("OwnerByName failed: %v", err)
+	}
+	if owner.Type != storage.OwnerTypeOrganization {
+		t.Errorf("expected acme to stay an organization, got %q", owner.Type)
+	}
+}
+
+func TestUserService_ListUsers(t *testing.T) {
+	svc, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	for _, name := range []string{"u1", "u2", "u3"} {
+		if _, err := svc.casReg.GetOrCreateUser(ctx, name); err != nil {
+			t.Fatalf("GetOrCreateUser failed: %v", err)
+		}
+	}
+	createTestModule(t, svc, "acme", "petapis", []registry.File{{Path: "a.proto", Content: "syntax = \"proto3\";"}}, []string{"main"})
+
+	userSvc := NewUserService(svc)
+	var names []string
+	token := ""
+	for {
+		resp, err := userSvc.ListUsers(ctx, connect.NewRequest(&v1.ListUsersRequest{
+			PageSize:  2,
+			PageToken: token,
+			Order:     v1.ListUsersRequest_ORDER_CREATE_TIME_ASC,
+		}))
+		if err != nil {
+			t.Fatalf("ListUsers failed: %v", err)
+		}
+		for _, u := range resp.Msg.Users {
+			names = append(names, u.Name)
+		}
+		token = resp.Msg.NextPageToken
+		if token == "" {
+			break
+		}
+	}
+
+	if len(names) != 3 || names[0] != "u1" || names[2] != "u3" {
+		t.Errorf("unexpected users: %v", names)
+	}
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"gocloud.dev/docstore"
+)
+
+// AuditRecord is an entry in the append-only audit log.
+// One record is written per targeted resource of a mutating request.
+type AuditRecord struct {
+	ID        string    `json:"id"`         // UUIDv7, time-sortable
+	RequestID string    `json:"request_id"` // shared by records written for the same request
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal,omitempty"`
+	Procedure string    `json:"procedure"`
+	Owner     string    `json:"owner,omitempty"`
+	Module    string    `json:"module,omitempty"`
+	Label     string    `json:"label,omitempty"`
+	Outcome   string    `json:"outcome"` // "ok" or the connect error code
+	Error     string    `json:"error,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+}
+
+// AuditQuery filters audit records. Zero-valued fields do not filter.
+type AuditQuery struct {
+	Since     time.Time
+	Until     time.Time
+	Owner     string
+	Principal string
+	Limit     int
+}
+
+// AuditStore persists audit records.
+type AuditStore interface {
+	Append(ctx context.Context, record *AuditRecord) error
+	// Query returns matching records, newest first.
+	Query(ctx context.Context, q AuditQuery) ([]*AuditRecord, error)
+}
+
+// AuditDoc is the docstore document for audit records.
+type AuditDoc struct {
+	ID        string    `docstore:"id"`
+	RequestID string    `docstore:"request_id"`
+	Time      time.Time `docstore:"time"`
+	Principal string    `docstore:"principal,omitempty"`
+	Procedure string    `docstore:"procedure"`
+	Owner     string    `docstore:"owner,omitempty"`
+	Module    string    `docstore:"module,omitempty"`
+	Label     string    `docstore:"label,omitempty"`
+	Outcome   string    `docstore:"outcome"`
+	Error     string    `docstore:"error,omitempty"`
+	ClientIP  string    `docstore:"client_ip,omitempty"`
+}
+
+// AuditStoreImpl implements AuditStore using a gocloud.dev/docstore collection.
+// Records can additionally be exported as JSON lines to a writer.
+type AuditStoreImpl struct {
+	coll *docstore.Collection
+
+	mu     sync.Mutex // serializes writes to export
+	export io.Writer
+}
+
+// NewAuditStore creates a docstore-backed audit store.
+// If export is non-nil, every appended record is also written to it as a JSON line.
+func NewAuditStore(coll *docstore.Collection, export io.Writer) *AuditStoreImpl {
+	return &AuditStoreImpl{coll: coll, export: export}
+}
+
+func (s *AuditStoreImpl) Append(ctx context.Context, record *AuditRecord) error {
+	doc := &AuditDoc{
+		ID:        record.ID,
+		RequestID: record.RequestID,
+		Time:      record.Time,
+		Principal: record.Principal,
+		Procedure: record.Procedure,
+		Owner:     record.Owner,
+		Module:    record.Module,
+		Label:     record.Label,
+		Outcome:   record.Outcome,
+		Error:     record.Error,
+		ClientIP:  record.ClientIP,
+	}
+	if err := s.coll.Create(ctx, doc); err != nil {
+		return err
+	}
+
+	if s.export != nil {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, err := s.export.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query filters, orders and limits in the docstore query, so only matching records are
+// read. Backends other than memdocstore may need an index on id and time, combined with
+// owner or principal for queries filtering on them.
+func (s *AuditStoreImpl) Query(ctx context.Context, q AuditQuery) ([]*AuditRecord, error) {
+	query := s.coll.Query()
+	if q.Owner != "" {
+		query = query.Where("owner", "=", q.Owner)
+	}
+	if q.Principal != "" {
+		query = query.Where("principal", "=", q.Principal)
+	}
+	if !q.Since.IsZero() {
+		query = query.Where("time", ">=", q.Since)
+	}
+	if !q.Until.IsZero() {
+		query = query.Where("time", "<", q.Until)
+	}
+	// Newest first (UUID v7 is time-sortable). Docstore only orders by fields with a
+	// Where clause, which all IDs match.
+	query = query.Where("id", ">", "").OrderBy("id", docstore.Descending)
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+
+	iter := query.Get(ctx)
+	defer iter.Stop()
+
+	var records []*AuditRecord
+	for {
+		doc := &AuditDoc{}
+		if err := iter.Next(ctx, doc); err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+			return nil, err
+		}
+		records = append(records, &AuditRecord{
+			ID:        doc.ID,
+			RequestID: doc.RequestID,
+			Time:      doc.Time,
+			Principal: doc.Principal,
+			Procedure: doc.Procedure,
+			Owner:     doc.Owner,
+			Module:    doc.Module,
+			Label:     doc.Label,
+			Outcome:   doc.Outcome,
+			Error:     doc.Error,
+			ClientIP:  doc.ClientIP,
+		})
+	}
+}
+
+// Close closes the audit collection and the export writer, if it is closable.
+func (s *AuditStoreImpl) Close() error {
+	err := s.coll.Close()
+	if c, ok := s.export.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
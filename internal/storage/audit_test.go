@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gocloud.dev/docstore/memdocstore"
+)
+
+func TestAuditStore_AppendQuery(t *testing.T) {
+	coll, err := memdocstore.OpenCollection("ID", nil)
+	if err != nil {
+		t.Fatalf("failed to open audit collection: %v", err)
+	}
+	var export bytes.Buffer
+	store := NewAuditStore(coll, &export)
+	ctx := context.Background()
+
+	base := time.Date(2025, 1, 8, 12, 0, 0, 0, time.UTC)
+	records := []*AuditRecord{
+		{ID: "01", Time: base, Principal: "alice", Procedure: "/p/Upload", Owner: "acme", Module: "api", Outcome: "ok"},
+		{ID: "02", Time: base.Add(time.Hour), Principal: "bob", Procedure: "/p/DeleteModules", Owner: "acme", Module: "api", Outcome: "permission_denied"},
+		{ID: "03", Time: base.Add(2 * time.Hour), Principal: "alice", Procedure: "/p/Upload", Owner: "other", Module: "api", Outcome: "ok"},
+	}
+	for _, r := range records {
+		if err := store.Append(ctx, r); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		query   AuditQuery
+		wantIDs []string
+	}{
+		{name: "all newest first", query: AuditQuery{}, wantIDs: []string{"03", "02", "01"}},
+		{name: "owner", query: AuditQuery{Owner: "acme"}, wantIDs: []string{"02", "01"}},
+		{name: "principal", query: AuditQuery{Principal: "alice"}, wantIDs: []string{"03", "01"}},
+		{name: "since", query: AuditQuery{Since: base.Add(time.Hour)}, wantIDs: []string{"03", "02"}},
+		{name: "until is exclusive", query: AuditQuery{Until: base.Add(time.Hour)}, wantIDs: []string{"01"}},
+		{name: "limit", query: AuditQuery{Limit: 1}, wantIDs: []string{"03"}},
+		{name: "range and limit", query: AuditQuery{Since: base, Until: base.Add(2 * time.Hour), Limit: 1}, wantIDs: []string{"02"}},
+		{name: "owner and limit", query: AuditQuery{Owner: "acme", Limit: 1}, wantIDs: []string{"02"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.Query(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			var ids []string
+			for _, r := range got {
+				ids = append(ids, r.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Errorf("got %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+
+	lines := strings.Split(strings.TrimSpace(export.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 exported lines, got %d", len(lines))
+	}
+	var first AuditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("invalid JSON line: %v", err)
+	}
+	if first.ID != "01" || first.Principal != "alice" || first.Outcome != "ok" {
+		t.Errorf("unexpected exported record: %+v", first)
+	}
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+// Batch is the undo journal of a batch of commits being applied. Each write is recorded
+// before it is made, so that a batch interrupted by a crash can be undone.
+type Batch struct {
+	ID         string
+	CreateTime time.Time
+	Modules    []string        // IDs of the modules created for the batch, deleted if still empty
+	Commits    []string        // IDs of the commits created by the batch
+	Updated    []*CommitRecord // commits updated by the batch, as they were before
+	Labels     []BatchLabel    // labels moved by the batch, in order
+}
+
+// BatchLabel is a label move of a batch. Before is nil if the move creates the label.
+// After has the revision the label has once moved.
+type BatchLabel struct {
+	OwnerID string
+	Before  *LabelRecord
+	After   *LabelRecord
+}
+
+// BatchStore keeps the journals of the batches of commits being applied.
+type BatchStore interface {
+	// PutBatch creates or replaces the journal of a batch.
+	PutBatch(ctx context.Context, batch *Batch) error
+	DeleteBatch(ctx context.Context, id string) error
+	ListBatches(ctx context.Context) ([]*Batch, error)
+}
+
+// BatchDoc is the docstore document of a batch journal.
+type BatchDoc struct {
+	ID         string          `docstore:"id"`
+	CreateTime time.Time       `docstore:"create_time"`
+	Modules    []string        `docstore:"modules,omitempty"`
+	Commits    []string        `docstore:"commits,omitempty"`
+	Updated    []*CommitDoc    `docstore:"updated,omitempty"`
+	Labels     []BatchLabelDoc `docstore:"labels,omitempty"`
+}
+
+// BatchLabelDoc is the embedded document for a label move of a batch.
+type BatchLabelDoc struct {
+	OwnerID        string `docstore:"owner_id"`
+	ModuleID       string `docstore:"module_id"`
+	Name           string `docstore:"name"`
+	BeforeCommitID string `docstore:"before_commit_id,omitempty"` // empty if the move creates the label
+	BeforeRevision int64  `docstore:"before_revision,omitempty"`
+	AfterCommitID  string `docstore:"after_commit_id"`
+	AfterRevision  int64  `docstore:"after_revision"`
+}
+
+// BatchStoreImpl implements BatchStore using a gocloud.dev/docstore collection.
+type BatchStoreImpl struct {
+	coll *docstore.Collection
+}
+
+// NewBatchStore creates a docstore-backed batch journal store.
+func NewBatchStore(coll *docstore.Collection) *BatchStoreImpl {
+	return &BatchStoreImpl{coll: coll}
+}
+
+func (s *BatchStoreImpl) PutBatch(ctx context.Context, batch *Batch) error {
+	doc := &BatchDoc{
+		ID:         batch.ID,
+		CreateTime: batch.CreateTime,
+		Modules:    batch.Modules,
+		Commits:    batch.Commits,
+	}
+	for _, c := range batch.Updated {
+		doc.Updated = append(doc.Updated, commitRecordToDoc(c))
+	}
+	for _, l := range batch.Labels {
+		ld := BatchLabelDoc{
+			OwnerID:       l.OwnerID,
+			ModuleID:      l.After.ModuleID,
+			Name:          l.After.Name,
+			AfterCommitID: l.After.CommitID,
+			AfterRevision: l.After.Revision,
+		}
+		if l.Before != nil {
+			ld.BeforeCommitID, ld.BeforeRevision = l.Before.CommitID, l.Before.Revision
+		}
+		doc.Labels = append(doc.Labels, ld)
+	}
+	return s.coll.Put(ctx, doc)
+}
+
+func (s *BatchStoreImpl) DeleteBatch(ctx context.Context, id string) error {
+	err := s.coll.Delete(ctx, &BatchDoc{ID: id})
+	if err != nil && gcerrors.Code(err) == gcerrors.NotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *BatchStoreImpl) ListBatches(ctx context.Context) ([]*Batch, error) {
+	iter := s.coll.Query().Get(ctx)
+	defer iter.Stop()
+
+	var batches []*Batch
+	for {
+		doc := &BatchDoc{}
+		if err := iter.Next(ctx, doc); err != nil {
+			if err == io.EOF {
+				return batches, nil
+			}
+			return nil, err
+		}
+		batch, err := batchDocToRecord(doc)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+}
+
+func batchDocToRecord(doc *BatchDoc) (*Batch, error) {
+	batch := &Batch{
+		ID:         doc.ID,
+		CreateTime: doc.CreateTime,
+		Modules:    doc.Modules,
+		Commits:    doc.Commits,
+	}
+	for _, c := range doc.Updated {
+		record, err := commitDocToRecord(c)
+		if err != nil {
+			return nil, err
+		}
+		batch.Updated = append(batch.Updated, record)
+	}
+	for _, l := range doc.Labels {
+		label := BatchLabel{
+			OwnerID: l.OwnerID,
+			After: &LabelRecord{
+				ID:       labelID(l.ModuleID, l.Name),
+				ModuleID: l.ModuleID,
+				Name:     l.Name,
+				CommitID: l.AfterCommitID,
+				Revision: l.AfterRevision,
+			},
+		}
+		if l.BeforeCommitID != "" {
+			label.Before = &LabelRecord{
+				ID:       labelID(l.ModuleID, l.Name),
+				ModuleID: l.ModuleID,
+				Name:     l.Name,
+				CommitID: l.BeforeCommitID,
+				Revision: l.BeforeRevision,
+			}
+		}
+		batch.Labels = append(batch.Labels, label)
+	}
+	return batch, nil
+}
@@ -3,7 +3,9 @@ package storage
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"io"
+	"path"
 
 	"gocloud.dev/blob"
 	"gocloud.dev/gcerrors"
@@ -107,3 +109,33 @@ func (s *BlobStoreImpl) Delete(ctx context.Context, digest Digest) error {
 	}
 	return err
 }
+
+// List calls fn for each stored blob.
+func (s *BlobStoreImpl) List(ctx context.Context, fn func(StoredObject) error) error {
+	return listObjects(ctx, s.bucket, DigestAlgorithmShake256+"/", fn)
+}
+
+// listObjects calls fn for each object under prefix whose key ends in a hex digest.
+func listObjects(ctx context.Context, bucket *blob.Bucket, prefix string, fn func(StoredObject) error) error {
+	iter := bucket.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		value, err := hex.DecodeString(path.Base(obj.Key))
+		if obj.IsDir || err != nil {
+			continue
+		}
+		if err := fn(StoredObject{
+			Digest:  Digest{Algorithm: DigestAlgorithmShake256, Value: value},
+			Size:    obj.Size,
+			ModTime: obj.ModTime,
+		}); err != nil {
+			return err
+		}
+	}
+}
@@ -142,3 +142,53 @@ func TestBlobStore_Deduplication(t *testing.T) {
 		t.Errorf("expected same digest, got %s and %s", digest1.Hex(), digest2.Hex())
 	}
 }
+
+func TestBlobStore_List(t *testing.T) {
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+
+	blobs := NewBlobStore(bucket)
+	manifests := NewManifestStore(bucket)
+	ctx := context.Background()
+
+	digest, err := blobs.Put(ctx, bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	manifestDigest, err := manifests.PutManifest(ctx, &Manifest{Entries: []ManifestEntry{{Digest: digest, Path: "a.proto"}}})
+	if err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+
+	list := func(store interface {
+		List(context.Context, func(StoredObject) error) error
+	}) []StoredObject {
+		t.Helper()
+		var objs []StoredObject
+		if err := store.List(ctx, func(obj StoredObject) error {
+			objs = append(objs, obj)
+			return nil
+		}); err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		return objs
+	}
+
+	// Blobs and manifests share the bucket but are listed separately
+	if objs := list(blobs); len(objs) != 1 || objs[0].Digest.String() != digest.String() || objs[0].Size != 5 {
+		t.Errorf("blobs: got %+v", objs)
+	}
+	if objs := list(manifests); len(objs) != 1 || objs[0].Digest.String() != manifestDigest.String() {
+		t.Errorf("manifests: got %+v", objs)
+	}
+
+	if err := manifests.Delete(ctx, manifestDigest); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := manifests.Delete(ctx, manifestDigest); err != nil {
+		t.Errorf("Delete of a missing manifest failed: %v", err)
+	}
+	if objs := list(manifests); len(objs) != 0 {
+		t.Errorf("manifests after delete: got %+v", objs)
+	}
+}
@@ -14,9 +14,14 @@ import (
 
 // OwnerDoc is the docstore document for owners.
 type OwnerDoc struct {
-	ID         string    `docstore:"id"`
-	Name       string    `docstore:"name"`
-	CreateTime time.Time `docstore:"create_time"`
+	ID          string    `docstore:"id"`
+	Name        string    `docstore:"name"`
+	Type        string    `docstore:"type,omitempty"`
+	Description string    `docstore:"description,omitempty"`
+	URL         string    `docstore:"url,omitempty"`
+	Deactivated bool      `docstore:"deactivated,omitempty"`
+	CreateTime  time.Time `docstore:"create_time"`
+	UpdateTime  time.Time `docstore:"update_time,omitempty"`
 }
 
 // ModuleDoc is the docstore document for modules.
@@ -27,21 +32,45 @@ type ModuleDoc struct {
 	Name             string    `docstore:"name"`
 	Description      string    `docstore:"description,omitempty"`
 	DefaultLabelName string    `docstore:"default_label_name"`
+	Mirror           string    `docstore:"mirror,omitempty"`
 	CreateTime       time.Time `docstore:"create_time"`
 	UpdateTime       time.Time `docstore:"update_time"`
+	DeleteTime       time.Time `docstore:"delete_time"`
 }
 
 // CommitDoc is the docstore document for commits.
 type CommitDoc struct {
-	ID               string    `docstore:"id"`
-	ModuleID         string    `docstore:"module_id"`
-	OwnerID          string    `docstore:"owner_id"`
-	FilesDigest      string    `docstore:"files_digest"`  // SHAKE256 digest as "shake256:hex"
-	ModuleDigest     string    `docstore:"module_digest"` // module digest as "b5:hex" or "shake256:hex"
-	CreateTime       time.Time `docstore:"create_time"`
-	CreatedByUserID  string    `docstore:"created_by_user_id,omitempty"`
-	SourceControlURL string    `docstore:"source_control_url,omitempty"`
-	DepCommitIDs     []string  `docstore:"dep_commit_ids,omitempty"`
+	ID               string       `docstore:"id"`
+	ModuleID         string       `docstore:"module_id"`
+	OwnerID          string       `docstore:"owner_id"`
+	FilesDigest      string       `docstore:"files_digest"`  // SHAKE256 digest as "shake256:hex"
+	ModuleDigest     string       `docstore:"module_digest"` // module digest as "b5:hex" or "shake256:hex"
+	CreateTime       time.Time    `docstore:"create_time"`
+	CreatedByUserID  string       `docstore:"created_by_user_id,omitempty"`
+	SourceControlURL string       `docstore:"source_control_url,omitempty"`
+	DepCommitIDs     []string     `docstore:"dep_commit_ids,omitempty"`
+	Deps             []DepDoc     `docstore:"deps,omitempty"`
+	Findings         []FindingDoc `docstore:"findings,omitempty"`
+}
+
+// FindingDoc is the embedded document for a finding of a commit check.
+type FindingDoc struct {
+	Check   string `docstore:"check"`
+	Rule    string `docstore:"rule"`
+	Path    string `docstore:"path,omitempty"`
+	Line    int    `docstore:"line,omitempty"`
+	Column  int    `docstore:"column,omitempty"`
+	Message string `docstore:"message"`
+	Against string `docstore:"against,omitempty"`
+}
+
+// DepDoc is the embedded document for a commit dependency.
+type DepDoc struct {
+	CommitID string `docstore:"commit_id"`
+	Registry string `docstore:"registry,omitempty"`
+	OwnerID  string `docstore:"owner_id"`
+	ModuleID string `docstore:"module_id"`
+	Digest   string `docstore:"digest"` // module digest as "b5:hex"
 }
 
 // LabelDoc is the docstore document for labels.
@@ -50,6 +79,11 @@ type LabelDoc struct {
 	ModuleID string `docstore:"module_id"`
 	Name     string `docstore:"name"`
 	CommitID string `docstore:"commit_id"`
+	// Revision counts the moves of the label. Labels stored before revisions were
+	// recorded have none, and are read as revision 1.
+	Revision int64 `docstore:"revision"`
+	// DocstoreRevision is the revision of the driver, which makes replacing a label atomic.
+	DocstoreRevision any
 }
 
 // MetadataStoreImpl implements MetadataStore using gocloud.dev/docstore.
@@ -101,11 +135,7 @@ func (s *MetadataStoreImpl) GetOwner(ctx context.Context, id string) (*OwnerReco
 		}
 		return nil, err
 	}
-	return &OwnerRecord{
-		ID:         doc.ID,
-		Name:       doc.Name,
-		CreateTime: doc.CreateTime,
-	}, nil
+	return ownerDocToRecord(doc), nil
 }
 
 func (s *MetadataStoreImpl) GetOwnerByName(ctx context.Context, name string) (*OwnerRecord, error) {
@@ -119,19 +149,11 @@ func (s *MetadataStoreImpl) GetOwnerByName(ctx context.Context, name string) (*O
 		}
 		return nil, err
 	}
-	return &OwnerRecord{
-		ID:         doc.ID,
-		Name:       doc.Name,
-		CreateTime: doc.CreateTime,
-	}, nil
+	return ownerDocToRecord(doc), nil
 }
 
 func (s *MetadataStoreImpl) CreateOwner(ctx context.Context, owner *OwnerRecord) error {
-	doc := &OwnerDoc{
-		ID:         owner.ID,
-		Name:       owner.Name,
-		CreateTime: owner.CreateTime,
-	}
+	doc := ownerRecordToDoc(owner)
 	if err := s.owners.Create(ctx, doc); err != nil {
 		if gcerrors.Code(err) == gcerrors.AlreadyExists {
 			return ErrAlreadyExists
@@ -154,15 +176,63 @@ func (s *MetadataStoreImpl) ListOwners(ctx context.Context) ([]*OwnerRecord, err
 			}
 			return nil, err
 		}
-		owners = append(owners, &OwnerRecord{
-			ID:         doc.ID,
-			Name:       doc.Name,
-			CreateTime: doc.CreateTime,
-		})
+		owners = append(owners, ownerDocToRecord(doc))
 	}
 	return owners, nil
 }
 
+func (s *MetadataStoreImpl) UpdateOwner(ctx context.Context, owner *OwnerRecord) error {
+	if err := s.owners.Replace(ctx, ownerRecordToDoc(owner)); err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *MetadataStoreImpl) DeleteOwner(ctx context.Context, id string) error {
+	err := s.owners.Delete(ctx, &OwnerDoc{ID: id})
+	if err != nil && gcerrors.Code(err) == gcerrors.NotFound {
+		return nil
+	}
+	return err
+}
+
+func ownerDocToRecord(doc *OwnerDoc) *OwnerRecord {
+	ownerType := OwnerType(doc.Type)
+	if ownerType == "" {
+		ownerType = OwnerTypeOrganization
+	}
+	updateTime := doc.UpdateTime
+	if updateTime.IsZero() {
+		updateTime = doc.CreateTime
+	}
+	return &OwnerRecord{
+		ID:          doc.ID,
+		Name:        doc.Name,
+		Type:        ownerType,
+		Description: doc.Description,
+		URL:         doc.URL,
+		Deactivated: doc.Deactivated,
+		CreateTime:  doc.CreateTime,
+		UpdateTime:  updateTime,
+	}
+}
+
+func ownerRecordToDoc(o *OwnerRecord) *OwnerDoc {
+	return &OwnerDoc{
+		ID:          o.ID,
+		Name:        o.Name,
+		Type:        string(o.Type),
+		Description: o.Description,
+		URL:         o.URL,
+		Deactivated: o.Deactivated,
+		CreateTime:  o.CreateTime,
+		UpdateTime:  o.UpdateTime,
+	}
+}
+
 // ----- Module operations -----
 
 func (s *MetadataStoreImpl) GetModule(ctx context.Context, id string) (*ModuleRecord, error) {
@@ -191,7 +261,11 @@ func (s *MetadataStoreImpl) GetModuleByName(ctx context.Context, owner, name str
 }
 
 func (s *MetadataStoreImpl) ListModules(ctx context.Context, ownerID string) ([]*ModuleRecord, error) {
-	iter := s.modules.Query().Where("owner_id", "=", ownerID).Get(ctx)
+	query := s.modules.Query()
+	if ownerID != "" {
+		query = query.Where("owner_id", "=", ownerID)
+	}
+	iter := query.Get(ctx)
 	defer iter.Stop()
 
 	var modules []*ModuleRecord
@@ -241,8 +315,10 @@ func moduleDocToRecord(doc *ModuleDoc) *ModuleRecord {
 		Name:             doc.Name,
 		Description:      doc.Description,
 		DefaultLabelName: doc.DefaultLabelName,
+		Mirror:           doc.Mirror,
 		CreateTime:       doc.CreateTime,
 		UpdateTime:       doc.UpdateTime,
+		DeleteTime:       doc.DeleteTime,
 	}
 }
 
@@ -254,8 +330,10 @@ func moduleRecordToDoc(m *ModuleRecord) *ModuleDoc {
 		Name:             m.Name,
 		Description:      m.Description,
 		DefaultLabelName: m.DefaultLabelName,
+		Mirror:           m.Mirror,
 		CreateTime:       m.CreateTime,
 		UpdateTime:       m.UpdateTime,
+		DeleteTime:       m.DeleteTime,
 	}
 }
 
@@ -353,6 +431,25 @@ func (s *MetadataStoreImpl) CreateCommit(ctx context.Context, commit *CommitReco
 	return nil
 }
 
+func (s *MetadataStoreImpl) UpdateCommit(ctx context.Context, commit *CommitRecord) error {
+	if err := s.commits.Replace(ctx, commitRecordToDoc(commit)); err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteCommit deletes a commit record. Deleting a missing commit is not an error.
+func (s *MetadataStoreImpl) DeleteCommit(ctx context.Context, id string) error {
+	err := s.commits.Delete(ctx, &CommitDoc{ID: id})
+	if err != nil && gcerrors.Code(err) == gcerrors.NotFound {
+		return nil
+	}
+	return err
+}
+
 func commitDocToRecord(doc *CommitDoc) (*CommitRecord, error) {
 	filesDigest, err := ParseDigest(doc.FilesDigest)
 	if err != nil {
@@ -365,6 +462,24 @@ func commitDocToRecord(doc *CommitDoc) (*CommitRecord, error) {
 			return nil, err
 		}
 	}
+	var deps []DepRecord
+	for _, d := range doc.Deps {
+		digest, err := ParseModuleDigest(d.Digest)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, DepRecord{
+			CommitID: d.CommitID,
+			Registry: d.Registry,
+			OwnerID:  d.OwnerID,
+			ModuleID: d.ModuleID,
+			Digest:   digest,
+		})
+	}
+	var findings []Finding
+	for _, f := range doc.Findings {
+		findings = append(findings, Finding(f))
+	}
 	return &CommitRecord{
 		ID:               doc.ID,
 		ModuleID:         doc.ModuleID,
@@ -375,10 +490,26 @@ func commitDocToRecord(doc *CommitDoc) (*CommitRecord, error) {
 		CreatedByUserID:  doc.CreatedByUserID,
 		SourceControlURL: doc.SourceControlURL,
 		DepCommitIDs:     doc.DepCommitIDs,
+		Deps:             deps,
+		Findings:         findings,
 	}, nil
 }
 
 func commitRecordToDoc(c *CommitRecord) *CommitDoc {
+	var deps []DepDoc
+	for _, d := range c.Deps {
+		deps = append(deps, DepDoc{
+			CommitID: d.CommitID,
+			Registry: d.Registry,
+			OwnerID:  d.OwnerID,
+			ModuleID: d.ModuleID,
+			Digest:   d.Digest.String(),
+		})
+	}
+	var findings []FindingDoc
+	for _, f := range c.Findings {
+		findings = append(findings, FindingDoc(f))
+	}
 	return &CommitDoc{
 		ID:               c.ID,
 		ModuleID:         c.ModuleID,
@@ -389,6 +520,8 @@ func commitRecordToDoc(c *CommitRecord) *CommitDoc {
 		CreatedByUserID:  c.CreatedByUserID,
 		SourceControlURL: c.SourceControlURL,
 		DepCommitIDs:     c.DepCommitIDs,
+		Deps:             deps,
+		Findings:         findings,
 	}
 }
 
@@ -417,12 +550,7 @@ func (s *MetadataStoreImpl) GetLabel(ctx context.Context, moduleID, name string)
 		}
 		return nil, err
 	}
-	return &LabelRecord{
-		ID:       doc.ID,
-		ModuleID: doc.ModuleID,
-		Name:     doc.Name,
-		CommitID: doc.CommitID,
-	}, nil
+	return labelDocToRecord(doc), nil
 }
 
 func (s *MetadataStoreImpl) ListLabels(ctx context.Context, moduleID string) ([]*LabelRecord, error) {
@@ -438,24 +566,56 @@ func (s *MetadataStoreImpl) ListLabels(ctx context.Context, moduleID string) ([]
 			}
 			return nil, err
 		}
-		labels = append(labels, &LabelRecord{
-			ID:       doc.ID,
-			ModuleID: doc.ModuleID,
-			Name:     doc.Name,
-			CommitID: doc.CommitID,
-		})
+		labels = append(labels, labelDocToRecord(doc))
 	}
 	return labels, nil
 }
 
-func (s *MetadataStoreImpl) CreateOrUpdateLabel(ctx context.Context, label *LabelRecord) error {
-	doc := &LabelDoc{
-		ID:       labelID(label.ModuleID, label.Name),
-		ModuleID: label.ModuleID,
-		Name:     label.Name,
-		CommitID: label.CommitID,
+func labelDocToRecord(doc *LabelDoc) *LabelRecord {
+	return &LabelRecord{
+		ID:       doc.ID,
+		ModuleID: doc.ModuleID,
+		Name:     doc.Name,
+		CommitID: doc.CommitID,
+		Revision: max(doc.Revision, 1),
+	}
+}
+
+func (s *MetadataStoreImpl) SwapLabel(ctx context.Context, label *LabelRecord) error {
+	id := labelID(label.ModuleID, label.Name)
+	if label.Revision == 0 {
+		doc := &LabelDoc{ID: id, ModuleID: label.ModuleID, Name: label.Name, CommitID: label.CommitID, Revision: 1}
+		if err := s.labels.Create(ctx, doc); err != nil {
+			if gcerrors.Code(err) == gcerrors.AlreadyExists {
+				return ErrConflict
+			}
+			return err
+		}
+		label.ID, label.Revision = id, 1
+		return nil
 	}
-	return s.labels.Put(ctx, doc)
+
+	// The driver revision read here makes Replace fail if the label is written meanwhile
+	doc := &LabelDoc{ID: id}
+	if err := s.labels.Get(ctx, doc); err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return ErrConflict
+		}
+		return err
+	}
+	if max(doc.Revision, 1) != label.Revision {
+		return ErrConflict
+	}
+	doc.CommitID = label.CommitID
+	doc.Revision = label.Revision + 1
+	if err := s.labels.Replace(ctx, doc); err != nil {
+		if code := gcerrors.Code(err); code == gcerrors.FailedPrecondition || code == gcerrors.NotFound {
+			return ErrConflict
+		}
+		return err
+	}
+	label.ID, label.Revision = id, doc.Revision
+	return nil
 }
 
 func (s *MetadataStoreImpl) DeleteLabel(ctx context.Context, moduleID, name string) error {
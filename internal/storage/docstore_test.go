@@ -157,6 +157,10 @@ func TestMetadataStore_Commit(t *testing.T) {
 		FilesDigest:  filesDigest,
 		ModuleDigest: moduleDigest,
 		CreateTime:   time.Now().UTC(),
+		DepCommitIDs: []string{"dep-456"},
+		Deps: []DepRecord{
+			{CommitID: "dep-456", Registry: "buf.build", OwnerID: "owner-456", ModuleID: "module-456", Digest: moduleDigest},
+		},
 	}
 
 	// Create commit
@@ -172,6 +176,9 @@ func TestMetadataStore_Commit(t *testing.T) {
 	if got.ModuleID != commit.ModuleID {
 		t.Errorf("expected module ID %q, got %q", commit.ModuleID, got.ModuleID)
 	}
+	if len(got.Deps) != 1 || got.Deps[0].Registry != "buf.build" || got.Deps[0].ModuleID != "module-456" || got.Deps[0].Digest.String() != moduleDigest.String() {
+		t.Errorf("expected dependency on buf.build to round-trip, got %+v", got.Deps)
+	}
 
 	// Get by files digest
 	got, err = store.GetCommitByFilesDigest(ctx, filesDigest)
@@ -181,6 +188,17 @@ func TestMetadataStore_Commit(t *testing.T) {
 	if got.ID != commit.ID {
 		t.Errorf("expected ID %q, got %q", commit.ID, got.ID)
 	}
+
+	// Delete
+	if err := store.DeleteCommit(ctx, commit.ID); err != nil {
+		t.Fatalf("DeleteCommit failed: %v", err)
+	}
+	if _, err := store.GetCommit(ctx, commit.ID); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	if err := store.DeleteCommit(ctx, commit.ID); err != nil {
+		t.Errorf("DeleteCommit of a missing commit failed: %v", err)
+	}
 }
 
 func TestMetadataStore_Label(t *testing.T) {
@@ -194,8 +212,11 @@ func TestMetadataStore_Label(t *testing.T) {
 	}
 
 	// Create label
-	if err := store.CreateOrUpdateLabel(ctx, label); err != nil {
-		t.Fatalf("CreateOrUpdateLabel failed: %v", err)
+	if err := store.SwapLabel(ctx, label); err != nil {
+		t.Fatalf("SwapLabel failed: %v", err)
+	}
+	if label.Revision != 1 {
+		t.Errorf("expected revision 1, got %d", label.Revision)
 	}
 
 	// Get label
@@ -203,18 +224,32 @@ func TestMetadataStore_Label(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetLabel failed: %v", err)
 	}
-	if got.CommitID != label.CommitID {
-		t.Errorf("expected commit ID %q, got %q", label.CommitID, got.CommitID)
+	if got.CommitID != label.CommitID || got.Revision != 1 {
+		t.Errorf("expected commit ID %q at revision 1, got %q at %d", label.CommitID, got.CommitID, got.Revision)
 	}
 
 	// Update label
 	label.CommitID = "commit-456"
-	if err := store.CreateOrUpdateLabel(ctx, label); err != nil {
-		t.Fatalf("CreateOrUpdateLabel (update) failed: %v", err)
+	if err := store.SwapLabel(ctx, label); err != nil {
+		t.Fatalf("SwapLabel (update) failed: %v", err)
+	}
+	got, _ = store.GetLabel(ctx, label.ModuleID, label.Name)
+	if got.CommitID != "commit-456" || got.Revision != 2 {
+		t.Errorf("expected commit ID %q at revision 2, got %q at %d", "commit-456", got.CommitID, got.Revision)
+	}
+
+	// Stale revisions and creating an existing label conflict
+	stale := &LabelRecord{ModuleID: label.ModuleID, Name: label.Name, CommitID: "commit-789", Revision: 1}
+	if err := store.SwapLabel(ctx, stale); err != ErrConflict {
+		t.Errorf("expected ErrConflict for a stale revision, got %v", err)
+	}
+	stale.Revision = 0
+	if err := store.SwapLabel(ctx, stale); err != ErrConflict {
+		t.Errorf("expected ErrConflict for creating an existing label, got %v", err)
 	}
 	got, _ = store.GetLabel(ctx, label.ModuleID, label.Name)
 	if got.CommitID != "commit-456" {
-		t.Errorf("expected commit ID %q, got %q", "commit-456", got.CommitID)
+		t.Errorf("expected conflicting swaps to leave the label, got %q", got.CommitID)
 	}
 
 	// List labels
@@ -285,3 +320,89 @@ func TestMetadataStore_LabelNotFound(t *testing.T) {
 		t.Errorf("expected ErrNotFound, got %v", err)
 	}
 }
+
+func TestRedirectStore(t *testing.T) {
+	coll, err := memdocstore.OpenCollection("ID", nil)
+	if err != nil {
+		t.Fatalf("failed to open redirects collection: %v", err)
+	}
+	defer coll.Close()
+	store := NewRedirectStore(coll)
+	ctx := context.Background()
+
+	if _, err := store.GetRedirect(ctx, "oldteam", "billing"); err != ErrNotFound {
+		t.Fatalf("GetRedirect: expected ErrNotFound, got %v", err)
+	}
+	now := time.Now().Truncate(time.Second)
+	want := Redirect{Owner: "oldteam", Name: "billing", ModuleID: "mod1", CreateTime: now, ExpireTime: now.Add(time.Hour)}
+	if err := store.PutRedirect(ctx, &want); err != nil {
+		t.Fatalf("PutRedirect failed: %v", err)
+	}
+	got, err := store.GetRedirect(ctx, "oldteam", "billing")
+	if err != nil || got.ModuleID != want.ModuleID || !got.ExpireTime.Equal(want.ExpireTime) {
+		t.Fatalf("GetRedirect = %+v, %v", got, err)
+	}
+	if all, err := store.ListRedirects(ctx); err != nil || len(all) != 1 {
+		t.Fatalf("ListRedirects = %v, %v", all, err)
+	}
+	if err := store.DeleteRedirect(ctx, "oldteam", "billing"); err != nil {
+		t.Fatalf("DeleteRedirect failed: %v", err)
+	}
+	if err := store.DeleteRedirect(ctx, "oldteam", "billing"); err != nil {
+		t.Fatalf("DeleteRedirect of a missing redirect failed: %v", err)
+	}
+}
+
+func TestBatchStore(t *testing.T) {
+	coll, err := memdocstore.OpenCollection("ID", nil)
+	if err != nil {
+		t.Fatalf("failed to open batches collection: %v", err)
+	}
+	defer coll.Close()
+	store := NewBatchStore(coll)
+	ctx := context.Background()
+
+	prev := &CommitRecord{
+		ID:           "commit0",
+		ModuleID:     "mod1",
+		FilesDigest:  Digest{Algorithm: "shake256", Value: make([]byte, 64)},
+		ModuleDigest: ModuleDigest{Type: DigestTypeB5, Value: make([]byte, 64)},
+		Findings:     []Finding{{Check: "lint", Rule: "ENUM_ZERO_VALUE_SUFFIX", Message: "bad"}},
+	}
+	batch := &Batch{
+		ID:         "batch1",
+		CreateTime: time.Now().Truncate(time.Second),
+		Modules:    []string{"mod2"},
+		Commits:    []string{"commit1"},
+		Updated:    []*CommitRecord{prev},
+		Labels: []BatchLabel{
+			{OwnerID: "owner1", After: &LabelRecord{ModuleID: "mod2", Name: "main", CommitID: "commit1", Revision: 1}},
+			{OwnerID: "owner1", Before: &LabelRecord{ModuleID: "mod1", Name: "main", CommitID: "commit0", Revision: 3}, After: &LabelRecord{ModuleID: "mod1", Name: "main", CommitID: "commit1", Revision: 4}},
+		},
+	}
+	if err := store.PutBatch(ctx, batch); err != nil {
+		t.Fatalf("PutBatch failed: %v", err)
+	}
+
+	all, err := store.ListBatches(ctx)
+	if err != nil || len(all) != 1 {
+		t.Fatalf("ListBatches = %v, %v", all, err)
+	}
+	got := all[0]
+	if got.ID != batch.ID || len(got.Modules) != 1 || len(got.Commits) != 1 {
+		t.Errorf("unexpected batch: %+v", got)
+	}
+	if len(got.Updated) != 1 || got.Updated[0].ID != prev.ID || len(got.Updated[0].Findings) != 1 {
+		t.Errorf("unexpected updated commits: %+v", got.Updated)
+	}
+	if len(got.Labels) != 2 || got.Labels[0].Before != nil || got.Labels[1].Before.Revision != 3 || got.Labels[1].After.Revision != 4 {
+		t.Errorf("unexpected labels: %+v", got.Labels)
+	}
+
+	if err := store.DeleteBatch(ctx, batch.ID); err != nil {
+		t.Fatalf("DeleteBatch failed: %v", err)
+	}
+	if all, err := store.ListBatches(ctx); err != nil || len(all) != 0 {
+		t.Fatalf("ListBatches after delete = %v, %v", all, err)
+	}
+}
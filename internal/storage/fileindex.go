@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+// FileIndex maps file paths to the modules providing them, to resolve proto imports
+// without reading module contents. Each module is indexed with the files of its latest commit.
+type FileIndex interface {
+	// SetModuleFiles replaces the indexed files of a module.
+	SetModuleFiles(ctx context.Context, moduleID string, paths []string) error
+	// ModulesWithFile returns the IDs of the modules providing a file.
+	ModulesWithFile(ctx context.Context, path string) ([]string, error)
+	// Built reports whether MarkBuilt was called, so existing modules need not be indexed again.
+	Built(ctx context.Context) (bool, error)
+	MarkBuilt(ctx context.Context) error
+}
+
+// FileIndexDoc is the docstore document of the file index. The collection holds one
+// document per file path, listing the modules providing it, and one per module,
+// listing its paths so they can be removed when the module's files change.
+type FileIndexDoc struct {
+	ID    string   `docstore:"id"` // "file/" + path, "module/" + moduleID or fileIndexBuiltID
+	Items []string `docstore:"items,omitempty"`
+}
+
+const fileIndexBuiltID = "built"
+
+// FileIndexImpl implements FileIndex using a gocloud.dev/docstore collection.
+type FileIndexImpl struct {
+	coll *docstore.Collection
+
+	mu sync.Mutex // serializes read-modify-write updates of path documents
+}
+
+// NewFileIndex creates a docstore-backed file index.
+func NewFileIndex(coll *docstore.Collection) *FileIndexImpl {
+	return &FileIndexImpl{coll: coll}
+}
+
+func (s *FileIndexImpl) SetModuleFiles(ctx context.Context, moduleID string, paths []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	moduleDoc, err := s.get(ctx, "module/"+moduleID)
+	if err != nil {
+		return err
+	}
+	old := moduleDoc.Items
+
+	paths = slices.Clone(paths)
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+	if slices.Equal(old, paths) {
+		return nil
+	}
+
+	for _, path := range old {
+		if _, found := slices.BinarySearch(paths, path); !found {
+			if err := s.update(ctx, "file/"+path, func(ids []string) []string {
+				return slices.DeleteFunc(ids, func(id string) bool { return id == moduleID })
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, path := range paths {
+		if _, found := slices.BinarySearch(old, path); !found {
+			if err := s.update(ctx, "file/"+path, func(ids []string) []string {
+				if slices.Contains(ids, moduleID) {
+					return ids
+				}
+				return append(ids, moduleID)
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	moduleDoc.Items = paths
+	return s.put(ctx, moduleDoc)
+}
+
+func (s *FileIndexImpl) ModulesWithFile(ctx context.Context, path string) ([]string, error) {
+	doc, err := s.get(ctx, "file/"+path)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Items, nil
+}
+
+func (s *FileIndexImpl) Built(ctx context.Context) (bool, error) {
+	doc, err := s.get(ctx, fileIndexBuiltID)
+	if err != nil {
+		return false, err
+	}
+	return len(doc.Items) > 0, nil
+}
+
+func (s *FileIndexImpl) MarkBuilt(ctx context.Context) error {
+	return s.coll.Put(ctx, &FileIndexDoc{ID: fileIndexBuiltID, Items: []string{"v1"}})
+}
+
+// get returns the document with the given ID, or an empty one if it does not exist.
+func (s *FileIndexImpl) get(ctx context.Context, id string) (*FileIndexDoc, error) {
+	doc := &FileIndexDoc{ID: id}
+	if err := s.coll.Get(ctx, doc); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// put stores a document, deleting it when it has no items.
+func (s *FileIndexImpl) put(ctx context.Context, doc *FileIndexDoc) error {
+	if len(doc.Items) == 0 {
+		err := s.coll.Delete(ctx, doc)
+		if err != nil && gcerrors.Code(err) == gcerrors.NotFound {
+			return nil
+		}
+		return err
+	}
+	return s.coll.Put(ctx, doc)
+}
+
+func (s *FileIndexImpl) update(ctx context.Context, id string, fn func([]string) []string) error {
+	doc, err := s.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	doc.Items = fn(doc.Items)
+	return s.put(ctx, doc)
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"gocloud.dev/docstore/memdocstore"
+)
+
+func TestFileIndex(t *testing.T) {
+	coll, err := memdocstore.OpenCollection("ID", nil)
+	if err != nil {
+		t.Fatalf("failed to open files collection: %v", err)
+	}
+	index := NewFileIndex(coll)
+	ctx := context.Background()
+
+	modulesWithFile := func(path string) []string {
+		t.Helper()
+		ids, err := index.ModulesWithFile(ctx, path)
+		if err != nil {
+			t.Fatalf("ModulesWithFile failed: %v", err)
+		}
+		return ids
+	}
+
+	if err := index.SetModuleFiles(ctx, "mod1", []string{"a.proto", "shared.proto"}); err != nil {
+		t.Fatalf("SetModuleFiles failed: %v", err)
+	}
+	if err := index.SetModuleFiles(ctx, "mod2", []string{"shared.proto"}); err != nil {
+		t.Fatalf("SetModuleFiles failed: %v", err)
+	}
+	if got := modulesWithFile("shared.proto"); !reflect.DeepEqual(got, []string{"mod1", "mod2"}) {
+		t.Errorf("shared.proto: got %v", got)
+	}
+
+	// Replacing the files of a module removes it from the paths it no longer has
+	if err := index.SetModuleFiles(ctx, "mod1", []string{"b.proto", "shared.proto"}); err != nil {
+		t.Fatalf("SetModuleFiles failed: %v", err)
+	}
+	if got := modulesWithFile("a.proto"); len(got) != 0 {
+		t.Errorf("a.proto: got %v, want none", got)
+	}
+	if got := modulesWithFile("b.proto"); !reflect.DeepEqual(got, []string{"mod1"}) {
+		t.Errorf("b.proto: got %v", got)
+	}
+
+	if err := index.SetModuleFiles(ctx, "mod2", nil); err != nil {
+		t.Fatalf("SetModuleFiles failed: %v", err)
+	}
+	if got := modulesWithFile("shared.proto"); !reflect.DeepEqual(got, []string{"mod1"}) {
+		t.Errorf("shared.proto after removing mod2: got %v", got)
+	}
+
+	if built, err := index.Built(ctx); err != nil || built {
+		t.Errorf("Built = %v, %v, want false", built, err)
+	}
+	if err := index.MarkBuilt(ctx); err != nil {
+		t.Fatalf("MarkBuilt failed: %v", err)
+	}
+	if built, err := index.Built(ctx); err != nil || !built {
+		t.Errorf("Built = %v, %v, want true", built, err)
+	}
+}
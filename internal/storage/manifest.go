@@ -146,6 +146,20 @@ func (s *ManifestStoreImpl) Exists(ctx context.Context, digest Digest) (bool, er
 	return s.bucket.Exists(ctx, key)
 }
 
+// Delete removes a manifest by its digest.
+func (s *ManifestStoreImpl) Delete(ctx context.Context, digest Digest) error {
+	err := s.bucket.Delete(ctx, manifestPath(digest))
+	if err != nil && gcerrors.Code(err) == gcerrors.NotFound {
+		return nil
+	}
+	return err
+}
+
+// List calls fn for each stored manifest.
+func (s *ManifestStoreImpl) List(ctx context.Context, fn func(StoredObject) error) error {
+	return listObjects(ctx, s.bucket, "manifests/"+DigestAlgorithmShake256+"/", fn)
+}
+
 // ComputeB5Digest computes a B5 module digest from a manifest and dependency digests.
 //
 // The B5 digest is computed as follows:
@@ -10,6 +10,8 @@ import (
 var (
 	ErrNotFound      = errors.New("not found")
 	ErrAlreadyExists = errors.New("already exists")
+	// ErrConflict is returned when a record changed since it was read.
+	ErrConflict = errors.New("conflict")
 )
 
 // ModuleRecord represents stored module metadata.
@@ -20,8 +22,10 @@ type ModuleRecord struct {
 	Name             string
 	Description      string
 	DefaultLabelName string // e.g., "main"
+	Mirror           string // upstream registry the module is mirrored from, empty for modules hosted here
 	CreateTime       time.Time
 	UpdateTime       time.Time
+	DeleteTime       time.Time // when the module was deleted, zero for modules that are not
 }
 
 // CommitRecord represents stored commit metadata.
@@ -35,6 +39,31 @@ type CommitRecord struct {
 	CreatedByUserID  string
 	SourceControlURL string
 	DepCommitIDs     []string // dependency commit IDs
+	Deps             []DepRecord
+	Findings         []Finding // results of the checks run when the commit was pushed
+}
+
+// DepRecord is a dependency of a commit, recorded with enough information to
+// report it without looking it up again. Registry is empty for dependencies on
+// commits of this registry.
+type DepRecord struct {
+	CommitID string
+	Registry string
+	OwnerID  string
+	ModuleID string
+	Digest   ModuleDigest // B5 module digest of the dependency commit
+}
+
+// Finding is a result of a check of a commit, such as a breaking change.
+// Line and Column are 1-based, and zero if the finding has no position.
+type Finding struct {
+	Check   string // e.g., "breaking"
+	Rule    string
+	Path    string
+	Line    int
+	Column  int
+	Message string
+	Against string // commit the check compared the commit with, if any
 }
 
 // LabelRecord represents a named reference to a commit (like a branch or tag).
@@ -43,13 +72,29 @@ type LabelRecord struct {
 	ModuleID string
 	Name     string // e.g., "main", "v1.0.0"
 	CommitID string
+	Revision int64 // incremented on each move, 0 for labels not stored yet
 }
 
-// OwnerRecord represents an owner/organization.
+// OwnerType discriminates between the kinds of owners.
+type OwnerType string
+
+const (
+	// OwnerTypeOrganization is an organization. Owners stored without a type are organizations.
+	OwnerTypeOrganization OwnerType = "organization"
+	// OwnerTypeUser is a user, provisioned when an authenticated principal is first seen.
+	OwnerTypeUser OwnerType = "user"
+)
+
+// OwnerRecord represents an owner: a user or an organization.
 type OwnerRecord struct {
-	ID         string
-	Name       string
-	CreateTime time.Time
+	ID          string
+	Name        string
+	Type        OwnerType
+	Description string
+	URL         string
+	Deactivated bool // users only
+	CreateTime  time.Time
+	UpdateTime  time.Time
 }
 
 // MetadataStore manages module, commit, label, and owner metadata.
@@ -58,11 +103,14 @@ type MetadataStore interface {
 	GetOwner(ctx context.Context, id string) (*OwnerRecord, error)
 	GetOwnerByName(ctx context.Context, name string) (*OwnerRecord, error)
 	CreateOwner(ctx context.Context, owner *OwnerRecord) error
+	UpdateOwner(ctx context.Context, owner *OwnerRecord) error
+	DeleteOwner(ctx context.Context, id string) error
 	ListOwners(ctx context.Context) ([]*OwnerRecord, error)
 
 	// Module operations
 	GetModule(ctx context.Context, id string) (*ModuleRecord, error)
 	GetModuleByName(ctx context.Context, owner, name string) (*ModuleRecord, error)
+	// ListModules lists the modules of an owner, or of all owners if ownerID is empty.
 	ListModules(ctx context.Context, ownerID string) ([]*ModuleRecord, error)
 	CreateModule(ctx context.Context, module *ModuleRecord) error
 	UpdateModule(ctx context.Context, module *ModuleRecord) error
@@ -73,10 +121,15 @@ type MetadataStore interface {
 	GetCommitByFilesDigest(ctx context.Context, digest Digest) (*CommitRecord, error)
 	ListCommits(ctx context.Context, moduleID string, limit int, pageToken string) ([]*CommitRecord, string, error)
 	CreateCommit(ctx context.Context, commit *CommitRecord) error
+	UpdateCommit(ctx context.Context, commit *CommitRecord) error
+	DeleteCommit(ctx context.Context, id string) error
 
 	// Label operations
 	GetLabel(ctx context.Context, moduleID, name string) (*LabelRecord, error)
 	ListLabels(ctx context.Context, moduleID string) ([]*LabelRecord, error)
-	CreateOrUpdateLabel(ctx context.Context, label *LabelRecord) error
+	// SwapLabel stores a label if the stored label is still at label.Revision, or if there is
+	// none and label.Revision is 0. It sets label.Revision to the new revision on success
+	// and returns ErrConflict if the label was moved, created or deleted meanwhile.
+	SwapLabel(ctx context.Context, label *LabelRecord) error
 	DeleteLabel(ctx context.Context, moduleID, name string) error
 }
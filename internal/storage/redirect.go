@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+// Redirect points the former name of a renamed module at the module, until it expires.
+type Redirect struct {
+	Owner      string // former owner name
+	Name       string // former module name
+	ModuleID   string
+	CreateTime time.Time
+	ExpireTime time.Time
+}
+
+// RedirectStore keeps the redirects of renamed modules, keyed by their former name.
+type RedirectStore interface {
+	// GetRedirect returns the redirect of a former module name, or ErrNotFound.
+	GetRedirect(ctx context.Context, owner, name string) (*Redirect, error)
+	// PutRedirect creates or replaces the redirect of a former module name.
+	PutRedirect(ctx context.Context, redirect *Redirect) error
+	DeleteRedirect(ctx context.Context, owner, name string) error
+	ListRedirects(ctx context.Context) ([]*Redirect, error)
+}
+
+// RedirectDoc is the docstore document of a redirect.
+type RedirectDoc struct {
+	ID         string    `docstore:"id"` // owner + "/" + name
+	Owner      string    `docstore:"owner"`
+	Name       string    `docstore:"name"`
+	ModuleID   string    `docstore:"module_id"`
+	CreateTime time.Time `docstore:"create_time"`
+	ExpireTime time.Time `docstore:"expire_time"`
+}
+
+// RedirectStoreImpl implements RedirectStore using a gocloud.dev/docstore collection.
+type RedirectStoreImpl struct {
+	coll *docstore.Collection
+}
+
+// NewRedirectStore creates a docstore-backed redirect store.
+func NewRedirectStore(coll *docstore.Collection) *RedirectStoreImpl {
+	return &RedirectStoreImpl{coll: coll}
+}
+
+func (s *RedirectStoreImpl) GetRedirect(ctx context.Context, owner, name string) (*Redirect, error) {
+	doc := &RedirectDoc{ID: owner + "/" + name}
+	if err := s.coll.Get(ctx, doc); err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return redirectDocToRecord(doc), nil
+}
+
+func (s *RedirectStoreImpl) PutRedirect(ctx context.Context, redirect *Redirect) error {
+	return s.coll.Put(ctx, &RedirectDoc{
+		ID:         redirect.Owner + "/" + redirect.Name,
+		Owner:      redirect.Owner,
+		Name:       redirect.Name,
+		ModuleID:   redirect.ModuleID,
+		CreateTime: redirect.CreateTime,
+		ExpireTime: redirect.ExpireTime,
+	})
+}
+
+func (s *RedirectStoreImpl) DeleteRedirect(ctx context.Context, owner, name string) error {
+	err := s.coll.Delete(ctx, &RedirectDoc{ID: owner + "/" + name})
+	if err != nil && gcerrors.Code(err) == gcerrors.NotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *RedirectStoreImpl) ListRedirects(ctx context.Context) ([]*Redirect, error) {
+	iter := s.coll.Query().Get(ctx)
+	defer iter.Stop()
+
+	var redirects []*Redirect
+	for {
+		doc := &RedirectDoc{}
+		if err := iter.Next(ctx, doc); err != nil {
+			if err == io.EOF {
+				return redirects, nil
+			}
+			return nil, err
+		}
+		redirects = append(redirects, redirectDocToRecord(doc))
+	}
+}
+
+func redirectDocToRecord(doc *RedirectDoc) *Redirect {
+	return &Redirect{
+		Owner:      doc.Owner,
+		Name:       doc.Name,
+		ModuleID:   doc.ModuleID,
+		CreateTime: doc.CreateTime,
+		ExpireTime: doc.ExpireTime,
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"time"
 )
 
 // DigestAlgorithmShake256 is the SHAKE256 algorithm identifier used for content-addressable storage.
@@ -152,6 +153,9 @@ type BlobStore interface {
 	// Delete removes a blob by its digest.
 	// Returns nil if the blob does not exist.
 	Delete(ctx context.Context, digest Digest) error
+
+	// List calls fn for each stored blob.
+	List(ctx context.Context, fn func(StoredObject) error) error
 }
 
 // ManifestStore manages manifests (collections of file blobs).
@@ -166,4 +170,18 @@ type ManifestStore interface {
 
 	// Exists checks if a manifest with the given digest exists.
 	Exists(ctx context.Context, digest Digest) (bool, error)
+
+	// Delete removes a manifest by its digest.
+	// Returns nil if the manifest does not exist.
+	Delete(ctx context.Context, digest Digest) error
+
+	// List calls fn for each stored manifest.
+	List(ctx context.Context, fn func(StoredObject) error) error
+}
+
+// StoredObject describes a stored blob or manifest.
+type StoredObject struct {
+	Digest  Digest
+	Size    int64
+	ModTime time.Time
 }
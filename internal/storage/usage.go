@@ -0,0 +1,354 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+// Usage is the storage used by an owner or a module. Bytes counts each blob referenced by
+// the commits of the owner or module once, however many commits and files reference it.
+type Usage struct {
+	OwnerID  string
+	ModuleID string // empty for the usage of an owner
+	Bytes    int64
+	Commits  int64
+	Labels   int64
+}
+
+// BlobUsage is a blob referenced by a commit.
+type BlobUsage struct {
+	Digest string // hex digest
+	Size   int64
+}
+
+// UsageStore accounts the storage used by owners and modules. It is updated incrementally
+// as commits and labels are created and deleted.
+type UsageStore interface {
+	// AddCommit accounts a commit of a module and the blobs it references, each listed once.
+	AddCommit(ctx context.Context, ownerID, moduleID string, blobs []BlobUsage) error
+	// RemoveCommit undoes AddCommit, releasing the bytes of blobs no other commit references.
+	RemoveCommit(ctx context.Context, ownerID, moduleID string, digests []string) error
+	// AddLabels adds delta, which may be negative, to the label count of a module.
+	AddLabels(ctx context.Context, ownerID, moduleID string, delta int64) error
+	// RemoveModule removes the usage of a module, and subtracts it from its owner.
+	RemoveModule(ctx context.Context, ownerID, moduleID string) error
+	// NewBytes returns the bytes that blobs would add to the usage of an owner,
+	// counting the blobs it doesn't reference yet.
+	NewBytes(ctx context.Context, ownerID string, blobs []BlobUsage) (int64, error)
+
+	// OwnerUsage returns the usage of an owner, zero if it has none.
+	OwnerUsage(ctx context.Context, ownerID string) (*Usage, error)
+	// ModuleUsage returns the usage of a module, zero if it has none.
+	ModuleUsage(ctx context.Context, ownerID, moduleID string) (*Usage, error)
+	// ListOwnerUsage returns the usage of all owners with any.
+	ListOwnerUsage(ctx context.Context) ([]*Usage, error)
+	// ListModuleUsage returns the usage of the modules of an owner, or of all modules if ownerID is empty.
+	ListModuleUsage(ctx context.Context, ownerID string) ([]*Usage, error)
+
+	// Built reports whether MarkBuilt was called, so existing commits need not be accounted again.
+	Built(ctx context.Context) (bool, error)
+	MarkBuilt(ctx context.Context) error
+}
+
+// UsageDoc is the docstore document of the usage store. The collection holds one counter
+// document per owner and per module, and one per blob referenced by an owner or module,
+// counting the commits referencing it.
+type UsageDoc struct {
+	ID       string `docstore:"id"` // "owner/" + ownerID, "module/" + moduleID, either + "/blob/" + digest, or usageBuiltID
+	Kind     string `docstore:"kind"`
+	OwnerID  string `docstore:"ownerID,omitempty"`
+	ModuleID string `docstore:"moduleID,omitempty"` // also set on the blob documents of a module
+	Bytes    int64  `docstore:"bytes,omitempty"`    // size of a blob, or the bytes of an owner or module
+	Commits  int64  `docstore:"commits,omitempty"`
+	Labels   int64  `docstore:"labels,omitempty"`
+	Refs     int64  `docstore:"refs,omitempty"` // commits referencing a blob
+}
+
+// Kinds of usage documents.
+const (
+	usageKindOwner  = "owner"
+	usageKindModule = "module"
+	usageKindBlob   = "blob"
+)
+
+const usageBuiltID = "built"
+
+// UsageStoreImpl implements UsageStore using a gocloud.dev/docstore collection.
+type UsageStoreImpl struct {
+	coll *docstore.Collection
+
+	mu sync.Mutex // serializes read-modify-write updates of counters
+}
+
+// NewUsageStore creates a docstore-backed usage store.
+func NewUsageStore(coll *docstore.Collection) *UsageStoreImpl {
+	return &UsageStoreImpl{coll: coll}
+}
+
+func ownerUsageID(ownerID string) string   { return usageKindOwner + "/" + ownerID }
+func moduleUsageID(moduleID string) string { return usageKindModule + "/" + moduleID }
+
+func (s *UsageStoreImpl) AddCommit(ctx context.Context, ownerID, moduleID string, blobs []BlobUsage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, counterID := range []string{ownerUsageID(ownerID), moduleUsageID(moduleID)} {
+		counter, err := s.counter(ctx, counterID, ownerID, moduleID)
+		if err != nil {
+			return err
+		}
+		counter.Commits++
+		for _, b := range blobs {
+			ref, err := s.get(ctx, counterID+"/blob/"+b.Digest)
+			if err != nil {
+				return err
+			}
+			if ref.Refs == 0 {
+				ref.Kind, ref.ModuleID, ref.Bytes = usageKindBlob, counter.ModuleID, b.Size
+				counter.Bytes += b.Size
+			}
+			ref.Refs++
+			if err := s.coll.Put(ctx, ref); err != nil {
+				return err
+			}
+		}
+		if err := s.coll.Put(ctx, counter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *UsageStoreImpl) RemoveCommit(ctx context.Context, ownerID, moduleID string, digests []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, counterID := range []string{ownerUsageID(ownerID), moduleUsageID(moduleID)} {
+		counter, err := s.counter(ctx, counterID, ownerID, moduleID)
+		if err != nil {
+			return err
+		}
+		counter.Commits = max(counter.Commits-1, 0)
+		for _, digest := range digests {
+			released, err := s.release(ctx, counterID+"/blob/"+digest, 1)
+			if err != nil {
+				return err
+			}
+			counter.Bytes -= released
+		}
+		if err := s.put(ctx, counter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *UsageStoreImpl) AddLabels(ctx context.Context, ownerID, moduleID string, delta int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, counterID := range []string{ownerUsageID(ownerID), moduleUsageID(moduleID)} {
+		counter, err := s.counter(ctx, counterID, ownerID, moduleID)
+		if err != nil {
+			return err
+		}
+		counter.Labels = max(counter.Labels+delta, 0)
+		if err := s.put(ctx, counter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *UsageStoreImpl) RemoveModule(ctx context.Context, ownerID, moduleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	module, err := s.get(ctx, moduleUsageID(moduleID))
+	if err != nil {
+		return err
+	}
+	owner, err := s.counter(ctx, ownerUsageID(ownerID), ownerID, "")
+	if err != nil {
+		return err
+	}
+	owner.Commits = max(owner.Commits-module.Commits, 0)
+	owner.Labels = max(owner.Labels-module.Labels, 0)
+
+	refs, err := s.query(ctx, s.coll.Query().Where("kind", "=", usageKindBlob).Where("moduleID", "=", moduleID))
+	if err != nil {
+		return err
+	}
+	prefix := moduleUsageID(moduleID) + "/blob/"
+	for _, ref := range refs {
+		released, err := s.release(ctx, ownerUsageID(ownerID)+"/blob/"+strings.TrimPrefix(ref.ID, prefix), ref.Refs)
+		if err != nil {
+			return err
+		}
+		owner.Bytes -= released
+		if err := s.delete(ctx, ref.ID); err != nil {
+			return err
+		}
+	}
+	if err := s.put(ctx, owner); err != nil {
+		return err
+	}
+	return s.delete(ctx, module.ID)
+}
+
+func (s *UsageStoreImpl) NewBytes(ctx context.Context, ownerID string, blobs []BlobUsage) (int64, error) {
+	var bytes int64
+	for _, b := range blobs {
+		ref, err := s.get(ctx, ownerUsageID(ownerID)+"/blob/"+b.Digest)
+		if err != nil {
+			return 0, err
+		}
+		if ref.Refs == 0 {
+			bytes += b.Size
+		}
+	}
+	return bytes, nil
+}
+
+func (s *UsageStoreImpl) OwnerUsage(ctx context.Context, ownerID string) (*Usage, error) {
+	doc, err := s.get(ctx, ownerUsageID(ownerID))
+	if err != nil {
+		return nil, err
+	}
+	doc.OwnerID = ownerID
+	return usageDocToUsage(doc), nil
+}
+
+func (s *UsageStoreImpl) ModuleUsage(ctx context.Context, ownerID, moduleID string) (*Usage, error) {
+	doc, err := s.get(ctx, moduleUsageID(moduleID))
+	if err != nil {
+		return nil, err
+	}
+	doc.OwnerID, doc.ModuleID = ownerID, moduleID
+	return usageDocToUsage(doc), nil
+}
+
+func (s *UsageStoreImpl) ListOwnerUsage(ctx context.Context) ([]*Usage, error) {
+	docs, err := s.query(ctx, s.coll.Query().Where("kind", "=", usageKindOwner))
+	if err != nil {
+		return nil, err
+	}
+	return usageDocsToUsage(docs), nil
+}
+
+func (s *UsageStoreImpl) ListModuleUsage(ctx context.Context, ownerID string) ([]*Usage, error) {
+	q := s.coll.Query().Where("kind", "=", usageKindModule)
+	if ownerID != "" {
+		q = q.Where("ownerID", "=", ownerID)
+	}
+	docs, err := s.query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return usageDocsToUsage(docs), nil
+}
+
+func (s *UsageStoreImpl) Built(ctx context.Context) (bool, error) {
+	doc, err := s.get(ctx, usageBuiltID)
+	if err != nil {
+		return false, err
+	}
+	return doc.Kind != "", nil
+}
+
+func (s *UsageStoreImpl) MarkBuilt(ctx context.Context) error {
+	return s.coll.Put(ctx, &UsageDoc{ID: usageBuiltID, Kind: "v1"})
+}
+
+// counter returns the counter document of an owner or module, initialized if it does not exist.
+func (s *UsageStoreImpl) counter(ctx context.Context, id, ownerID, moduleID string) (*UsageDoc, error) {
+	doc, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	doc.Kind, doc.OwnerID = usageKindOwner, ownerID
+	if id == moduleUsageID(moduleID) {
+		doc.Kind, doc.ModuleID = usageKindModule, moduleID
+	}
+	return doc, nil
+}
+
+// release drops refs references to a blob and returns its size if no references are left.
+func (s *UsageStoreImpl) release(ctx context.Context, id string, refs int64) (int64, error) {
+	ref, err := s.get(ctx, id)
+	if err != nil || ref.Refs == 0 {
+		return 0, err
+	}
+	ref.Refs -= refs
+	if ref.Refs > 0 {
+		return 0, s.coll.Put(ctx, ref)
+	}
+	return ref.Bytes, s.delete(ctx, id)
+}
+
+// get returns the document with the given ID, or an empty one if it does not exist.
+func (s *UsageStoreImpl) get(ctx context.Context, id string) (*UsageDoc, error) {
+	doc := &UsageDoc{ID: id}
+	if err := s.coll.Get(ctx, doc); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// put stores a counter document, deleting it when the owner or module uses nothing.
+func (s *UsageStoreImpl) put(ctx context.Context, doc *UsageDoc) error {
+	if doc.Bytes <= 0 && doc.Commits == 0 && doc.Labels == 0 {
+		return s.delete(ctx, doc.ID)
+	}
+	return s.coll.Put(ctx, doc)
+}
+
+func (s *UsageStoreImpl) delete(ctx context.Context, id string) error {
+	err := s.coll.Delete(ctx, &UsageDoc{ID: id})
+	if err != nil && gcerrors.Code(err) == gcerrors.NotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *UsageStoreImpl) query(ctx context.Context, q *docstore.Query) ([]*UsageDoc, error) {
+	iter := q.Get(ctx)
+	defer iter.Stop()
+
+	var docs []*UsageDoc
+	for {
+		doc := &UsageDoc{}
+		err := iter.Next(ctx, doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func usageDocToUsage(doc *UsageDoc) *Usage {
+	return &Usage{
+		OwnerID:  doc.OwnerID,
+		ModuleID: doc.ModuleID,
+		Bytes:    max(doc.Bytes, 0),
+		Commits:  doc.Commits,
+		Labels:   doc.Labels,
+	}
+}
+
+func usageDocsToUsage(docs []*UsageDoc) []*Usage {
+	usage := make([]*Usage, len(docs))
+	for i, doc := range docs {
+		usage[i] = usageDocToUsage(doc)
+	}
+	return usage
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"gocloud.dev/docstore/memdocstore"
+)
+
+func TestUsageStore(t *testing.T) {
+	coll, err := memdocstore.OpenCollection("ID", nil)
+	if err != nil {
+		t.Fatalf("failed to open usage collection: %v", err)
+	}
+	store := NewUsageStore(coll)
+	ctx := context.Background()
+
+	check := func(name string, got *Usage, err error, want Usage) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if *got != want {
+			t.Errorf("%s: got %+v, want %+v", name, *got, want)
+		}
+	}
+
+	// Blobs shared by commits, and by modules of the same owner, count once
+	if err := store.AddCommit(ctx, "o1", "m1", []BlobUsage{{"a", 10}, {"b", 20}}); err != nil {
+		t.Fatalf("AddCommit failed: %v", err)
+	}
+	if err := store.AddCommit(ctx, "o1", "m1", []BlobUsage{{"a", 10}, {"c", 5}}); err != nil {
+		t.Fatalf("AddCommit failed: %v", err)
+	}
+	if err := store.AddCommit(ctx, "o1", "m2", []BlobUsage{{"a", 10}}); err != nil {
+		t.Fatalf("AddCommit failed: %v", err)
+	}
+	if err := store.AddLabels(ctx, "o1", "m1", 2); err != nil {
+		t.Fatalf("AddLabels failed: %v", err)
+	}
+	u, err := store.ModuleUsage(ctx, "o1", "m1")
+	check("m1", u, err, Usage{OwnerID: "o1", ModuleID: "m1", Bytes: 35, Commits: 2, Labels: 2})
+	u, err = store.ModuleUsage(ctx, "o1", "m2")
+	check("m2", u, err, Usage{OwnerID: "o1", ModuleID: "m2", Bytes: 10, Commits: 1})
+	u, err = store.OwnerUsage(ctx, "o1")
+	check("o1", u, err, Usage{OwnerID: "o1", Bytes: 35, Commits: 3, Labels: 2})
+
+	newBytes, err := store.NewBytes(ctx, "o1", []BlobUsage{{"a", 10}, {"d", 7}})
+	if err != nil || newBytes != 7 {
+		t.Errorf("NewBytes: got %d, %v, want 7", newBytes, err)
+	}
+
+	// Bytes are released when the last commit referencing a blob is removed
+	if err := store.RemoveCommit(ctx, "o1", "m1", []string{"a", "c"}); err != nil {
+		t.Fatalf("RemoveCommit failed: %v", err)
+	}
+	u, err = store.ModuleUsage(ctx, "o1", "m1")
+	check("m1 after remove", u, err, Usage{OwnerID: "o1", ModuleID: "m1", Bytes: 30, Commits: 1, Labels: 2})
+	u, err = store.OwnerUsage(ctx, "o1")
+	check("o1 after remove", u, err, Usage{OwnerID: "o1", Bytes: 30, Commits: 2, Labels: 2})
+
+	// Removing a module releases the blobs only it referenced
+	if err := store.RemoveModule(ctx, "o1", "m1"); err != nil {
+		t.Fatalf("RemoveModule failed: %v", err)
+	}
+	u, err = store.OwnerUsage(ctx, "o1")
+	check("o1 after module removal", u, err, Usage{OwnerID: "o1", Bytes: 10, Commits: 1})
+	modules, err := store.ListModuleUsage(ctx, "o1")
+	if err != nil || len(modules) != 1 || modules[0].ModuleID != "m2" {
+		t.Errorf("ListModuleUsage: got %v, %v", modules, err)
+	}
+	owners, err := store.ListOwnerUsage(ctx)
+	if err != nil || len(owners) != 1 || owners[0].OwnerID != "o1" {
+		t.Errorf("ListOwnerUsage: got %v, %v", owners, err)
+	}
+}